@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"protoapi"
+
+	"github.com/pkg/errors"
+)
+
+// accessPolicy maps server access tokens to the verbs they may invoke. Verbs
+// are matched by name (e.g. "LinodeTunnelStatus") and may use shell-style
+// wildcards (e.g. "LinodeList*"). A nil policy allows everything, except for
+// admin-only verbs, which always require an admin token.
+type accessPolicy struct {
+	// Entries keyed by SHA-256 of the token, so that plain tokens don't
+	// linger in memory longer than needed.
+	tokens map[string]*accessPolicyEntry
+}
+
+type accessPolicyEntry struct {
+	verbs []string
+	admin bool
+}
+
+// accessPolicyFile is the on-disk representation of accessPolicy.
+//
+//	{
+//	  "tokens": [
+//	    {"token": "admin-token", "verbs": ["*"], "admin": true},
+//	    {"token": "full-access-token", "verbs": ["*"]},
+//	    {"token": "monitoring-token", "verbs": ["LinodeTunnelStatus", "LinodeList*"]}
+//	  ]
+//	}
+type accessPolicyFile struct {
+	Tokens []struct {
+		Token string   `json:"token"`
+		Verbs []string `json:"verbs"`
+		Admin bool     `json:"admin"`
+	} `json:"tokens"`
+}
+
+func loadAccessPolicy(filename string) (*accessPolicy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read access policy")
+	}
+
+	var file accessPolicyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse access policy")
+	}
+
+	policy := &accessPolicy{tokens: make(map[string]*accessPolicyEntry)}
+	for n, entry := range file.Tokens {
+		if len(entry.Token) == 0 {
+			return nil, errors.Errorf("Access policy entry #%d has empty token", n)
+		}
+		for _, pattern := range entry.Verbs {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Errorf("Access policy entry #%d has malformed verb: %s", n, pattern)
+			}
+		}
+		policy.tokens[hashToken(entry.Token)] = &accessPolicyEntry{
+			verbs: entry.Verbs,
+			admin: entry.Admin,
+		}
+	}
+	return policy, nil
+}
+
+// Authorize checks whether the token is allowed to invoke the verb.
+func (p *accessPolicy) Authorize(token string, verb string) error {
+	if p == nil {
+		return nil
+	}
+
+	entry, ok := p.tokens[hashToken(token)]
+	if !ok {
+		return newHolepuncherError(protoapi.HolepuncherError_UNAUTHORIZED, "Invalid access token")
+	}
+	for _, pattern := range entry.verbs {
+		if matched, _ := path.Match(pattern, verb); matched {
+			return nil
+		}
+	}
+	return newHolepuncherError(
+		protoapi.HolepuncherError_VERB_FORBIDDEN,
+		"Access token is not allowed to invoke %s", verb,
+	)
+}
+
+// AuthorizeAdmin checks whether the token grants admin privileges.
+func (p *accessPolicy) AuthorizeAdmin(token string) error {
+	if p != nil {
+		if entry, ok := p.tokens[hashToken(token)]; ok && entry.admin {
+			return nil
+		}
+	}
+	return newHolepuncherError(protoapi.HolepuncherError_ADMIN_REQUIRED, "Admin access token is required")
+}
+
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"protoapi"
+	"testing"
+)
+
+func writeAccessPolicy(t *testing.T, data string) string {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	if err := ioutil.WriteFile(filename, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestAccessPolicyAuthorize(t *testing.T) {
+	policy, err := loadAccessPolicy(writeAccessPolicy(t, `{
+		"tokens": [
+			{"token": "admin-token", "verbs": ["*"], "admin": true},
+			{"token": "monitoring-token", "verbs": ["LinodeTunnelStatus", "LinodeList*"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		token string
+		verb  string
+		code  protoapi.HolepuncherError_Code
+	}{
+		{"admin-token", "LinodeCreateTunnel", 0},
+		{"monitoring-token", "LinodeTunnelStatus", 0},
+		{"monitoring-token", "LinodeListInstances", 0},
+		{"monitoring-token", "LinodeCreateTunnel", protoapi.HolepuncherError_VERB_FORBIDDEN},
+		{"monitoring-token", "LinodeDestroyTunnel", protoapi.HolepuncherError_VERB_FORBIDDEN},
+		{"unknown-token", "LinodeTunnelStatus", protoapi.HolepuncherError_UNAUTHORIZED},
+		{"", "LinodeTunnelStatus", protoapi.HolepuncherError_UNAUTHORIZED},
+	}
+	for _, c := range cases {
+		err := policy.Authorize(c.token, c.verb)
+		if c.code == 0 {
+			if err != nil {
+				t.Errorf("%s/%s: got error %v", c.token, c.verb, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s/%s: was authorized", c.token, c.verb)
+		} else if code := errorCode(t, err); code != c.code {
+			t.Errorf("%s/%s: got code %v, want %v", c.token, c.verb, code, c.code)
+		}
+	}
+
+	if err := policy.AuthorizeAdmin("admin-token"); err != nil {
+		t.Errorf("admin token: got error %v", err)
+	}
+	for _, token := range []string{"monitoring-token", "unknown-token"} {
+		if err := policy.AuthorizeAdmin(token); err == nil {
+			t.Errorf("token '%s' was authorized as admin", token)
+		}
+	}
+}
+
+func TestNilAccessPolicy(t *testing.T) {
+	var policy *accessPolicy
+	if err := policy.Authorize("any-token", "LinodeCreateTunnel"); err != nil {
+		t.Errorf("got error %v", err)
+	}
+	if err := policy.AuthorizeAdmin("any-token"); err == nil {
+		t.Error("nil policy granted admin")
+	}
+}
+
+func TestLoadAccessPolicyRejectsInvalidEntries(t *testing.T) {
+	for _, data := range []string{
+		`{"tokens": [{"token": "some-token", "verbs": ["Linode[List"]}]}`,
+		`{"tokens": [{"token": "", "verbs": ["*"]}]}`,
+		`{"tokens": [`,
+	} {
+		if _, err := loadAccessPolicy(writeAccessPolicy(t, data)); err == nil {
+			t.Errorf("policy %s was loaded", data)
+		}
+	}
+
+	if _, err := loadAccessPolicy(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing policy was loaded")
+	}
+}
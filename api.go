@@ -1,98 +1,466 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"protoapi"
 	"protocore"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// minProtocolVersion is the oldest protocol revision the server still
+	// understands. Requests that don't carry a version are treated as this
+	// revision.
+	minProtocolVersion = 1
+	// maxProtocolVersion is the newest protocol revision the server speaks.
+	maxProtocolVersion = 1
+)
+
+// maxRequestBodySize limits size of the verb sent in request body.
+const maxRequestBodySize = 1 << 20
+
+// adminVerbs lists verbs that can be invoked only with an admin access token.
+var adminVerbs = map[string]bool{
+	"ServerGetKeyInfo": true,
+	"ServerGetConfig":  true,
+}
+
 type protobufAPIServer struct {
-	proto *protocore.Proto
+	// One per authorized peer key, tried in order.
+	protos       []*protocore.Proto
+	keys         *keyInfo
+	config       *serverConfig
+	policy       *accessPolicy
+	metrics      MetricsSink
+	linodeConfig *linodeConfig
+	inFlight     int64
+	streamsOpen  int64
+
+	// Closed when server shuts down to terminate open status streams.
+	streamsClosed     chan struct{}
+	streamsClosedOnce sync.Once
 }
 
-func newProtobufAPIServer(hostKey []byte, peerKey []byte) *protobufAPIServer {
-	return &protobufAPIServer{
-		proto: protocore.NewProto(hostKey, peerKey),
+func newProtobufAPIServer(
+	hostKey []byte,
+	peerKeys [][]byte,
+	keys *keyInfo,
+	config *serverConfig,
+	policy *accessPolicy,
+	metrics MetricsSink,
+	linodeConfig *linodeConfig,
+) *protobufAPIServer {
+	s := &protobufAPIServer{
+		keys:         keys,
+		config:       config,
+		policy:       policy,
+		metrics:      metrics,
+		linodeConfig: linodeConfig,
+
+		streamsClosed: make(chan struct{}),
+	}
+	for _, peerKey := range peerKeys {
+		s.protos = append(s.protos, protocore.NewProto(hostKey, peerKey))
 	}
+	return s
 }
 
 func (s *protobufAPIServer) Routes() chi.Router {
 	r := chi.NewRouter()
+	r.Get("/stream/*", s.handleStream)
 	r.Get("/*", s.handleVerb)
+	r.Post("/", s.handleVerb)
 	return r
 }
 
 func (s *protobufAPIServer) handleVerb(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 
-	// Decode base64 payload.
-	b64Data := strings.TrimSpace(chi.URLParam(r, "*"))
-	if len(b64Data) == 0 {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		http.Error(w, "empty verb", 400)
+	request, proto := s.readRequest(w, r)
+	if request == nil {
 		return
 	}
-	ciphertext, err := base64.RawStdEncoding.DecodeString(b64Data)
+
+	// Verbs that provision instances block until the instance is up, so they
+	// are allowed to outlive the await, others are cut short. Streams are
+	// long-lived by design and are not subject to the timeout.
+	ctx, cancel := context.WithTimeout(r.Context(), s.linodeConfig.requestTimeout(s.verbName(request)))
+	defer cancel()
+	s.dispatchVerb(request, proto, w, r.WithContext(ctx))
+}
+
+// readRequest decodes and decrypts request carried in the URL, or in the body
+// of POST request. Proto of the peer key that decrypted the request is
+// returned along, responses must be encrypted with it. On failure the error is
+// written to the client and nil is returned.
+func (s *protobufAPIServer) readRequest(w http.ResponseWriter, r *http.Request) (*protoapi.Request, *protocore.Proto) {
+	var ciphertext []byte
+	var err error
+	if r.Method == http.MethodPost {
+		ciphertext, err = s.readBody(r)
+	} else {
+		ciphertext, err = s.decodeVerb(strings.TrimSpace(chi.URLParam(r, "*")))
+	}
 	if err != nil {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		http.Error(w, "verb decode error: "+err.Error(), 400)
-		return
+		http.Error(w, err.Error(), 400)
+		return nil, nil
 	}
 
-	// Decrypt message.
-	request := &protoapi.Request{}
-	err = s.proto.ReadMessage(request, ciphertext)
-	if err != nil {
+	// Decrypt message with each peer key until one fits.
+	var request *protoapi.Request
+	var proto *protocore.Proto
+	for n, candidate := range s.protos {
+		request = &protoapi.Request{}
+		if err = candidate.ReadMessage(request, ciphertext); err == nil {
+			log.WithField("peer_key", n).Debug("Request was decrypted")
+			proto = candidate
+			break
+		}
+	}
+	if proto == nil {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		http.Error(w, "verb decode error: "+err.Error(), 400)
-		return
+		return nil, nil
+	}
+
+	if err := s.checkProtocolVersion(request); err != nil {
+		s.logRequest(r, "Rejected request from incompatible client")
+		newProtobufHTTPWriter(w, proto).WriteError(s.createErrorResponse(err), err)
+		return nil, nil
+	}
+	return request, proto
+}
+
+// readBody reads ciphertext from the request body. Body is raw ciphertext,
+// unless it is sent as text, in which case it is base64 like the URL verb.
+func (s *protobufAPIServer) readBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "verb read error")
+	}
+	if len(body) > maxRequestBodySize {
+		return nil, errors.New("verb is too large")
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/plain") {
+		return s.decodeVerb(strings.TrimSpace(string(body)))
+	}
+	if len(body) == 0 {
+		return nil, errors.New("empty verb")
+	}
+	return body, nil
+}
+
+// decodeVerb decodes base64 ciphertext.
+func (s *protobufAPIServer) decodeVerb(b64Data string) ([]byte, error) {
+	if len(b64Data) == 0 {
+		return nil, errors.New("empty verb")
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(b64Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "verb decode error")
+	}
+	return ciphertext, nil
+}
+
+func (s *protobufAPIServer) checkProtocolVersion(v *protoapi.Request) error {
+	version := v.GetProtocolVersion()
+	if version == 0 {
+		// Legacy clients don't send protocol version at all.
+		version = minProtocolVersion
 	}
-	s.dispatchVerb(request, w, r)
+	if version < minProtocolVersion || version > maxProtocolVersion {
+		return newHolepuncherError(
+			protoapi.HolepuncherError_UNSUPPORTED_PROTOCOL_VERSION,
+			"Unsupported protocol version %d (supported versions: %d-%d)",
+			version, minProtocolVersion, maxProtocolVersion,
+		)
+	}
+	return nil
 }
 
-func (s *protobufAPIServer) dispatchVerb(v *protoapi.Request, w http.ResponseWriter, r *http.Request) {
-	writer := newProtobufHTTPWriter(w, s.proto)
+func (s *protobufAPIServer) dispatchVerb(
+	v *protoapi.Request,
+	proto *protocore.Proto,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	writer := newProtobufHTTPWriter(w, proto)
+
+	verb := s.verbName(v)
+	start := time.Now()
+	s.metrics.SetGauge("requests_in_flight", float64(atomic.AddInt64(&s.inFlight, 1)), nil)
+	defer func() {
+		labels := map[string]string{"verb": verb}
+		s.metrics.IncCounter("requests_total", labels)
+		if writer.err != nil {
+			s.metrics.IncCounter("request_errors_total", map[string]string{
+				"verb": verb,
+				"type": errorType(writer.err),
+			})
+		}
+		s.metrics.ObserveHistogram("request_duration_seconds", time.Since(start).Seconds(), labels)
+		s.metrics.SetGauge("requests_in_flight", float64(atomic.AddInt64(&s.inFlight, -1)), nil)
+	}()
+
+	if err := s.policy.Authorize(v.GetAccessToken(), verb); err != nil {
+		s.logRequest(r, "Rejected request: "+err.Error())
+		writer.WriteError(s.createErrorResponse(err), err)
+		return
+	}
+	if adminVerbs[verb] {
+		if err := s.policy.AuthorizeAdmin(v.GetAccessToken()); err != nil {
+			s.logRequest(r, "Rejected request: "+err.Error())
+			writer.WriteError(s.createErrorResponse(err), err)
+			return
+		}
+	}
+	newProvider, err := selectTunnelProvider(v.GetProvider(), verb)
+	if err != nil {
+		s.logRequest(r, "Rejected request: "+err.Error())
+		writer.WriteError(s.createErrorResponse(err), err)
+		return
+	}
 
 	if args := v.GetLinodeCreateTunnel(); args != nil {
 		s.logRequest(r, "Got request to create tunnel")
-		newProtobufLinode(writer).CreateTunnel(args)
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).CreateTunnel(args)
 	} else if args := v.GetLinodeDestroyTunnel(); args != nil {
 		s.logRequest(r, "Got request to destroy tunnel")
-		newProtobufLinode(writer).DestroyTunnel(args)
+		linode := newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider)
+		if err := s.overrideLabelPrefix(r, v, linode, args.LabelPrefixOverride); err != nil {
+			writer.WriteError(s.createErrorResponse(err), err)
+			return
+		}
+		linode.DestroyTunnel(args)
+	} else if args := v.GetLinodeCloneTunnel(); args != nil {
+		s.logRequest(r, "Got request to clone tunnel")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).CloneTunnel(args)
 	} else if args := v.GetLinodeRebuildTunnel(); args != nil {
 		s.logRequest(r, "Got request to rebuild tunnel")
-		newProtobufLinode(writer).RebuildTunnel(args)
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).RebuildTunnel(args)
 	} else if args := v.GetLinodeTunnelStatus(); args != nil {
 		s.logRequest(r, "Got request to retrieve tunnel status")
-		newProtobufLinode(writer).TunnelStatus(args)
+		linode := newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider)
+		if err := s.overrideLabelPrefix(r, v, linode, args.LabelPrefixOverride); err != nil {
+			writer.WriteError(s.createErrorResponse(err), err)
+			return
+		}
+		linode.TunnelStatus(args)
+	} else if args := v.GetLinodeAcceptMaintenance(); args != nil {
+		s.logRequest(r, "Got request to accept scheduled maintenance")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).AcceptMaintenance(args)
+	} else if args := v.GetLinodeResizeTunnelDisk(); args != nil {
+		s.logRequest(r, "Got request to resize tunnel disk")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ResizeTunnelDisk(args)
+	} else if args := v.GetLinodeGetTunnelSpecDiff(); args != nil {
+		s.logRequest(r, "Got request to compare tunnel spec")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetTunnelSpecDiff(args)
+	} else if args := v.GetLinodeGetTunnelFirewall(); args != nil {
+		s.logRequest(r, "Got request to retrieve tunnel firewall")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetTunnelFirewall(args)
+	} else if args := v.GetLinodeUpdateTunnelFirewall(); args != nil {
+		s.logRequest(r, "Got request to update tunnel firewall")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).UpdateTunnelFirewall(args)
+	} else if args := v.GetLinodeGetTransferUsage(); args != nil {
+		s.logRequest(r, "Got request to retrieve transfer usage")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetTransferUsage(args)
+	} else if args := v.GetLinodeGetTransferForecast(); args != nil {
+		s.logRequest(r, "Got request to forecast transfer usage")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetTransferForecast(args)
+	} else if args := v.GetLinodeListTunnelBackups(); args != nil {
+		s.logRequest(r, "Got request to list tunnel backups")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListTunnelBackups(args)
+	} else if args := v.GetLinodeRestoreTunnelBackup(); args != nil {
+		s.logRequest(r, "Got request to restore tunnel backup")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).RestoreTunnelBackup(args)
+	} else if args := v.GetLinodeRescueTunnel(); args != nil {
+		s.logRequest(r, "Got request to boot tunnel into rescue mode")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).RescueTunnel(args)
+	} else if args := v.GetLinodeBootTunnel(); args != nil {
+		s.logRequest(r, "Got request to boot tunnel")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).BootTunnel(args)
+	} else if args := v.GetLinodeRebootTunnel(); args != nil {
+		s.logRequest(r, "Got request to reboot tunnel")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).RebootTunnel(args)
+	} else if args := v.GetLinodeSetTunnelReverseDNS(); args != nil {
+		s.logRequest(r, "Got request to set tunnel reverse DNS")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).SetTunnelReverseDNS(args)
+	} else if args := v.GetLinodeAddTunnelIP(); args != nil {
+		s.logRequest(r, "Got request to add tunnel IP")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).AddTunnelIP(args)
+	} else if args := v.GetLinodeGetTunnelNetworkDetails(); args != nil {
+		s.logRequest(r, "Got request to retrieve tunnel network details")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetTunnelNetworkDetails(args)
+	} else if args := v.GetLinodePreviewTunnelConfig(); args != nil {
+		s.logRequest(r, "Got request to preview tunnel config")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).PreviewTunnelConfig(args)
+	} else if args := v.GetLinodeGetReliabilityStats(); args != nil {
+		s.logRequest(r, "Got request to retrieve reliability stats")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetReliabilityStats(args)
+	} else if args := v.GetLinodeListTunnelConfigs(); args != nil {
+		s.logRequest(r, "Got request to list tunnel configs")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListTunnelConfigs(args)
+	} else if args := v.GetLinodeShutdownTunnel(); args != nil {
+		s.logRequest(r, "Got request to shut down tunnel")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ShutdownTunnel(args)
+	} else if args := v.GetLinodeResizeTunnel(); args != nil {
+		s.logRequest(r, "Got request to resize tunnel")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ResizeTunnel(args)
+	} else if args := v.GetLinodeGetCostHistory(); args != nil {
+		s.logRequest(r, "Got request to retrieve cost history")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetCostHistory(args)
+	} else if args := v.GetLinodeCanCreate(); args != nil {
+		s.logRequest(r, "Got request to check whether tunnel can be created")
+		policyErr := s.policy.Authorize(v.GetAccessToken(), "LinodeCreateTunnel")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).CanCreate(args, policyErr)
+	} else if args := v.GetLinodeExportInventory(); args != nil {
+		s.logRequest(r, "Got request to export tunnel inventory")
+		linode := newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider)
+		if err := s.overrideLabelPrefix(r, v, linode, args.LabelPrefixOverride); err != nil {
+			writer.WriteError(s.createErrorResponse(err), err)
+			return
+		}
+		linode.ExportInventory(args)
+	} else if args := v.GetLinodeBatchTunnelStatus(); args != nil {
+		s.logRequest(r, "Got request to retrieve status of multiple tunnels")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).BatchTunnelStatus(args)
+	} else if args := v.GetLinodeGetProviderStatus(); args != nil {
+		s.logRequest(r, "Got request to retrieve provider status")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).GetProviderStatus(args)
 	} else if args := v.GetLinodeListInstances(); args != nil {
 		s.logRequest(r, "Got request to list Linode instances")
-		newProtobufLinode(writer).ListInstances(args)
+		linode := newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider)
+		if err := s.overrideLabelPrefix(r, v, linode, args.LabelPrefixOverride); err != nil {
+			writer.WriteError(s.createErrorResponse(err), err)
+			return
+		}
+		linode.ListInstances(args)
+	} else if args := v.GetLinodeListTunnels(); args != nil {
+		s.logRequest(r, "Got request to list tunnels")
+		linode := newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider)
+		if err := s.overrideLabelPrefix(r, v, linode, args.LabelPrefixOverride); err != nil {
+			writer.WriteError(s.createErrorResponse(err), err)
+			return
+		}
+		linode.ListTunnels(args)
 	} else if args := v.GetLinodeListPlans(); args != nil {
 		s.logRequest(r, "Got request to list Linode instance types")
-		newProtobufLinode(writer).ListPlans(args)
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListPlans(args)
 	} else if args := v.GetLinodeListRegions(); args != nil {
 		s.logRequest(r, "Got request to list Linode regions")
-		newProtobufLinode(writer).ListRegions(args)
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListRegions(args)
+	} else if args := v.GetLinodeListKernels(); args != nil {
+		s.logRequest(r, "Got request to list Linode kernels")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListKernels(args)
 	} else if args := v.GetLinodeListImages(); args != nil {
 		s.logRequest(r, "Got request to list Linode images")
-		newProtobufLinode(writer).ListImages(args)
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListImages(args)
+	} else if args := v.GetLinodeListCompatibleImages(); args != nil {
+		s.logRequest(r, "Got request to list images compatible with StackScript")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListCompatibleImages(args)
 	} else if args := v.GetLinodeListStackscripts(); args != nil {
 		s.logRequest(r, "Got request to list Linode StackScripts")
-		newProtobufLinode(writer).ListStackScripts(args)
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListStackScripts(args)
+	} else if args := v.GetLinodeCreateStackScript(); args != nil {
+		s.logRequest(r, "Got request to create StackScript")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).CreateStackScript(args)
+	} else if args := v.GetLinodeUpdateStackScript(); args != nil {
+		s.logRequest(r, "Got request to update StackScript")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).UpdateStackScript(args)
+	} else if args := v.GetLinodeDeleteStackScript(); args != nil {
+		s.logRequest(r, "Got request to delete StackScript")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).DeleteStackScript(args)
+	} else if args := v.GetLinodeListSshKeys(); args != nil {
+		s.logRequest(r, "Got request to list profile SSH keys")
+		newProtobufLinode(r.Context(), writer, s.linodeConfig, newProvider).ListSSHKeys(args)
+	} else if args := v.GetServerGetKeyInfo(); args != nil {
+		s.logRequest(r, "Got request to retrieve key info")
+		s.GetKeyInfo(writer, args)
+	} else if args := v.GetServerGetConfig(); args != nil {
+		s.logRequest(r, "Got request to retrieve server config")
+		s.GetConfig(writer, args)
 	} else {
 		render.Status(r, 400)
 		render.PlainText(w, r, "unsupported request")
 	}
 }
 
+func (s *protobufAPIServer) GetKeyInfo(writer aProtobufWriter, args *protoapi.ServerGetKeyInfoRequest) error {
+	return writer.WriteMessage(&protoapi.Response{
+		R: &protoapi.Response_ServerGetKeyInfoResult{
+			ServerGetKeyInfoResult: &protoapi.ServerGetKeyInfoResponse{
+				KeyInfo: s.keys.toProtobuf(),
+			},
+		},
+	})
+}
+
+// overrideLabelPrefix makes the handler look up tunnels under another label
+// prefix, e.g. one used by legacy deployments. Only admins may do so, the
+// override is silently ignored for other tokens.
+func (s *protobufAPIServer) overrideLabelPrefix(
+	r *http.Request,
+	v *protoapi.Request,
+	linode *protobufLinode,
+	prefix string,
+) error {
+	if len(prefix) == 0 || s.policy.AuthorizeAdmin(v.GetAccessToken()) != nil {
+		return nil
+	}
+	if !namespaceRe.MatchString(prefix) {
+		return errors.Errorf("Invalid label prefix: %s", prefix)
+	}
+	s.logRequest(r, "Overriding label prefix with "+prefix)
+	linode.labelPrefix = prefix
+	return nil
+}
+
+func (s *protobufAPIServer) GetConfig(writer aProtobufWriter, args *protoapi.ServerGetConfigRequest) error {
+	return writer.WriteMessage(&protoapi.Response{
+		R: &protoapi.Response_ServerGetConfigResult{
+			ServerGetConfigResult: &protoapi.ServerGetConfigResponse{
+				Config: s.config.toProtobuf(),
+			},
+		},
+	})
+}
+
+// verbName returns name of the verb carried by the request, for example
+// "LinodeCreateTunnel".
+func (s *protobufAPIServer) verbName(v *protoapi.Request) string {
+	if v.V == nil {
+		return "unknown"
+	}
+	return strings.TrimPrefix(reflect.TypeOf(v.V).Elem().Name(), "Request_")
+}
+
+// createErrorResponse produces a verb-agnostic error response for failures
+// that happen before the request could be dispatched.
+func (s *protobufAPIServer) createErrorResponse(err error) *protoapi.Response {
+	papiError := &protoapi.HolepuncherError{Message: err.Error()}
+	if hpErr, ok := errors.Cause(err).(*HolepuncherError); ok {
+		papiError.Code = hpErr.Code
+	}
+	return &protoapi.Response{
+		R: &protoapi.Response_Error{Error: papiError},
+	}
+}
+
 func (s *protobufAPIServer) logRequest(r *http.Request, msg string) {
 	fields := log.Fields{
 		"ip": r.RemoteAddr,
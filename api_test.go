@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http/httptest"
+	"protoapi"
+	"strings"
+	"testing"
+)
+
+func TestCheckProtocolVersion(t *testing.T) {
+	s := &protobufAPIServer{}
+
+	for _, version := range []uint32{0, minProtocolVersion, maxProtocolVersion} {
+		if err := s.checkProtocolVersion(&protoapi.Request{ProtocolVersion: version}); err != nil {
+			t.Errorf("version %d: got error %v", version, err)
+		}
+	}
+
+	err := s.checkProtocolVersion(&protoapi.Request{ProtocolVersion: maxProtocolVersion + 1})
+	if code := errorCode(t, err); code != protoapi.HolepuncherError_UNSUPPORTED_PROTOCOL_VERSION {
+		t.Errorf("got code %v, want UNSUPPORTED_PROTOCOL_VERSION", code)
+	}
+}
+
+func TestCreateErrorResponseKeepsCode(t *testing.T) {
+	s := &protobufAPIServer{}
+	err := newHolepuncherError(protoapi.HolepuncherError_UNSUPPORTED_PROTOCOL_VERSION, "Unsupported")
+
+	response := s.createErrorResponse(err)
+	result, ok := response.R.(*protoapi.Response_Error)
+	if !ok {
+		t.Fatalf("got response %+v, want error", response.R)
+	}
+	if result.Error.Code != protoapi.HolepuncherError_UNSUPPORTED_PROTOCOL_VERSION {
+		t.Errorf("got code %v, want UNSUPPORTED_PROTOCOL_VERSION", result.Error.Code)
+	}
+}
+
+// newTestAPIServer returns API server working with the fake Linode, without
+// access policy.
+func newTestAPIServer(linode *fakeLinode, metrics MetricsSink) *protobufAPIServer {
+	key := make([]byte, keySize)
+	return newProtobufAPIServer(key, [][]byte{key}, nil, &serverConfig{}, nil, metrics, newTestLinodeConfig(linode))
+}
+
+// dispatch dispatches the request as if it was sent by a client.
+func dispatch(s *protobufAPIServer, request *protoapi.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.dispatchVerb(request, s.protos[0], w, httptest.NewRequest("POST", "/proto/", nil))
+	return w
+}
+
+func TestDispatchVerbInstrumentsCreate(t *testing.T) {
+	metrics := &fakeMetricsSink{}
+	s := newTestAPIServer(newFakeLinode(t), metrics)
+
+	dispatch(s, &protoapi.Request{V: &protoapi.Request_LinodeCreateTunnel{
+		LinodeCreateTunnel: &protoapi.LinodeCreateTunnelRequest{
+			Auth:   testAuth(),
+			Region: "us-east",
+			Plan:   "g6-nanode-1",
+		},
+	}})
+
+	for _, call := range []string{
+		"counter requests_total{verb=LinodeCreateTunnel}",
+		"histogram request_duration_seconds{verb=LinodeCreateTunnel}",
+		"gauge requests_in_flight{}",
+	} {
+		if !metrics.called(call) {
+			t.Errorf("missing metric call %s, got %v", call, metrics.calls)
+		}
+	}
+	for _, call := range metrics.calls {
+		if strings.HasPrefix(call, "counter request_errors_total") {
+			t.Errorf("successful create was counted as error: %s", call)
+		}
+	}
+}
+
+func TestOverrideLabelPrefixRequiresAdmin(t *testing.T) {
+	s := newTestAPIServer(newFakeLinode(t), &fakeMetricsSink{})
+	s.policy = &accessPolicy{tokens: map[string]*accessPolicyEntry{
+		hashToken("admin-token"): {verbs: []string{"*"}, admin: true},
+		hashToken("user-token"):  {verbs: []string{"*"}},
+	}}
+	r := httptest.NewRequest("POST", "/proto/", nil)
+
+	cases := []struct {
+		token  string
+		prefix string
+		want   string
+	}{
+		{"admin-token", "legacy", "legacy"},
+		{"admin-token", "", "hp"},
+		// Ignored silently for other tokens.
+		{"user-token", "legacy", "hp"},
+	}
+	for _, c := range cases {
+		linode, _ := newTestProtobufLinode(newFakeLinode(t))
+		err := s.overrideLabelPrefix(r, &protoapi.Request{AccessToken: c.token}, linode, c.prefix)
+		if err != nil {
+			t.Errorf("%s/%s: got error %v", c.token, c.prefix, err)
+		}
+		if linode.labelPrefix != c.want {
+			t.Errorf("%s/%s: got prefix %s, want %s", c.token, c.prefix, linode.labelPrefix, c.want)
+		}
+	}
+
+	linode, _ := newTestProtobufLinode(newFakeLinode(t))
+	if err := s.overrideLabelPrefix(r, &protoapi.Request{AccessToken: "admin-token"}, linode, "bad_prefix"); err == nil {
+		t.Error("invalid prefix was accepted")
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	s := &protobufAPIServer{}
+	ciphertext := []byte{0x00, 0x01, 0xfe, 0xff}
+
+	r := httptest.NewRequest("POST", "/proto/", bytes.NewReader(ciphertext))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	if body, err := s.readBody(r); err != nil || !bytes.Equal(body, ciphertext) {
+		t.Errorf("got %x, %v for raw body", body, err)
+	}
+
+	// Text body is base64 like the URL verb.
+	encoded := base64.RawStdEncoding.EncodeToString(ciphertext)
+	r = httptest.NewRequest("POST", "/proto/", strings.NewReader(encoded+"\n"))
+	r.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if body, err := s.readBody(r); err != nil || !bytes.Equal(body, ciphertext) {
+		t.Errorf("got %x, %v for text body", body, err)
+	}
+}
+
+func TestReadBodyErrors(t *testing.T) {
+	s := &protobufAPIServer{}
+
+	for _, test := range []struct {
+		name        string
+		body        []byte
+		contentType string
+	}{
+		{"empty", nil, "application/octet-stream"},
+		{"empty text", []byte(" \n"), "text/plain"},
+		{"bad base64", []byte("not base64!"), "text/plain"},
+		{"too large", make([]byte, maxRequestBodySize+1), "application/octet-stream"},
+	} {
+		r := httptest.NewRequest("POST", "/proto/", bytes.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+		if _, err := s.readBody(r); err == nil {
+			t.Errorf("%s body was accepted", test.name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// auditLogger writes tunnel lifecycle events as JSON lines, separately from
+// the server log. Nil logger discards events.
+type auditLogger struct {
+	logger *log.Logger
+}
+
+// auditEvent describes a single create, clone, rebuild or destroy. Instance
+// is nil when the action failed before the instance was known.
+type auditEvent struct {
+	Action    string
+	Namespace string
+	Name      string
+	Region    string
+	Plan      string
+	Instance  *Tunnel
+	Err       error
+}
+
+type clientIPKey struct{}
+
+func newAuditLogger(filename string) (*auditLogger, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open audit log")
+	}
+
+	logger := log.New()
+	logger.Out = file
+	logger.Formatter = &log.JSONFormatter{}
+	logger.Level = log.InfoLevel
+	return &auditLogger{logger: logger}, nil
+}
+
+// Record writes the event along with the IP of the client that requested
+// the action.
+func (a *auditLogger) Record(ctx context.Context, event *auditEvent) {
+	if a == nil {
+		return
+	}
+
+	fields := log.Fields{
+		"action":    event.Action,
+		"namespace": event.Namespace,
+		"name":      event.Name,
+		"client_ip": clientIPFromContext(ctx),
+	}
+	if instance := event.Instance; instance != nil {
+		fields["instance_id"] = instance.ID
+		fields["label"] = instance.Label
+		fields["region"] = instance.Region
+		fields["plan"] = instance.Plan
+	} else {
+		fields["region"] = event.Region
+		fields["plan"] = event.Plan
+	}
+
+	if event.Err != nil {
+		fields["outcome"] = "failure"
+		fields["error"] = event.Err.Error()
+		a.logger.WithFields(fields).Warn("Tunnel " + event.Action + " failed")
+		return
+	}
+	fields["outcome"] = "success"
+	a.logger.WithFields(fields).Info("Tunnel " + event.Action + " succeeded")
+}
+
+// withClientIP stores client IP in the request context for the rate limiter
+// and the audit log.
+func withClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
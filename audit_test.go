@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"protoapi"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// readAuditLog decodes events written to the audit log.
+func readAuditLog(t *testing.T, filename string) []map[string]interface{} {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Audit log line %q isn't JSON: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestAuditLogRecord(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLogger(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withClientIP(context.Background(), "203.0.113.7")
+	audit.Record(ctx, &auditEvent{
+		Action:   "create",
+		Name:     "instance",
+		Region:   "us-west",
+		Instance: &Tunnel{ID: 1, Label: "hp_instance", Region: "us-east", Plan: "g6-nanode-1"},
+	})
+	audit.Record(ctx, &auditEvent{
+		Action: "create",
+		Name:   "instance",
+		Region: "us-east",
+		Plan:   "g6-nanode-1",
+		Err:    errors.New("Region is sold out"),
+	})
+
+	events := readAuditLog(t, filename)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	success := events[0]
+	if success["outcome"] != "success" || success["instance_id"] != float64(1) || success["client_ip"] != "203.0.113.7" {
+		t.Errorf("got success event %v", success)
+	}
+	// Instance is where the tunnel really is.
+	if success["region"] != "us-east" || success["plan"] != "g6-nanode-1" {
+		t.Errorf("got region %v, plan %v of instance", success["region"], success["plan"])
+	}
+	failure := events[1]
+	if failure["outcome"] != "failure" || failure["error"] != "Region is sold out" || failure["region"] != "us-east" {
+		t.Errorf("got failure event %v", failure)
+	}
+	if _, ok := failure["instance_id"]; ok {
+		t.Errorf("got instance in failure event %v", failure)
+	}
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	var audit *auditLogger
+	audit.Record(context.Background(), &auditEvent{Action: "destroy"})
+}
+
+func TestCreateTunnelIsAudited(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	config := newTestLinodeConfig(newFakeLinode(t))
+	var err error
+	if config.audit, err = newAuditLogger(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		ctx := withClientIP(context.Background(), "203.0.113.7")
+		writer := &protobufCaptureWriter{}
+		if err := newProtobufLinode(ctx, writer, config, newLinodeTunnelProvider).CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+			Auth:   testAuth(),
+			Region: "us-east",
+			Plan:   "g6-nanode-1",
+			DryRun: dryRun,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+	}
+
+	// Dry run provisions nothing.
+	events := readAuditLog(t, filename)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want create only", len(events))
+	}
+	if events[0]["action"] != "create" || events[0]["instance_id"] != float64(1001) || events[0]["client_ip"] != "203.0.113.7" {
+		t.Errorf("got event %v", events[0])
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	log "github.com/sirupsen/logrus"
+)
+
+// drainController coordinates connection draining before shutdown. Once
+// draining starts, readiness probe reports 503 so that load balancers stop
+// routing new traffic, while the server keeps serving requests for the
+// duration of the drain window.
+type drainController struct {
+	window  time.Duration
+	policy  *accessPolicy
+	once    sync.Once
+	started chan struct{}
+	done    chan struct{}
+}
+
+func newDrainController(window time.Duration, policy *accessPolicy) *drainController {
+	return &drainController{
+		window:  window,
+		policy:  policy,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start puts server into draining state. Subsequent calls have no effect.
+func (d *drainController) Start() {
+	d.once.Do(func() {
+		log.WithField("window", d.window).Info("Draining server before shutdown")
+		close(d.started)
+		go func() {
+			time.Sleep(d.window)
+			close(d.done)
+		}()
+	})
+}
+
+// IsDraining checks whether draining was started.
+func (d *drainController) IsDraining() bool {
+	select {
+	case <-d.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// Started returns a channel that is closed when draining starts.
+func (d *drainController) Started() <-chan struct{} {
+	return d.started
+}
+
+// Done returns a channel that is closed when the drain window elapses.
+func (d *drainController) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *drainController) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	if d.IsDraining() {
+		render.Status(r, http.StatusServiceUnavailable)
+		render.PlainText(w, r, "draining")
+		return
+	}
+	render.PlainText(w, r, "ok")
+}
+
+func (d *drainController) handleDrain(w http.ResponseWriter, r *http.Request) {
+	// Draining is an operator action, it requires an admin access token
+	// passed as "Authorization: Bearer <token>". Source address isn't
+	// trusted, as behind a local reverse proxy every client is loopback.
+	// Without access policy draining can be started only by a signal.
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := d.policy.AuthorizeAdmin(token); err != nil {
+		render.Status(r, http.StatusForbidden)
+		render.PlainText(w, r, "forbidden")
+		return
+	}
+
+	d.Start()
+	render.Status(r, http.StatusAccepted)
+	render.PlainText(w, r, "draining")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainRequiresAdminToken(t *testing.T) {
+	policy := &accessPolicy{tokens: map[string]*accessPolicyEntry{
+		hashToken("admin-token"): {verbs: []string{"*"}, admin: true},
+		hashToken("user-token"):  {verbs: []string{"*"}},
+	}}
+
+	for _, token := range []string{"", "user-token", "unknown-token"} {
+		drain := newDrainController(time.Minute, policy)
+		r := httptest.NewRequest("POST", "/drain", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		drain.handleDrain(w, r)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("token '%s': got status %d, want 403", token, w.Code)
+		}
+		if drain.IsDraining() {
+			t.Errorf("token '%s' started draining", token)
+		}
+	}
+
+	drain := newDrainController(time.Minute, policy)
+	r := httptest.NewRequest("POST", "/drain", nil)
+	r.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	drain.handleDrain(w, r)
+	if w.Code != http.StatusAccepted || !drain.IsDraining() {
+		t.Errorf("admin token: got status %d, draining %v", w.Code, drain.IsDraining())
+	}
+}
+
+func TestDrainFlipsReadinessAndKeepsServing(t *testing.T) {
+	drain := newDrainController(50*time.Millisecond, nil)
+	health := newHealthChecker(drain, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/readyz":
+			health.handleReady(w, r)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	get := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	drain.Start()
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness during drain: got status %d, want 503", code)
+	}
+	if code := get("/proto/"); code != http.StatusOK {
+		t.Errorf("request during drain: got status %d, want 200", code)
+	}
+
+	select {
+	case <-drain.Done():
+	case <-time.After(time.Second):
+		t.Error("drain window didn't elapse")
+	}
+}
+
+func TestShutdownOnDrainWaitsForRequests(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+
+	drain := newDrainController(0, nil)
+	stopped := make(chan struct{})
+	go shutdownOnDrain(server, drain, stopped)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-entered
+	drain.Start()
+
+	select {
+	case <-stopped:
+		t.Fatal("server stopped before in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("in-flight request: got status %d, want 200", code)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("server didn't stop")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"protoapi"
+)
+
+// HolepuncherError represents an error raised by the server itself, as opposed
+// to errors reported by Linode API. Code is stable and can be acted upon by
+// clients.
+type HolepuncherError struct {
+	Code    protoapi.HolepuncherError_Code
+	Message string
+}
+
+func newHolepuncherError(
+	code protoapi.HolepuncherError_Code,
+	format string,
+	args ...interface{},
+) *HolepuncherError {
+	return &HolepuncherError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *HolepuncherError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// firewallPort identifies a single port exposed by the tunnel.
+type firewallPort struct {
+	protocol string
+	port     int
+}
+
+// sshPort is always considered a core port of the tunnel, since it is the
+// only way to administer the instance.
+var sshPort = firewallPort{protocol: "TCP", port: 22}
+
+type portRange struct {
+	from int
+	to   int
+}
+
+// validateFirewallRules checks that every rule is well-formed: known action
+// and protocol, ports within range and addresses in CIDR notation.
+func validateFirewallRules(rules []LinodeFirewallRule) error {
+	for n, rule := range rules {
+		switch rule.Action {
+		case "ACCEPT", "DROP":
+		default:
+			return errors.Errorf("Rule #%d: invalid action '%s'", n, rule.Action)
+		}
+		switch rule.Protocol {
+		case "TCP", "UDP":
+			if _, err := parsePortRanges(rule.Ports); err != nil {
+				return errors.Wrapf(err, "Rule #%d", n)
+			}
+		case "ICMP", "IPENCAP":
+			if len(rule.Ports) > 0 {
+				return errors.Errorf("Rule #%d: ports can't be set for %s", n, rule.Protocol)
+			}
+		default:
+			return errors.Errorf("Rule #%d: invalid protocol '%s'", n, rule.Protocol)
+		}
+		for _, address := range append(rule.Addresses.IPv4, rule.Addresses.IPv6...) {
+			if _, _, err := net.ParseCIDR(address); err != nil {
+				return errors.Errorf("Rule #%d: invalid address '%s'", n, address)
+			}
+		}
+	}
+	return nil
+}
+
+// exposedFirewallPorts returns TCP/UDP ports that the ruleset accepts from
+// any address. These are the public services of the tunnel.
+func exposedFirewallPorts(rules LinodeFirewallRules) []firewallPort {
+	var ports []firewallPort
+	for _, rule := range rules.Inbound {
+		if rule.Action != "ACCEPT" || !isOpenToAnyone(rule) {
+			continue
+		}
+		ranges, err := parsePortRanges(rule.Ports)
+		if err != nil {
+			continue
+		}
+		for _, r := range ranges {
+			// Wide ranges are not services, but rather a relaxed policy.
+			if r.to-r.from > 16 {
+				continue
+			}
+			for port := r.from; port <= r.to; port++ {
+				ports = append(ports, firewallPort{protocol: rule.Protocol, port: port})
+			}
+		}
+	}
+	return ports
+}
+
+// firewallAllowsPort checks whether the ruleset lets the port through. Rules
+// are matched in order, the first matching rule wins; inbound policy applies
+// when no rule matches.
+func firewallAllowsPort(rules LinodeFirewallRules, port firewallPort) bool {
+	for _, rule := range rules.Inbound {
+		if rule.Protocol != port.protocol {
+			continue
+		}
+		ranges, err := parsePortRanges(rule.Ports)
+		if err != nil {
+			continue
+		}
+		for _, r := range ranges {
+			if port.port >= r.from && port.port <= r.to {
+				return rule.Action == "ACCEPT"
+			}
+		}
+	}
+	return rules.InboundPolicy != "DROP"
+}
+
+func isOpenToAnyone(rule LinodeFirewallRule) bool {
+	for _, address := range append(rule.Addresses.IPv4, rule.Addresses.IPv6...) {
+		if address == "0.0.0.0/0" || address == "::/0" {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePortRanges parses Linode port specification, e.g. "22,80,1000-2000".
+// Empty specification means all ports.
+func parsePortRanges(spec string) ([]portRange, error) {
+	if len(strings.TrimSpace(spec)) == 0 {
+		return []portRange{{from: 1, to: 65535}}, nil
+	}
+
+	var ranges []portRange
+	for _, item := range strings.Split(spec, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(item), "-", 2)
+		from, err := parsePort(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = parsePort(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if from > to {
+			return nil, errors.Errorf("invalid port range '%s'", item)
+		}
+		ranges = append(ranges, portRange{from: from, to: to})
+	}
+	return ranges, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, errors.Errorf("invalid port '%s'", s)
+	}
+	return port, nil
+}
+
+// tunnelFirewallRules makes the ruleset of a new tunnel firewall. Unless the
+// spec has its own inbound rules, only ports of the configured tunnel
+// services are open and everything else is dropped.
+func tunnelFirewallRules(spec *TunnelSpec) (*LinodeFirewallRules, error) {
+	rules := &LinodeFirewallRules{
+		InboundPolicy:  "DROP",
+		Inbound:        spec.Firewall.Inbound,
+		OutboundPolicy: "ACCEPT",
+		Outbound:       []LinodeFirewallRule{},
+	}
+	if len(rules.Inbound) > 0 {
+		if err := validateFirewallRules(rules.Inbound); err != nil {
+			return nil, err
+		}
+		return rules, nil
+	}
+
+	accept := func(label string, protocol string, port int) {
+		rule := LinodeFirewallRule{
+			Action:   "ACCEPT",
+			Label:    label,
+			Protocol: protocol,
+			Ports:    strconv.Itoa(port),
+		}
+		rule.Addresses.IPv4 = []string{"0.0.0.0/0"}
+		rule.Addresses.IPv6 = []string{"::/0"}
+		rules.Inbound = append(rules.Inbound, rule)
+	}
+	if spec.WireGuard != nil {
+		accept("wireguard", strings.ToUpper(spec.WireGuard.Protocol()), spec.WireGuard.Port)
+	}
+	if spec.Obfsproxy4 != nil {
+		accept("obfs4-ipv4", "TCP", spec.Obfsproxy4.Port)
+	}
+	if spec.Obfsproxy6 != nil && (spec.Obfsproxy4 == nil || spec.Obfsproxy6.Port != spec.Obfsproxy4.Port) {
+		accept("obfs4-ipv6", "TCP", spec.Obfsproxy6.Port)
+	}
+	if len(rules.Inbound) == 0 {
+		return nil, errors.New("Firewall would block all traffic, no tunnel services are configured")
+	}
+	return rules, nil
+}
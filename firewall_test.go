@@ -0,0 +1,403 @@
+package main
+
+import (
+	"net/http"
+	"protoapi"
+	"strings"
+	"testing"
+)
+
+// firewallInstance is the tunnel instance served along with firewallRoutes.
+var firewallInstance = LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}
+
+// firewallRoutes serve the firewall of the tunnel instance, whose rules can
+// be updated. Nil firewall means that the instance has no firewall attached.
+func firewallRoutes(firewall *LinodeFirewall) fakeRoutes {
+	page := &linodeFirewallPaginated{Pages: 1, Page: 1}
+	if firewall != nil {
+		page.Data = []LinodeFirewall{*firewall}
+		page.Results = 1
+	}
+	return fakeRoutes{
+		"GET /linode/instances/:id/firewalls": respond(http.StatusOK, page),
+		"GET /networking/firewalls/:id":       respond(http.StatusOK, firewall),
+		"PUT /networking/firewalls/:id/rules": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			var rules LinodeFirewallRules
+			f.body("PUT /networking/firewalls/:id/rules", &rules)
+			writeJSON(f.t, w, http.StatusOK, &rules)
+		},
+	}
+}
+
+// firewallRule makes a rule accepting the ports from the addresses.
+func firewallRule(protocol string, ports string, addresses ...string) LinodeFirewallRule {
+	rule := LinodeFirewallRule{Action: "ACCEPT", Protocol: protocol, Ports: ports}
+	rule.Addresses.IPv4 = addresses
+	return rule
+}
+
+func testFirewall() *LinodeFirewall {
+	return &LinodeFirewall{
+		ID:     7,
+		Label:  "hp_instance",
+		Status: "enabled",
+		Rules: LinodeFirewallRules{
+			InboundPolicy: "DROP",
+			Inbound: []LinodeFirewallRule{
+				firewallRule("TCP", "22", "203.0.113.0/24"),
+				firewallRule("TCP", "443", "0.0.0.0/0"),
+			},
+			OutboundPolicy: "ACCEPT",
+		},
+	}
+}
+
+func TestGetTunnelFirewall(t *testing.T) {
+	linode := newFakeLinode(t, firewallInstance).handle(firewallRoutes(testFirewall()))
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetTunnelFirewall(&protoapi.LinodeGetTunnelFirewallRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeGetTunnelFirewallResult).LinodeGetTunnelFirewallResult
+	firewall := result.Result.(*protoapi.LinodeGetTunnelFirewallResponse_Firewall).Firewall
+
+	if firewall.Id != 7 || firewall.InboundPolicy != "DROP" || firewall.OutboundPolicy != "ACCEPT" {
+		t.Errorf("got firewall %+v", firewall)
+	}
+	if len(firewall.Inbound) != 2 || len(firewall.Outbound) != 0 {
+		t.Fatalf("got %d inbound and %d outbound rules, want 2 and 0", len(firewall.Inbound), len(firewall.Outbound))
+	}
+	ssh := firewall.Inbound[0]
+	if ssh.Action != "ACCEPT" || ssh.Protocol != "TCP" || ssh.Ports != "22" ||
+		len(ssh.Ipv4) != 1 || ssh.Ipv4[0] != "203.0.113.0/24" {
+		t.Errorf("got rule %+v", ssh)
+	}
+}
+
+func TestGetTunnelFirewallNotAttached(t *testing.T) {
+	linode := newFakeLinode(t, firewallInstance).handle(firewallRoutes(nil))
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetTunnelFirewall(&protoapi.LinodeGetTunnelFirewallRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeGetTunnelFirewallResult).LinodeGetTunnelFirewallResult
+	if _, ok := result.Result.(*protoapi.LinodeGetTunnelFirewallResponse_NotAttached); !ok {
+		t.Errorf("got result %+v, want not attached", result.Result)
+	}
+}
+
+func TestUpdateTunnelFirewall(t *testing.T) {
+	linode := newFakeLinode(t, firewallInstance).handle(firewallRoutes(testFirewall()))
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.UpdateTunnelFirewall(&protoapi.LinodeUpdateTunnelFirewallRequest{
+			Auth: testAuth(),
+			Inbound: []*protoapi.LinodeFirewallRule{
+				{Action: "accept", Protocol: "tcp", Ports: "22", Ipv4: []string{"198.51.100.7/32"}},
+				{Action: "accept", Protocol: "tcp", Ports: "443", Ipv4: []string{"0.0.0.0/0"}},
+			},
+		})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	var rules LinodeFirewallRules
+	linode.body("PUT /networking/firewalls/:id/rules", &rules)
+	if rules.InboundPolicy != "DROP" || rules.OutboundPolicy != "ACCEPT" {
+		t.Errorf("policies weren't kept: %+v", rules)
+	}
+	if len(rules.Inbound) != 2 || rules.Inbound[0].Action != "ACCEPT" || rules.Inbound[0].Protocol != "TCP" ||
+		rules.Inbound[0].Addresses.IPv4[0] != "198.51.100.7/32" {
+		t.Errorf("got inbound rules %+v", rules.Inbound)
+	}
+
+	result := writer.response.R.(*protoapi.Response_LinodeUpdateTunnelFirewallResult).LinodeUpdateTunnelFirewallResult
+	firewall := result.Result.(*protoapi.LinodeUpdateTunnelFirewallResponse_Firewall).Firewall
+	if len(firewall.Inbound) != 2 || firewall.Inbound[0].Ipv4[0] != "198.51.100.7/32" {
+		t.Errorf("got firewall %+v", firewall)
+	}
+}
+
+func TestUpdateTunnelFirewallRefusesLockout(t *testing.T) {
+	// Dropping the public service port cuts clients off the tunnel.
+	inbound := []*protoapi.LinodeFirewallRule{
+		{Action: "ACCEPT", Protocol: "TCP", Ports: "22", Ipv4: []string{"198.51.100.7/32"}},
+	}
+
+	for _, force := range []bool{false, true} {
+		linode := newFakeLinode(t, firewallInstance).handle(firewallRoutes(testFirewall()))
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.UpdateTunnelFirewall(&protoapi.LinodeUpdateTunnelFirewallRequest{
+				Auth:    testAuth(),
+				Inbound: inbound,
+				Force:   force,
+			})
+		})
+		if force {
+			if writer.err != nil {
+				t.Errorf("forced update failed: %v", writer.err)
+			}
+			continue
+		}
+		if code := errorCode(t, writer.err); code != protoapi.HolepuncherError_FIREWALL_LOCKOUT {
+			t.Errorf("got code %v, want FIREWALL_LOCKOUT", code)
+		}
+		if linode.requested("PUT /networking/firewalls/:id/rules") {
+			t.Error("firewall rules were updated")
+		}
+	}
+}
+
+func TestUpdateTunnelFirewallValidatesRules(t *testing.T) {
+	for _, rule := range []*protoapi.LinodeFirewallRule{
+		{Action: "ALLOW", Protocol: "TCP", Ports: "22"},
+		{Action: "ACCEPT", Protocol: "SCTP", Ports: "22"},
+		{Action: "ACCEPT", Protocol: "TCP", Ports: "0"},
+		{Action: "ACCEPT", Protocol: "TCP", Ports: "2000-1000"},
+		{Action: "ACCEPT", Protocol: "ICMP", Ports: "22"},
+		{Action: "ACCEPT", Protocol: "TCP", Ports: "22", Ipv4: []string{"203.0.113.7"}},
+	} {
+		linode := newFakeLinode(t, firewallInstance).handle(firewallRoutes(testFirewall()))
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.UpdateTunnelFirewall(&protoapi.LinodeUpdateTunnelFirewallRequest{
+				Auth:    testAuth(),
+				Inbound: []*protoapi.LinodeFirewallRule{rule},
+				Force:   true,
+			})
+		})
+		if writer.err == nil {
+			t.Errorf("rule %+v was accepted", rule)
+		}
+	}
+}
+
+func TestFirewallAllowsPort(t *testing.T) {
+	rules := LinodeFirewallRules{
+		InboundPolicy: "DROP",
+		Inbound: []LinodeFirewallRule{
+			{Action: "DROP", Protocol: "TCP", Ports: "8080"},
+			firewallRule("TCP", "22,8000-9000", "0.0.0.0/0"),
+		},
+	}
+	cases := []struct {
+		port    firewallPort
+		allowed bool
+	}{
+		{firewallPort{"TCP", 22}, true},
+		{firewallPort{"TCP", 8500}, true},
+		{firewallPort{"TCP", 8080}, false},
+		{firewallPort{"UDP", 22}, false},
+		{firewallPort{"TCP", 443}, false},
+	}
+	for _, c := range cases {
+		if allowed := firewallAllowsPort(rules, c.port); allowed != c.allowed {
+			t.Errorf("%v: got %v, want %v", c.port, allowed, c.allowed)
+		}
+	}
+}
+
+func TestExposedFirewallPorts(t *testing.T) {
+	rules := LinodeFirewallRules{
+		Inbound: []LinodeFirewallRule{
+			firewallRule("TCP", "22", "203.0.113.0/24"),
+			firewallRule("TCP", "443,8443", "0.0.0.0/0"),
+			firewallRule("UDP", "1000-2000", "0.0.0.0/0"),
+		},
+	}
+	ports := exposedFirewallPorts(rules)
+	want := []firewallPort{{"TCP", 443}, {"TCP", 8443}}
+	if len(ports) != len(want) {
+		t.Fatalf("got ports %v, want %v", ports, want)
+	}
+	for n := range ports {
+		if ports[n] != want[n] {
+			t.Errorf("got ports %v, want %v", ports, want)
+		}
+	}
+}
+
+func TestTunnelFirewallRules(t *testing.T) {
+	rules, err := tunnelFirewallRules(&TunnelSpec{
+		WireGuard:  &WireGuardSpec{Port: 51820},
+		Obfsproxy4: &ObfsproxySpec{Port: 443},
+		Obfsproxy6: &ObfsproxySpec{Port: 443},
+		Firewall:   &FirewallSpec{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.InboundPolicy != "DROP" || rules.OutboundPolicy != "ACCEPT" {
+		t.Errorf("got policies %s/%s", rules.InboundPolicy, rules.OutboundPolicy)
+	}
+	// Obfsproxy sharing the port for both address families needs one rule.
+	ports := exposedFirewallPorts(*rules)
+	want := []firewallPort{{"UDP", 51820}, {"TCP", 443}}
+	if len(rules.Inbound) != 2 || len(ports) != len(want) || ports[0] != want[0] || ports[1] != want[1] {
+		t.Errorf("got rules %+v, want ports %v open", rules.Inbound, want)
+	}
+}
+
+func TestTunnelFirewallRulesFromSpec(t *testing.T) {
+	rule := firewallRule("TCP", "22", "203.0.113.0/24")
+	rules, err := tunnelFirewallRules(&TunnelSpec{
+		WireGuard: &WireGuardSpec{Port: 51820},
+		Firewall:  &FirewallSpec{Inbound: []LinodeFirewallRule{rule}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.Inbound) != 1 || rules.Inbound[0].Ports != "22" {
+		t.Errorf("got rules %+v, want rules of the spec only", rules.Inbound)
+	}
+
+	bad := firewallRule("SCTP", "22", "0.0.0.0/0")
+	if _, err := tunnelFirewallRules(&TunnelSpec{Firewall: &FirewallSpec{Inbound: []LinodeFirewallRule{bad}}}); err == nil {
+		t.Error("invalid rule was accepted")
+	}
+	// Nothing to let through.
+	if _, err := tunnelFirewallRules(&TunnelSpec{Firewall: &FirewallSpec{}}); err == nil {
+		t.Error("firewall blocking all traffic was accepted")
+	}
+}
+
+// createFirewallRoutes create firewalls and attach them to instances.
+var createFirewallRoutes = fakeRoutes{
+	"POST /networking/firewalls": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Label string `json:"label"`
+		}
+		f.body("POST /networking/firewalls", &body)
+		writeJSON(f.t, w, http.StatusOK, &LinodeFirewall{ID: 7, Label: body.Label, Status: "enabled"})
+	},
+	"POST /networking/firewalls/:id/devices": respond(http.StatusOK, struct{}{}),
+	"DELETE /networking/firewalls/:id":       respond(http.StatusOK, struct{}{}),
+}
+
+// firewalledTunnelRequest asks for a WireGuard tunnel behind a firewall.
+func firewalledTunnelRequest(t *testing.T) *protoapi.LinodeCreateTunnelRequest {
+	serverKey, _ := newWireGuardKey(t)
+	_, peerKey := newWireGuardKey(t)
+	return &protoapi.LinodeCreateTunnelRequest{
+		Auth:   testAuth(),
+		Region: "us-east",
+		Plan:   "g6-nanode-1",
+		WireguardOptions: &protoapi.WireguardOptions{
+			Port:      51820,
+			ServerKey: serverKey,
+			PeerKeys:  []string{peerKey},
+		},
+		FirewallRules: &protoapi.LinodeTunnelFirewallRules{},
+	}
+}
+
+func TestCreateTunnelWithFirewall(t *testing.T) {
+	linode := newFakeLinode(t).handle(createFirewallRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CreateTunnel(firewalledTunnelRequest(t))
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeCreateTunnelResult).LinodeCreateTunnelResult
+	if len(result.Warnings) != 0 {
+		t.Errorf("got warnings %v", result.Warnings)
+	}
+
+	var firewall struct {
+		Label string              `json:"label"`
+		Rules LinodeFirewallRules `json:"rules"`
+	}
+	linode.body("POST /networking/firewalls", &firewall)
+	if firewall.Label != "hp_instance" || len(firewall.Rules.Inbound) != 1 || firewall.Rules.Inbound[0].Ports != "51820" {
+		t.Errorf("got firewall %+v", firewall)
+	}
+	var device map[string]interface{}
+	linode.body("POST /networking/firewalls/:id/devices", &device)
+	if device["type"] != "linode" || device["id"] != float64(1001) {
+		t.Errorf("got device %v, want the new instance", device)
+	}
+}
+
+func TestCreateTunnelWarnsWhenFirewallNotAttached(t *testing.T) {
+	linode := newFakeLinode(t).handle(createFirewallRoutes, fakeRoutes{
+		"POST /networking/firewalls/:id/devices": respondError(http.StatusBadRequest, "Linode already has a firewall"),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CreateTunnel(firewalledTunnelRequest(t))
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeCreateTunnelResult).LinodeCreateTunnelResult
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != protoapi.Warning_FIREWALL_NOT_ATTACHED {
+		t.Errorf("got warnings %v, want FIREWALL_NOT_ATTACHED", result.Warnings)
+	}
+	if linode.instance(1001) == nil {
+		t.Error("instance was deleted")
+	}
+	if !linode.requested("DELETE /networking/firewalls/:id") {
+		t.Error("unattached firewall wasn't deleted")
+	}
+}
+
+func TestDestroyTunnelDeletesTunnelFirewall(t *testing.T) {
+	for _, label := range []string{"hp_instance", "shared"} {
+		firewall := testFirewall()
+		firewall.Label = label
+		linode := newFakeLinode(t, firewallInstance).handle(firewallRoutes(firewall), fakeRoutes{
+			"DELETE /networking/firewalls/:id": respond(http.StatusOK, struct{}{}),
+		})
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.DestroyTunnel(&protoapi.LinodeDestroyTunnelRequest{Auth: testAuth()})
+		})
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+		// Firewalls shared with other instances are kept.
+		if deleted := linode.requested("DELETE /networking/firewalls/:id"); deleted != (label == "hp_instance") {
+			t.Errorf("firewall %s: got deleted %v", label, deleted)
+		}
+	}
+}
+
+func TestDestroyTunnelWhenFirewallsCannotBeListed(t *testing.T) {
+	linode := newFakeLinode(t, firewallInstance).handle(firewallRoutes(testFirewall()), fakeRoutes{
+		// Token lacks the firewalls scope.
+		"GET /linode/instances/:id/firewalls": respondError(http.StatusUnauthorized,
+			"Your OAuth token is not authorized to use this endpoint."),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.DestroyTunnel(&protoapi.LinodeDestroyTunnelRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if linode.instance(1) != nil {
+		t.Error("instance wasn't deleted")
+	}
+	if linode.requested("DELETE /networking/firewalls/:id") {
+		t.Error("firewall was deleted without being listed")
+	}
+}
+
+func TestTunnelFirewallLabel(t *testing.T) {
+	if label := tunnelFirewallLabel("hp_team-a_vpn"); label != "hp_team-a_vpn" {
+		t.Errorf("got label %s of short tunnel label", label)
+	}
+
+	long := "hp_team-a_" + strings.Repeat("x", 21) + "_vpn"
+	label := tunnelFirewallLabel(long)
+	if len(label) > maxFirewallLabelLength {
+		t.Errorf("got label %s longer than %d characters", label, maxFirewallLabelLength)
+	}
+	if strings.Contains(label, "_-") || label != tunnelFirewallLabel(long) {
+		t.Errorf("got label %s", label)
+	}
+	if tunnelFirewallLabel(long+"2") == label {
+		t.Error("tunnels sharing label prefix got the same firewall label")
+	}
+}
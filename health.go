@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	log "github.com/sirupsen/logrus"
+)
+
+// healthStatus is the JSON body of health and readiness probes.
+type healthStatus struct {
+	Status string `json:"status"`
+}
+
+// healthChecker serves unauthenticated probes for load balancers and
+// orchestrators. Liveness only tells that the process serves HTTP, while
+// readiness also requires Linode API to be reachable.
+type healthChecker struct {
+	drain   *drainController
+	clients *linodeClientCache
+}
+
+func newHealthChecker(drain *drainController, clients *linodeClientCache) *healthChecker {
+	return &healthChecker{
+		drain:   drain,
+		clients: clients,
+	}
+}
+
+func (h *healthChecker) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	render.JSON(w, r, healthStatus{Status: "ok"})
+}
+
+func (h *healthChecker) handleReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	if h.drain.IsDraining() {
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, healthStatus{Status: "draining"})
+		return
+	}
+	// Regions are served from the metadata cache most of the time, so the
+	// probe doesn't hammer Linode API.
+	if _, err := h.clients.Get("").WithContext(r.Context()).ListRegions(); err != nil {
+		log.WithField("cause", err).Warn("Readiness probe couldn't reach Linode API")
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, healthStatus{Status: "linode unreachable"})
+		return
+	}
+	render.JSON(w, r, healthStatus{Status: "ok"})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// probe calls the probe handler and returns its status code and body.
+func probe(t *testing.T, handler http.HandlerFunc) (int, string) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+
+	var status healthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Unable to decode probe response %q: %v", w.Body.String(), err)
+	}
+	if w.Header().Get("Cache-Control") != "no-cache" {
+		t.Error("probe response may be cached")
+	}
+	return w.Code, status.Status
+}
+
+func TestHealthProbe(t *testing.T) {
+	// Liveness doesn't depend on Linode or draining.
+	drain := newDrainController(time.Minute, nil)
+	drain.Start()
+	health := newHealthChecker(drain, nil)
+
+	if code, status := probe(t, health.handleHealth); code != http.StatusOK || status != "ok" {
+		t.Errorf("got %d %s, want 200 ok", code, status)
+	}
+}
+
+func TestReadyProbe(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /regions": respond(http.StatusOK, &linodeRegionPaginated{Pages: 1, Page: 1, Data: []LinodeRegion{{ID: "us-east"}}}),
+	})
+	health := newHealthChecker(newDrainController(time.Minute, nil), newTestLinodeConfig(linode).clients)
+
+	if code, status := probe(t, health.handleReady); code != http.StatusOK || status != "ok" {
+		t.Errorf("got %d %s, want 200 ok", code, status)
+	}
+	if !linode.requested("GET /regions") {
+		t.Error("Linode API wasn't checked")
+	}
+}
+
+func TestReadyProbeLinodeUnreachable(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /regions": respondError(http.StatusForbidden, "Unavailable"),
+	})
+	health := newHealthChecker(newDrainController(time.Minute, nil), newTestLinodeConfig(linode).clients)
+
+	if code, status := probe(t, health.handleReady); code != http.StatusServiceUnavailable || status != "linode unreachable" {
+		t.Errorf("got %d %s, want 503 linode unreachable", code, status)
+	}
+}
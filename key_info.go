@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"protoapi"
+)
+
+// keyInfo describes the keys server is running with. It holds fingerprints
+// only, never the key material itself.
+type keyInfo struct {
+	hostFingerprint  string
+	peerFingerprints []string
+	embeddedHostKey  bool
+	embeddedPeerKey  bool
+}
+
+func newKeyInfo(hostKey []byte, peerKeys [][]byte, embeddedHostKey bool, embeddedPeerKey bool) *keyInfo {
+	info := &keyInfo{
+		hostFingerprint: keyFingerprint(hostKey),
+		embeddedHostKey: embeddedHostKey,
+		embeddedPeerKey: embeddedPeerKey,
+	}
+	for _, key := range peerKeys {
+		info.peerFingerprints = append(info.peerFingerprints, keyFingerprint(key))
+	}
+	return info
+}
+
+func (k *keyInfo) toProtobuf() *protoapi.ServerKeyInfo {
+	return &protoapi.ServerKeyInfo{
+		HostKeyFingerprint:  k.hostFingerprint,
+		PeerKeyFingerprints: k.peerFingerprints,
+		EmbeddedHostKey:     k.embeddedHostKey,
+		EmbeddedPeerKey:     k.embeddedPeerKey,
+	}
+}
+
+// keyFingerprint returns hex-encoded SHA-256 digest of the key.
+func keyFingerprint(key []byte) string {
+	digest := sha256.Sum256(key)
+	return hex.EncodeToString(digest[:])
+}
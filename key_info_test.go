@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"protoapi"
+	"strings"
+	"testing"
+)
+
+func TestKeyInfoFingerprints(t *testing.T) {
+	hostKey := bytes.Repeat([]byte{1}, keySize)
+	peerKeys := [][]byte{bytes.Repeat([]byte{2}, keySize), bytes.Repeat([]byte{3}, keySize)}
+
+	info := newKeyInfo(hostKey, peerKeys, false, true).toProtobuf()
+
+	// SHA-256 of the empty key, a well known digest.
+	if fingerprint := keyFingerprint(nil); fingerprint != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("got fingerprint %s of empty key", fingerprint)
+	}
+	if info.HostKeyFingerprint != keyFingerprint(hostKey) {
+		t.Errorf("got host fingerprint %s", info.HostKeyFingerprint)
+	}
+	if len(info.PeerKeyFingerprints) != 2 ||
+		info.PeerKeyFingerprints[0] != keyFingerprint(peerKeys[0]) ||
+		info.PeerKeyFingerprints[1] != keyFingerprint(peerKeys[1]) {
+		t.Errorf("got peer fingerprints %v", info.PeerKeyFingerprints)
+	}
+	if info.EmbeddedHostKey || !info.EmbeddedPeerKey {
+		t.Errorf("got embedded host %v, peer %v", info.EmbeddedHostKey, info.EmbeddedPeerKey)
+	}
+	if info.HostKeyFingerprint == keyFingerprint(peerKeys[0]) {
+		t.Error("different keys have the same fingerprint")
+	}
+}
+
+func TestGetKeyInfoRequiresAdminToken(t *testing.T) {
+	metrics := &fakeMetricsSink{}
+	s := newTestAPIServer(newFakeLinode(t), metrics)
+	s.keys = newKeyInfo(nil, nil, true, true)
+
+	dispatch(s, &protoapi.Request{
+		AccessToken: "user-token",
+		V:           &protoapi.Request_ServerGetKeyInfo{ServerGetKeyInfo: &protoapi.ServerGetKeyInfoRequest{}},
+	})
+	if !metrics.called("counter request_errors_total{type=holepuncher_admin_required,verb=ServerGetKeyInfo}") {
+		t.Errorf("request wasn't rejected, got %v", metrics.calls)
+	}
+
+	metrics.calls = nil
+	s.policy = &accessPolicy{tokens: map[string]*accessPolicyEntry{
+		hashToken("admin-token"): {verbs: []string{"*"}, admin: true},
+	}}
+	dispatch(s, &protoapi.Request{
+		AccessToken: "admin-token",
+		V:           &protoapi.Request_ServerGetKeyInfo{ServerGetKeyInfo: &protoapi.ServerGetKeyInfoRequest{}},
+	})
+	for _, call := range metrics.calls {
+		if strings.HasPrefix(call, "counter request_errors_total") {
+			t.Errorf("admin request failed: %s", call)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,6 +17,29 @@ import (
 type LinodeAPI struct {
 	apiKey string
 	client *resty.Client
+	// Cache of rarely changing listings, nil disables caching.
+	metadata *linodeMetadataCache
+	// Context of the request the API is used for, nil means requests are
+	// never cancelled.
+	ctx context.Context
+}
+
+// linodeAccountNotReadyMarkers are fragments of error reasons Linode reports
+// for accounts which aren't allowed to create instances yet.
+var linodeAccountNotReadyMarkers = []string{
+	"terms of service",
+	"must be activated",
+	"pending verification",
+	"verify your account",
+	"account is not active",
+}
+
+// linodeIPJustificationMarkers are fragments of error reasons Linode reports
+// when additional IPv4 addresses can't be allocated without a support ticket.
+var linodeIPJustificationMarkers = []string{
+	"justification",
+	"contact support",
+	"open a support ticket",
 }
 
 // LinodeError represents a Linode error.
@@ -32,7 +59,7 @@ type LinodeInfo struct {
 	Region     string       `json:"region"`
 	Image      string       `json:"image"`
 	IPv4       []string     `json:"ipv4"`
-	IPv6       string       `json:"ipv6"`
+	IPv6       LinodeIPv6   `json:"ipv6"`
 	Label      string       `json:"label"`
 	Group      string       `json:"group"`
 	Type       string       `json:"type"`
@@ -40,6 +67,7 @@ type LinodeInfo struct {
 	CreatedAt  string       `json:"created"`
 	Updated    string       `json:"updated"`
 	Hypervisor string       `json:"hypervisor"`
+	Tags       []string     `json:"tags"`
 	Specs      struct {
 		Disk     int `json:"disk"`
 		Memory   int `json:"memory"`
@@ -48,6 +76,83 @@ type LinodeInfo struct {
 	} `json:"specs"`
 }
 
+// LinodeIPv6 is a list of IPv6 addresses in slash notation. Instance listing
+// reports only the SLAAC address as a string (or null), complete set of
+// addresses is retrieved with GetInstanceIPs.
+type LinodeIPv6 []string
+
+// UnmarshalJSON accepts a single address, a list of addresses or null.
+func (a *LinodeIPv6) UnmarshalJSON(data []byte) error {
+	var address *string
+	if err := json.Unmarshal(data, &address); err == nil {
+		*a = nil
+		if address != nil && len(*address) > 0 {
+			*a = LinodeIPv6{*address}
+		}
+		return nil
+	}
+
+	var addresses []string
+	if err := json.Unmarshal(data, &addresses); err != nil {
+		return errors.Wrapf(err, "Unable to decode IPv6 addresses")
+	}
+	*a = addresses
+	return nil
+}
+
+// LinodeInstanceIPs is a struct containing networking of an instance.
+type LinodeInstanceIPs struct {
+	IPv4 struct {
+		Public  []LinodeIPAddress `json:"public"`
+		Private []LinodeIPAddress `json:"private"`
+	} `json:"ipv4"`
+	IPv6 struct {
+		SLAAC     *LinodeIPAddress  `json:"slaac"`
+		LinkLocal *LinodeIPAddress  `json:"link_local"`
+		Global    []LinodeIPv6Range `json:"global"`
+	} `json:"ipv6"`
+}
+
+// LinodeIPAddress is a single address assigned to an instance.
+type LinodeIPAddress struct {
+	Address string `json:"address"`
+	Prefix  int    `json:"prefix"`
+	Gateway string `json:"gateway"`
+}
+
+// LinodeIP is an IP address allocated to an instance.
+type LinodeIP struct {
+	Address  string `json:"address"`
+	Gateway  string `json:"gateway"`
+	Prefix   int    `json:"prefix"`
+	Type     string `json:"type"`
+	Public   bool   `json:"public"`
+	RDNS     string `json:"rdns"`
+	LinodeID int    `json:"linode_id"`
+	Region   string `json:"region"`
+}
+
+// LinodeIPv6Range is a routed IPv6 range assigned to an instance.
+type LinodeIPv6Range struct {
+	Range  string `json:"range"`
+	Prefix int    `json:"prefix"`
+}
+
+// IPv6Addresses returns all IPv6 addresses and ranges of the instance in
+// slash notation: SLAAC address first, then link-local, then global ranges.
+func (ips *LinodeInstanceIPs) IPv6Addresses() LinodeIPv6 {
+	var addresses LinodeIPv6
+	for _, address := range []*LinodeIPAddress{ips.IPv6.SLAAC, ips.IPv6.LinkLocal} {
+		if address != nil && len(address.Address) > 0 {
+			addresses = append(addresses, fmt.Sprintf("%s/%d", address.Address, address.Prefix))
+		}
+	}
+	for _, r := range ips.IPv6.Global {
+		addresses = append(addresses, fmt.Sprintf("%s/%d", r.Range, r.Prefix))
+	}
+	return addresses
+}
+
 // StackScript is a struct containing a single StackScript description.
 type StackScript struct {
 	ID          int      `json:"id"`
@@ -57,10 +162,20 @@ type StackScript struct {
 	IsPublic    bool     `json:"is_public"`
 }
 
+// StackScriptSpec is a struct containing StackScript fields that can be set
+// on create or update. Empty fields are left unchanged by update.
+type StackScriptSpec struct {
+	Label       string   `json:"label,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Images      []string `json:"images,omitempty"`
+	Script      string   `json:"script,omitempty"`
+}
+
 // LinodeRegion is a struct containing a single Linode region description.
 type LinodeRegion struct {
 	ID      string `json:"id"`
 	Country string `json:"country"`
+	Status  string `json:"status"`
 }
 
 // LinodeImage is a struct containing a description of single deployable
@@ -77,6 +192,17 @@ type LinodeImage struct {
 	Deprecated  bool   `json:"deprecated"`
 }
 
+// LinodeKernel is a struct containing a description of single kernel which
+// can be booted by instance config.
+type LinodeKernel struct {
+	ID           string `json:"id"`
+	Label        string `json:"label"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	KVM          bool   `json:"kvm"`
+	Deprecated   bool   `json:"deprecated"`
+}
+
 // LinodeType is a struct containing a single Linode type description.
 type LinodeType struct {
 	ID         string `json:"id"`
@@ -86,12 +212,172 @@ type LinodeType struct {
 	Memory     int    `json:"memory"`
 	Transfer   int    `json:"transfer"`
 	VCPUs      int    `json:"vcpus"`
+	Class      string `json:"class"`
 	Price      struct {
 		Hourly  float32 `json:"hourly"`
 		Monthly float32 `json:"monthly"`
 	} `json:"price"`
 }
 
+// LinodeDisk is a struct containing a description of a single disk attached
+// to Linode instance.
+type LinodeDisk struct {
+	ID         int        `json:"id"`
+	Label      string     `json:"label"`
+	Status     DiskStatus `json:"status"`
+	Size       int        `json:"size"`
+	Filesystem string     `json:"filesystem"`
+	CreatedAt  string     `json:"created"`
+	Updated    string     `json:"updated"`
+}
+
+// LinodeBackup is a struct containing a description of a single backup of
+// an instance, either automatic or a manual snapshot.
+type LinodeBackup struct {
+	ID        int                `json:"id"`
+	Label     string             `json:"label"`
+	Status    string             `json:"status"`
+	Type      string             `json:"type"`
+	CreatedAt string             `json:"created"`
+	Finished  string             `json:"finished"`
+	Disks     []LinodeBackupDisk `json:"disks"`
+}
+
+// LinodeBackupDisk describes a disk stored in a backup.
+type LinodeBackupDisk struct {
+	Label      string `json:"label"`
+	Size       int    `json:"size"`
+	Filesystem string `json:"filesystem"`
+}
+
+// LinodeInstanceConfig is a struct containing a description of instance
+// configuration profile, which defines how the instance boots.
+type LinodeInstanceConfig struct {
+	ID         int                            `json:"id"`
+	Label      string                         `json:"label"`
+	Kernel     string                         `json:"kernel"`
+	RootDevice string                         `json:"root_device"`
+	Interfaces []LinodeConfigInterface        `json:"interfaces"`
+	Devices    map[string]*LinodeConfigDevice `json:"devices"`
+}
+
+// LinodeConfigInterface is a network interface of configuration profile.
+// Purpose is one of "public", "vlan" or "vpc".
+type LinodeConfigInterface struct {
+	Purpose     string `json:"purpose"`
+	Label       string `json:"label"`
+	IPAMAddress string `json:"ipam_address"`
+}
+
+// LinodeConfigDevice is a disk or volume attached to configuration profile
+// as a block device (e.g. "sda"). Unused devices are reported as nil.
+type LinodeConfigDevice struct {
+	DiskID   int `json:"disk_id"`
+	VolumeID int `json:"volume_id"`
+}
+
+// LinodeFirewall is a struct containing a description of a Cloud Firewall.
+type LinodeFirewall struct {
+	ID     int                 `json:"id"`
+	Label  string              `json:"label"`
+	Status string              `json:"status"`
+	Rules  LinodeFirewallRules `json:"rules"`
+}
+
+// LinodeFirewallRules is a struct containing a complete ruleset of a Cloud
+// Firewall.
+type LinodeFirewallRules struct {
+	InboundPolicy  string               `json:"inbound_policy"`
+	Inbound        []LinodeFirewallRule `json:"inbound"`
+	OutboundPolicy string               `json:"outbound_policy"`
+	Outbound       []LinodeFirewallRule `json:"outbound"`
+}
+
+// LinodeFirewallRule is a struct containing a single Cloud Firewall rule.
+type LinodeFirewallRule struct {
+	Action      string `json:"action"`
+	Label       string `json:"label,omitempty"`
+	Description string `json:"description,omitempty"`
+	Protocol    string `json:"protocol"`
+	Ports       string `json:"ports,omitempty"`
+	Addresses   struct {
+		IPv4 []string `json:"ipv4,omitempty"`
+		IPv6 []string `json:"ipv6,omitempty"`
+	} `json:"addresses"`
+}
+
+// LinodeTransfer is a struct containing account-wide network transfer usage
+// for the current month, in GB.
+type LinodeTransfer struct {
+	Used     int `json:"used"`
+	Quota    int `json:"quota"`
+	Billable int `json:"billable"`
+}
+
+// LinodeNotification is a struct containing a single account notification,
+// such as an outage or scheduled maintenance. Entity is the affected object,
+// its ID is a string for regions and a number for instances.
+type LinodeNotification struct {
+	Type     string `json:"type"`
+	Label    string `json:"label"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+	When     string `json:"when"`
+	Until    string `json:"until"`
+	Entity   *struct {
+		ID    interface{} `json:"id"`
+		Label string      `json:"label"`
+		Type  string      `json:"type"`
+	} `json:"entity"`
+}
+
+// LinodeProfile is a struct containing a description of the user owning the
+// access token.
+type LinodeProfile struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// LinodeSSHKey is a struct containing a description of SSH key stored in user
+// profile.
+type LinodeSSHKey struct {
+	ID        int    `json:"id"`
+	Label     string `json:"label"`
+	SSHKey    string `json:"ssh_key"`
+	CreatedAt string `json:"created"`
+}
+
+// LinodeInvoice is a struct containing a description of account invoice.
+type LinodeInvoice struct {
+	ID    int     `json:"id"`
+	Date  string  `json:"date"`
+	Label string  `json:"label"`
+	Total float64 `json:"total"`
+}
+
+// LinodeInvoiceItem is a struct containing a single invoice line. Items
+// billing instances are labeled like "Linode 2GB - hp_instance (12345)".
+type LinodeInvoiceItem struct {
+	Label  string  `json:"label"`
+	Type   string  `json:"type"`
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+	Total  float64 `json:"total"`
+}
+
+// linodeDateLayout is the layout of timestamps returned by Linode API.
+const linodeDateLayout = "2006-01-02T15:04:05"
+
+// LinodeGrants is a struct containing account-wide permissions of a
+// restricted user.
+type LinodeGrants struct {
+	Global struct {
+		AddLinodes    bool   `json:"add_linodes"`
+		AccountAccess string `json:"account_access"`
+	} `json:"global"`
+}
+
 // LinodeInstanceBuilder provides a comprehensive set of methods for configuring
 // new Linode instance.
 type LinodeInstanceBuilder struct {
@@ -100,6 +386,7 @@ type LinodeInstanceBuilder struct {
 	Type            string                 `json:"type"`
 	Label           string                 `json:"label,omitempty"`
 	Group           string                 `json:"group,omitempty"`
+	Tags            []string               `json:"tags,omitempty"`
 	RootPass        string                 `json:"root_pass,omitempty"`
 	AuthorizedKeys  []string               `json:"authorized_keys,omitempty"`
 	StackscriptID   int                    `json:"stackscript_id,omitempty"`
@@ -150,17 +437,30 @@ const (
 	LinodeStatusCloning LinodeStatus = "cloning"
 )
 
+// DiskStatus enum describes status of a Linode disk.
+type DiskStatus string
+
+const (
+	// DiskStatusReady indicates that disk is ready for use.
+	DiskStatusReady DiskStatus = "ready"
+	// DiskStatusNotReady indicates that disk is busy (e.g. being resized).
+	DiskStatusNotReady DiskStatus = "not ready"
+	// DiskStatusDeleting indicates that disk is being deleted.
+	DiskStatusDeleting DiskStatus = "deleting"
+)
+
 // NewLinodeAPI creates an authenticated LinodeAPI instance that can be used
 // to access any API endpoint without restrictions (assuming you have appropriate
-// access permissions).
-func NewLinodeAPI(apiKey string) *LinodeAPI {
+// access permissions). Debug mode dumps requests and responses, including
+// the access token, to the log; it must never be enabled in production.
+func NewLinodeAPI(apiKey string, debug bool) *LinodeAPI {
 	client := resty.New()
 	client.SetAuthToken(apiKey)
 	client.SetError(&LinodeError{})
 	client.SetTimeout(60 * time.Second)
 	client.SetHeader("User-Agent", "linode_client")
 
-	client.SetDebug(true)
+	client.SetDebug(debug)
 
 	return &LinodeAPI{
 		apiKey: apiKey,
@@ -168,15 +468,23 @@ func NewLinodeAPI(apiKey string) *LinodeAPI {
 	}
 }
 
+// WithContext returns a copy of the API whose requests are cancelled together
+// with the context. The copy shares HTTP client and caches with the original.
+func (e *LinodeAPI) WithContext(ctx context.Context) *LinodeAPI {
+	api := *e
+	api.ctx = ctx
+	return &api
+}
+
 // NewLinodeAPIUnauthenticated creates an unauthenticated LinodeAPI instance that
 // has access to API endpoints that do not require authentication.
-func NewLinodeAPIUnauthenticated() *LinodeAPI {
+func NewLinodeAPIUnauthenticated(debug bool) *LinodeAPI {
 	client := resty.New()
 	client.SetError(&LinodeError{})
 	client.SetTimeout(60 * time.Second)
 	client.SetHeader("User-Agent", "linode_client")
 
-	client.SetDebug(true)
+	client.SetDebug(debug)
 
 	return &LinodeAPI{
 		client: client,
@@ -214,6 +522,113 @@ func (e *LinodeAPI) BootInstance(linodeID int) error {
 	return errors.Wrapf(result.err, "Unable to boot instance")
 }
 
+// BootInstanceWithConfig attempts to boot specified instance using the given
+// configuration profile.
+func (e *LinodeAPI) BootInstanceWithConfig(linodeID int, configID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/boot", linodeID)
+	body := map[string]interface{}{"config_id": configID}
+	result := linodePOST(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to boot instance")
+}
+
+// RebootInstance attempts to reboot specified instance.
+func (e *LinodeAPI) RebootInstance(linodeID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/reboot", linodeID)
+	result := linodePOST(endpoint, e.authedR().SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to reboot instance")
+}
+
+// ShutdownInstance attempts to shut down specified instance.
+func (e *LinodeAPI) ShutdownInstance(linodeID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/shutdown", linodeID)
+	result := linodePOST(endpoint, e.authedR().SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to shut down instance")
+}
+
+// CloneInstance clones disks and configs of specified instance into a new
+// instance in the given region. Empty type keeps the type of the source.
+// The clone is left offline.
+func (e *LinodeAPI) CloneInstance(linodeID int, region string, linodeType string, label string) (*LinodeInfo, error) {
+	endpoint := fmt.Sprintf("/linode/instances/%d/clone", linodeID)
+	body := map[string]interface{}{
+		"region": region,
+		"label":  label,
+	}
+	if len(linodeType) > 0 {
+		body["type"] = linodeType
+	}
+	r := e.authedR().SetBody(body).SetResult(&LinodeInfo{})
+	result := linodePOST(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if instance, ok := result.data.(*LinodeInfo); ok {
+		return instance, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// InitiateMigration initiates a pending migration of specified instance,
+// which was previously scheduled by Linode.
+func (e *LinodeAPI) InitiateMigration(linodeID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/migrate", linodeID)
+	result := linodePOST(endpoint, e.authedR().SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to initiate migration")
+}
+
+// ResizeInstance changes plan of the instance. Resize involves migration of
+// the instance to another host, during which it is rebooted. Auto disk
+// resize lets Linode grow the disk to fill the new plan.
+func (e *LinodeAPI) ResizeInstance(linodeID int, newType string, allowAutoDiskResize bool) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/resize", linodeID)
+	body := map[string]interface{}{
+		"type":                   newType,
+		"allow_auto_disk_resize": allowAutoDiskResize,
+	}
+	result := linodePOST(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to resize instance")
+}
+
+// SetInstanceTags replaces tags of specified instance.
+func (e *LinodeAPI) SetInstanceTags(linodeID int, tags []string) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d", linodeID)
+	body := map[string]interface{}{"tags": tags}
+	result := linodePUT(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to set instance tags")
+}
+
 // DeleteInstance irreversibly deletes an existing instance.
 func (e *LinodeAPI) DeleteInstance(linodeID int) error {
 	var dummy map[string]interface{}
@@ -244,11 +659,26 @@ func (e *LinodeAPI) QueryLinode(linodeID int) (*LinodeInfo, error) {
 	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
 }
 
+// GetInstanceIPs returns networking of the instance.
+func (e *LinodeAPI) GetInstanceIPs(linodeID int) (*LinodeInstanceIPs, error) {
+	endpoint := fmt.Sprintf("/linode/instances/%d/ips", linodeID)
+	r := e.authedR().SetResult(&LinodeInstanceIPs{})
+	result := linodeGET(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if ips, ok := result.data.(*LinodeInstanceIPs); ok {
+		return ips, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
 // ListLinodeInstances returns a list of active linodes.
 func (e *LinodeAPI) ListLinodeInstances() ([]LinodeInfo, error) {
 	endpoint := "/linode/instances"
-	r := e.authedR().SetResult([]LinodeInfo{})
-	iter := linodePaginatedGET(endpoint, r, &linodeInfoPaginated{})
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeInfoPaginated{})
 	list := []LinodeInfo{}
 
 	for {
@@ -269,11 +699,517 @@ func (e *LinodeAPI) ListLinodeInstances() ([]LinodeInfo, error) {
 	return list, nil
 }
 
+// ListLinodeInstancesPage returns a single page of active linodes along with
+// the number of pages. Pages are numbered from 1.
+func (e *LinodeAPI) ListLinodeInstancesPage(page int) ([]LinodeInfo, int, error) {
+	endpoint := "/linode/instances"
+	result, pageCount := linodePageGET(endpoint, e.authedR, &linodeInfoPaginated{}, page)
+	if result.err != nil {
+		return nil, 0, result.err
+	}
+
+	if list, ok := result.data.([]LinodeInfo); ok {
+		return list, pageCount, nil
+	}
+	return nil, 0, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// ListInstanceDisks returns a list of disks attached to the instance.
+func (e *LinodeAPI) ListInstanceDisks(linodeID int) ([]LinodeDisk, error) {
+	endpoint := fmt.Sprintf("/linode/instances/%d/disks", linodeID)
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeDiskPaginated{})
+	list := []LinodeDisk{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeDisk); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+// ListInstanceConfigs returns a list of configuration profiles of the
+// instance.
+func (e *LinodeAPI) ListInstanceConfigs(linodeID int) ([]LinodeInstanceConfig, error) {
+	endpoint := fmt.Sprintf("/linode/instances/%d/configs", linodeID)
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeInstanceConfigPaginated{})
+	list := []LinodeInstanceConfig{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeInstanceConfig); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+// UpdateInstanceConfig changes configuration profile of the instance. Body
+// holds only the fields to change, e.g. "kernel" or "interfaces".
+func (e *LinodeAPI) UpdateInstanceConfig(
+	linodeID int,
+	configID int,
+	body map[string]interface{},
+) (*LinodeInstanceConfig, error) {
+	endpoint := fmt.Sprintf("/linode/instances/%d/configs/%d", linodeID, configID)
+	r := e.authedR().SetBody(body).SetResult(&LinodeInstanceConfig{})
+	result := linodePUT(endpoint, r)
+
+	if result.err != nil {
+		return nil, errors.Wrapf(result.err, "Unable to update instance config")
+	}
+
+	if config, ok := result.data.(*LinodeInstanceConfig); ok {
+		return config, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// ListBackups returns automatic backups and snapshots of the instance.
+// Snapshot that is still being taken is included as well.
+func (e *LinodeAPI) ListBackups(linodeID int) ([]LinodeBackup, error) {
+	var backups struct {
+		Automatic []LinodeBackup `json:"automatic"`
+		Snapshot  struct {
+			Current    *LinodeBackup `json:"current"`
+			InProgress *LinodeBackup `json:"in_progress"`
+		} `json:"snapshot"`
+	}
+	endpoint := fmt.Sprintf("/linode/instances/%d/backups", linodeID)
+	result := linodeGET(endpoint, e.authedR().SetResult(&backups))
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	list := append([]LinodeBackup{}, backups.Automatic...)
+	if backups.Snapshot.Current != nil {
+		list = append(list, *backups.Snapshot.Current)
+	}
+	if backups.Snapshot.InProgress != nil {
+		list = append(list, *backups.Snapshot.InProgress)
+	}
+	return list, nil
+}
+
+// RestoreBackup restores backup of the instance to the target instance,
+// which may be the instance itself. Disks and configs of the target are
+// overwritten.
+func (e *LinodeAPI) RestoreBackup(linodeID int, backupID int, targetID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/backups/%d/restore", linodeID, backupID)
+	body := map[string]interface{}{
+		"linode_id": targetID,
+		"overwrite": true,
+	}
+	result := linodePOST(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to restore backup")
+}
+
+// QueryDisk returns information about a single disk of the instance.
+func (e *LinodeAPI) QueryDisk(linodeID int, diskID int) (*LinodeDisk, error) {
+	endpoint := fmt.Sprintf("/linode/instances/%d/disks/%d", linodeID, diskID)
+	r := e.authedR().SetResult(&LinodeDisk{})
+	result := linodeGET(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if disk, ok := result.data.(*LinodeDisk); ok {
+		return disk, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// ResizeDisk resizes a disk of the instance. Size is specified in megabytes.
+// The instance must be powered off.
+func (e *LinodeAPI) ResizeDisk(linodeID int, diskID int, size int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/disks/%d/resize", linodeID, diskID)
+	body := map[string]interface{}{"size": size}
+	result := linodePOST(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to resize disk")
+}
+
+// BootRescueMode reboots instance into Finnix rescue environment. Devices map
+// rescue device names (sda, sdb, ...) to IDs of disks attached to them.
+func (e *LinodeAPI) BootRescueMode(linodeID int, devices map[string]int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/instances/%d/rescue", linodeID)
+	rescueDevices := make(map[string]interface{})
+	for device, diskID := range devices {
+		rescueDevices[device] = map[string]interface{}{"disk_id": diskID}
+	}
+	body := map[string]interface{}{"devices": rescueDevices}
+	result := linodePOST(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to boot instance into rescue mode")
+}
+
+// ListInstanceFirewalls returns a list of firewalls attached to the instance.
+func (e *LinodeAPI) ListInstanceFirewalls(linodeID int) ([]LinodeFirewall, error) {
+	endpoint := fmt.Sprintf("/linode/instances/%d/firewalls", linodeID)
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeFirewallPaginated{})
+	list := []LinodeFirewall{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeFirewall); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+// GetFirewall returns information about a Cloud Firewall, including its
+// rules.
+func (e *LinodeAPI) GetFirewall(firewallID int) (*LinodeFirewall, error) {
+	endpoint := fmt.Sprintf("/networking/firewalls/%d", firewallID)
+	r := e.authedR().SetResult(&LinodeFirewall{})
+	result := linodeGET(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if firewall, ok := result.data.(*LinodeFirewall); ok {
+		return firewall, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// CreateFirewall creates a Cloud Firewall with the ruleset. Firewall isn't
+// attached to any instance.
+func (e *LinodeAPI) CreateFirewall(label string, rules *LinodeFirewallRules) (*LinodeFirewall, error) {
+	endpoint := "/networking/firewalls"
+	body := map[string]interface{}{
+		"label": label,
+		"rules": rules,
+	}
+	r := e.authedR().SetBody(body).SetResult(&LinodeFirewall{})
+	result := linodePOST(endpoint, r)
+
+	if result.err != nil {
+		return nil, errors.Wrapf(result.err, "Unable to create firewall")
+	}
+
+	if firewall, ok := result.data.(*LinodeFirewall); ok {
+		return firewall, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// AttachFirewallToInstance puts the instance behind a Cloud Firewall.
+func (e *LinodeAPI) AttachFirewallToInstance(firewallID int, linodeID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/networking/firewalls/%d/devices", firewallID)
+	body := map[string]interface{}{
+		"type": "linode",
+		"id":   linodeID,
+	}
+	result := linodePOST(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to attach firewall")
+}
+
+// DeleteFirewall deletes a Cloud Firewall, detaching it from all instances.
+func (e *LinodeAPI) DeleteFirewall(firewallID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/networking/firewalls/%d", firewallID)
+	result := linodeDELETE(endpoint, e.authedR().SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to delete firewall")
+}
+
+// UpdateFirewallRules replaces the complete ruleset of a Cloud Firewall.
+func (e *LinodeAPI) UpdateFirewallRules(firewallID int, rules *LinodeFirewallRules) (*LinodeFirewallRules, error) {
+	endpoint := fmt.Sprintf("/networking/firewalls/%d/rules", firewallID)
+	r := e.authedR().SetBody(rules).SetResult(&LinodeFirewallRules{})
+	result := linodePUT(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if updated, ok := result.data.(*LinodeFirewallRules); ok {
+		return updated, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// SetReverseDNS sets reverse DNS (PTR record) of an IP address. Linode
+// requires the hostname to resolve back to the address. Empty hostname
+// restores the default one.
+func (e *LinodeAPI) SetReverseDNS(ip string, rdns string) error {
+	var dummy map[string]interface{}
+	body := map[string]interface{}{"rdns": nil}
+	if len(rdns) > 0 {
+		body["rdns"] = rdns
+	}
+	endpoint := fmt.Sprintf("/networking/ips/%s", ip)
+	result := linodePUT(endpoint, e.authedR().SetBody(body).SetResult(&dummy))
+
+	// Validation errors are returned as is, so that their field details
+	// reach the client.
+	return result.err
+}
+
+// AllocateIP allocates an additional IPv4 address to the instance. Linode
+// allows extra public addresses only with a justification, error reporting
+// that is returned as is, see LinodeError.IPJustificationRequired.
+func (e *LinodeAPI) AllocateIP(linodeID int, public bool) (*LinodeIP, error) {
+	endpoint := "/networking/ips"
+	body := map[string]interface{}{
+		"linode_id": linodeID,
+		"type":      "ipv4",
+		"public":    public,
+	}
+	r := e.authedR().SetBody(body).SetResult(&LinodeIP{})
+	result := linodePOST(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if ip, ok := result.data.(*LinodeIP); ok {
+		return ip, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// ListNotifications returns notifications of the account, including
+// outages and maintenance affecting its regions.
+func (e *LinodeAPI) ListNotifications() ([]LinodeNotification, error) {
+	endpoint := "/account/notifications"
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeNotificationPaginated{})
+	list := []LinodeNotification{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeNotification); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+// GetTransferUsage returns network transfer used by the account in the
+// current month.
+func (e *LinodeAPI) GetTransferUsage() (*LinodeTransfer, error) {
+	endpoint := "/account/transfer"
+	r := e.authedR().SetResult(&LinodeTransfer{})
+	result := linodeGET(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if transfer, ok := result.data.(*LinodeTransfer); ok {
+		return transfer, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// GetProfile returns profile of the user owning the access token.
+func (e *LinodeAPI) GetProfile() (*LinodeProfile, error) {
+	endpoint := "/profile"
+	r := e.authedR().SetResult(&LinodeProfile{})
+	result := linodeGET(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if profile, ok := result.data.(*LinodeProfile); ok {
+		return profile, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// ListProfileSSHKeys returns a list of SSH keys stored in user profile.
+func (e *LinodeAPI) ListProfileSSHKeys() ([]LinodeSSHKey, error) {
+	endpoint := "/profile/sshkeys"
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeSSHKeyPaginated{})
+	list := []LinodeSSHKey{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeSSHKey); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+// ListInvoiceItems returns items of all invoices issued within [since, until)
+// that bill usage started within the same period.
+func (e *LinodeAPI) ListInvoiceItems(since time.Time, until time.Time) ([]LinodeInvoiceItem, error) {
+	invoices, err := e.listInvoices()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []LinodeInvoiceItem{}
+	for _, invoice := range invoices {
+		// Invoices are issued after the billed period, so an invoice dated
+		// past the range may still bill usage within it.
+		date, err := time.Parse(linodeDateLayout, invoice.Date)
+		if err != nil || date.Before(since) {
+			continue
+		}
+
+		items, err := e.listInvoiceItems(invoice.ID)
+		if err != nil {
+			return list, err
+		}
+		for _, item := range items {
+			from, err := time.Parse(linodeDateLayout, item.From)
+			if err != nil || from.Before(since) || !from.Before(until) {
+				continue
+			}
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+func (e *LinodeAPI) listInvoices() ([]LinodeInvoice, error) {
+	endpoint := "/account/invoices"
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeInvoicePaginated{})
+	list := []LinodeInvoice{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeInvoice); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+func (e *LinodeAPI) listInvoiceItems(invoiceID int) ([]LinodeInvoiceItem, error) {
+	endpoint := fmt.Sprintf("/account/invoices/%d/items", invoiceID)
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeInvoiceItemPaginated{})
+	list := []LinodeInvoiceItem{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeInvoiceItem); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+// GetGrants returns permissions of the user owning the access token. Nil is
+// returned for unrestricted users, who are allowed to do anything.
+func (e *LinodeAPI) GetGrants() (*LinodeGrants, error) {
+	endpoint := "/profile/grants"
+	r := e.authedR().SetResult(&LinodeGrants{})
+	result := linodeGET(endpoint, r)
+
+	if result.err != nil {
+		return nil, result.err
+	}
+	if result.response.StatusCode() == http.StatusNoContent {
+		return nil, nil
+	}
+
+	if grants, ok := result.data.(*LinodeGrants); ok {
+		return grants, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
 // ListStackScriptsPrivate returns a list of all private StackScripts.
 func (e *LinodeAPI) ListStackScriptsPrivate() ([]StackScript, error) {
 	endpoint := "/linode/stackscripts"
-	r := e.authedR().SetResult([]StackScript{}).SetHeader("X-Filter", `{"mine": true}`)
-	iter := linodePaginatedGET(endpoint, r, &stackScriptPaginated{})
+	newRequest := func() *resty.Request {
+		return e.authedR().SetHeader("X-Filter", `{"mine": true}`)
+	}
+	iter := linodePaginatedGET(endpoint, newRequest, &stackScriptPaginated{})
 	list := []StackScript{}
 
 	for {
@@ -294,11 +1230,77 @@ func (e *LinodeAPI) ListStackScriptsPrivate() ([]StackScript, error) {
 	return list, nil
 }
 
+// CreateStackScript creates a private StackScript. Label, images and the
+// script itself are required.
+func (e *LinodeAPI) CreateStackScript(spec *StackScriptSpec) (*StackScript, error) {
+	if len(spec.Label) == 0 {
+		return nil, errors.New("StackScript label is required")
+	}
+	if len(spec.Images) == 0 {
+		return nil, errors.New("StackScript must list compatible images")
+	}
+	if len(spec.Script) == 0 {
+		return nil, errors.New("StackScript body is required")
+	}
+
+	endpoint := "/linode/stackscripts"
+	r := e.authedR().SetBody(spec).SetResult(&StackScript{})
+	result := linodePOST(endpoint, r)
+
+	if result.err != nil {
+		return nil, errors.Wrapf(result.err, "Unable to create StackScript")
+	}
+
+	if script, ok := result.data.(*StackScript); ok {
+		return script, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// UpdateStackScript changes fields of a StackScript which are set in the
+// spec.
+func (e *LinodeAPI) UpdateStackScript(scriptID int, spec *StackScriptSpec) (*StackScript, error) {
+	endpoint := fmt.Sprintf("/linode/stackscripts/%d", scriptID)
+	r := e.authedR().SetBody(spec).SetResult(&StackScript{})
+	result := linodePUT(endpoint, r)
+
+	if result.err != nil {
+		return nil, errors.Wrapf(result.err, "Unable to update StackScript")
+	}
+
+	if script, ok := result.data.(*StackScript); ok {
+		return script, nil
+	}
+	return nil, errors.New("unable to decode RPC return value (" + endpoint + ")")
+}
+
+// DeleteStackScript deletes a private StackScript.
+func (e *LinodeAPI) DeleteStackScript(scriptID int) error {
+	var dummy map[string]interface{}
+	endpoint := fmt.Sprintf("/linode/stackscripts/%d", scriptID)
+	result := linodeDELETE(endpoint, e.authedR().SetResult(&dummy))
+
+	if result.err == nil {
+		return nil
+	}
+	return errors.Wrapf(result.err, "Unable to delete StackScript")
+}
+
 // ListLinodeImages returns a list of deployable images.
 func (e *LinodeAPI) ListLinodeImages() ([]LinodeImage, error) {
+	// Private images are listed too, so the list is specific to the account.
+	list, err := e.metadata.get("/images/"+hashToken(e.apiKey), func() (interface{}, error) {
+		return e.listLinodeImages()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.([]LinodeImage), nil
+}
+
+func (e *LinodeAPI) listLinodeImages() ([]LinodeImage, error) {
 	endpoint := "/images"
-	r := e.authedR().SetResult([]LinodeImage{})
-	iter := linodePaginatedGET(endpoint, r, &linodeImagePaginated{})
+	iter := linodePaginatedGET(endpoint, e.authedR, &linodeImagePaginated{})
 	list := []LinodeImage{}
 
 	for {
@@ -322,9 +1324,18 @@ func (e *LinodeAPI) ListLinodeImages() ([]LinodeImage, error) {
 // ListInstanceTypes returns a list of supported instance types.
 // Can be used without authentication.
 func (e *LinodeAPI) ListInstanceTypes() ([]LinodeType, error) {
+	list, err := e.metadata.get("/linode/types", func() (interface{}, error) {
+		return e.listInstanceTypes()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.([]LinodeType), nil
+}
+
+func (e *LinodeAPI) listInstanceTypes() ([]LinodeType, error) {
 	endpoint := "/linode/types"
-	r := e.unprivR().SetResult([]LinodeType{})
-	iter := linodePaginatedGET(endpoint, r, &linodeTypePaginated{})
+	iter := linodePaginatedGET(endpoint, e.unprivR, &linodeTypePaginated{})
 	list := []LinodeType{}
 
 	for {
@@ -348,9 +1359,43 @@ func (e *LinodeAPI) ListInstanceTypes() ([]LinodeType, error) {
 // ListRegions returns a list of supported geographic regions.
 // Can be used without authentication.
 func (e *LinodeAPI) ListRegions() ([]LinodeRegion, error) {
+	list, err := e.metadata.get("/regions", func() (interface{}, error) {
+		return e.listRegions()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.([]LinodeRegion), nil
+}
+
+// ListKernels returns a list of kernels available to instance configs.
+// Can be used without authentication.
+func (e *LinodeAPI) ListKernels() ([]LinodeKernel, error) {
+	endpoint := "/linode/kernels"
+	iter := linodePaginatedGET(endpoint, e.unprivR, &linodeKernelPaginated{})
+	list := []LinodeKernel{}
+
+	for {
+		item, hasNext := iter.next()
+		if item.err != nil {
+			return list, item.err
+		}
+		if moreItems, ok := item.data.([]LinodeKernel); ok {
+			list = append(list, moreItems...)
+		} else {
+			err := errors.New("unable to decode RPC return value (" + endpoint + ")")
+			return list, err
+		}
+		if !hasNext {
+			break
+		}
+	}
+	return list, nil
+}
+
+func (e *LinodeAPI) listRegions() ([]LinodeRegion, error) {
 	endpoint := "/regions"
-	r := e.unprivR().SetResult([]LinodeRegion{})
-	iter := linodePaginatedGET(endpoint, r, &linodeRegionPaginated{})
+	iter := linodePaginatedGET(endpoint, e.unprivR, &linodeRegionPaginated{})
 	list := []LinodeRegion{}
 
 	for {
@@ -398,13 +1443,48 @@ func (e *LinodeError) IsPermissionsError() bool {
 	return e.isPermissionsError
 }
 
+// AccountNotReadyReason returns reason reported by Linode when the account
+// can't create instances yet, e.g. because terms of service weren't accepted
+// or the account awaits verification.
+func (e *LinodeError) AccountNotReadyReason() (string, bool) {
+	return e.findReason(linodeAccountNotReadyMarkers)
+}
+
+// IPJustificationRequired returns reason reported by Linode when additional
+// IP address can't be allocated until a justification is given to support.
+func (e *LinodeError) IPJustificationRequired() (string, bool) {
+	return e.findReason(linodeIPJustificationMarkers)
+}
+
+// findReason returns the first error reason containing any of the markers,
+// compared case-insensitively.
+func (e *LinodeError) findReason(markers []string) (string, bool) {
+	for _, err := range e.Errors {
+		reason := strings.ToLower(err.Reason)
+		for _, marker := range markers {
+			if strings.Contains(reason, marker) {
+				return err.Reason, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (e *LinodeAPI) newR() *resty.Request {
+	r := e.client.R().SetError(&LinodeError{})
+	if e.ctx != nil {
+		r.SetContext(e.ctx)
+	}
+	return r
+}
+
 func (e *LinodeAPI) unprivR() *resty.Request {
-	return e.client.R().SetError(&LinodeError{})
+	return e.newR()
 }
 
 func (e *LinodeAPI) authedR() *resty.Request {
 	if len(e.apiKey) > 0 {
-		return e.client.R().SetError(&LinodeError{})
+		return e.newR()
 	}
 	panic("Attempted to perform authenticated request, but this LinodeAPI instance has no API key")
 }
@@ -421,6 +1501,12 @@ func (e *LinodeInstanceBuilder) SetGroup(group string) *LinodeInstanceBuilder {
 	return e
 }
 
+// SetTags sets Linode tags.
+func (e *LinodeInstanceBuilder) SetTags(tags []string) *LinodeInstanceBuilder {
+	e.Tags = tags
+	return e
+}
+
 // SetRootPass sets Linode password. This setting must be set, if image is provided.
 func (e *LinodeInstanceBuilder) SetRootPass(rootPass string) *LinodeInstanceBuilder {
 	e.RootPass = rootPass
@@ -1,26 +1,79 @@
 package main
 
 import (
-	"strconv"
-
+	"math/rand"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/resty.v1"
 )
 
 const linodeAPIBaseURL = "https://api.linode.com/v4"
 
+// linodeMetrics receives instrumentation of Linode API calls. It is set at
+// startup.
+var linodeMetrics MetricsSink = noopMetricsSink{}
+
+// linodeMaxRetries limits how many times a request failed due to a transient
+// error is repeated. It is set at startup.
+var linodeMaxRetries = 3
+
+const (
+	// linodeRetryBaseDelay is the delay before the first retry, doubled with
+	// every following one.
+	linodeRetryBaseDelay = 500 * time.Millisecond
+	// linodeRetryMaxDelay caps the delay between retries, including the one
+	// requested by Retry-After.
+	linodeRetryMaxDelay = 30 * time.Second
+)
+
 type paginatedResult interface {
 	pageNumber() int
 	pageCount() int
 	data() interface{}
 }
 
+// Bounds of page size accepted by Linode list endpoints.
+const (
+	linodeMinPageSize = 25
+	linodeMaxPageSize = 500
+)
+
+// linodePageSize is how many items are requested per page of a listing. It
+// is set at startup.
+var linodePageSize = 100
+
+// clampLinodePageSize limits page size to what Linode accepts.
+func clampLinodePageSize(size int) int {
+	if size < linodeMinPageSize {
+		return linodeMinPageSize
+	}
+	if size > linodeMaxPageSize {
+		return linodeMaxPageSize
+	}
+	return size
+}
+
+// linodePageWorkers limits how many pages of a single listing are fetched
+// at once.
+const linodePageWorkers = 4
+
+// pageIterator walks paginated listing. The first page is fetched alone to
+// learn the page count, the remaining pages are then fetched concurrently
+// and handed out in order.
 type pageIterator struct {
-	request  *resty.Request
-	endpoint string
-	page     int
+	newRequest func() *resty.Request
+	endpoint   string
+	result     paginatedResult
+	page       int
+	pageCount  int
+	pages      []apiResult
 }
 
 type apiResult struct {
@@ -49,36 +102,103 @@ func linodeGET(endpoint string, r *resty.Request) apiResult {
 	return linodeSimpleExec("GET", endpoint, r)
 }
 
-func linodePaginatedGET(endpoint string, r *resty.Request, t paginatedResult) pageIterator {
-	iter := pageIterator{
-		request:  r,
-		endpoint: endpoint,
-		page:     1,
+// linodePaginatedGET lists all pages of the endpoint. Each page is fetched
+// with a fresh request made by newRequest and decoded into a new instance of
+// t's type.
+func linodePaginatedGET(endpoint string, newRequest func() *resty.Request, t paginatedResult) pageIterator {
+	return pageIterator{
+		newRequest: newRequest,
+		endpoint:   endpoint,
+		result:     t,
+		page:       1,
 	}
-	r.Result = t
-	return iter
+}
+
+// linodePageGET fetches a single page of the listing, returning its data and
+// the total number of pages.
+func linodePageGET(endpoint string, newRequest func() *resty.Request, t paginatedResult, page int) (apiResult, int) {
+	iter := linodePaginatedGET(endpoint, newRequest, t)
+	result, pageInfo := iter.fetchPage(page)
+	if result.err != nil {
+		return result, 0
+	}
+	return result, pageInfo.pageCount()
 }
 
 func (e *pageIterator) next() (apiResult, bool) {
-	if e.page > 1 {
-		e.request.SetQueryParam("page", strconv.Itoa(e.page))
+	if e.page == 1 {
+		result, pageInfo := e.fetchPage(1)
+		if result.err != nil {
+			return result, false
+		}
+		e.pageCount = pageInfo.pageCount()
+		e.prefetch()
+		e.page++
+		return result, e.page <= e.pageCount
 	}
 
-	result := linodeSimpleExec("GET", e.endpoint, e.request)
+	result := e.pages[e.page-2]
 	if result.err != nil {
 		return result, false
 	}
+	e.page++
+	return result, e.page <= e.pageCount
+}
+
+// prefetch fetches pages following the first one concurrently, keeping them
+// in order.
+func (e *pageIterator) prefetch() {
+	if e.pageCount < 2 {
+		return
+	}
+	e.pages = make([]apiResult, e.pageCount-1)
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, linodePageWorkers)
+	for page := 2; page <= e.pageCount; page++ {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(page int) {
+			defer wg.Done()
+			e.pages[page-2], _ = e.fetchPage(page)
+			<-workers
+		}(page)
+	}
+	wg.Wait()
+}
+
+func (e *pageIterator) fetchPage(page int) (apiResult, paginatedResult) {
+	request := e.newRequest()
+	request.Result = reflect.New(reflect.TypeOf(e.result).Elem()).Interface()
+	request.SetQueryParam("page_size", strconv.Itoa(linodePageSize))
+	if page > 1 {
+		request.SetQueryParam("page", strconv.Itoa(page))
+	}
+
+	result := linodeSimpleExec("GET", e.endpoint, request)
+	if result.err != nil {
+		return result, nil
+	}
 
 	response := result.response
 	pageInfo, ok := response.Result().(paginatedResult)
 	if !ok {
 		err := errors.Errorf("Possible API incompatibility: Unable to parse paginated response")
-		return apiResult{nil, err, response}, false
+		return apiResult{nil, err, response}, nil
 	}
+	return apiResult{pageInfo.data(), nil, response}, pageInfo
+}
 
-	e.page++
-	hasMorePages := e.page < pageInfo.pageCount()
-	return apiResult{pageInfo.data(), nil, response}, hasMorePages
+// linodeEndpointFamily replaces IDs in the endpoint with a placeholder, so
+// that e.g. calls for different instances share metric labels.
+func linodeEndpointFamily(endpoint string) string {
+	parts := strings.Split(endpoint, "/")
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			parts[i] = ":id"
+		}
+	}
+	return strings.Join(parts, "/")
 }
 
 func linodeSimpleExec(method string, endpoint string, r *resty.Request) apiResult {
@@ -100,7 +220,89 @@ func linodeSimpleExec(method string, endpoint string, r *resty.Request) apiResul
 		panic("Unknown request method: " + method)
 	}
 
+	for attempt := 0; ; attempt++ {
+		result := linodeExecOnce(method, endpoint, execRequest)
+		if attempt >= linodeMaxRetries || !isTransientLinodeError(method, result) {
+			return result
+		}
+		// Cancelled request fails with a transport error, which isn't worth
+		// repeating.
+		ctx := r.Context()
+		if ctx.Err() != nil {
+			return result
+		}
+
+		delay := linodeRetryDelay(attempt, result.response)
+		log.WithFields(log.Fields{
+			"method":   method,
+			"endpoint": endpoint,
+			"cause":    result.err,
+			"attempt":  attempt + 1,
+			"delay":    delay,
+		}).Warn("Linode API request failed, retrying")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return result
+		}
+	}
+}
+
+// isTransientLinodeError tells whether the request may succeed when repeated.
+// Only GET is retried after server errors, since a failed POST may have
+// been carried out; rate limited requests are never carried out and can be
+// retried regardless of the method.
+func isTransientLinodeError(method string, result apiResult) bool {
+	if result.err == nil {
+		return false
+	}
+	if result.response == nil || result.response.RawResponse == nil {
+		return method == "GET"
+	}
+
+	status := result.response.StatusCode()
+	switch {
+	case status == http.StatusTooManyRequests:
+		return true
+	case status >= 500:
+		return method == "GET"
+	default:
+		return false
+	}
+}
+
+// linodeRetryDelay returns exponential backoff with jitter, or the delay
+// requested by Linode in Retry-After header.
+func linodeRetryDelay(attempt int, response *resty.Response) time.Duration {
+	if response != nil && response.RawResponse != nil {
+		if seconds, err := strconv.Atoi(response.Header().Get("Retry-After")); err == nil && seconds >= 0 {
+			delay := time.Duration(seconds) * time.Second
+			if delay > linodeRetryMaxDelay {
+				delay = linodeRetryMaxDelay
+			}
+			return delay
+		}
+	}
+
+	delay := linodeRetryBaseDelay << uint(attempt)
+	if delay > linodeRetryMaxDelay {
+		delay = linodeRetryMaxDelay
+	}
+	// Up to 50% of jitter keeps concurrent requests from retrying in lockstep.
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func linodeExecOnce(
+	method string,
+	endpoint string,
+	execRequest func(string) (*resty.Response, error),
+) apiResult {
+	start := time.Now()
 	response, err := execRequest(linodeAPIBaseURL + endpoint)
+	linodeMetrics.ObserveHistogram("linode_request_duration_seconds", time.Since(start).Seconds(), map[string]string{
+		"method":   method,
+		"endpoint": linodeEndpointFamily(endpoint),
+	})
 	if err != nil {
 		err = errors.Wrapf(err, "%s request ('%s') failed", method, endpoint)
 		return apiResult{nil, err, response}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// linodeClientTTL is how long an unused Linode API client is kept around.
+const linodeClientTTL = 10 * time.Minute
+
+// linodeClientCache keeps one LinodeAPI per access token, so that requests
+// of the same user share connection pool and keep-alive connections to
+// Linode API. Clients that weren't used for the TTL are evicted. All clients
+// share the metadata cache.
+type linodeClientCache struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	debug     bool
+	metadata  *linodeMetadataCache
+	clients   map[string]*cachedLinodeClient
+	lastSweep time.Time
+}
+
+type cachedLinodeClient struct {
+	api      *LinodeAPI
+	lastUsed time.Time
+}
+
+func newLinodeClientCache(ttl time.Duration, debug bool, metadata *linodeMetadataCache) *linodeClientCache {
+	return &linodeClientCache{
+		ttl:       ttl,
+		debug:     debug,
+		metadata:  metadata,
+		clients:   make(map[string]*cachedLinodeClient),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns client for the access token, creating it when there is none.
+// Empty token stands for unauthenticated client.
+func (c *linodeClientCache) Get(accessToken string) *LinodeAPI {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		c.sweep(now)
+	}
+
+	// Keyed by SHA-256 of the token, like access policy entries.
+	key := hashToken(accessToken)
+	client, ok := c.clients[key]
+	if !ok {
+		client = &cachedLinodeClient{}
+		if len(accessToken) > 0 {
+			client.api = NewLinodeAPI(accessToken, c.debug)
+		} else {
+			client.api = NewLinodeAPIUnauthenticated(c.debug)
+		}
+		client.api.metadata = c.metadata
+		c.clients[key] = client
+	}
+	client.lastUsed = now
+	return client.api
+}
+
+// sweep evicts clients that weren't used for the TTL. Caller must hold the
+// mutex.
+func (c *linodeClientCache) sweep(now time.Time) {
+	for key, client := range c.clients {
+		if now.Sub(client.lastUsed) >= c.ttl {
+			delete(c.clients, key)
+		}
+	}
+	c.lastSweep = now
+}
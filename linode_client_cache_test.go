@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLinodeClientDebugFollowsVerbose(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		clients := newLinodeClientCache(linodeClientTTL, verbose, nil)
+		for _, token := range []string{"some-token", ""} {
+			if debug := clients.Get(token).client.Debug; debug != verbose {
+				t.Errorf("verbose %v, token '%s': got debug %v", verbose, token, debug)
+			}
+		}
+	}
+}
+
+func TestLinodeClientCacheReusesClients(t *testing.T) {
+	clients := newLinodeClientCache(time.Minute, false, nil)
+
+	first := clients.Get("some-token")
+	if clients.Get("some-token").client != first.client {
+		t.Error("client of the same token wasn't reused")
+	}
+	if clients.Get("other-token").client == first.client {
+		t.Error("client was shared between tokens")
+	}
+
+	// Client unused for the TTL is replaced on the next sweep.
+	clients.clients[hashToken("some-token")].lastUsed = time.Now().Add(-time.Hour)
+	clients.lastSweep = time.Now().Add(-time.Hour)
+	clients.Get("other-token")
+	if len(clients.clients) != 1 {
+		t.Errorf("got %d cached clients, want 1", len(clients.clients))
+	}
+	if clients.Get("some-token").client == first.client {
+		t.Error("expired client was reused")
+	}
+}
+
+// benchmarkLinodeConnections lists instances with a client returned by
+// newAPI on each iteration and reports the number of new connections to the
+// Linode API per request.
+func benchmarkLinodeConnections(b *testing.B, newAPI func(target *url.URL) *LinodeAPI) {
+	instances := []LinodeInfo{{ID: 1, Label: "hp_instance"}}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(linodeInfoPaginated{Pages: 1, Page: 1, Data: instances})
+	}))
+	var connections int64
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := newAPI(target).ListLinodeInstances(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&connections))/float64(b.N), "conns/op")
+}
+
+// Each client has its own connection pool, like resty clients do.
+func newBenchmarkTransport(target *url.URL) (*redirectTransport, *http.Transport) {
+	base := &http.Transport{}
+	return &redirectTransport{target: target, base: base}, base
+}
+
+func BenchmarkLinodeClientPerRequest(b *testing.B) {
+	var transports []*http.Transport
+	defer func() {
+		for _, transport := range transports {
+			transport.CloseIdleConnections()
+		}
+	}()
+	benchmarkLinodeConnections(b, func(target *url.URL) *LinodeAPI {
+		api := NewLinodeAPI("some-token", false)
+		transport, base := newBenchmarkTransport(target)
+		transports = append(transports, base)
+		api.client.SetTransport(transport)
+		return api
+	})
+}
+
+func BenchmarkLinodeClientCache(b *testing.B) {
+	clients := newLinodeClientCache(linodeClientTTL, false, nil)
+	var base *http.Transport
+	defer func() {
+		if base != nil {
+			base.CloseIdleConnections()
+		}
+	}()
+	benchmarkLinodeConnections(b, func(target *url.URL) *LinodeAPI {
+		api := clients.Get("some-token")
+		if base == nil {
+			var transport *redirectTransport
+			transport, base = newBenchmarkTransport(target)
+			api.client.SetTransport(transport)
+		}
+		return api
+	})
+}
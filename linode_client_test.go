@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/resty.v1"
+)
+
+// servePages serves a listing of pageCount pages with pageSize instances
+// each, numbering instances from 1 across pages.
+func servePages(t *testing.T, pageCount int, pageSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if s := r.URL.Query().Get("page"); len(s) > 0 {
+			var err error
+			if page, err = strconv.Atoi(s); err != nil || page < 1 || page > pageCount {
+				http.Error(w, "bad page", http.StatusBadRequest)
+				return
+			}
+		}
+
+		instances := make([]LinodeInfo, 0, pageSize)
+		for i := 0; i < pageSize; i++ {
+			instances = append(instances, LinodeInfo{ID: (page-1)*pageSize + i + 1})
+		}
+		writeJSON(t, w, http.StatusOK, linodeInfoPaginated{
+			Pages:   pageCount,
+			Results: pageCount * pageSize,
+			Data:    instances,
+			Page:    page,
+		})
+	})
+}
+
+func TestListLinodeInstancesFetchesAllPages(t *testing.T) {
+	api := newTestLinodeAPI(t, servePages(t, 5, 3))
+
+	instances, err := api.ListLinodeInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 15 {
+		t.Fatalf("got %d instances, want 15", len(instances))
+	}
+	for i, instance := range instances {
+		if instance.ID != i+1 {
+			t.Errorf("instance #%d: got ID %d, want %d", i, instance.ID, i+1)
+		}
+	}
+}
+
+func TestListLinodeInstancesReportsFailedPage(t *testing.T) {
+	pages := servePages(t, 5, 3)
+	api := newTestLinodeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "4" {
+			http.Error(w, "{}", http.StatusBadRequest)
+			return
+		}
+		pages.ServeHTTP(w, r)
+	}))
+
+	if _, err := api.ListLinodeInstances(); err == nil {
+		t.Error("listing with a failed page succeeded")
+	}
+}
+
+// failingTimes responds with status the given number of times before
+// handing requests to the handler. Retry-After keeps retries immediate.
+func failingTimes(times int32, status int, handler http.Handler) (http.Handler, *int32) {
+	var calls int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= times {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "{}", status)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}), &calls
+}
+
+func TestLinodeGETRetriesTransientErrors(t *testing.T) {
+	handler, calls := failingTimes(2, http.StatusServiceUnavailable, serveInstances(t, []LinodeInfo{{ID: 1}}))
+	api := newTestLinodeAPI(t, handler)
+
+	instances, err := api.ListLinodeInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 {
+		t.Errorf("got %d instances, want 1", len(instances))
+	}
+	if *calls != 3 {
+		t.Errorf("got %d requests, want 3", *calls)
+	}
+}
+
+func TestLinodeGETGivesUpAfterMaxRetries(t *testing.T) {
+	handler, calls := failingTimes(10, http.StatusServiceUnavailable, serveInstances(t, nil))
+	api := newTestLinodeAPI(t, handler)
+
+	if _, err := api.ListLinodeInstances(); err == nil {
+		t.Error("request succeeded")
+	}
+	if want := int32(linodeMaxRetries + 1); *calls != want {
+		t.Errorf("got %d requests, want %d", *calls, want)
+	}
+}
+
+func TestLinodeRequestsNotRetried(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		do     func(api *LinodeAPI) error
+	}{
+		{
+			name:   "GET failed with 404",
+			status: http.StatusNotFound,
+			do: func(api *LinodeAPI) error {
+				_, err := api.ListLinodeInstances()
+				return err
+			},
+		},
+		{
+			name:   "DELETE failed with 503",
+			status: http.StatusServiceUnavailable,
+			do: func(api *LinodeAPI) error {
+				return api.DeleteInstance(1)
+			},
+		},
+	}
+	for _, c := range cases {
+		handler, calls := failingTimes(1, c.status, serveInstances(t, nil))
+		api := newTestLinodeAPI(t, handler)
+
+		if err := c.do(api); err == nil {
+			t.Errorf("%s: request succeeded", c.name)
+		}
+		if *calls != 1 {
+			t.Errorf("%s: got %d requests, want 1", c.name, *calls)
+		}
+	}
+}
+
+func TestLinodeRetryDelayHonorsRetryAfter(t *testing.T) {
+	response := &resty.Response{RawResponse: &http.Response{
+		Header: http.Header{"Retry-After": []string{"7"}},
+	}}
+	if delay := linodeRetryDelay(0, response); delay != 7*time.Second {
+		t.Errorf("got delay %v, want 7s", delay)
+	}
+
+	response.RawResponse.Header.Set("Retry-After", "3600")
+	if delay := linodeRetryDelay(0, response); delay != linodeRetryMaxDelay {
+		t.Errorf("got delay %v, want %v", delay, linodeRetryMaxDelay)
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := linodeRetryDelay(attempt, nil)
+		if delay <= 0 || delay > linodeRetryMaxDelay {
+			t.Errorf("attempt #%d: got delay %v", attempt, delay)
+		}
+	}
+}
+
+func TestLinodeRequestCancelledWithContext(t *testing.T) {
+	started := make(chan struct{})
+	api := newTestLinodeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err := api.WithContext(ctx).QueryLinode(1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context cancellation", err)
+	}
+}
+
+func TestListLinodeInstancesSendsPageSize(t *testing.T) {
+	defer func(size int) { linodePageSize = size }(linodePageSize)
+	linodePageSize = clampLinodePageSize(250)
+
+	var pageSize string
+	api := newTestLinodeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageSize = r.URL.Query().Get("page_size")
+		writeJSON(t, w, http.StatusOK, linodeInfoPaginated{Pages: 1, Page: 1})
+	}))
+
+	if _, err := api.ListLinodeInstances(); err != nil {
+		t.Fatal(err)
+	}
+	if pageSize != "250" {
+		t.Errorf("got page_size '%s', want '250'", pageSize)
+	}
+}
+
+func TestClampLinodePageSize(t *testing.T) {
+	cases := map[int]int{
+		0:    linodeMinPageSize,
+		100:  100,
+		1000: linodeMaxPageSize,
+	}
+	for size, want := range cases {
+		if got := clampLinodePageSize(size); got != want {
+			t.Errorf("clampLinodePageSize(%d): got %d, want %d", size, got, want)
+		}
+	}
+}
+
+func TestLinodeEndpointFamily(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"/linode/instances":                 "/linode/instances",
+		"/linode/instances/123":             "/linode/instances/:id",
+		"/linode/instances/123/configs/456": "/linode/instances/:id/configs/:id",
+		"/networking/ips/192.0.2.10":        "/networking/ips/192.0.2.10",
+	} {
+		if got := linodeEndpointFamily(endpoint); got != want {
+			t.Errorf("%s: got %s, want %s", endpoint, got, want)
+		}
+	}
+}
+
+func TestLinodeRequestsObserveLatency(t *testing.T) {
+	metrics := &fakeMetricsSink{}
+	linodeMetrics = metrics
+	t.Cleanup(func() { linodeMetrics = noopMetricsSink{} })
+
+	linode := newFakeLinode(t, LinodeInfo{ID: 42, Label: "hp_instance"})
+	if _, err := linode.api.QueryLinode(42); err != nil {
+		t.Fatal(err)
+	}
+	// Instance IDs are left out of labels to keep their cardinality low.
+	if call := "histogram linode_request_duration_seconds{endpoint=/linode/instances/:id,method=GET}"; !metrics.called(call) {
+		t.Errorf("missing metric call %s, got %v", call, metrics.calls)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// providerStatusTTL is how long account notifications are cached. Status
+// changes quickly during incidents, so it's much shorter than metadata TTL.
+const providerStatusTTL = time.Minute
+
+// linodeMetadataCache keeps rarely changing Linode listings (regions, types,
+// images) keyed by endpoint. Listings visible only to a particular account
+// must include the account in the key. Zero TTL disables caching.
+type linodeMetadataCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]*metadataEntry
+
+	hits   int64
+	misses int64
+}
+
+type metadataEntry struct {
+	data    interface{}
+	expires time.Time
+}
+
+func newLinodeMetadataCache(ttl time.Duration) *linodeMetadataCache {
+	return &linodeMetadataCache{
+		ttl:     ttl,
+		entries: make(map[string]*metadataEntry),
+	}
+}
+
+// get returns cached data for the key, calling fetch when it is missing or
+// expired. Errors are not cached. Cached data is shared and must not be
+// modified.
+func (c *linodeMetadataCache) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	if c == nil || c.ttl <= 0 {
+		return fetch()
+	}
+
+	now := time.Now()
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		c.logAccess(key, atomic.AddInt64(&c.hits, 1), atomic.LoadInt64(&c.misses), "Metadata cache hit")
+		return entry.data, nil
+	}
+	c.logAccess(key, atomic.LoadInt64(&c.hits), atomic.AddInt64(&c.misses, 1), "Metadata cache miss")
+
+	data, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.entries[key] = &metadataEntry{data: data, expires: now.Add(c.ttl)}
+	c.mutex.Unlock()
+	return data, nil
+}
+
+func (c *linodeMetadataCache) logAccess(key string, hits int64, misses int64, msg string) {
+	log.WithFields(log.Fields{
+		"key":    key,
+		"hits":   hits,
+		"misses": misses,
+	}).Debug(msg)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLinodeMetadataCache(t *testing.T) {
+	cache := newLinodeMetadataCache(time.Minute)
+	fetches := 0
+	fetch := func() (interface{}, error) {
+		fetches++
+		return fetches, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if data, err := cache.get("/regions", fetch); err != nil || data != 1 {
+			t.Errorf("got %v, %v, want cached 1", data, err)
+		}
+	}
+	if data, _ := cache.get("/types", fetch); data != 2 {
+		t.Errorf("got %v, want 2 for another key", data)
+	}
+
+	cache.entries["/regions"].expires = time.Now().Add(-time.Second)
+	if data, _ := cache.get("/regions", fetch); data != 3 {
+		t.Errorf("got %v, want 3 after expiry", data)
+	}
+}
+
+func TestLinodeMetadataCacheDoesNotCacheErrors(t *testing.T) {
+	cache := newLinodeMetadataCache(time.Minute)
+	fail := true
+	fetch := func() (interface{}, error) {
+		if fail {
+			return nil, errors.New("outage")
+		}
+		return "regions", nil
+	}
+
+	if _, err := cache.get("/regions", fetch); err == nil {
+		t.Fatal("error was swallowed")
+	}
+	fail = false
+	if data, err := cache.get("/regions", fetch); err != nil || data != "regions" {
+		t.Errorf("got %v, %v after recovery", data, err)
+	}
+}
+
+func TestLinodeMetadataCacheDisabled(t *testing.T) {
+	fetches := 0
+	fetch := func() (interface{}, error) {
+		fetches++
+		return nil, nil
+	}
+	for _, cache := range []*linodeMetadataCache{nil, newLinodeMetadataCache(0)} {
+		fetches = 0
+		cache.get("/regions", fetch)
+		cache.get("/regions", fetch)
+		if fetches != 2 {
+			t.Errorf("got %d fetches, want 2", fetches)
+		}
+	}
+}
+
+func TestListRegionsIsCached(t *testing.T) {
+	requests := 0
+	api := newTestLinodeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		writeJSON(t, w, http.StatusOK, &linodeRegionPaginated{Pages: 1, Page: 1, Data: []LinodeRegion{{ID: "us-east"}}})
+	}))
+	api.metadata = newLinodeMetadataCache(time.Minute)
+
+	for i := 0; i < 3; i++ {
+		regions, err := api.ListRegions()
+		if err != nil || len(regions) != 1 {
+			t.Fatalf("got %v, %v", regions, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+}
@@ -21,6 +21,13 @@ type linodeRegionPaginated struct {
 	Page    int            `json:"page"`
 }
 
+type linodeKernelPaginated struct {
+	Pages   int            `json:"pages"`
+	Results int            `json:"results"`
+	Data    []LinodeKernel `json:"data"`
+	Page    int            `json:"page"`
+}
+
 type linodeImagePaginated struct {
 	Pages   int           `json:"pages"`
 	Results int           `json:"results"`
@@ -35,6 +42,55 @@ type linodeTypePaginated struct {
 	Page    int          `json:"page"`
 }
 
+type linodeDiskPaginated struct {
+	Pages   int          `json:"pages"`
+	Results int          `json:"results"`
+	Data    []LinodeDisk `json:"data"`
+	Page    int          `json:"page"`
+}
+
+type linodeFirewallPaginated struct {
+	Pages   int              `json:"pages"`
+	Results int              `json:"results"`
+	Data    []LinodeFirewall `json:"data"`
+	Page    int              `json:"page"`
+}
+
+type linodeSSHKeyPaginated struct {
+	Pages   int            `json:"pages"`
+	Results int            `json:"results"`
+	Data    []LinodeSSHKey `json:"data"`
+	Page    int            `json:"page"`
+}
+
+type linodeInstanceConfigPaginated struct {
+	Pages   int                    `json:"pages"`
+	Results int                    `json:"results"`
+	Data    []LinodeInstanceConfig `json:"data"`
+	Page    int                    `json:"page"`
+}
+
+type linodeNotificationPaginated struct {
+	Pages   int                  `json:"pages"`
+	Results int                  `json:"results"`
+	Data    []LinodeNotification `json:"data"`
+	Page    int                  `json:"page"`
+}
+
+type linodeInvoicePaginated struct {
+	Pages   int             `json:"pages"`
+	Results int             `json:"results"`
+	Data    []LinodeInvoice `json:"data"`
+	Page    int             `json:"page"`
+}
+
+type linodeInvoiceItemPaginated struct {
+	Pages   int                 `json:"pages"`
+	Results int                 `json:"results"`
+	Data    []LinodeInvoiceItem `json:"data"`
+	Page    int                 `json:"page"`
+}
+
 // paginatedResult implementation for linodeInfoPaginated.
 func (e *linodeInfoPaginated) pageNumber() int {
 	return e.Page
@@ -99,3 +155,107 @@ func (e *linodeTypePaginated) pageCount() int {
 func (e *linodeTypePaginated) data() interface{} {
 	return e.Data
 }
+
+// paginatedResult implementation for linodeDiskPaginated.
+func (e *linodeDiskPaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeDiskPaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeDiskPaginated) data() interface{} {
+	return e.Data
+}
+
+// paginatedResult implementation for linodeFirewallPaginated.
+func (e *linodeFirewallPaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeFirewallPaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeFirewallPaginated) data() interface{} {
+	return e.Data
+}
+
+// paginatedResult implementation for linodeSSHKeyPaginated.
+func (e *linodeSSHKeyPaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeSSHKeyPaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeSSHKeyPaginated) data() interface{} {
+	return e.Data
+}
+
+// paginatedResult implementation for linodeNotificationPaginated.
+func (e *linodeNotificationPaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeNotificationPaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeNotificationPaginated) data() interface{} {
+	return e.Data
+}
+
+// paginatedResult implementation for linodeInvoicePaginated.
+func (e *linodeInvoicePaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeInvoicePaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeInvoicePaginated) data() interface{} {
+	return e.Data
+}
+
+// paginatedResult implementation for linodeInvoiceItemPaginated.
+func (e *linodeInvoiceItemPaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeInvoiceItemPaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeInvoiceItemPaginated) data() interface{} {
+	return e.Data
+}
+
+// paginatedResult implementation for linodeInstanceConfigPaginated.
+func (e *linodeInstanceConfigPaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeInstanceConfigPaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeInstanceConfigPaginated) data() interface{} {
+	return e.Data
+}
+
+// paginatedResult implementation for linodeKernelPaginated.
+func (e *linodeKernelPaginated) pageNumber() int {
+	return e.Page
+}
+
+func (e *linodeKernelPaginated) pageCount() int {
+	return e.Pages
+}
+
+func (e *linodeKernelPaginated) data() interface{} {
+	return e.Data
+}
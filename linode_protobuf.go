@@ -1,505 +1,3114 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"protoapi"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+// namespaceRe describes valid tunnel namespaces. Underscores are reserved as
+// separators of label components.
+var namespaceRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,30}[a-zA-Z0-9])?$`)
+
+// maxTunnelLabelLength is the longest instance label Linode accepts.
+const maxTunnelLabelLength = 64
+
+// rescueDevices are device slots available in rescue mode, in order.
+var rescueDevices = []string{"sda", "sdb", "sdc", "sdd", "sde", "sdf", "sdg"}
+
+// lishGateways maps regions of older datacenters to their LISH gateways.
+var lishGateways = map[string]string{
+	"us-east":      "newark",
+	"us-central":   "dallas",
+	"us-west":      "fremont",
+	"us-southeast": "atlanta",
+	"ca-central":   "toronto1",
+	"eu-west":      "london",
+	"eu-central":   "frankfurt",
+	"ap-south":     "singapore",
+	"ap-northeast": "tokyo2",
+	"ap-west":      "mumbai1",
+	"ap-southeast": "sydney",
+}
+
+// invoiceItemRe extracts instance label and ID from invoice item label, e.g.
+// "Linode 2GB - hp_instance (12345)".
+var invoiceItemRe = regexp.MustCompile(`^.* - (\S+) \((\d+)\)$`)
+
+// maxBatchTunnelStatus limits number of tunnels in BatchTunnelStatus.
+const maxBatchTunnelStatus = 50
+
+// linodeConfig holds server-wide settings of Linode verbs.
+type linodeConfig struct {
+	// How long to sleep between polls of instance status.
+	awaitDelay time.Duration
+	// How many times to poll instance status before giving up, 0 means
+	// polling is limited by awaitTimeout only.
+	awaitAttempts int
+	// How long to wait for an instance before warning that it is slow.
+	awaitWarnAfter time.Duration
+	// How long to wait for an instance before giving up.
+	awaitTimeout time.Duration
+	// How long to wait for a newly created instance of the plan class (e.g.
+	// "dedicated") before giving up. Classes missing here use awaitTimeout.
+	awaitTimeoutByClass map[string]time.Duration
+	// How long to wait for an instance to come back after resize. Resize
+	// migrates instance to another host, which takes much longer than boot.
+	resizeAwaitTimeout time.Duration
+	// Linode API clients shared by requests.
+	clients *linodeClientCache
+	// Operator-defined webhooks, may be nil.
+	hooks *provisionHooks
+	// Short-lived cache of account notifications.
+	notifications *linodeMetadataCache
+	// Key signing list cursors handed to clients.
+	cursorKey []byte
+	// Tags enforced on created and rebuilt tunnels, may be nil.
+	tagPolicy *tagPolicy
+	// Audit trail of tunnel lifecycle, nil disables it.
+	audit *auditLogger
+	// Outcomes of recent creates.
+	reliability *reliabilityTracker
+	// Whether listings failed midway return what was fetched so far.
+	partialResults bool
+	// Whether mutating verbs on tunnels with duplicate instances are refused.
+	strictSingleTunnel bool
+}
+
+// defaultRequestTimeout limits verbs which don't wait for instances.
+const defaultRequestTimeout = 45 * time.Second
+
+// cleanupTimeout limits Linode requests undoing a half-done operation, which
+// are made even after the request was cancelled or timed out.
+const cleanupTimeout = 30 * time.Second
+
+// verbAwaits counts awaits which a verb makes one after another.
+type verbAwaits struct {
+	// Awaits of instance booting or shutting down, or of a disk, each limited
+	// by the await timeout.
+	boot int
+	// Awaits of instance moving to another host or getting its disks copied,
+	// each limited by the resize await timeout.
+	host int
+	// Whether boot awaits are limited by timeout of the plan class instead.
+	planClass bool
+}
+
+// awaitingVerbs lists verbs which wait for instances, e.g. rescue shuts the
+// instance down and then boots it into rescue mode. Other verbs don't wait.
+var awaitingVerbs = map[string]verbAwaits{
+	"LinodeCreateTunnel":        {boot: 1, planClass: true},
+	"LinodeRebuildTunnel":       {boot: 2},
+	"LinodeRebootTunnel":        {boot: 1},
+	"LinodeRescueTunnel":        {boot: 2},
+	"LinodeShutdownTunnel":      {boot: 1},
+	"LinodeRestoreTunnelBackup": {boot: 1},
+	"LinodeResizeTunnel":        {host: 1},
+	"LinodeResizeTunnelDisk":    {boot: 3},
+	"LinodeAcceptMaintenance":   {boot: 1, host: 1},
+	"LinodeCloneTunnel":         {boot: 1, host: 1},
+}
+
+// requestTimeout returns how long a verb may take, including all awaits of
+// the verb at their longest. Deadline that cuts a sequence of awaits short
+// could leave the instance e.g. shut down halfway through.
+func (c *linodeConfig) requestTimeout(verb string) time.Duration {
+	awaits := awaitingVerbs[verb]
+	bootTimeout := c.awaitTimeout
+	if awaits.planClass {
+		for _, classTimeout := range c.awaitTimeoutByClass {
+			if classTimeout > bootTimeout {
+				bootTimeout = classTimeout
+			}
+		}
+	}
+	return time.Duration(awaits.boot)*bootTimeout +
+		time.Duration(awaits.host)*c.resizeAwaitTimeout +
+		defaultRequestTimeout
+}
+
 type protobufLinode struct {
+	ctx            context.Context
 	writer         aProtobufWriter
-	instanceLabel  string
+	config         *linodeConfig
+	provider       TunnelProvider
+	labelPrefix    string
+	instanceName   string
 	instanceImage  string
 	instanceScript string
 }
 
-func newProtobufLinode(w aProtobufWriter) *protobufLinode {
-	return &protobufLinode{
+// newProtobufLinode creates handler of a single request. Context is the
+// request context, waiting for Linode is abandoned once it is done. Tunnel
+// verbs are carried out by the provider made by newProvider.
+func newProtobufLinode(
+	ctx context.Context,
+	w aProtobufWriter,
+	config *linodeConfig,
+	newProvider tunnelProviderFactory,
+) *protobufLinode {
+	p := &protobufLinode{
+		ctx:            ctx,
 		writer:         w,
-		instanceLabel:  "hp_instance",
+		config:         config,
+		labelPrefix:    "hp",
+		instanceName:   "instance",
 		instanceImage:  "linode/debian9",
 		instanceScript: "freedom_node",
 	}
+	p.provider = newProvider(p)
+	return p
 }
 
 func (p *protobufLinode) CreateTunnel(args *protoapi.LinodeCreateTunnelRequest) error {
-	api := NewLinodeAPI(p.extractAuth(args.Auth))
+	ref := p.tunnelRef(args.Auth, args.Namespace, args.TunnelName)
+	req := &CreateTunnelRequest{
+		TunnelRef: ref,
+		Region:    args.Region,
+		Plan:      args.Plan,
+		Spec: TunnelSpec{
+			SSHKeys:         args.SshKeys,
+			SSHKeyLabels:    args.SshKeyLabels,
+			RootPassword:    args.RootPassword,
+			AccountName:     args.RegularAccountName,
+			AccountPassword: args.RegularAccountPassword,
+			Timezone:        args.Timezone,
+			Tags:            args.Tags,
+			WireGuard:       p.protobufWireGuardToSpec(args.WireguardOptions),
+			Obfsproxy4:      p.protobufObfsproxy4ToSpec(args.Obfsproxy4Options),
+			Obfsproxy6:      p.protobufObfsproxy6ToSpec(args.Obfsproxy6Options),
+			Firewall:        p.protobufFirewallToSpec(args.FirewallRules),
+		},
+		DryRun: args.DryRun,
+	}
+	tunnel, err := p.provider.CreateTunnel(req)
+	// Dry runs provision nothing, so there is nothing to audit.
+	if !req.DryRun {
+		event := &auditEvent{
+			Action:    "create",
+			Namespace: ref.Namespace,
+			Name:      ref.Name,
+			Region:    args.Region,
+			Plan:      args.Plan,
+			Err:       err,
+		}
+		if err == nil {
+			event.Instance = tunnel.Tunnel
+		}
+		p.config.audit.Record(p.ctx, event)
+	}
+	if err != nil {
+		return p.writer.WriteError(p.createCreateTunnelErr(err), err)
+	}
+
+	protoInstance := p.tunnelToProtobuf(tunnel.Tunnel)
+	warnings := p.tunnelWarningsToProtobuf(tunnel.Warnings)
+	endpoints := p.tunnelEndpointsToProtobuf(&req.Spec)
+	return p.writer.WriteMessage(p.createCreateTunnelOK(protoInstance, tunnel.Slow, tunnel.Duration, warnings, endpoints, tunnel.DryRun))
+}
 
-	if err := p.ensureTunnelDoesNotExist(api, p.instanceLabel); err != nil {
-		p.writer.WriteError(p.createCreateTunnelErr(err), err)
+func (p *protobufLinode) RebuildTunnel(args *protoapi.LinodeRebuildTunnelRequest) error {
+	ref := p.tunnelRef(args.Auth, args.Namespace, args.TunnelName)
+	req := &RebuildTunnelRequest{
+		TunnelRef:   ref,
+		ConfigLabel: args.ConfigLabel,
+		Spec: TunnelSpec{
+			SSHKeys:         args.SshKeys,
+			RootPassword:    args.RootPassword,
+			AccountName:     args.RegularAccountName,
+			AccountPassword: args.RegularAccountPassword,
+			WireGuard:       p.protobufWireGuardToSpec(args.WireguardOptions),
+			Obfsproxy4:      p.protobufObfsproxy4ToSpec(args.Obfsproxy4Options),
+			Obfsproxy6:      p.protobufObfsproxy6ToSpec(args.Obfsproxy6Options),
+		},
+		DryRun: args.DryRun,
+	}
+	tunnel, err := p.provider.RebuildTunnel(req)
+	if !req.DryRun {
+		event := &auditEvent{Action: "rebuild", Namespace: ref.Namespace, Name: ref.Name, Err: err}
+		if err == nil {
+			event.Instance = tunnel.Tunnel
+		}
+		p.config.audit.Record(p.ctx, event)
+	}
+	if err != nil {
+		return p.writer.WriteError(p.createRebuildTunnelErr(err), err)
 	}
 
-	// Configure builder.
-	tunnelBuilder := api.NewInstanceBuilder(args.Region, args.Plan)
-	tunnelBuilder.SetLabel(p.instanceLabel)
-	tunnelBuilder.SetAuthorizedKeys(args.SshKeys)
-	tunnelBuilder.SetImage(p.instanceImage)
-	tunnelBuilder.SetBooted(true)
-	tunnelBuilder.SetBackupsEnabled(false)
-	tunnelBuilder.SetRootPass(args.RootPassword)
+	protoInstance := p.tunnelToProtobuf(tunnel.Tunnel)
+	warnings := p.tunnelWarningsToProtobuf(tunnel.Warnings)
+	endpoints := p.tunnelEndpointsToProtobuf(&req.Spec)
+	return p.writer.WriteMessage(p.createRebuildTunnelOK(protoInstance, tunnel.Slow, tunnel.Duration, warnings, endpoints, tunnel.DryRun))
+}
 
-	script, params, err := p.makeStackScriptParams(
-		api, p.instanceScript,
-		args.RegularAccountName, args.RegularAccountPassword,
-		args.WireguardOptions, args.Obfsproxy4Options, args.Obfsproxy6Options,
-	)
+func (p *protobufLinode) DestroyTunnel(args *protoapi.LinodeDestroyTunnelRequest) error {
+	ref := p.tunnelRef(args.Auth, args.Namespace, args.TunnelName)
+	tunnel, err := p.provider.DestroyTunnel(&DestroyTunnelRequest{
+		TunnelRef:  ref,
+		InstanceID: int(args.InstanceId),
+	})
+	p.config.audit.Record(p.ctx, &auditEvent{
+		Action:    "destroy",
+		Namespace: ref.Namespace,
+		Name:      ref.Name,
+		Instance:  tunnel,
+		Err:       err,
+	})
 	if err != nil {
-		return p.writer.WriteError(p.createCreateTunnelErr(err), err)
+		return p.writer.WriteError(p.createDestroyTunnelErr(err), err)
 	}
-	tunnelBuilder.SetStackscript(script.ID, params)
+	return p.writer.WriteMessage(p.createDestroyTunnelOK())
+}
 
-	// Create instance.
-	instance, err := tunnelBuilder.Create()
+func (p *protobufLinode) TunnelStatus(args *protoapi.LinodeGetTunnelStatusRequest) error {
+	ref := p.tunnelRef(args.Auth, args.Namespace, args.TunnelName)
+	status, err := p.provider.TunnelStatus(&ref)
 	if err != nil {
-		p.logError(err, "Couldn't create Linode instance")
-		return p.writer.WriteError(p.createCreateTunnelErr(err), err)
+		return p.writer.WriteError(p.createTunnelStatusErr(err), err)
 	}
 
-	p.logInstance(instance, "Job to create instance was started successfully")
-	protoInstance := p.linodeInstanceToProtobuf(instance)
-	return p.writer.WriteMessage(p.createCreateTunnelOK(protoInstance))
+	var conflicts []*protoapi.LinodeInstanceConflict
+	for _, tunnel := range status.Conflicts {
+		conflicts = append(conflicts, p.tunnelToConflict(tunnel))
+	}
+
+	protoTunnel := p.tunnelToProtobuf(status.Tunnel)
+	warnings := p.tunnelWarningsToProtobuf(status.Warnings)
+	return p.writer.WriteMessage(p.createTunnelStatusOK(protoTunnel, conflicts, warnings))
 }
 
-func (p *protobufLinode) RebuildTunnel(args *protoapi.LinodeRebuildTunnelRequest) error {
-	api := NewLinodeAPI(p.extractAuth(args.Auth))
+func (p *protobufLinode) AcceptMaintenance(args *protoapi.LinodeAcceptMaintenanceRequest) error {
+	api := p.newLinodeAPI(args.Auth)
 
-	tunnel, err := p.ensureTunnelExists(api, p.instanceLabel)
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
 	if err != nil {
-		return p.writer.WriteError(p.createRebuildTunnelErr(err), err)
+		return p.writer.WriteError(p.createAcceptMaintenanceErr(err), err)
 	}
 
-	tunnelRebuilder := api.NewInstanceRebuilder(tunnel.ID)
-	tunnelRebuilder.SetAuthorizedKeys(args.SshKeys)
-	tunnelRebuilder.SetBooted(true)
-	tunnelRebuilder.SetImage(p.instanceImage)
-	tunnelRebuilder.SetRootPass(args.RootPassword)
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createAcceptMaintenanceErr(err), err)
+	}
 
-	script, params, err := p.makeStackScriptParams(
-		api, p.instanceScript,
-		args.RegularAccountName, args.RegularAccountPassword,
-		args.WireguardOptions, args.Obfsproxy4Options, args.Obfsproxy6Options,
-	)
+	err = api.InitiateMigration(tunnel.ID)
 	if err != nil {
-		return p.writer.WriteError(p.createRebuildTunnelErr(err), err)
+		p.logError(err, "Couldn't initiate migration")
+		return p.writer.WriteError(p.createAcceptMaintenanceErr(err), err)
 	}
-	tunnelRebuilder.SetStackscript(script.ID, params)
+	p.logInstance(tunnel, "Scheduled migration was initiated")
 
-	instance, err := tunnelRebuilder.Rebuild()
+	// Instance keeps reporting running until the migration actually starts,
+	// so it has to leave that status before the wait for running means
+	// anything. Migration takes about as long as resize.
+	if _, err := p.awaitStatusLeft(api, tunnel.ID, LinodeStatusRunning); err != nil {
+		return p.writer.WriteError(p.createAcceptMaintenanceErr(err), err)
+	}
+	instance, _, err := p.awaitUntilStatusWithin(
+		api, tunnel.ID, LinodeStatusRunning, p.config.resizeAwaitTimeout, 0,
+	)
 	if err != nil {
-		p.logError(err, "Couldn't rebuild Linode instance")
-		return p.writer.WriteError(p.createRebuildTunnelErr(err), err)
+		return p.writer.WriteError(p.createAcceptMaintenanceErr(err), err)
 	}
 
-	p.logInstance(instance, "Job to rebuild instance was started successfully")
+	p.logInstance(instance, "Instance was successfully migrated")
 	protoInstance := p.linodeInstanceToProtobuf(instance)
-	return p.writer.WriteMessage(p.createRebuildTunnelOK(protoInstance))
+	return p.writer.WriteMessage(p.createAcceptMaintenanceOK(protoInstance))
 }
 
-func (p *protobufLinode) DestroyTunnel(args *protoapi.LinodeDestroyTunnelRequest) error {
-	api := NewLinodeAPI(p.extractAuth(args.Auth))
+func (p *protobufLinode) ResizeTunnelDisk(args *protoapi.LinodeResizeTunnelDiskRequest) error {
+	api := p.newLinodeAPI(args.Auth)
 
-	tunnel, err := p.ensureTunnelExists(api, p.instanceLabel)
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
 	if err != nil {
-		return p.writer.WriteError(p.createDestroyTunnelErr(err), err)
+		return p.writer.WriteError(p.createResizeTunnelDiskErr(err), err)
 	}
 
-	err = api.DeleteInstance(tunnel.ID)
+	tunnel, err := p.ensureTunnelMutable(api, label)
 	if err != nil {
-		p.logError(err, "Couldn't delete instance")
-		return p.writer.WriteError(p.createDestroyTunnelErr(err), err)
+		return p.writer.WriteError(p.createResizeTunnelDiskErr(err), err)
 	}
-	p.logInstance(tunnel, "Instance was successfully deleted")
-	return p.writer.WriteMessage(p.createDestroyTunnelOK())
-}
 
-func (p *protobufLinode) TunnelStatus(args *protoapi.LinodeGetTunnelStatusRequest) error {
-	api := NewLinodeAPI(p.extractAuth(args.Auth))
+	disks, err := api.ListInstanceDisks(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't list instance disks")
+		return p.writer.WriteError(p.createResizeTunnelDiskErr(err), err)
+	}
+
+	// Find the disk being resized. When disk isn't specified, the largest one
+	// is assumed to be the root disk.
+	var disk *LinodeDisk
+	for i := range disks {
+		if args.DiskId != 0 && int64(disks[i].ID) == args.DiskId {
+			disk = &disks[i]
+		} else if args.DiskId == 0 && (disk == nil || disks[i].Size > disk.Size) {
+			disk = &disks[i]
+		}
+	}
+	if disk == nil {
+		err = errors.Errorf("Disk %d does not exist", args.DiskId)
+		return p.writer.WriteError(p.createResizeTunnelDiskErr(err), err)
+	}
 
-	tunnel, err := p.ensureTunnelExists(api, p.instanceLabel)
+	otherDisksSize := 0
+	for _, d := range disks {
+		if d.ID != disk.ID {
+			otherDisksSize += d.Size
+		}
+	}
+
+	size := int(args.Size)
+	if size <= 0 {
+		err = errors.New("Disk size must be positive")
+		return p.writer.WriteError(p.createResizeTunnelDiskErr(err), err)
+	}
+	if size+otherDisksSize > tunnel.Specs.Disk {
+		err = errors.Errorf(
+			"Requested size %d MB exceeds available space (%d MB of %d MB used by other disks)",
+			size, otherDisksSize, tunnel.Specs.Disk,
+		)
+		return p.writer.WriteError(p.createResizeTunnelDiskErr(err), err)
+	}
+
+	instance, err := p.resizeDiskOffline(api, args.Auth, tunnel, disk.ID, size)
 	if err != nil {
-		return p.writer.WriteError(p.createTunnelStatusErr(err), err)
+		return p.writer.WriteError(p.createResizeTunnelDiskErr(err), err)
 	}
-	protoTunnel := p.linodeInstanceToProtobuf(tunnel)
-	return p.writer.WriteMessage(p.createTunnelStatusOK(protoTunnel))
+
+	p.logInstance(instance, "Disk was successfully resized", log.Fields{"disk": disk.ID, "size": size})
+	protoInstance := p.linodeInstanceToProtobuf(instance)
+	return p.writer.WriteMessage(p.createResizeTunnelDiskOK(protoInstance))
 }
 
-func (p *protobufLinode) ListPlans(args *protoapi.LinodeListPlansRequest) error {
-	plans, err := NewLinodeAPIUnauthenticated().ListInstanceTypes()
+// resizeDiskOffline resizes the disk while instance is powered off. Instance
+// is booted afterwards only if it was running before. Once it was shut down,
+// failures boot it back on a best-effort basis, so that the tunnel isn't left
+// offline. This includes the request being cancelled or timing out, hence the
+// boot goes through a cleanup API of the auth.
+func (p *protobufLinode) resizeDiskOffline(
+	api *LinodeAPI,
+	auth *protoapi.LinodeAuth,
+	tunnel *LinodeInfo,
+	diskID int,
+	size int,
+) (instance *LinodeInfo, err error) {
+	wasRunning := tunnel.Status == LinodeStatusRunning || tunnel.Status == LinodeStatusBooting
+	if tunnel.Status != LinodeStatusOffline {
+		if err := api.ShutdownInstance(tunnel.ID); err != nil {
+			p.logError(err, "Couldn't shut down instance")
+			return nil, err
+		}
+		defer func() {
+			if err == nil {
+				return
+			}
+			cleanupAPI, cancel := p.newCleanupLinodeAPI(auth)
+			defer cancel()
+			if bootErr := cleanupAPI.BootInstance(tunnel.ID); bootErr != nil {
+				p.logError(bootErr, "Couldn't boot instance after failed disk resize")
+			}
+		}()
+		if _, _, err := p.awaitUntilStatus(api, tunnel.ID, LinodeStatusOffline); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := api.ResizeDisk(tunnel.ID, diskID, size); err != nil {
+		p.logError(err, "Couldn't resize disk")
+		return nil, err
+	}
+	if _, err := p.awaitDiskReady(api, tunnel.ID, diskID); err != nil {
+		return nil, err
+	}
+
+	if !wasRunning {
+		return api.QueryLinode(tunnel.ID)
+	}
+	if err := api.BootInstance(tunnel.ID); err != nil {
+		p.logError(err, "Couldn't boot instance")
+		return nil, err
+	}
+	instance, _, err = p.awaitUntilRunning(api, tunnel.ID)
+	return instance, err
+}
+
+func (p *protobufLinode) GetTunnelSpecDiff(args *protoapi.LinodeGetTunnelSpecDiffRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
 	if err != nil {
-		p.logError(err, "Couldn't list Linode plans")
-		return p.writer.WriteError(p.createListPlansErr(err), err)
+		return p.writer.WriteError(p.createGetTunnelSpecDiffErr(err), err)
 	}
 
-	var protoPlans []*protoapi.LinodePlan
-	for _, plan := range plans {
-		protoPlan := &protoapi.LinodePlan{
-			Id:           plan.ID,
-			Disk:         uint64(plan.Disk),
-			PriceHourly:  plan.Price.Hourly,
-			PriceMonthly: plan.Price.Monthly,
-			Label:        plan.Label,
-			NetworkOut:   uint64(plan.NetworkOut),
-			Memory:       uint64(plan.Memory),
-			Transfer:     uint64(plan.Transfer),
-			Vcpus:        uint32(plan.VCPUs),
+	tunnel, err := p.ensureTunnelExists(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createGetTunnelSpecDiffErr(err), err)
+	}
+
+	// Fields that weren't requested are not compared.
+	diff := &protoapi.LinodeTunnelSpecDiff{
+		Entries: []*protoapi.LinodeTunnelSpecDiff_Entry{},
+	}
+	compare := func(field string, requested string, actual string) {
+		if len(requested) > 0 && requested != actual {
+			entry := &protoapi.LinodeTunnelSpecDiff_Entry{
+				Field:     field,
+				Requested: requested,
+				Actual:    actual,
+			}
+			diff.Entries = append(diff.Entries, entry)
 		}
-		protoPlans = append(protoPlans, protoPlan)
 	}
-	return p.writer.WriteMessage(p.createListPlansOK(protoPlans))
+	compare("region", args.Region, tunnel.Region)
+	compare("plan", args.Plan, tunnel.Type)
+	compare("image", args.Image, tunnel.Image)
+	diff.Matches = len(diff.Entries) == 0
+
+	if !diff.Matches {
+		p.logInstance(tunnel, "Live instance does not match requested spec", log.Fields{
+			"mismatches": len(diff.Entries),
+		})
+	}
+	return p.writer.WriteMessage(p.createGetTunnelSpecDiffOK(diff))
 }
 
-func (p *protobufLinode) ListInstances(args *protoapi.LinodeListInstancesRequest) error {
-	instances, err := NewLinodeAPI(p.extractAuth(args.Auth)).ListLinodeInstances()
+func (p *protobufLinode) GetTunnelFirewall(args *protoapi.LinodeGetTunnelFirewallRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
 	if err != nil {
-		p.logError(err, "Couldn't list Linode instances")
-		return p.writer.WriteError(p.createListInstancesErr(err), err)
+		return p.writer.WriteError(p.createGetTunnelFirewallErr(err), err)
 	}
 
-	var protoInstances []*protoapi.LinodeInstance
-	for _, instance := range instances {
-		protoInstances = append(protoInstances, p.linodeInstanceToProtobuf(&instance))
+	tunnel, err := p.ensureTunnelExists(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createGetTunnelFirewallErr(err), err)
 	}
-	return p.writer.WriteMessage(p.createListInstancesOK(protoInstances))
-}
 
-func (p *protobufLinode) ListImages(args *protoapi.LinodeListImagesRequest) error {
-	images, err := NewLinodeAPI(p.extractAuth(args.Auth)).ListLinodeImages()
+	firewalls, err := api.ListInstanceFirewalls(tunnel.ID)
 	if err != nil {
-		p.logError(err, "Couldn't list Linode images")
-		return p.writer.WriteError(p.createListImagesErr(err), err)
+		p.logError(err, "Couldn't list instance firewalls")
+		return p.writer.WriteError(p.createGetTunnelFirewallErr(err), err)
+	}
+	if len(firewalls) == 0 {
+		return p.writer.WriteMessage(p.createGetTunnelFirewallNotAttached())
+	}
+	if len(firewalls) > 1 {
+		p.logInstance(tunnel, "Multiple firewalls are attached to instance", log.Fields{
+			"count": len(firewalls),
+		})
 	}
 
-	var protoImages []*protoapi.LinodeImage
-	for _, image := range images {
-		protoImage := &protoapi.LinodeImage{
-			Id:        image.ID,
-			Label:     image.Label,
-			Size:      uint64(image.Size),
-			CreatedBy: image.CreatedBy,
-			CreatedAt: image.CreatedAt,
-			Vendor:    image.Vendor,
-		}
-		protoImages = append(protoImages, protoImage)
+	firewall, err := api.GetFirewall(firewalls[0].ID)
+	if err != nil {
+		p.logError(err, "Couldn't retrieve firewall")
+		return p.writer.WriteError(p.createGetTunnelFirewallErr(err), err)
 	}
-	return p.writer.WriteMessage(p.createListImagesOK(protoImages))
+	return p.writer.WriteMessage(p.createGetTunnelFirewallOK(p.linodeFirewallToProtobuf(firewall)))
 }
 
-func (p *protobufLinode) ListRegions(args *protoapi.LinodeListRegionsRequest) error {
-	regions, err := NewLinodeAPIUnauthenticated().ListRegions()
+func (p *protobufLinode) UpdateTunnelFirewall(args *protoapi.LinodeUpdateTunnelFirewallRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
 	if err != nil {
-		p.logError(err, "Couldn't list Linode regions")
-		return p.writer.WriteError(p.createListRegionsErr(err), err)
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
 	}
 
-	var protoRegions []*protoapi.LinodeRegion
-	for _, region := range regions {
-		protoRegion := &protoapi.LinodeRegion{
-			Id:      region.ID,
-			Country: region.Country,
-		}
-		protoRegions = append(protoRegions, protoRegion)
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
 	}
-	return p.writer.WriteMessage(p.createListRegionsOK(protoRegions))
-}
 
-func (p *protobufLinode) ListStackScripts(args *protoapi.LinodeListStackScriptsRequest) error {
-	scripts, err := NewLinodeAPI(p.extractAuth(args.Auth)).ListStackScriptsPrivate()
+	firewalls, err := api.ListInstanceFirewalls(tunnel.ID)
 	if err != nil {
-		p.logError(err, "Couldn't list Linode StackScripts")
-		return p.writer.WriteError(p.createListStackScriptsErr(err), err)
+		p.logError(err, "Couldn't list instance firewalls")
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
+	}
+	if len(firewalls) == 0 {
+		err = errors.New("Tunnel has no firewall attached")
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
+	}
+	firewall, err := api.GetFirewall(firewalls[0].ID)
+	if err != nil {
+		p.logError(err, "Couldn't retrieve firewall")
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
 	}
 
-	var protoScripts []*protoapi.LinodeStackScript
-	for _, script := range scripts {
-		protoScript := &protoapi.LinodeStackScript{
-			Id:          int64(script.ID),
-			Label:       script.Label,
-			Description: script.Description,
+	// Only inbound rules are replaced, outbound rules are kept as is.
+	rules := firewall.Rules
+	if len(args.InboundPolicy) > 0 {
+		rules.InboundPolicy = args.InboundPolicy
+	}
+	rules.Inbound = p.protobufFirewallRulesToLinode(args.Inbound)
+
+	if rules.InboundPolicy != "ACCEPT" && rules.InboundPolicy != "DROP" {
+		err = errors.Errorf("Invalid inbound policy '%s'", rules.InboundPolicy)
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
+	}
+	if err := validateFirewallRules(rules.Inbound); err != nil {
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
+	}
+
+	// Refuse to cut off SSH and services that are currently open to the
+	// world, unless explicitly told to.
+	if !args.Force {
+		corePorts := append([]firewallPort{sshPort}, exposedFirewallPorts(firewall.Rules)...)
+		for _, port := range corePorts {
+			if !firewallAllowsPort(rules, port) {
+				err = newHolepuncherError(
+					protoapi.HolepuncherError_FIREWALL_LOCKOUT,
+					"New rules would block %s port %d", port.protocol, port.port,
+				)
+				return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
+			}
 		}
-		protoScripts = append(protoScripts, protoScript)
 	}
-	return p.writer.WriteMessage(p.createListStackScriptsOK(protoScripts))
-}
 
-func (p *protobufLinode) extractAuth(a *protoapi.LinodeAuth) string {
-	if a != nil {
-		return a.AccessToken
+	updated, err := api.UpdateFirewallRules(firewall.ID, &rules)
+	if err != nil {
+		p.logError(err, "Couldn't update firewall rules")
+		return p.writer.WriteError(p.createUpdateTunnelFirewallErr(err), err)
 	}
-	return ""
+	firewall.Rules = *updated
+
+	p.logInstance(tunnel, "Firewall rules were updated", log.Fields{
+		"firewall": firewall.ID,
+		"forced":   args.Force,
+	})
+	return p.writer.WriteMessage(p.createUpdateTunnelFirewallOK(p.linodeFirewallToProtobuf(firewall)))
 }
 
-// makeStackScriptParams produces script parameters, that are usable by either
-// LinodeInstanceBuilder or LinodeInstanceRebuilder, for the instance
-// initialization script.
-func (p *protobufLinode) makeStackScriptParams(
-	api *LinodeAPI,
-	scriptName string,
-	username, password string,
-	wg *protoapi.WireguardOptions,
-	obfs4 *protoapi.ObfsproxyIPv4Options,
-	obfs6 *protoapi.ObfsproxyIPv6Options,
-) (*StackScript, map[string]interface{}, error) {
-	scripts, err := api.ListStackScriptsPrivate()
+func (p *protobufLinode) GetTransferUsage(args *protoapi.LinodeGetTransferUsageRequest) error {
+	usage, err := p.newLinodeAPI(args.Auth).GetTransferUsage()
 	if err != nil {
-		p.logError(err, "Couldn't list StackScripts")
-		return nil, nil, err
+		p.logError(err, "Couldn't retrieve transfer usage")
+		return p.writer.WriteError(p.createGetTransferUsageErr(err), err)
 	}
 
-	// Find the script by name.
-	var script *StackScript
-	for _, s := range scripts {
-		if s.Label == scriptName {
-			script = &s
-		}
+	return p.writer.WriteMessage(p.createGetTransferUsageOK(&protoapi.LinodeTransferUsage{
+		UsedGb:     uint64(usage.Used),
+		QuotaGb:    uint64(usage.Quota),
+		BillableGb: uint64(usage.Billable),
+	}))
+}
+
+func (p *protobufLinode) GetTransferForecast(args *protoapi.LinodeGetTransferForecastRequest) error {
+	usage, err := p.newLinodeAPI(args.Auth).GetTransferUsage()
+	if err != nil {
+		p.logError(err, "Couldn't retrieve transfer usage")
+		return p.writer.WriteError(p.createGetTransferForecastErr(err), err)
 	}
-	if script == nil {
-		err = errors.New("Stackscript is missing: " + scriptName)
-		p.logError(err, "Couldn't retrieve StackScript information")
-		return nil, nil, err
+
+	forecast := forecastTransfer(usage, time.Now())
+	return p.writer.WriteMessage(p.createGetTransferForecastOK(&protoapi.LinodeTransferForecast{
+		UsedGb:        uint64(usage.Used),
+		QuotaGb:       uint64(usage.Quota),
+		ProjectedGb:   forecast.projectedGB,
+		OverageGb:     forecast.overageGB,
+		OverageCost:   forecast.overageCost,
+		DaysRemaining: uint32(forecast.daysRemaining),
+		Confidence:    forecast.confidence,
+	}))
+}
+
+func (p *protobufLinode) ListTunnelBackups(args *protoapi.LinodeListTunnelBackupsRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createListTunnelBackupsErr(err), err)
 	}
 
-	params := make(map[string]interface{})
-	params["udf_local_user_name"] = username
-	params["udf_local_user_password"] = password
-	if wg != nil {
-		params["udf_enable_wireguard"] = 1
-		params["udf_wireguard_port"] = wg.Port
-		params["udf_wireguard_private_key"] = wg.ServerKey
-		params["udf_wireguard_peer_keys"] = strings.Join(wg.PeerKeys, " ")
-	} else {
-		params["udf_enable_wireguard"] = 0
+	tunnel, err := p.ensureTunnelExists(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createListTunnelBackupsErr(err), err)
 	}
-	if obfs4 != nil {
-		params["udf_enable_obfs4"] = 1
-		params["udf_obfs4_port"] = obfs4.Port
-		params["udf_obfs4_secret"] = obfs4.Secret
-	} else {
-		params["udf_enable_obfs4"] = 0
+
+	backups, err := api.ListBackups(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't list instance backups")
+		return p.writer.WriteError(p.createListTunnelBackupsErr(err), err)
 	}
-	if obfs6 != nil {
-		params["udf_enable_obfs6"] = 1
-		params["udf_obfs6_port"] = obfs6.Port
-		params["udf_obfs6_secret"] = obfs6.Secret
-	} else {
-		params["udf_enable_obfs6"] = 0
+
+	protoBackups := make([]*protoapi.LinodeBackup, 0, len(backups))
+	for i := range backups {
+		protoBackups = append(protoBackups, p.linodeBackupToProtobuf(&backups[i]))
 	}
-	return script, params, nil
+	return p.writer.WriteMessage(p.createListTunnelBackupsOK(protoBackups))
 }
 
-func (p *protobufLinode) ensureTunnelExists(api *LinodeAPI, name string) (*LinodeInfo, error) {
-	tunnelInstance, err := p.retrieveTunnelInstance(api, name)
+func (p *protobufLinode) RestoreTunnelBackup(args *protoapi.LinodeRestoreTunnelBackupRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
 	if err != nil {
-		return nil, err
+		return p.writer.WriteError(p.createRestoreTunnelBackupErr(err), err)
 	}
-	if tunnelInstance == nil {
-		err := errors.New("Tunnel does not exist")
-		p.logError(err, "Guard failure")
-		return nil, err
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createRestoreTunnelBackupErr(err), err)
+	}
+
+	// Disks can't be overwritten while the instance is running.
+	if tunnel.Status != LinodeStatusOffline {
+		if err := api.ShutdownInstance(tunnel.ID); err != nil {
+			p.logError(err, "Couldn't shut down instance")
+			return p.writer.WriteError(p.createRestoreTunnelBackupErr(err), err)
+		}
+		if _, _, err := p.awaitUntilStatus(api, tunnel.ID, LinodeStatusOffline); err != nil {
+			return p.writer.WriteError(p.createRestoreTunnelBackupErr(err), err)
+		}
+	}
+
+	if err := api.RestoreBackup(tunnel.ID, int(args.BackupId), tunnel.ID); err != nil {
+		p.logError(err, "Couldn't restore backup")
+		return p.writer.WriteError(p.createRestoreTunnelBackupErr(err), err)
+	}
+
+	// Restore runs in background and leaves instance offline, client boots
+	// it once restore completes.
+	instance, err := api.QueryLinode(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't query instance")
+		return p.writer.WriteError(p.createRestoreTunnelBackupErr(err), err)
 	}
-	return tunnelInstance, nil
+
+	p.logInstance(instance, "Job to restore backup was started successfully", log.Fields{
+		"backup": args.BackupId,
+	})
+	protoInstance := p.linodeInstanceToProtobuf(instance)
+	return p.writer.WriteMessage(p.createRestoreTunnelBackupOK(protoInstance))
 }
 
-func (p *protobufLinode) ensureTunnelDoesNotExist(api *LinodeAPI, name string) error {
-	tunnelInstance, err := p.retrieveTunnelInstance(api, name)
+func (p *protobufLinode) RescueTunnel(args *protoapi.LinodeRescueTunnelRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
 	if err != nil {
-		return err
+		return p.writer.WriteError(p.createRescueTunnelErr(err), err)
 	}
-	if tunnelInstance != nil {
-		err := errors.New("Tunnel already exists")
-		p.logError(err, "Guard failure")
-		return err
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createRescueTunnelErr(err), err)
 	}
-	return nil
-}
 
-func (p *protobufLinode) retrieveTunnelInstance(api *LinodeAPI, name string) (*LinodeInfo, error) {
-	instances, err := api.ListLinodeInstances()
+	disks, err := api.ListInstanceDisks(tunnel.ID)
 	if err != nil {
-		p.logError(err, "Couldn't list Linode instances")
-		return nil, err
+		p.logError(err, "Couldn't list instance disks")
+		return p.writer.WriteError(p.createRescueTunnelErr(err), err)
+	}
+	if len(disks) > len(rescueDevices) {
+		err = errors.Errorf("Instance has too many disks for rescue mode (%d)", len(disks))
+		return p.writer.WriteError(p.createRescueTunnelErr(err), err)
 	}
 
-	// Collect all instances with matching label.
-	var tunnelInstances []*LinodeInfo
-	for _, instance := range instances {
-		if strings.HasPrefix(instance.Label, name) {
-			tunnelInstances = append(tunnelInstances, &instance)
-		}
+	// Attach disks in order of creation, so that root disk (which is created
+	// first) ends up as sda, just like in regular boot configuration.
+	sort.Slice(disks, func(i, j int) bool { return disks[i].ID < disks[j].ID })
+	devices := make(map[string]int)
+	for i, disk := range disks {
+		devices[rescueDevices[i]] = disk.ID
 	}
 
-	if len(tunnelInstances) >= 1 {
-		if len(tunnelInstances) != 1 {
-			log.
-				WithField("count", len(tunnelInstances)).
-				Error("Multiple tunnel instances are currently active!")
-			for i, instance := range tunnelInstances {
-				p.logInstance(instance, fmt.Sprintf("Active tunnel instance #%d", i))
-			}
+	// Disks can't be attached to rescue environment while instance is
+	// running off them.
+	if tunnel.Status != LinodeStatusOffline {
+		if err := api.ShutdownInstance(tunnel.ID); err != nil {
+			p.logError(err, "Couldn't shut down instance")
+			return p.writer.WriteError(p.createRescueTunnelErr(err), err)
+		}
+		if _, _, err := p.awaitUntilStatus(api, tunnel.ID, LinodeStatusOffline); err != nil {
+			return p.writer.WriteError(p.createRescueTunnelErr(err), err)
 		}
-		return tunnelInstances[0], nil
 	}
-	return nil, nil
-}
 
-func (p *protobufLinode) linodeInstanceToProtobuf(instance *LinodeInfo) *protoapi.LinodeInstance {
-	status := protoapi.LinodeInstance_Status_value[strings.ToUpper(string(instance.Status))]
-	return &protoapi.LinodeInstance{
-		Id:         int64(instance.ID),
-		Label:      instance.Label,
-		Group:      instance.Group,
-		Region:     instance.Region,
-		Plan:       instance.Type,
-		Image:      instance.Image,
-		Ipv4:       instance.IPv4,
-		Ipv6:       []string{instance.IPv6},
-		Status:     protoapi.LinodeInstance_Status(status),
-		CreatedAt:  instance.CreatedAt,
-		UpdatedAt:  instance.Updated,
-		Hypervisor: instance.Hypervisor,
-		Disk:       uint64(instance.Specs.Disk),
-		Memory:     uint64(instance.Specs.Memory),
-		Vcpus:      uint32(instance.Specs.VCPUs),
-		Transfer:   uint64(instance.Specs.Transfer),
+	if err := api.BootRescueMode(tunnel.ID, devices); err != nil {
+		p.logError(err, "Couldn't boot instance into rescue mode")
+		return p.writer.WriteError(p.createRescueTunnelErr(err), err)
+	}
+	instance, _, err := p.awaitUntilRunning(api, tunnel.ID)
+	if err != nil {
+		return p.writer.WriteError(p.createRescueTunnelErr(err), err)
+	}
+
+	profile, err := api.GetProfile()
+	if err != nil {
+		p.logError(err, "Couldn't retrieve user profile")
+		return p.writer.WriteError(p.createRescueTunnelErr(err), err)
+	}
+
+	p.logInstance(instance, "Instance was booted into rescue mode")
+	return p.writer.WriteMessage(p.createRescueTunnelOK(&protoapi.LinodeTunnelRescue{
+		Instance: p.linodeInstanceToProtobuf(instance),
+		Lish:     p.lishConnection(instance, profile.Username),
+	}))
+}
+
+func (p *protobufLinode) BootTunnel(args *protoapi.LinodeBootTunnelRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createBootTunnelErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createBootTunnelErr(err), err)
+	}
+
+	if len(args.ConfigLabel) > 0 {
+		configID, err := p.resolveConfigLabel(api, tunnel.ID, args.ConfigLabel)
+		if err != nil {
+			return p.writer.WriteError(p.createBootTunnelErr(err), err)
+		}
+		err = api.BootInstanceWithConfig(tunnel.ID, configID)
+	} else {
+		err = api.BootInstance(tunnel.ID)
+	}
+	if err != nil {
+		p.logError(err, "Couldn't boot instance")
+		return p.writer.WriteError(p.createBootTunnelErr(err), err)
+	}
+	p.logInstance(tunnel, "Instance was successfully booted")
+	return p.writer.WriteMessage(p.createBootTunnelOK())
+}
+
+// CloneTunnel clones a tunnel into another region, e.g. to fail over. The
+// clone is named after the source tunnel suffixed with the region, unless
+// the target name is given.
+func (p *protobufLinode) CloneTunnel(args *protoapi.LinodeCloneTunnelRequest) error {
+	ref := p.tunnelRef(args.Auth, args.Namespace, args.TunnelName)
+	req := &CloneTunnelRequest{
+		TunnelRef:  ref,
+		TargetName: args.TargetTunnelName,
+		Region:     args.Region,
+		Plan:       args.Plan,
+	}
+	tunnel, err := p.provider.CloneTunnel(req)
+	event := &auditEvent{
+		Action:    "clone",
+		Namespace: ref.Namespace,
+		Name:      ref.Name,
+		Region:    args.Region,
+		Plan:      args.Plan,
+		Err:       err,
+	}
+	if err == nil {
+		event.Instance = tunnel.Tunnel
+	}
+	p.config.audit.Record(p.ctx, event)
+	if err != nil {
+		return p.writer.WriteError(p.createCloneTunnelErr(err), err)
+	}
+
+	protoInstance := p.tunnelToProtobuf(tunnel.Tunnel)
+	return p.writer.WriteMessage(p.createCloneTunnelOK(protoInstance))
+}
+
+func (p *protobufLinode) ListTunnelConfigs(args *protoapi.LinodeListTunnelConfigsRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createListTunnelConfigsErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelExists(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createListTunnelConfigsErr(err), err)
+	}
+
+	configs, err := api.ListInstanceConfigs(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't list instance configs")
+		return p.writer.WriteError(p.createListTunnelConfigsErr(err), err)
+	}
+
+	protoConfigs := make([]*protoapi.LinodeInstanceConfig, 0, len(configs))
+	for i := range configs {
+		protoConfigs = append(protoConfigs, instanceConfigToProtobuf(&configs[i]))
+	}
+	return p.writer.WriteMessage(p.createListTunnelConfigsOK(protoConfigs))
+}
+
+func (p *protobufLinode) RebootTunnel(args *protoapi.LinodeRebootTunnelRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createRebootTunnelErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createRebootTunnelErr(err), err)
+	}
+
+	// Instance that isn't running would never come back on its own, awaiting
+	// it is pointless.
+	if tunnel.Status == LinodeStatusOffline {
+		err = errors.New("Tunnel is offline, boot it instead")
+		return p.writer.WriteError(p.createRebootTunnelErr(err), err)
+	}
+
+	err = api.RebootInstance(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't reboot instance")
+		return p.writer.WriteError(p.createRebootTunnelErr(err), err)
+	}
+
+	instance := tunnel
+	if args.Await {
+		instance, _, err = p.awaitUntilRunning(api, tunnel.ID)
+		if err != nil {
+			return p.writer.WriteError(p.createRebootTunnelErr(err), err)
+		}
+	} else if instance, err = api.QueryLinode(tunnel.ID); err != nil {
+		p.logError(err, "Couldn't query instance")
+		return p.writer.WriteError(p.createRebootTunnelErr(err), err)
+	}
+
+	p.logInstance(instance, "Instance was successfully rebooted")
+	protoInstance := p.linodeInstanceToProtobuf(instance)
+	return p.writer.WriteMessage(p.createRebootTunnelOK(protoInstance))
+}
+
+func (p *protobufLinode) SetTunnelReverseDNS(args *protoapi.LinodeSetTunnelReverseDNSRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createSetTunnelReverseDNSErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createSetTunnelReverseDNSErr(err), err)
+	}
+	if len(tunnel.IPv4) == 0 {
+		err = errors.New("Tunnel has no IPv4 address")
+		return p.writer.WriteError(p.createSetTunnelReverseDNSErr(err), err)
+	}
+
+	// Linode rejects hostnames that don't resolve back to the address, its
+	// validation error is passed to the client as is.
+	if err := api.SetReverseDNS(tunnel.IPv4[0], args.Hostname); err != nil {
+		p.logError(err, "Couldn't set reverse DNS")
+		return p.writer.WriteError(p.createSetTunnelReverseDNSErr(err), err)
+	}
+
+	p.logInstance(tunnel, "Reverse DNS was successfully set")
+	protoInstance := p.linodeInstanceToProtobuf(tunnel)
+	return p.writer.WriteMessage(p.createSetTunnelReverseDNSOK(protoInstance))
+}
+
+func (p *protobufLinode) AddTunnelIP(args *protoapi.LinodeAddTunnelIPRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createAddTunnelIPErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createAddTunnelIPErr(err), err)
+	}
+
+	ip, err := api.AllocateIP(tunnel.ID, args.Public)
+	if err != nil {
+		p.logError(err, "Couldn't allocate IP address")
+		if linodeErr, ok := err.(*LinodeError); ok {
+			if reason, ok := linodeErr.IPJustificationRequired(); ok {
+				err = newHolepuncherError(
+					protoapi.HolepuncherError_IP_JUSTIFICATION_REQUIRED,
+					"Linode requires a justification for additional IP address: %s", reason,
+				)
+			}
+		}
+		return p.writer.WriteError(p.createAddTunnelIPErr(err), err)
+	}
+	p.logInstance(tunnel, "IP address was successfully allocated", log.Fields{"address": ip.Address})
+
+	protoIP := &protoapi.LinodeTunnelIP{
+		Address: ip.Address,
+		Prefix:  int32(ip.Prefix),
+		Gateway: ip.Gateway,
+		Public:  ip.Public,
+		Rdns:    ip.RDNS,
+	}
+
+	// Instance is fetched again so that its addresses include the new one.
+	// The address is already allocated (and billed), so failure to do so is
+	// only a warning, client retrying on error would get yet another one.
+	instance, err := api.QueryLinode(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't retrieve Linode instance")
+		protoIP.Instance = p.linodeInstanceToProtobuf(tunnel)
+		protoIP.Warnings = p.tunnelWarningsToProtobuf([]TunnelWarning{{
+			Code:    "INSTANCE_NOT_REFRESHED",
+			Message: err.Error(),
+		}})
+	} else {
+		protoIP.Instance = p.linodeInstanceToProtobuf(instance)
+	}
+	return p.writer.WriteMessage(p.createAddTunnelIPOK(protoIP))
+}
+
+func (p *protobufLinode) GetTunnelNetworkDetails(args *protoapi.LinodeGetTunnelNetworkDetailsRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createGetTunnelNetworkDetailsErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelExists(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createGetTunnelNetworkDetailsErr(err), err)
+	}
+
+	ips, err := api.GetInstanceIPs(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't retrieve instance IPs")
+		return p.writer.WriteError(p.createGetTunnelNetworkDetailsErr(err), err)
+	}
+
+	// Linode doesn't report interface MTU and the instance doesn't report
+	// back to the server, so it stays unknown (zero).
+	details := &protoapi.LinodeTunnelNetworkDetails{
+		Ipv6: ips.IPv6Addresses(),
+	}
+	for _, address := range ips.IPv4.Public {
+		details.PublicIpv4 = append(details.PublicIpv4, address.Address)
+		if len(details.Gateway) == 0 {
+			details.Gateway = address.Gateway
+		}
+	}
+	for _, address := range ips.IPv4.Private {
+		details.PrivateIpv4 = append(details.PrivateIpv4, address.Address)
+	}
+	if ips.IPv6.SLAAC != nil {
+		details.Ipv6Gateway = ips.IPv6.SLAAC.Gateway
+	}
+	return p.writer.WriteMessage(p.createGetTunnelNetworkDetailsOK(details))
+}
+
+// PreviewTunnelConfig renders WireGuard client configs of a hypothetical
+// tunnel. Nothing is provisioned, the tunnel address is a placeholder.
+func (p *protobufLinode) PreviewTunnelConfig(args *protoapi.LinodePreviewTunnelConfigRequest) error {
+	spec := p.protobufWireGuardToSpec(args.WireguardOptions)
+	configs, err := renderWireGuardClientConfigs(spec, wireGuardEndpointPlaceholder)
+	if err != nil {
+		return p.writer.WriteError(p.createPreviewTunnelConfigErr(err), err)
+	}
+
+	preview := &protoapi.LinodeTunnelConfigPreview{
+		EndpointPlaceholder: wireGuardEndpointPlaceholder,
+		Transport:           spec.Protocol(),
+	}
+	for _, config := range configs {
+		preview.Configs = append(preview.Configs, &protoapi.LinodeWireguardClientConfig{
+			PeerKey: config.PeerKey,
+			Config:  config.Config,
+		})
+	}
+	return p.writer.WriteMessage(p.createPreviewTunnelConfigOK(preview))
+}
+
+// GetReliabilityStats reports outcomes of creates carried out by this server
+// within the reliability window.
+func (p *protobufLinode) GetReliabilityStats(args *protoapi.LinodeGetReliabilityStatsRequest) error {
+	stats := p.config.reliability.Stats()
+
+	protoStats := &protoapi.LinodeReliabilityStats{
+		WindowSeconds: uint32(stats.Window.Seconds()),
+		Total:         reliabilityCountsToProtobuf(&stats.Total),
+	}
+	for _, group := range stats.Breakdown {
+		protoStats.Breakdown = append(protoStats.Breakdown, &protoapi.LinodeReliabilityBreakdown{
+			Region: group.Region,
+			Plan:   group.Plan,
+			Counts: reliabilityCountsToProtobuf(&group.Counts),
+		})
+	}
+	return p.writer.WriteMessage(p.createGetReliabilityStatsOK(protoStats))
+}
+
+func (p *protobufLinode) ShutdownTunnel(args *protoapi.LinodeShutdownTunnelRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createShutdownTunnelErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createShutdownTunnelErr(err), err)
+	}
+
+	instance := tunnel
+	if tunnel.Status != LinodeStatusOffline {
+		if err := api.ShutdownInstance(tunnel.ID); err != nil {
+			p.logError(err, "Couldn't shut down instance")
+			return p.writer.WriteError(p.createShutdownTunnelErr(err), err)
+		}
+		instance, _, err = p.awaitUntilStatus(api, tunnel.ID, LinodeStatusOffline)
+		if err != nil {
+			return p.writer.WriteError(p.createShutdownTunnelErr(err), err)
+		}
+	}
+
+	p.logInstance(instance, "Instance was successfully shut down")
+	protoInstance := p.linodeInstanceToProtobuf(instance)
+	return p.writer.WriteMessage(p.createShutdownTunnelOK(protoInstance))
+}
+
+func (p *protobufLinode) ResizeTunnel(args *protoapi.LinodeResizeTunnelRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+
+	tunnel, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+	if tunnel.Type == args.Plan {
+		err = errors.Errorf("Tunnel is already on plan %s", args.Plan)
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+
+	plans, err := p.newLinodeAPIUnauthenticated().ListInstanceTypes()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode plans")
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+	var plan *LinodeType
+	for i := range plans {
+		if plans[i].ID == args.Plan {
+			plan = &plans[i]
+		}
+	}
+	if plan == nil {
+		err = errors.Errorf("Plan %s does not exist", args.Plan)
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+
+	// Linode refuses to shrink instance below the space allocated to its
+	// disks, but its field error doesn't tell by how much.
+	disks, err := api.ListInstanceDisks(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't list instance disks")
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+	allocated := 0
+	for _, disk := range disks {
+		allocated += disk.Size
+	}
+	if allocated > plan.Disk {
+		err = errors.Errorf(
+			"Plan %s has %d MB of storage, but instance disks take %d MB; shrink the disks first",
+			plan.ID, plan.Disk, allocated,
+		)
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+
+	// Auto disk resize is on unless the client explicitly opts out.
+	allowAutoDiskResize := args.AllowAutoDiskResize == nil || *args.AllowAutoDiskResize
+	if err := api.ResizeInstance(tunnel.ID, plan.ID, allowAutoDiskResize); err != nil {
+		p.logError(err, "Couldn't resize instance")
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+	p.logInstance(tunnel, "Job to resize instance was started successfully", log.Fields{
+		"plan":                   plan.ID,
+		"allow_auto_disk_resize": allowAutoDiskResize,
+	})
+
+	instance, _, err := p.awaitUntilStatusWithin(
+		api, tunnel.ID, LinodeStatusRunning, p.config.resizeAwaitTimeout, 0,
+	)
+	if err != nil {
+		return p.writer.WriteError(p.createResizeTunnelErr(err), err)
+	}
+
+	p.logInstance(instance, "Instance was successfully resized")
+	protoInstance := p.linodeInstanceToProtobuf(instance)
+	return p.writer.WriteMessage(p.createResizeTunnelOK(protoInstance))
+}
+
+func (p *protobufLinode) GetCostHistory(args *protoapi.LinodeGetCostHistoryRequest) error {
+	if len(args.Namespace) > 0 && !namespaceRe.MatchString(args.Namespace) {
+		err := errors.Errorf("Invalid namespace: %s", args.Namespace)
+		return p.writer.WriteError(p.createGetCostHistoryErr(err), err)
+	}
+
+	since, err := time.Parse("2006-01-02", args.Since)
+	if err != nil {
+		err = errors.Errorf("Invalid start date: %s", args.Since)
+		return p.writer.WriteError(p.createGetCostHistoryErr(err), err)
+	}
+	until := time.Now()
+	if len(args.Until) > 0 {
+		if until, err = time.Parse("2006-01-02", args.Until); err != nil {
+			err = errors.Errorf("Invalid end date: %s", args.Until)
+			return p.writer.WriteError(p.createGetCostHistoryErr(err), err)
+		}
+		if until.Before(since) {
+			err = errors.New("End date must not precede start date")
+			return p.writer.WriteError(p.createGetCostHistoryErr(err), err)
+		}
+		// End date is inclusive, the range ends at midnight after it.
+		until = until.AddDate(0, 0, 1)
+	} else if !since.Before(until) {
+		err = errors.New("Start date must not be in the future")
+		return p.writer.WriteError(p.createGetCostHistoryErr(err), err)
+	}
+
+	items, err := p.newLinodeAPI(args.Auth).ListInvoiceItems(since, until)
+	if err != nil {
+		p.logError(err, "Couldn't list invoice items")
+		return p.writer.WriteError(p.createGetCostHistoryErr(err), err)
+	}
+
+	// Items are matched by label recorded at billing time, so tunnels that
+	// were destroyed since are accounted for as well.
+	prefix := p.labelPrefix + "_"
+	if len(args.Namespace) > 0 {
+		prefix += args.Namespace + "_"
+	}
+	history := &protoapi.LinodeCostHistory{}
+	costs := make(map[int64]*protoapi.LinodeTunnelCost)
+	for _, item := range items {
+		match := invoiceItemRe.FindStringSubmatch(item.Label)
+		if match == nil || !strings.HasPrefix(match[1], prefix) {
+			continue
+		}
+		id, _ := strconv.ParseInt(match[2], 10, 64)
+		cost, ok := costs[id]
+		if !ok {
+			cost = &protoapi.LinodeTunnelCost{Id: id, Label: match[1]}
+			costs[id] = cost
+			history.Tunnels = append(history.Tunnels, cost)
+		}
+		cost.Cost += item.Total
+		history.Total += item.Total
+	}
+	return p.writer.WriteMessage(p.createGetCostHistoryOK(history))
+}
+
+// CanCreate tells whether CreateTunnel would be able to proceed with the
+// given spec. Server access policy is checked by the caller and passed in.
+func (p *protobufLinode) CanCreate(args *protoapi.LinodeCanCreateRequest, policyErr error) error {
+	code, reason, err := p.checkCanCreate(args, policyErr)
+	if err != nil {
+		return p.writer.WriteError(p.createCanCreateErr(err), err)
+	}
+	return p.writer.WriteMessage(p.createCanCreateOK(&protoapi.LinodeCanCreateResult{
+		Allowed:        code == protoapi.LinodeCanCreateResult_NONE,
+		BlockerCode:    code,
+		BlockerMessage: reason,
+	}))
+}
+
+// checkCanCreate evaluates preconditions of tunnel creation, returning the
+// first one that isn't met. Error is returned only when a precondition can't
+// be evaluated.
+func (p *protobufLinode) checkCanCreate(
+	args *protoapi.LinodeCanCreateRequest,
+	policyErr error,
+) (protoapi.LinodeCanCreateResult_BlockerCode, string, error) {
+	if policyErr != nil {
+		return protoapi.LinodeCanCreateResult_FORBIDDEN_BY_POLICY, policyErr.Error(), nil
+	}
+	label, err := p.tunnelLabel(args.Namespace, args.TunnelName)
+	if err != nil {
+		return protoapi.LinodeCanCreateResult_INVALID_NAMESPACE, err.Error(), nil
+	}
+
+	regions, err := p.newLinodeAPIUnauthenticated().ListRegions()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode regions")
+		return 0, "", err
+	}
+	var region *LinodeRegion
+	for i := range regions {
+		if regions[i].ID == args.Region {
+			region = &regions[i]
+		}
+	}
+	if region == nil {
+		return protoapi.LinodeCanCreateResult_UNKNOWN_REGION, "Region " + args.Region + " does not exist", nil
+	}
+	if region.Status != "" && region.Status != "ok" {
+		return protoapi.LinodeCanCreateResult_REGION_UNAVAILABLE,
+			fmt.Sprintf("Region %s is unavailable (%s)", region.ID, region.Status), nil
+	}
+
+	plans, err := p.newLinodeAPIUnauthenticated().ListInstanceTypes()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode plans")
+		return 0, "", err
+	}
+	planExists := false
+	for _, plan := range plans {
+		planExists = planExists || plan.ID == args.Plan
+	}
+	if !planExists {
+		return protoapi.LinodeCanCreateResult_UNKNOWN_PLAN, "Plan " + args.Plan + " does not exist", nil
+	}
+
+	api := p.newLinodeAPI(args.Auth)
+	grants, err := api.GetGrants()
+	if err != nil {
+		p.logError(err, "Couldn't retrieve user grants")
+		return 0, "", err
+	}
+	if grants != nil && !grants.Global.AddLinodes {
+		return protoapi.LinodeCanCreateResult_INSUFFICIENT_GRANTS, "User is not allowed to create instances", nil
+	}
+
+	tunnel, err := p.retrieveTunnelInstance(api, label)
+	if err != nil {
+		return 0, "", err
+	}
+	if tunnel != nil {
+		return protoapi.LinodeCanCreateResult_TUNNEL_EXISTS, "Tunnel already exists", nil
+	}
+
+	scripts, err := api.ListStackScriptsPrivate()
+	if err != nil {
+		p.logError(err, "Couldn't list StackScripts")
+		return 0, "", err
+	}
+	scriptExists := false
+	for _, script := range scripts {
+		scriptExists = scriptExists || script.Label == p.instanceScript
+	}
+	if !scriptExists {
+		return protoapi.LinodeCanCreateResult_STACKSCRIPT_MISSING, "Stackscript is missing: " + p.instanceScript, nil
+	}
+
+	return protoapi.LinodeCanCreateResult_NONE, "", nil
+}
+
+// ExportInventory returns every tunnel managed by holepuncher. The export
+// is built from instance data only, which carries no secrets: passwords and
+// keys passed to StackScript are never stored.
+func (p *protobufLinode) ExportInventory(args *protoapi.LinodeExportInventoryRequest) error {
+	listing, err := p.listManagedTunnels(args.Auth, args.Namespace)
+	if err != nil {
+		return p.writer.WriteError(p.createExportInventoryErr(err), err)
+	}
+
+	inventory := &protoapi.LinodeInventory{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Truncated:   listing.Truncated,
+		Warnings:    p.tunnelWarningsToProtobuf(listing.Warnings),
+	}
+	for _, tunnel := range listing.Tunnels {
+		namespace, _ := p.parseTunnelLabel(tunnel.Label)
+		inventory.Tunnels = append(inventory.Tunnels, &protoapi.LinodeInventoryEntry{
+			Namespace: namespace,
+			Instance:  p.tunnelToProtobuf(tunnel),
+			Tags:      tunnel.Tags,
+		})
+	}
+	return p.writer.WriteMessage(p.createExportInventoryOK(inventory))
+}
+
+// BatchTunnelStatus retrieves status of several tunnels at once. Instances
+// are listed once for the whole batch, failures are reported per tunnel.
+func (p *protobufLinode) BatchTunnelStatus(args *protoapi.LinodeBatchTunnelStatusRequest) error {
+	if len(args.TunnelNames) == 0 || len(args.TunnelNames) > maxBatchTunnelStatus {
+		err := errors.Errorf("Batch must contain 1 to %d tunnels", maxBatchTunnelStatus)
+		return p.writer.WriteError(p.createBatchTunnelStatusErr(err), err)
+	}
+
+	instances, err := p.newLinodeAPI(args.Auth).ListLinodeInstances()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode instances")
+		return p.writer.WriteError(p.createBatchTunnelStatusErr(err), err)
+	}
+	instancesByLabel := make(map[string][]*LinodeInfo)
+	for i := range instances {
+		label := instances[i].Label
+		instancesByLabel[label] = append(instancesByLabel[label], &instances[i])
+	}
+
+	entries := make([]*protoapi.LinodeTunnelStatusEntry, 0, len(args.TunnelNames))
+	for _, name := range args.TunnelNames {
+		entry := &protoapi.LinodeTunnelStatusEntry{TunnelName: name}
+		entries = append(entries, entry)
+
+		label, err := p.tunnelLabel(args.Namespace, name)
+		if err != nil {
+			entry.Error = p.createError(err)
+			continue
+		}
+		tunnels := instancesByLabel[label]
+		if len(tunnels) == 0 {
+			entry.Error = p.createError(errors.New("Tunnel does not exist"))
+			continue
+		}
+
+		entry.Instance = p.linodeInstanceToProtobuf(tunnels[0])
+		if len(tunnels) > 1 {
+			p.logDuplicateInstances(tunnels)
+			for _, tunnel := range tunnels {
+				entry.Conflicts = append(entry.Conflicts, p.tunnelToConflict(linodeInstanceToTunnel(tunnel)))
+			}
+		}
+	}
+	return p.writer.WriteMessage(p.createBatchTunnelStatusOK(entries))
+}
+
+// providerNoticeTypes lists notification types that describe service status,
+// as opposed to billing or account reminders.
+var providerNoticeTypes = map[string]bool{
+	"outage":                true,
+	"maintenance":           true,
+	"maintenance_one":       true,
+	"maintenance_many":      true,
+	"maintenance_scheduled": true,
+}
+
+func (p *protobufLinode) GetProviderStatus(args *protoapi.LinodeGetProviderStatusRequest) error {
+	token := p.extractAuth(args.Auth)
+	data, err := p.config.notifications.get("/account/notifications/"+hashToken(token), func() (interface{}, error) {
+		return p.newLinodeAPI(args.Auth).ListNotifications()
+	})
+	if err != nil {
+		p.logError(err, "Couldn't list account notifications")
+		return p.writer.WriteError(p.createGetProviderStatusErr(err), err)
+	}
+
+	// Notices that aren't tied to a region affect all of them.
+	notices := []*protoapi.LinodeProviderNotice{}
+	for _, notification := range data.([]LinodeNotification) {
+		if !providerNoticeTypes[notification.Type] {
+			continue
+		}
+		region := ""
+		if notification.Entity != nil && notification.Entity.Type == "region" {
+			region = fmt.Sprint(notification.Entity.ID)
+		}
+		if len(args.Region) > 0 && len(region) > 0 && region != args.Region {
+			continue
+		}
+		notices = append(notices, &protoapi.LinodeProviderNotice{
+			Type:     notification.Type,
+			Region:   region,
+			Label:    notification.Label,
+			Message:  notification.Message,
+			Severity: notification.Severity,
+			Since:    notification.When,
+			Until:    notification.Until,
+		})
+	}
+	return p.writer.WriteMessage(p.createGetProviderStatusOK(notices))
+}
+
+func (p *protobufLinode) ListPlans(args *protoapi.LinodeListPlansRequest) error {
+	plans, err := p.newLinodeAPIUnauthenticated().ListInstanceTypes()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode plans")
+		return p.writer.WriteError(p.createListPlansErr(err), err)
+	}
+
+	protoPlans := make([]*protoapi.LinodePlan, 0, len(plans))
+	for _, plan := range plans {
+		protoPlan := &protoapi.LinodePlan{
+			Id:           plan.ID,
+			Disk:         uint64(plan.Disk),
+			PriceHourly:  plan.Price.Hourly,
+			PriceMonthly: plan.Price.Monthly,
+			Label:        plan.Label,
+			NetworkOut:   uint64(plan.NetworkOut),
+			Memory:       uint64(plan.Memory),
+			Transfer:     uint64(plan.Transfer),
+			Vcpus:        uint32(plan.VCPUs),
+		}
+		protoPlans = append(protoPlans, protoPlan)
+	}
+	return p.writer.WriteMessage(p.createListPlansOK(protoPlans))
+}
+
+func (p *protobufLinode) ListInstances(args *protoapi.LinodeListInstancesRequest) error {
+	// Paginated listing starts at the first page, cursor carries the page to
+	// resume from. Cursor is bound to the namespace it was issued for.
+	page := 0
+	if args.Paginate || len(args.Cursor) > 0 {
+		page = 1
+	}
+	if len(args.Cursor) > 0 {
+		cursor, err := decodeListCursor(p.config.cursorKey, args.Cursor)
+		if err == nil && cursor.Namespace != args.Namespace {
+			err = errors.New("List cursor was issued for another namespace")
+		}
+		if err != nil {
+			return p.writer.WriteError(p.createListInstancesErr(err), err)
+		}
+		page = cursor.Page
+	}
+
+	listing, err := p.provider.ListInstances(&ListInstancesRequest{
+		AccessToken:  p.extractAuth(args.Auth),
+		Namespace:    args.Namespace,
+		Page:         page,
+		AllowPartial: p.config.partialResults,
+	})
+	if err != nil {
+		return p.writer.WriteError(p.createListInstancesErr(err), err)
+	}
+
+	nextCursor := ""
+	if listing.NextPage > 0 {
+		nextCursor, err = encodeListCursor(p.config.cursorKey, &listCursor{
+			Page:      listing.NextPage,
+			Namespace: args.Namespace,
+		})
+		if err != nil {
+			return p.writer.WriteError(p.createListInstancesErr(err), err)
+		}
+	}
+
+	protoInstances := make([]*protoapi.LinodeInstance, 0, len(listing.Tunnels))
+	for _, tunnel := range listing.Tunnels {
+		protoInstances = append(protoInstances, p.tunnelToProtobuf(tunnel))
+	}
+	warnings := p.tunnelWarningsToProtobuf(listing.Warnings)
+	return p.writer.WriteMessage(p.createListInstancesOK(protoInstances, nextCursor, listing.Truncated, warnings))
+}
+
+// ListTunnels lists only instances managed by holepuncher, i.e. ones carrying
+// a tunnel label, optionally limited to a namespace.
+func (p *protobufLinode) ListTunnels(args *protoapi.LinodeListTunnelsRequest) error {
+	listing, err := p.listManagedTunnels(args.Auth, args.Namespace)
+	if err != nil {
+		return p.writer.WriteError(p.createListTunnelsErr(err), err)
+	}
+
+	protoInstances := make([]*protoapi.LinodeInstance, 0, len(listing.Tunnels))
+	for _, tunnel := range listing.Tunnels {
+		protoInstances = append(protoInstances, p.tunnelToProtobuf(tunnel))
+	}
+	warnings := p.tunnelWarningsToProtobuf(listing.Warnings)
+	return p.writer.WriteMessage(p.createListTunnelsOK(protoInstances, listing.Truncated, warnings))
+}
+
+// listManagedTunnels lists all tunnels, optionally limited to a namespace.
+func (p *protobufLinode) listManagedTunnels(auth *protoapi.LinodeAuth, namespace string) (*TunnelPage, error) {
+	return p.provider.ListInstances(&ListInstancesRequest{
+		AccessToken:  p.extractAuth(auth),
+		Namespace:    namespace,
+		AllowPartial: p.config.partialResults,
+		ManagedOnly:  true,
+	})
+}
+
+func (p *protobufLinode) ListImages(args *protoapi.LinodeListImagesRequest) error {
+	images, err := p.newLinodeAPI(args.Auth).ListLinodeImages()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode images")
+		return p.writer.WriteError(p.createListImagesErr(err), err)
+	}
+
+	protoImages := make([]*protoapi.LinodeImage, 0, len(images))
+	for i := range images {
+		protoImages = append(protoImages, p.linodeImageToProtobuf(&images[i]))
+	}
+	return p.writer.WriteMessage(p.createListImagesOK(protoImages))
+}
+
+// ListCompatibleImages lists images supported by the tunnel StackScript.
+func (p *protobufLinode) ListCompatibleImages(args *protoapi.LinodeListCompatibleImagesRequest) error {
+	api := p.newLinodeAPI(args.Auth)
+
+	script, err := p.findStackScript(api, p.instanceScript)
+	if err != nil {
+		return p.writer.WriteError(p.createListCompatibleImagesErr(err), err)
+	}
+
+	images, err := api.ListLinodeImages()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode images")
+		return p.writer.WriteError(p.createListCompatibleImagesErr(err), err)
+	}
+
+	// StackScript may declare that it runs on any image.
+	supported := make(map[string]bool)
+	for _, image := range script.Images {
+		supported[image] = true
+	}
+	anyImage := supported["any/all"]
+
+	protoImages := make([]*protoapi.LinodeImage, 0, len(images))
+	for i := range images {
+		if anyImage || supported[images[i].ID] {
+			protoImages = append(protoImages, p.linodeImageToProtobuf(&images[i]))
+		}
+	}
+	return p.writer.WriteMessage(p.createListCompatibleImagesOK(protoImages))
+}
+
+func (p *protobufLinode) ListRegions(args *protoapi.LinodeListRegionsRequest) error {
+	regions, err := p.newLinodeAPIUnauthenticated().ListRegions()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode regions")
+		return p.writer.WriteError(p.createListRegionsErr(err), err)
+	}
+
+	protoRegions := make([]*protoapi.LinodeRegion, 0, len(regions))
+	for _, region := range regions {
+		protoRegion := &protoapi.LinodeRegion{
+			Id:      region.ID,
+			Country: region.Country,
+		}
+		protoRegions = append(protoRegions, protoRegion)
+	}
+	return p.writer.WriteMessage(p.createListRegionsOK(protoRegions))
+}
+
+func (p *protobufLinode) ListKernels(args *protoapi.LinodeListKernelsRequest) error {
+	kernels, err := p.newLinodeAPIUnauthenticated().ListKernels()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode kernels")
+		return p.writer.WriteError(p.createListKernelsErr(err), err)
+	}
+
+	protoKernels := make([]*protoapi.LinodeKernel, 0, len(kernels))
+	for _, kernel := range kernels {
+		protoKernel := &protoapi.LinodeKernel{
+			Id:           kernel.ID,
+			Label:        kernel.Label,
+			Version:      kernel.Version,
+			Architecture: kernel.Architecture,
+			Kvm:          kernel.KVM,
+			Deprecated:   kernel.Deprecated,
+		}
+		protoKernels = append(protoKernels, protoKernel)
+	}
+	return p.writer.WriteMessage(p.createListKernelsOK(protoKernels))
+}
+
+func (p *protobufLinode) ListStackScripts(args *protoapi.LinodeListStackScriptsRequest) error {
+	scripts, err := p.newLinodeAPI(args.Auth).ListStackScriptsPrivate()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode StackScripts")
+		return p.writer.WriteError(p.createListStackScriptsErr(err), err)
+	}
+
+	protoScripts := make([]*protoapi.LinodeStackScript, 0, len(scripts))
+	for i := range scripts {
+		protoScripts = append(protoScripts, p.stackScriptToProtobuf(&scripts[i]))
+	}
+	return p.writer.WriteMessage(p.createListStackScriptsOK(protoScripts))
+}
+
+func (p *protobufLinode) CreateStackScript(args *protoapi.LinodeCreateStackScriptRequest) error {
+	script, err := p.newLinodeAPI(args.Auth).CreateStackScript(&StackScriptSpec{
+		Label:       args.Label,
+		Description: args.Description,
+		Images:      args.Images,
+		Script:      args.Script,
+	})
+	if err != nil {
+		p.logError(err, "Couldn't create StackScript")
+		return p.writer.WriteError(p.createCreateStackScriptErr(err), err)
+	}
+
+	log.WithFields(log.Fields{
+		"id":    script.ID,
+		"label": script.Label,
+	}).Info("StackScript was successfully created")
+	return p.writer.WriteMessage(p.createCreateStackScriptOK(p.stackScriptToProtobuf(script)))
+}
+
+func (p *protobufLinode) UpdateStackScript(args *protoapi.LinodeUpdateStackScriptRequest) error {
+	script, err := p.newLinodeAPI(args.Auth).UpdateStackScript(int(args.Id), &StackScriptSpec{
+		Label:       args.Label,
+		Description: args.Description,
+		Images:      args.Images,
+		Script:      args.Script,
+	})
+	if err != nil {
+		p.logError(err, "Couldn't update StackScript")
+		return p.writer.WriteError(p.createUpdateStackScriptErr(err), err)
+	}
+
+	log.WithFields(log.Fields{
+		"id":    script.ID,
+		"label": script.Label,
+	}).Info("StackScript was successfully updated")
+	return p.writer.WriteMessage(p.createUpdateStackScriptOK(p.stackScriptToProtobuf(script)))
+}
+
+func (p *protobufLinode) DeleteStackScript(args *protoapi.LinodeDeleteStackScriptRequest) error {
+	if err := p.newLinodeAPI(args.Auth).DeleteStackScript(int(args.Id)); err != nil {
+		p.logError(err, "Couldn't delete StackScript")
+		return p.writer.WriteError(p.createDeleteStackScriptErr(err), err)
+	}
+
+	log.WithField("id", args.Id).Info("StackScript was successfully deleted")
+	return p.writer.WriteMessage(p.createDeleteStackScriptOK())
+}
+
+func (p *protobufLinode) ListSSHKeys(args *protoapi.LinodeListSSHKeysRequest) error {
+	keys, err := p.newLinodeAPI(args.Auth).ListProfileSSHKeys()
+	if err != nil {
+		if linodeErr, ok := err.(*LinodeError); ok && linodeErr.IsPermissionsError() {
+			p.logError(err, "Access token lacks read-only access to profile")
+		} else {
+			p.logError(err, "Couldn't list profile SSH keys")
+		}
+		return p.writer.WriteError(p.createListSSHKeysErr(err), err)
+	}
+
+	protoKeys := make([]*protoapi.LinodeSSHKey, 0, len(keys))
+	for _, key := range keys {
+		protoKey := &protoapi.LinodeSSHKey{
+			Id:        int64(key.ID),
+			Label:     key.Label,
+			PublicKey: key.SSHKey,
+			CreatedAt: key.CreatedAt,
+		}
+		protoKeys = append(protoKeys, protoKey)
+	}
+	return p.writer.WriteMessage(p.createListSSHKeysOK(protoKeys))
+}
+
+// tunnelLabel produces label of the tunnel instance, which is scoped by the
+// client-provided namespace. Label has a form of <prefix>_<namespace>_<name>,
+// or <prefix>_<name> when namespace is empty. Empty name stands for the
+// default tunnel.
+func (p *protobufLinode) tunnelLabel(namespace string, name string) (string, error) {
+	if len(name) == 0 {
+		name = p.instanceName
+	} else if !namespaceRe.MatchString(name) {
+		return "", errors.Errorf("Invalid tunnel name: %s", name)
+	}
+	label := p.labelPrefix + "_" + name
+	if len(namespace) > 0 {
+		if !namespaceRe.MatchString(namespace) {
+			return "", errors.Errorf("Invalid namespace: %s", namespace)
+		}
+		label = p.labelPrefix + "_" + namespace + "_" + name
+	}
+	// Name and namespace are valid on their own, but together with the
+	// prefix they may not fit into a label.
+	if len(label) > maxTunnelLabelLength {
+		return "", errors.Errorf(
+			"Invalid tunnel name: %s, label %s is longer than %d characters", name, label, maxTunnelLabelLength,
+		)
+	}
+	return label, nil
+}
+
+// parseTunnelLabel is the reverse of tunnelLabel. It extracts namespace from
+// the label, reporting whether the label belongs to a tunnel at all.
+func (p *protobufLinode) parseTunnelLabel(label string) (string, bool) {
+	parts := strings.Split(label, "_")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != p.labelPrefix {
+		return "", false
+	}
+	for _, part := range parts[1:] {
+		if !namespaceRe.MatchString(part) {
+			return "", false
+		}
+	}
+	if len(parts) == 2 {
+		return "", true
+	}
+	return parts[1], true
+}
+
+// newLinodeAPI returns API client whose requests are cancelled when the
+// client disconnects or the request times out.
+func (p *protobufLinode) newLinodeAPI(a *protoapi.LinodeAuth) *LinodeAPI {
+	return p.config.clients.Get(p.extractAuth(a)).WithContext(p.ctx)
+}
+
+// newCleanupLinodeAPI returns API client for undoing a half-done operation.
+// Its requests outlive the request context and are limited by cleanupTimeout
+// instead. Caller must call the returned cancel function.
+func (p *protobufLinode) newCleanupLinodeAPI(a *protoapi.LinodeAuth) (*LinodeAPI, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	return p.config.clients.Get(p.extractAuth(a)).WithContext(ctx), cancel
+}
+
+func (p *protobufLinode) newLinodeAPIUnauthenticated() *LinodeAPI {
+	return p.config.clients.Get("").WithContext(p.ctx)
+}
+
+func (p *protobufLinode) extractAuth(a *protoapi.LinodeAuth) string {
+	if a != nil {
+		return a.AccessToken
+	}
+	return ""
+}
+
+func (p *protobufLinode) tunnelRef(a *protoapi.LinodeAuth, namespace string, name string) TunnelRef {
+	return TunnelRef{
+		AccessToken: p.extractAuth(a),
+		Namespace:   namespace,
+		Name:        name,
+	}
+}
+
+// makeStackScriptParams produces script parameters, that are usable by either
+// LinodeInstanceBuilder or LinodeInstanceRebuilder, for the instance
+// initialization script.
+func (p *protobufLinode) makeStackScriptParams(
+	api *LinodeAPI,
+	scriptName string,
+	spec *TunnelSpec,
+) (*StackScript, map[string]interface{}, error) {
+	timezone := spec.Timezone
+	if len(timezone) == 0 {
+		timezone = "UTC"
+	}
+	// "Local" is accepted by LoadLocation, but means nothing to the instance.
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		return nil, nil, errors.Errorf("Unknown timezone: %s", timezone)
+	}
+	if err := validateWireGuardOptions(spec); err != nil {
+		return nil, nil, err
+	}
+
+	script, err := p.findStackScript(api, scriptName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := make(map[string]interface{})
+	params["udf_local_user_name"] = spec.AccountName
+	params["udf_local_user_password"] = spec.AccountPassword
+	params["udf_timezone"] = timezone
+	if wg := spec.WireGuard; wg != nil {
+		params["udf_enable_wireguard"] = 1
+		params["udf_wireguard_port"] = wg.Port
+		params["udf_wireguard_private_key"] = wg.ServerKey
+		params["udf_wireguard_peer_keys"] = strings.Join(wg.PeerKeys, " ")
+		params["udf_wireguard_transport"] = wg.Protocol()
+	} else {
+		params["udf_enable_wireguard"] = 0
+	}
+	if obfs4 := spec.Obfsproxy4; obfs4 != nil {
+		params["udf_enable_obfs4"] = 1
+		params["udf_obfs4_port"] = obfs4.Port
+		params["udf_obfs4_secret"] = obfs4.Secret
+	} else {
+		params["udf_enable_obfs4"] = 0
+	}
+	if obfs6 := spec.Obfsproxy6; obfs6 != nil {
+		params["udf_enable_obfs6"] = 1
+		params["udf_obfs6_port"] = obfs6.Port
+		params["udf_obfs6_secret"] = obfs6.Secret
+	} else {
+		params["udf_enable_obfs6"] = 0
+	}
+	return script, params, nil
+}
+
+// findStackScript finds private StackScript by its label.
+func (p *protobufLinode) findStackScript(api *LinodeAPI, scriptName string) (*StackScript, error) {
+	scripts, err := api.ListStackScriptsPrivate()
+	if err != nil {
+		p.logError(err, "Couldn't list StackScripts")
+		return nil, err
+	}
+
+	for i := range scripts {
+		if scripts[i].Label == scriptName {
+			return &scripts[i], nil
+		}
+	}
+	err = errors.New("Stackscript is missing: " + scriptName)
+	p.logError(err, "Couldn't retrieve StackScript information")
+	return nil, err
+}
+
+// resolveConfigLabel finds ID of the instance configuration profile by its
+// label.
+func (p *protobufLinode) resolveConfigLabel(api *LinodeAPI, linodeID int, label string) (int, error) {
+	configs, err := api.ListInstanceConfigs(linodeID)
+	if err != nil {
+		p.logError(err, "Couldn't list instance configs")
+		return 0, err
+	}
+	for _, config := range configs {
+		if config.Label == label {
+			return config.ID, nil
+		}
+	}
+	return 0, errors.Errorf("Config '%s' does not exist", label)
+}
+
+// resolveSSHKeys merges keys passed verbatim with keys stored in user profile
+// that are referenced by label.
+func (p *protobufLinode) resolveSSHKeys(api *LinodeAPI, keys []string, labels []string) ([]string, error) {
+	if len(labels) == 0 {
+		return keys, nil
+	}
+
+	profileKeys, err := api.ListProfileSSHKeys()
+	if err != nil {
+		p.logError(err, "Couldn't list profile SSH keys")
+		return nil, err
+	}
+	keysByLabel := make(map[string]string)
+	for _, key := range profileKeys {
+		keysByLabel[key.Label] = key.SSHKey
+	}
+
+	resolved := append([]string{}, keys...)
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for _, label := range labels {
+		key, ok := keysByLabel[label]
+		if !ok {
+			return nil, errors.Errorf("SSH key '%s' does not exist in profile", label)
+		}
+		if !seen[key] {
+			resolved = append(resolved, key)
+			seen[key] = true
+		}
+	}
+	return resolved, nil
+}
+
+// ensureTunnelExists returns the instance read-only verbs report on, which is
+// the first one when there are duplicates.
+func (p *protobufLinode) ensureTunnelExists(api *LinodeAPI, name string) (*LinodeInfo, error) {
+	tunnelInstances, err := p.ensureTunnelInstances(api, name)
+	if err != nil {
+		return nil, err
+	}
+	return tunnelInstances[0], nil
+}
+
+// ensureTunnelMutable returns the instance mutating verbs operate on. It is
+// the first one when there are duplicates, unless those are refused in
+// strict mode.
+func (p *protobufLinode) ensureTunnelMutable(api *LinodeAPI, name string) (*LinodeInfo, error) {
+	tunnelInstances, err := p.ensureTunnelInstances(api, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.refuseDuplicateInstances(tunnelInstances); err != nil {
+		return nil, err
+	}
+	return tunnelInstances[0], nil
+}
+
+// ensureTunnelInstances returns all instances carrying the tunnel label, at
+// least one.
+func (p *protobufLinode) ensureTunnelInstances(api *LinodeAPI, name string) ([]*LinodeInfo, error) {
+	tunnelInstances, err := p.retrieveTunnelInstances(api, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(tunnelInstances) == 0 {
+		err := errors.New("Tunnel does not exist")
+		p.logError(err, "Guard failure")
+		return nil, err
+	}
+	if len(tunnelInstances) > 1 {
+		p.logDuplicateInstances(tunnelInstances)
+	}
+	return tunnelInstances, nil
+}
+
+// refuseDuplicateInstances fails in strict mode when more than one instance
+// carries the tunnel label, so that mutating verbs can't act on the wrong
+// one. Duplicates can still be destroyed by instance ID.
+func (p *protobufLinode) refuseDuplicateInstances(instances []*LinodeInfo) error {
+	if len(instances) > 1 && p.config.strictSingleTunnel {
+		return newHolepuncherError(
+			protoapi.HolepuncherError_DUPLICATE_TUNNELS,
+			"%d instances carry the tunnel label, remove duplicates first", len(instances),
+		)
+	}
+	return nil
+}
+
+// duplicateInstancesWarning tells client which of the instances carrying the
+// tunnel label is operated on.
+func duplicateInstancesWarning(instances []*LinodeInfo) TunnelWarning {
+	return TunnelWarning{
+		Code: "DUPLICATE_INSTANCES",
+		Message: fmt.Sprintf(
+			"%d instances carry the tunnel label, operating on ID %d", len(instances), instances[0].ID,
+		),
+	}
+}
+
+func (p *protobufLinode) ensureTunnelDoesNotExist(api *LinodeAPI, name string) error {
+	tunnelInstance, err := p.retrieveTunnelInstance(api, name)
+	if err != nil {
+		return err
+	}
+	if tunnelInstance != nil {
+		err := errors.New("Tunnel already exists")
+		p.logError(err, "Guard failure")
+		return err
+	}
+	return nil
+}
+
+func (p *protobufLinode) retrieveTunnelInstance(api *LinodeAPI, name string) (*LinodeInfo, error) {
+	tunnelInstances, err := p.retrieveTunnelInstances(api, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tunnelInstances) >= 1 {
+		if len(tunnelInstances) != 1 {
+			p.logDuplicateInstances(tunnelInstances)
+		}
+		return tunnelInstances[0], nil
+	}
+	return nil, nil
+}
+
+// retrieveTunnelInstances returns all instances carrying the tunnel label.
+// Normally there is at most one, but nothing prevents duplicates from being
+// created outside of holepuncher.
+func (p *protobufLinode) retrieveTunnelInstances(api *LinodeAPI, name string) ([]*LinodeInfo, error) {
+	instances, err := api.ListLinodeInstances()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode instances")
+		return nil, err
+	}
+
+	// Collect all instances with matching label. Label must match exactly,
+	// otherwise tunnels from other namespaces could be picked up.
+	var tunnelInstances []*LinodeInfo
+	for _, instance := range instances {
+		if instance.Label == name {
+			// Copy, so that each pointer refers to a distinct instance rather
+			// than to the loop variable.
+			instance := instance
+			tunnelInstances = append(tunnelInstances, &instance)
+		}
+	}
+	return tunnelInstances, nil
+}
+
+func (p *protobufLinode) logDuplicateInstances(instances []*LinodeInfo) {
+	ids := make([]int, 0, len(instances))
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+	}
+	log.
+		WithFields(log.Fields{
+			"count":        len(instances),
+			"ids":          ids,
+			"operating_on": instances[0].ID,
+			"strict":       p.config.strictSingleTunnel,
+		}).
+		Error("Multiple tunnel instances are currently active!")
+	for i, instance := range instances {
+		p.logInstance(instance, fmt.Sprintf("Active tunnel instance #%d", i))
+	}
+}
+
+// awaitUntilRunning polls instance status until it becomes running.
+func (p *protobufLinode) awaitUntilRunning(api *LinodeAPI, linodeID int) (*LinodeInfo, bool, error) {
+	return p.awaitUntilStatus(api, linodeID, LinodeStatusRunning)
+}
+
+// awaitLimitsForPlan returns deadline and attempt limit of waiting for a new
+// instance of the plan. Plan classes with their own deadline aren't limited
+// by attempts, as the default limit is tuned for the default deadline.
+func (p *protobufLinode) awaitLimitsForPlan(api *LinodeAPI, plan string) (time.Duration, int) {
+	if len(p.config.awaitTimeoutByClass) == 0 {
+		return p.config.awaitTimeout, p.config.awaitAttempts
+	}
+
+	types, err := api.ListInstanceTypes()
+	if err != nil {
+		p.logError(err, "Couldn't list Linode instance types, using default await timeout")
+		return p.config.awaitTimeout, p.config.awaitAttempts
+	}
+	for _, t := range types {
+		if t.ID != plan {
+			continue
+		}
+		if timeout, ok := p.config.awaitTimeoutByClass[t.Class]; ok {
+			return timeout, 0
+		}
+	}
+	return p.config.awaitTimeout, p.config.awaitAttempts
+}
+
+// awaitUntilStatus polls instance status until it reaches the desired one.
+// Crossing the soft threshold is not an error, but it is reported back to the
+// caller via the returned flag; only the hard deadline fails the wait.
+func (p *protobufLinode) awaitUntilStatus(
+	api *LinodeAPI,
+	linodeID int,
+	status LinodeStatus,
+) (*LinodeInfo, bool, error) {
+	return p.awaitUntilStatusWithin(api, linodeID, status, p.config.awaitTimeout, p.config.awaitAttempts)
+}
+
+// awaitUntilStatusWithin is awaitUntilStatus with a custom hard deadline and
+// attempt limit (0 means no limit).
+func (p *protobufLinode) awaitUntilStatusWithin(
+	api *LinodeAPI,
+	linodeID int,
+	status LinodeStatus,
+	timeout time.Duration,
+	maxAttempts int,
+) (*LinodeInfo, bool, error) {
+	start := time.Now()
+	slow := false
+	for attempt := 0; maxAttempts == 0 || attempt < maxAttempts; attempt++ {
+		if err := p.sleep(p.config.awaitDelay); err != nil {
+			log.WithField("id", linodeID).Info("Stopped waiting for instance, request was cancelled")
+			return nil, slow, err
+		}
+
+		instance, err := api.QueryLinode(linodeID)
+		if err != nil {
+			p.logError(err, "Couldn't query instance status")
+			return nil, slow, err
+		}
+		if instance.Status == status {
+			return instance, slow, nil
+		}
+
+		elapsed := time.Since(start)
+		if elapsed >= timeout {
+			break
+		}
+		if elapsed >= p.config.awaitWarnAfter && !slow {
+			slow = true
+			log.WithFields(log.Fields{
+				"id":       linodeID,
+				"awaiting": status,
+				"elapsed":  elapsed,
+			}).Warn("Instance is taking longer than expected, still waiting")
+		} else {
+			p.logInstance(instance, "Instance has not reached desired status yet", log.Fields{
+				"attempt":  attempt,
+				"awaiting": status,
+			})
+		}
+	}
+
+	err := &awaitTimeoutError{status: status}
+	log.WithField("id", linodeID).Error("Gave up waiting for instance")
+	return nil, slow, err
+}
+
+// awaitStatusLeft polls instance status until it changes from the given one,
+// e.g. until a job on the instance actually starts.
+func (p *protobufLinode) awaitStatusLeft(api *LinodeAPI, linodeID int, status LinodeStatus) (*LinodeInfo, error) {
+	start := time.Now()
+	for time.Since(start) < p.config.awaitTimeout {
+		if err := p.sleep(p.config.awaitDelay); err != nil {
+			log.WithField("id", linodeID).Info("Stopped waiting for instance, request was cancelled")
+			return nil, err
+		}
+
+		instance, err := api.QueryLinode(linodeID)
+		if err != nil {
+			p.logError(err, "Couldn't query instance status")
+			return nil, err
+		}
+		if instance.Status != status {
+			return instance, nil
+		}
+	}
+
+	err := errors.Errorf("Instance took too long to leave %s status", status)
+	log.WithField("id", linodeID).Error("Gave up waiting for instance")
+	return nil, err
+}
+
+// awaitTimeoutError is returned when instance didn't reach awaited status in
+// time.
+type awaitTimeoutError struct {
+	status LinodeStatus
+}
+
+func (e *awaitTimeoutError) Error() string {
+	return fmt.Sprintf("Instance took too long to become %s", e.status)
+}
+
+// awaitDiskReady polls disk status until it becomes ready.
+func (p *protobufLinode) awaitDiskReady(api *LinodeAPI, linodeID int, diskID int) (*LinodeDisk, error) {
+	start := time.Now()
+	for time.Since(start) < p.config.awaitTimeout {
+		if err := p.sleep(p.config.awaitDelay); err != nil {
+			log.WithFields(log.Fields{"id": linodeID, "disk": diskID}).
+				Info("Stopped waiting for disk, request was cancelled")
+			return nil, err
+		}
+
+		disk, err := api.QueryDisk(linodeID, diskID)
+		if err != nil {
+			p.logError(err, "Couldn't query disk status")
+			return nil, err
+		}
+		if disk.Status == DiskStatusReady {
+			return disk, nil
+		}
+	}
+
+	err := errors.New("Disk took too long to become ready")
+	log.WithFields(log.Fields{"id": linodeID, "disk": diskID}).Error("Gave up waiting for disk")
+	return nil, err
+}
+
+// sleep pauses a polling loop. It returns early with an error when the
+// request is cancelled, e.g. because client has disconnected.
+func (p *protobufLinode) sleep(d time.Duration) error {
+	select {
+	case <-p.ctx.Done():
+		return errors.Wrap(p.ctx.Err(), "Request was cancelled")
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func (p *protobufLinode) linodeInstanceToProtobuf(instance *LinodeInfo) *protoapi.LinodeInstance {
+	return p.tunnelToProtobuf(linodeInstanceToTunnel(instance))
+}
+
+func (p *protobufLinode) tunnelToProtobuf(tunnel *Tunnel) *protoapi.LinodeInstance {
+	return &protoapi.LinodeInstance{
+		Id:         int64(tunnel.ID),
+		Label:      tunnel.Label,
+		Group:      tunnel.Group,
+		Region:     tunnel.Region,
+		Plan:       tunnel.Plan,
+		Image:      tunnel.Image,
+		Ipv4:       tunnel.IPv4,
+		Ipv6:       tunnel.IPv6,
+		Status:     p.instanceStatusToProtobuf(tunnel.Status),
+		CreatedAt:  tunnel.CreatedAt,
+		UpdatedAt:  tunnel.UpdatedAt,
+		Hypervisor: tunnel.Hypervisor,
+		Disk:       uint64(tunnel.Disk),
+		Memory:     uint64(tunnel.Memory),
+		Vcpus:      uint32(tunnel.VCPUs),
+		Transfer:   uint64(tunnel.Transfer),
+		Tags:       tunnel.Tags,
+	}
+}
+
+func (p *protobufLinode) tunnelToConflict(tunnel *Tunnel) *protoapi.LinodeInstanceConflict {
+	return &protoapi.LinodeInstanceConflict{
+		Id:        int64(tunnel.ID),
+		CreatedAt: tunnel.CreatedAt,
+		Status:    p.instanceStatusToProtobuf(tunnel.Status),
+	}
+}
+
+// instanceStatusToProtobuf maps status reported by the provider to the enum.
+// Statuses the enum doesn't know yet become UNKNOWN rather than the zero
+// value, which is a real status.
+func (p *protobufLinode) instanceStatusToProtobuf(status string) protoapi.LinodeInstance_Status {
+	value, ok := protoapi.LinodeInstance_Status_value[strings.ToUpper(status)]
+	if !ok {
+		errorLog.Warn(log.Fields{"status": status}, "Unknown instance status")
+		return protoapi.LinodeInstance_UNKNOWN
+	}
+	return protoapi.LinodeInstance_Status(value)
+}
+
+func (p *protobufLinode) tunnelWarningsToProtobuf(warnings []TunnelWarning) []*protoapi.Warning {
+	var protoWarnings []*protoapi.Warning
+	for _, warning := range warnings {
+		protoWarnings = append(protoWarnings, &protoapi.Warning{
+			Code:    protoapi.Warning_Code(protoapi.Warning_Code_value[warning.Code]),
+			Message: warning.Message,
+		})
+	}
+	return protoWarnings
+}
+
+func (p *protobufLinode) protobufWireGuardToSpec(wg *protoapi.WireguardOptions) *WireGuardSpec {
+	if wg == nil {
+		return nil
+	}
+	spec := &WireGuardSpec{Port: int(wg.Port), ServerKey: wg.ServerKey, PeerKeys: wg.PeerKeys}
+	switch wg.Transport {
+	case protoapi.WireguardOptions_UDP:
+		spec.Transport = WireGuardTransportUDP
+	case protoapi.WireguardOptions_TCP:
+		spec.Transport = WireGuardTransportTCP
+	default:
+		// Rejected by validation.
+		spec.Transport = wg.Transport.String()
+	}
+	return spec
+}
+
+// instanceConfigToProtobuf converts configuration profile, listing devices in
+// order of their names and leaving out unused ones.
+func instanceConfigToProtobuf(config *LinodeInstanceConfig) *protoapi.LinodeInstanceConfig {
+	result := &protoapi.LinodeInstanceConfig{
+		Id:         int64(config.ID),
+		Label:      config.Label,
+		Kernel:     config.Kernel,
+		RootDevice: config.RootDevice,
+	}
+	for _, iface := range config.Interfaces {
+		result.Interfaces = append(result.Interfaces, &protoapi.LinodeConfigInterface{
+			Purpose:     iface.Purpose,
+			Label:       iface.Label,
+			IpamAddress: iface.IPAMAddress,
+		})
+	}
+
+	names := make([]string, 0, len(config.Devices))
+	for name, device := range config.Devices {
+		if device != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		device := config.Devices[name]
+		result.Devices = append(result.Devices, &protoapi.LinodeConfigDevice{
+			Name:     name,
+			DiskId:   int64(device.DiskID),
+			VolumeId: int64(device.VolumeID),
+		})
+	}
+	return result
+}
+
+func reliabilityCountsToProtobuf(c *reliabilityCounts) *protoapi.LinodeReliabilityCounts {
+	return &protoapi.LinodeReliabilityCounts{
+		Succeeded:   uint32(c.Succeeded),
+		Failed:      uint32(c.Failed),
+		TimedOut:    uint32(c.TimedOut),
+		SuccessRate: c.Rate(c.Succeeded),
+		FailureRate: c.Rate(c.Failed),
+		TimeoutRate: c.Rate(c.TimedOut),
+	}
+}
+
+func (p *protobufLinode) tunnelEndpointsToProtobuf(spec *TunnelSpec) []*protoapi.LinodeTunnelEndpoint {
+	var endpoints []*protoapi.LinodeTunnelEndpoint
+	for _, endpoint := range tunnelEndpoints(spec) {
+		endpoints = append(endpoints, &protoapi.LinodeTunnelEndpoint{
+			Service:  endpoint.Service,
+			Protocol: endpoint.Protocol,
+			Port:     uint32(endpoint.Port),
+		})
+	}
+	return endpoints
+}
+
+func (p *protobufLinode) protobufObfsproxy4ToSpec(obfs *protoapi.ObfsproxyIPv4Options) *ObfsproxySpec {
+	if obfs == nil {
+		return nil
+	}
+	return &ObfsproxySpec{Port: int(obfs.Port), Secret: obfs.Secret}
+}
+
+func (p *protobufLinode) protobufObfsproxy6ToSpec(obfs *protoapi.ObfsproxyIPv6Options) *ObfsproxySpec {
+	if obfs == nil {
+		return nil
+	}
+	return &ObfsproxySpec{Port: int(obfs.Port), Secret: obfs.Secret}
+}
+
+func (p *protobufLinode) protobufFirewallToSpec(rules *protoapi.LinodeTunnelFirewallRules) *FirewallSpec {
+	if rules == nil {
+		return nil
+	}
+	return &FirewallSpec{Inbound: p.protobufFirewallRulesToLinode(rules.Inbound)}
+}
+
+func (p *protobufLinode) linodeImageToProtobuf(image *LinodeImage) *protoapi.LinodeImage {
+	return &protoapi.LinodeImage{
+		Id:        image.ID,
+		Label:     image.Label,
+		Size:      uint64(image.Size),
+		CreatedBy: image.CreatedBy,
+		CreatedAt: image.CreatedAt,
+		Vendor:    image.Vendor,
+	}
+}
+
+func (p *protobufLinode) stackScriptToProtobuf(script *StackScript) *protoapi.LinodeStackScript {
+	return &protoapi.LinodeStackScript{
+		Id:          int64(script.ID),
+		Label:       script.Label,
+		Description: script.Description,
+	}
+}
+
+func (p *protobufLinode) linodeBackupToProtobuf(backup *LinodeBackup) *protoapi.LinodeBackup {
+	disks := make([]*protoapi.LinodeBackupDisk, 0, len(backup.Disks))
+	for _, disk := range backup.Disks {
+		disks = append(disks, &protoapi.LinodeBackupDisk{
+			Label:      disk.Label,
+			Size:       int64(disk.Size),
+			Filesystem: disk.Filesystem,
+		})
+	}
+
+	return &protoapi.LinodeBackup{
+		Id:         int64(backup.ID),
+		Label:      backup.Label,
+		Status:     backup.Status,
+		Type:       backup.Type,
+		CreatedAt:  backup.CreatedAt,
+		FinishedAt: backup.Finished,
+		Disks:      disks,
+	}
+}
+
+func (p *protobufLinode) linodeFirewallToProtobuf(firewall *LinodeFirewall) *protoapi.LinodeFirewall {
+	convertRules := func(rules []LinodeFirewallRule) []*protoapi.LinodeFirewallRule {
+		protoRules := make([]*protoapi.LinodeFirewallRule, 0, len(rules))
+		for _, rule := range rules {
+			protoRule := &protoapi.LinodeFirewallRule{
+				Action:      rule.Action,
+				Label:       rule.Label,
+				Description: rule.Description,
+				Protocol:    rule.Protocol,
+				Ports:       rule.Ports,
+				Ipv4:        rule.Addresses.IPv4,
+				Ipv6:        rule.Addresses.IPv6,
+			}
+			protoRules = append(protoRules, protoRule)
+		}
+		return protoRules
+	}
+
+	return &protoapi.LinodeFirewall{
+		Id:             int64(firewall.ID),
+		Label:          firewall.Label,
+		Status:         firewall.Status,
+		InboundPolicy:  firewall.Rules.InboundPolicy,
+		OutboundPolicy: firewall.Rules.OutboundPolicy,
+		Inbound:        convertRules(firewall.Rules.Inbound),
+		Outbound:       convertRules(firewall.Rules.Outbound),
+	}
+}
+
+func (p *protobufLinode) protobufFirewallRulesToLinode(protoRules []*protoapi.LinodeFirewallRule) []LinodeFirewallRule {
+	rules := make([]LinodeFirewallRule, 0, len(protoRules))
+	for _, protoRule := range protoRules {
+		rule := LinodeFirewallRule{
+			Action:      strings.ToUpper(protoRule.Action),
+			Label:       protoRule.Label,
+			Description: protoRule.Description,
+			Protocol:    strings.ToUpper(protoRule.Protocol),
+			Ports:       protoRule.Ports,
+		}
+		rule.Addresses.IPv4 = protoRule.Ipv4
+		rule.Addresses.IPv6 = protoRule.Ipv6
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// lishConnection describes how to reach the instance console via LISH.
+func (p *protobufLinode) lishConnection(instance *LinodeInfo, username string) *protoapi.LinodeLishConnection {
+	// Older datacenters have gateways named after the city, newer ones use
+	// region ID.
+	gateway := instance.Region
+	if city, ok := lishGateways[instance.Region]; ok {
+		gateway = city
+	}
+	host := fmt.Sprintf("lish-%s.linode.com", gateway)
+	return &protoapi.LinodeLishConnection{
+		Username:   username,
+		Host:       host,
+		SshCommand: fmt.Sprintf("ssh -t %s@%s %s", username, host, instance.Label),
+		WeblishUrl: fmt.Sprintf("https://cloud.linode.com/linodes/%d/lish/weblish", instance.ID),
+	}
+}
+
+func (p *protobufLinode) logInstance(instance *LinodeInfo, msg string, extra ...log.Fields) {
+	fields := log.Fields{
+		"id":         instance.ID,
+		"label":      instance.Label,
+		"region":     instance.Region,
+		"plan":       instance.Type,
+		"image":      instance.Image,
+		"status":     instance.Status,
+		"ipv4":       instance.IPv4,
+		"ipv6":       instance.IPv6,
+		"created":    instance.CreatedAt,
+		"hypervisor": instance.Hypervisor,
+	}
+
+	if len(extra) > 0 {
+		for k, v := range extra[0] {
+			fields[k] = v
+		}
+	}
+	log.WithFields(fields).Debug(msg)
+}
+
+func (p *protobufLinode) logError(err error, msg string) {
+	errorLog.Error(log.Fields{"cause": err}, msg)
+}
+
+func (p *protobufLinode) createError(err error) *protoapi.LinodeError {
+	papiError := &protoapi.LinodeError{}
+	if linodeErr, ok := err.(*LinodeError); ok {
+		var errorStack []*protoapi.LinodeError_ErrorEntry
+		for _, err := range linodeErr.Errors {
+			entry := &protoapi.LinodeError_ErrorEntry{
+				Field:  err.Field,
+				Reason: err.Reason,
+			}
+			errorStack = append(errorStack, entry)
+		}
+		papiError.Details = errorStack
+	} else if hpErr, ok := errors.Cause(err).(*HolepuncherError); ok {
+		papiError.Error = &protoapi.HolepuncherError{Code: hpErr.Code, Message: hpErr.Message}
+	} else {
+		papiError.Error = &protoapi.HolepuncherError{Message: err.Error()}
+	}
+	return papiError
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Purgatory begins here. Turn back while you can.
+//
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeCreateTunnelRequest.
+
+func (p *protobufLinode) createCreateTunnelOK(
+	x *protoapi.LinodeInstance,
+	slow bool,
+	duration time.Duration,
+	warnings []*protoapi.Warning,
+	endpoints []*protoapi.LinodeTunnelEndpoint,
+	dryRun bool,
+) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeCreateTunnelResult{
+			LinodeCreateTunnelResult: &protoapi.LinodeCreateTunnelResponse{
+				Result:           &protoapi.LinodeCreateTunnelResponse_Instance{Instance: x},
+				SlowProvisioning: slow,
+				DurationSeconds:  uint32(duration.Seconds()),
+				Warnings:         warnings,
+				Endpoints:        endpoints,
+				DryRun:           dryRun,
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createCreateTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeCreateTunnelResult{
+			LinodeCreateTunnelResult: &protoapi.LinodeCreateTunnelResponse{
+				Result: &protoapi.LinodeCreateTunnelResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeDestroyTunnelRequest.
+
+func (p *protobufLinode) createDestroyTunnelOK() *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeDestroyTunnelResult{
+			LinodeDestroyTunnelResult: &protoapi.LinodeDestroyTunnelResponse{},
+		},
+	}
+}
+
+func (p *protobufLinode) createDestroyTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeDestroyTunnelResult{
+			LinodeDestroyTunnelResult: &protoapi.LinodeDestroyTunnelResponse{
+				Error: p.createError(err),
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeRebuildTunnelRequest.
+
+func (p *protobufLinode) createRebuildTunnelOK(
+	x *protoapi.LinodeInstance,
+	slow bool,
+	duration time.Duration,
+	warnings []*protoapi.Warning,
+	endpoints []*protoapi.LinodeTunnelEndpoint,
+	dryRun bool,
+) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRebuildTunnelResult{
+			LinodeRebuildTunnelResult: &protoapi.LinodeRebuildTunnelResponse{
+				Result:           &protoapi.LinodeRebuildTunnelResponse_Instance{Instance: x},
+				SlowProvisioning: slow,
+				DurationSeconds:  uint32(duration.Seconds()),
+				Warnings:         warnings,
+				Endpoints:        endpoints,
+				DryRun:           dryRun,
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createRebuildTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRebuildTunnelResult{
+			LinodeRebuildTunnelResult: &protoapi.LinodeRebuildTunnelResponse{
+				Result: &protoapi.LinodeRebuildTunnelResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetTunnelStatusRequest.
+
+func (p *protobufLinode) createTunnelStatusOK(
+	x *protoapi.LinodeInstance,
+	conflicts []*protoapi.LinodeInstanceConflict,
+	warnings []*protoapi.Warning,
+) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeTunnelStatusResult{
+			LinodeTunnelStatusResult: &protoapi.LinodeGetTunnelStatusResponse{
+				Result:    &protoapi.LinodeGetTunnelStatusResponse_Instance{Instance: x},
+				Conflicts: conflicts,
+				Warnings:  warnings,
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createTunnelStatusErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeTunnelStatusResult{
+			LinodeTunnelStatusResult: &protoapi.LinodeGetTunnelStatusResponse{
+				Result: &protoapi.LinodeGetTunnelStatusResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeAcceptMaintenanceRequest.
+
+func (p *protobufLinode) createAcceptMaintenanceOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeAcceptMaintenanceResult{
+			LinodeAcceptMaintenanceResult: &protoapi.LinodeAcceptMaintenanceResponse{
+				Result: &protoapi.LinodeAcceptMaintenanceResponse_Instance{Instance: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createAcceptMaintenanceErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeAcceptMaintenanceResult{
+			LinodeAcceptMaintenanceResult: &protoapi.LinodeAcceptMaintenanceResponse{
+				Result: &protoapi.LinodeAcceptMaintenanceResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeResizeTunnelDiskRequest.
+
+func (p *protobufLinode) createResizeTunnelDiskOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeResizeTunnelDiskResult{
+			LinodeResizeTunnelDiskResult: &protoapi.LinodeResizeTunnelDiskResponse{
+				Result: &protoapi.LinodeResizeTunnelDiskResponse_Instance{Instance: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createResizeTunnelDiskErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeResizeTunnelDiskResult{
+			LinodeResizeTunnelDiskResult: &protoapi.LinodeResizeTunnelDiskResponse{
+				Result: &protoapi.LinodeResizeTunnelDiskResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetTunnelSpecDiffRequest.
+
+func (p *protobufLinode) createGetTunnelSpecDiffOK(x *protoapi.LinodeTunnelSpecDiff) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTunnelSpecDiffResult{
+			LinodeGetTunnelSpecDiffResult: &protoapi.LinodeGetTunnelSpecDiffResponse{
+				Result: &protoapi.LinodeGetTunnelSpecDiffResponse_Diff{Diff: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createGetTunnelSpecDiffErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTunnelSpecDiffResult{
+			LinodeGetTunnelSpecDiffResult: &protoapi.LinodeGetTunnelSpecDiffResponse{
+				Result: &protoapi.LinodeGetTunnelSpecDiffResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetTunnelFirewallRequest.
+
+func (p *protobufLinode) createGetTunnelFirewallOK(x *protoapi.LinodeFirewall) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTunnelFirewallResult{
+			LinodeGetTunnelFirewallResult: &protoapi.LinodeGetTunnelFirewallResponse{
+				Result: &protoapi.LinodeGetTunnelFirewallResponse_Firewall{Firewall: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createGetTunnelFirewallNotAttached() *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTunnelFirewallResult{
+			LinodeGetTunnelFirewallResult: &protoapi.LinodeGetTunnelFirewallResponse{
+				Result: &protoapi.LinodeGetTunnelFirewallResponse_NotAttached{NotAttached: true},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createGetTunnelFirewallErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTunnelFirewallResult{
+			LinodeGetTunnelFirewallResult: &protoapi.LinodeGetTunnelFirewallResponse{
+				Result: &protoapi.LinodeGetTunnelFirewallResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeUpdateTunnelFirewallRequest.
+
+func (p *protobufLinode) createUpdateTunnelFirewallOK(x *protoapi.LinodeFirewall) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeUpdateTunnelFirewallResult{
+			LinodeUpdateTunnelFirewallResult: &protoapi.LinodeUpdateTunnelFirewallResponse{
+				Result: &protoapi.LinodeUpdateTunnelFirewallResponse_Firewall{Firewall: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createUpdateTunnelFirewallErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeUpdateTunnelFirewallResult{
+			LinodeUpdateTunnelFirewallResult: &protoapi.LinodeUpdateTunnelFirewallResponse{
+				Result: &protoapi.LinodeUpdateTunnelFirewallResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetTransferUsageRequest.
+
+func (p *protobufLinode) createGetTransferUsageOK(x *protoapi.LinodeTransferUsage) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTransferUsageResult{
+			LinodeGetTransferUsageResult: &protoapi.LinodeGetTransferUsageResponse{
+				Result: &protoapi.LinodeGetTransferUsageResponse_Usage{Usage: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createGetTransferUsageErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTransferUsageResult{
+			LinodeGetTransferUsageResult: &protoapi.LinodeGetTransferUsageResponse{
+				Result: &protoapi.LinodeGetTransferUsageResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetTransferForecastRequest.
+
+func (p *protobufLinode) createGetTransferForecastOK(x *protoapi.LinodeTransferForecast) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTransferForecastResult{
+			LinodeGetTransferForecastResult: &protoapi.LinodeGetTransferForecastResponse{
+				Result: &protoapi.LinodeGetTransferForecastResponse_Forecast{Forecast: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createGetTransferForecastErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTransferForecastResult{
+			LinodeGetTransferForecastResult: &protoapi.LinodeGetTransferForecastResponse{
+				Result: &protoapi.LinodeGetTransferForecastResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeListTunnelBackupsRequest.
+
+func (p *protobufLinode) createListTunnelBackupsOK(xs []*protoapi.LinodeBackup) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListTunnelBackupsResult{
+			LinodeListTunnelBackupsResult: &protoapi.LinodeListTunnelBackupsResponse{
+				Result: &protoapi.LinodeListTunnelBackupsResponse_Backups{
+					Backups: &protoapi.LinodeListTunnelBackupsResponse_List{L: xs},
+				},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createListTunnelBackupsErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListTunnelBackupsResult{
+			LinodeListTunnelBackupsResult: &protoapi.LinodeListTunnelBackupsResponse{
+				Result: &protoapi.LinodeListTunnelBackupsResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeRestoreTunnelBackupRequest.
+
+func (p *protobufLinode) createRestoreTunnelBackupOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRestoreTunnelBackupResult{
+			LinodeRestoreTunnelBackupResult: &protoapi.LinodeRestoreTunnelBackupResponse{
+				Result: &protoapi.LinodeRestoreTunnelBackupResponse_Instance{Instance: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createRestoreTunnelBackupErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRestoreTunnelBackupResult{
+			LinodeRestoreTunnelBackupResult: &protoapi.LinodeRestoreTunnelBackupResponse{
+				Result: &protoapi.LinodeRestoreTunnelBackupResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeRescueTunnelRequest.
+
+func (p *protobufLinode) createRescueTunnelOK(x *protoapi.LinodeTunnelRescue) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRescueTunnelResult{
+			LinodeRescueTunnelResult: &protoapi.LinodeRescueTunnelResponse{
+				Result: &protoapi.LinodeRescueTunnelResponse_Rescue{Rescue: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createRescueTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRescueTunnelResult{
+			LinodeRescueTunnelResult: &protoapi.LinodeRescueTunnelResponse{
+				Result: &protoapi.LinodeRescueTunnelResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeBootTunnelRequest.
+
+func (p *protobufLinode) createBootTunnelOK() *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeBootTunnelResult{
+			LinodeBootTunnelResult: &protoapi.LinodeBootTunnelResponse{},
+		},
+	}
+}
+
+func (p *protobufLinode) createBootTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeBootTunnelResult{
+			LinodeBootTunnelResult: &protoapi.LinodeBootTunnelResponse{
+				Error: p.createError(err),
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeRebootTunnelRequest.
+
+func (p *protobufLinode) createRebootTunnelOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRebootTunnelResult{
+			LinodeRebootTunnelResult: &protoapi.LinodeRebootTunnelResponse{
+				Result: &protoapi.LinodeRebootTunnelResponse_Instance{Instance: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createRebootTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeRebootTunnelResult{
+			LinodeRebootTunnelResult: &protoapi.LinodeRebootTunnelResponse{
+				Result: &protoapi.LinodeRebootTunnelResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeSetTunnelReverseDNSRequest.
+
+func (p *protobufLinode) createSetTunnelReverseDNSOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeSetTunnelReverseDNSResult{
+			LinodeSetTunnelReverseDNSResult: &protoapi.LinodeSetTunnelReverseDNSResponse{
+				Result: &protoapi.LinodeSetTunnelReverseDNSResponse_Instance{Instance: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createSetTunnelReverseDNSErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeSetTunnelReverseDNSResult{
+			LinodeSetTunnelReverseDNSResult: &protoapi.LinodeSetTunnelReverseDNSResponse{
+				Result: &protoapi.LinodeSetTunnelReverseDNSResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetTunnelNetworkDetailsRequest.
+
+func (p *protobufLinode) createGetTunnelNetworkDetailsOK(x *protoapi.LinodeTunnelNetworkDetails) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTunnelNetworkDetailsResult{
+			LinodeGetTunnelNetworkDetailsResult: &protoapi.LinodeGetTunnelNetworkDetailsResponse{
+				Result: &protoapi.LinodeGetTunnelNetworkDetailsResponse_Details{Details: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createGetTunnelNetworkDetailsErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetTunnelNetworkDetailsResult{
+			LinodeGetTunnelNetworkDetailsResult: &protoapi.LinodeGetTunnelNetworkDetailsResponse{
+				Result: &protoapi.LinodeGetTunnelNetworkDetailsResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeShutdownTunnelRequest.
+
+func (p *protobufLinode) createShutdownTunnelOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeShutdownTunnelResult{
+			LinodeShutdownTunnelResult: &protoapi.LinodeShutdownTunnelResponse{
+				Result: &protoapi.LinodeShutdownTunnelResponse_Instance{Instance: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createShutdownTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeShutdownTunnelResult{
+			LinodeShutdownTunnelResult: &protoapi.LinodeShutdownTunnelResponse{
+				Result: &protoapi.LinodeShutdownTunnelResponse_Error{Error: p.createError(err)},
+			},
+		},
 	}
 }
 
-func (p *protobufLinode) logInstance(instance *LinodeInfo, msg string, extra ...log.Fields) {
-	// TODO: calculate duration.
-	fields := log.Fields{
-		"id":         instance.ID,
-		"label":      instance.Label,
-		"region":     instance.Region,
-		"plan":       instance.Type,
-		"image":      instance.Image,
-		"status":     instance.Status,
-		"ipv4":       instance.IPv4,
-		"ipv6":       instance.IPv6,
-		"created":    instance.CreatedAt,
-		"hypervisor": instance.Hypervisor,
-	}
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeResizeTunnelRequest.
 
-	if len(extra) > 0 {
-		for k, v := range extra[0] {
-			fields[k] = v
-		}
+func (p *protobufLinode) createResizeTunnelOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeResizeTunnelResult{
+			LinodeResizeTunnelResult: &protoapi.LinodeResizeTunnelResponse{
+				Result: &protoapi.LinodeResizeTunnelResponse_Instance{Instance: x},
+			},
+		},
 	}
-	log.WithFields(fields).Debug(msg)
 }
 
-func (p *protobufLinode) logError(err error, msg string) {
-	log.WithFields(log.Fields{}).Error(msg)
+func (p *protobufLinode) createResizeTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeResizeTunnelResult{
+			LinodeResizeTunnelResult: &protoapi.LinodeResizeTunnelResponse{
+				Result: &protoapi.LinodeResizeTunnelResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
 }
 
-func (p *protobufLinode) createError(err error) *protoapi.LinodeError {
-	papiError := &protoapi.LinodeError{}
-	if linodeErr, ok := err.(*LinodeError); ok {
-		var errorStack []*protoapi.LinodeError_ErrorEntry
-		for _, err := range linodeErr.Errors {
-			entry := &protoapi.LinodeError_ErrorEntry{
-				Field:  err.Field,
-				Reason: err.Reason,
-			}
-			errorStack = append(errorStack, entry)
-		}
-		papiError.Details = errorStack
-	} else {
-		papiError.Error = &protoapi.HolepuncherError{Message: err.Error()}
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetCostHistoryRequest.
+
+func (p *protobufLinode) createGetCostHistoryOK(x *protoapi.LinodeCostHistory) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetCostHistoryResult{
+			LinodeGetCostHistoryResult: &protoapi.LinodeGetCostHistoryResponse{
+				Result: &protoapi.LinodeGetCostHistoryResponse_History{History: x},
+			},
+		},
 	}
-	return papiError
 }
 
-///////////////////////////////////////////////////////////////////////////////
-// Purgatory begins here. Turn back while you can.
-//
+func (p *protobufLinode) createGetCostHistoryErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetCostHistoryResult{
+			LinodeGetCostHistoryResult: &protoapi.LinodeGetCostHistoryResponse{
+				Result: &protoapi.LinodeGetCostHistoryResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
 
 ///////////////////////////////////////////////////////////////////////////////
-// Responses to protoapi.LinodeCreateTunnelRequest.
+// Responses to protoapi.LinodeCanCreateRequest.
 
-func (p *protobufLinode) createCreateTunnelOK(x *protoapi.LinodeInstance) *protoapi.Response {
+func (p *protobufLinode) createCanCreateOK(x *protoapi.LinodeCanCreateResult) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeCreateTunnelResult{
-			LinodeCreateTunnelResult: &protoapi.LinodeCreateTunnelResponse{
-				Result: &protoapi.LinodeCreateTunnelResponse_Instance{Instance: x},
+		R: &protoapi.Response_LinodeCanCreateResult{
+			LinodeCanCreateResult: &protoapi.LinodeCanCreateResponse{
+				Result: &protoapi.LinodeCanCreateResponse_Result{Result: x},
 			},
 		},
 	}
 }
 
-func (p *protobufLinode) createCreateTunnelErr(err error) *protoapi.Response {
+func (p *protobufLinode) createCanCreateErr(err error) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeCreateTunnelResult{
-			LinodeCreateTunnelResult: &protoapi.LinodeCreateTunnelResponse{
-				Result: &protoapi.LinodeCreateTunnelResponse_Error{Error: p.createError(err)},
+		R: &protoapi.Response_LinodeCanCreateResult{
+			LinodeCanCreateResult: &protoapi.LinodeCanCreateResponse{
+				Result: &protoapi.LinodeCanCreateResponse_Error{Error: p.createError(err)},
 			},
 		},
 	}
 }
 
 ///////////////////////////////////////////////////////////////////////////////
-// Responses to protoapi.LinodeDestroyTunnelRequest.
+// Responses to protoapi.LinodeExportInventoryRequest.
 
-func (p *protobufLinode) createDestroyTunnelOK() *protoapi.Response {
+func (p *protobufLinode) createExportInventoryOK(x *protoapi.LinodeInventory) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeDestroyTunnelResult{
-			LinodeDestroyTunnelResult: &protoapi.LinodeDestroyTunnelResponse{},
+		R: &protoapi.Response_LinodeExportInventoryResult{
+			LinodeExportInventoryResult: &protoapi.LinodeExportInventoryResponse{
+				Result: &protoapi.LinodeExportInventoryResponse_Inventory{Inventory: x},
+			},
 		},
 	}
 }
 
-func (p *protobufLinode) createDestroyTunnelErr(err error) *protoapi.Response {
+func (p *protobufLinode) createExportInventoryErr(err error) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeDestroyTunnelResult{
-			LinodeDestroyTunnelResult: &protoapi.LinodeDestroyTunnelResponse{
-				Error: p.createError(err),
+		R: &protoapi.Response_LinodeExportInventoryResult{
+			LinodeExportInventoryResult: &protoapi.LinodeExportInventoryResponse{
+				Result: &protoapi.LinodeExportInventoryResponse_Error{Error: p.createError(err)},
 			},
 		},
 	}
 }
 
 ///////////////////////////////////////////////////////////////////////////////
-// Responses to protoapi.LinodeRebuildTunnelRequest.
+// Responses to protoapi.LinodeBatchTunnelStatusRequest.
 
-func (p *protobufLinode) createRebuildTunnelOK(x *protoapi.LinodeInstance) *protoapi.Response {
+func (p *protobufLinode) createBatchTunnelStatusOK(xs []*protoapi.LinodeTunnelStatusEntry) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeRebuildTunnelResult{
-			LinodeRebuildTunnelResult: &protoapi.LinodeRebuildTunnelResponse{
-				Result: &protoapi.LinodeRebuildTunnelResponse_Instance{Instance: x},
+		R: &protoapi.Response_LinodeBatchTunnelStatusResult{
+			LinodeBatchTunnelStatusResult: &protoapi.LinodeBatchTunnelStatusResponse{
+				Result: &protoapi.LinodeBatchTunnelStatusResponse_Statuses{
+					Statuses: &protoapi.LinodeBatchTunnelStatusResponse_List{L: xs},
+				},
 			},
 		},
 	}
 }
 
-func (p *protobufLinode) createRebuildTunnelErr(err error) *protoapi.Response {
+func (p *protobufLinode) createBatchTunnelStatusErr(err error) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeRebuildTunnelResult{
-			LinodeRebuildTunnelResult: &protoapi.LinodeRebuildTunnelResponse{
-				Result: &protoapi.LinodeRebuildTunnelResponse_Error{Error: p.createError(err)},
+		R: &protoapi.Response_LinodeBatchTunnelStatusResult{
+			LinodeBatchTunnelStatusResult: &protoapi.LinodeBatchTunnelStatusResponse{
+				Result: &protoapi.LinodeBatchTunnelStatusResponse_Error{Error: p.createError(err)},
 			},
 		},
 	}
 }
 
 ///////////////////////////////////////////////////////////////////////////////
-// Responses to protoapi.LinodeGetTunnelStatusRequest.
+// Responses to protoapi.LinodeGetProviderStatusRequest.
 
-func (p *protobufLinode) createTunnelStatusOK(x *protoapi.LinodeInstance) *protoapi.Response {
+func (p *protobufLinode) createGetProviderStatusOK(xs []*protoapi.LinodeProviderNotice) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeTunnelStatusResult{
-			LinodeTunnelStatusResult: &protoapi.LinodeGetTunnelStatusResponse{
-				Result: &protoapi.LinodeGetTunnelStatusResponse_Instance{Instance: x},
+		R: &protoapi.Response_LinodeGetProviderStatusResult{
+			LinodeGetProviderStatusResult: &protoapi.LinodeGetProviderStatusResponse{
+				Result: &protoapi.LinodeGetProviderStatusResponse_Notices{
+					Notices: &protoapi.LinodeGetProviderStatusResponse_List{L: xs},
+				},
 			},
 		},
 	}
 }
 
-func (p *protobufLinode) createTunnelStatusErr(err error) *protoapi.Response {
+func (p *protobufLinode) createGetProviderStatusErr(err error) *protoapi.Response {
 	return &protoapi.Response{
-		R: &protoapi.Response_LinodeTunnelStatusResult{
-			LinodeTunnelStatusResult: &protoapi.LinodeGetTunnelStatusResponse{
-				Result: &protoapi.LinodeGetTunnelStatusResponse_Error{Error: p.createError(err)},
+		R: &protoapi.Response_LinodeGetProviderStatusResult{
+			LinodeGetProviderStatusResult: &protoapi.LinodeGetProviderStatusResponse{
+				Result: &protoapi.LinodeGetProviderStatusResponse_Error{Error: p.createError(err)},
 			},
 		},
 	}
@@ -508,13 +3117,21 @@ func (p *protobufLinode) createTunnelStatusErr(err error) *protoapi.Response {
 ///////////////////////////////////////////////////////////////////////////////
 // Responses to protoapi.LinodeListInstancesRequest.
 
-func (p *protobufLinode) createListInstancesOK(xs []*protoapi.LinodeInstance) *protoapi.Response {
+func (p *protobufLinode) createListInstancesOK(
+	xs []*protoapi.LinodeInstance,
+	nextCursor string,
+	truncated bool,
+	warnings []*protoapi.Warning,
+) *protoapi.Response {
 	return &protoapi.Response{
 		R: &protoapi.Response_LinodeListInstancesResult{
 			LinodeListInstancesResult: &protoapi.LinodeListInstancesResponse{
 				Result: &protoapi.LinodeListInstancesResponse_Instances{
 					Instances: &protoapi.LinodeListInstancesResponse_List{L: xs},
 				},
+				NextCursor: nextCursor,
+				Truncated:  truncated,
+				Warnings:   warnings,
 			},
 		},
 	}
@@ -580,6 +3197,31 @@ func (p *protobufLinode) createListImagesErr(err error) *protoapi.Response {
 	}
 }
 
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeListCompatibleImagesRequest.
+
+func (p *protobufLinode) createListCompatibleImagesOK(xs []*protoapi.LinodeImage) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListCompatibleImagesResult{
+			LinodeListCompatibleImagesResult: &protoapi.LinodeListCompatibleImagesResponse{
+				Result: &protoapi.LinodeListCompatibleImagesResponse_Images{
+					Images: &protoapi.LinodeListCompatibleImagesResponse_List{L: xs},
+				},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createListCompatibleImagesErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListCompatibleImagesResult{
+			LinodeListCompatibleImagesResult: &protoapi.LinodeListCompatibleImagesResponse{
+				Result: &protoapi.LinodeListCompatibleImagesResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // Responses to protoapi.LinodeListRegionsRequest.
 
@@ -629,3 +3271,258 @@ func (p *protobufLinode) createListStackScriptsErr(err error) *protoapi.Response
 		},
 	}
 }
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeCreateStackScriptRequest.
+
+func (p *protobufLinode) createCreateStackScriptOK(x *protoapi.LinodeStackScript) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeCreateStackscriptResult{
+			LinodeCreateStackscriptResult: &protoapi.LinodeCreateStackScriptResponse{
+				Result: &protoapi.LinodeCreateStackScriptResponse_Stackscript{Stackscript: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createCreateStackScriptErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeCreateStackscriptResult{
+			LinodeCreateStackscriptResult: &protoapi.LinodeCreateStackScriptResponse{
+				Result: &protoapi.LinodeCreateStackScriptResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeUpdateStackScriptRequest.
+
+func (p *protobufLinode) createUpdateStackScriptOK(x *protoapi.LinodeStackScript) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeUpdateStackscriptResult{
+			LinodeUpdateStackscriptResult: &protoapi.LinodeUpdateStackScriptResponse{
+				Result: &protoapi.LinodeUpdateStackScriptResponse_Stackscript{Stackscript: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createUpdateStackScriptErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeUpdateStackscriptResult{
+			LinodeUpdateStackscriptResult: &protoapi.LinodeUpdateStackScriptResponse{
+				Result: &protoapi.LinodeUpdateStackScriptResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeDeleteStackScriptRequest.
+
+func (p *protobufLinode) createDeleteStackScriptOK() *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeDeleteStackscriptResult{
+			LinodeDeleteStackscriptResult: &protoapi.LinodeDeleteStackScriptResponse{},
+		},
+	}
+}
+
+func (p *protobufLinode) createDeleteStackScriptErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeDeleteStackscriptResult{
+			LinodeDeleteStackscriptResult: &protoapi.LinodeDeleteStackScriptResponse{
+				Error: p.createError(err),
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeListSSHKeysRequest.
+
+func (p *protobufLinode) createListSSHKeysOK(xs []*protoapi.LinodeSSHKey) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListSshKeysResult{
+			LinodeListSshKeysResult: &protoapi.LinodeListSSHKeysResponse{
+				Result: &protoapi.LinodeListSSHKeysResponse_Keys{
+					Keys: &protoapi.LinodeListSSHKeysResponse_List{L: xs},
+				},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createListSSHKeysErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListSshKeysResult{
+			LinodeListSshKeysResult: &protoapi.LinodeListSSHKeysResponse{
+				Result: &protoapi.LinodeListSSHKeysResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodePreviewTunnelConfigRequest.
+
+func (p *protobufLinode) createPreviewTunnelConfigOK(x *protoapi.LinodeTunnelConfigPreview) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodePreviewTunnelConfigResult{
+			LinodePreviewTunnelConfigResult: &protoapi.LinodePreviewTunnelConfigResponse{
+				Result: &protoapi.LinodePreviewTunnelConfigResponse_Preview{Preview: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createPreviewTunnelConfigErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodePreviewTunnelConfigResult{
+			LinodePreviewTunnelConfigResult: &protoapi.LinodePreviewTunnelConfigResponse{
+				Result: &protoapi.LinodePreviewTunnelConfigResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeListKernelsRequest.
+
+func (p *protobufLinode) createListKernelsOK(xs []*protoapi.LinodeKernel) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListKernelsResult{
+			LinodeListKernelsResult: &protoapi.LinodeListKernelsResponse{
+				Result: &protoapi.LinodeListKernelsResponse_Kernels{
+					Kernels: &protoapi.LinodeListKernelsResponse_List{L: xs},
+				},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createListKernelsErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListKernelsResult{
+			LinodeListKernelsResult: &protoapi.LinodeListKernelsResponse{
+				Result: &protoapi.LinodeListKernelsResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeGetReliabilityStatsRequest.
+
+func (p *protobufLinode) createGetReliabilityStatsOK(x *protoapi.LinodeReliabilityStats) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeGetReliabilityStatsResult{
+			LinodeGetReliabilityStatsResult: &protoapi.LinodeGetReliabilityStatsResponse{
+				Result: &protoapi.LinodeGetReliabilityStatsResponse_Stats{Stats: x},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeListTunnelsRequest.
+
+func (p *protobufLinode) createListTunnelsOK(
+	xs []*protoapi.LinodeInstance,
+	truncated bool,
+	warnings []*protoapi.Warning,
+) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListTunnelsResult{
+			LinodeListTunnelsResult: &protoapi.LinodeListTunnelsResponse{
+				Result: &protoapi.LinodeListTunnelsResponse_Tunnels{
+					Tunnels: &protoapi.LinodeListTunnelsResponse_List{L: xs},
+				},
+				Truncated: truncated,
+				Warnings:  warnings,
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createListTunnelsErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListTunnelsResult{
+			LinodeListTunnelsResult: &protoapi.LinodeListTunnelsResponse{
+				Result: &protoapi.LinodeListTunnelsResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeCloneTunnelRequest.
+
+func (p *protobufLinode) createCloneTunnelOK(x *protoapi.LinodeInstance) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeCloneTunnelResult{
+			LinodeCloneTunnelResult: &protoapi.LinodeCloneTunnelResponse{
+				Result: &protoapi.LinodeCloneTunnelResponse_Instance{Instance: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createCloneTunnelErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeCloneTunnelResult{
+			LinodeCloneTunnelResult: &protoapi.LinodeCloneTunnelResponse{
+				Result: &protoapi.LinodeCloneTunnelResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeListTunnelConfigsRequest.
+
+func (p *protobufLinode) createListTunnelConfigsOK(xs []*protoapi.LinodeInstanceConfig) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListTunnelConfigsResult{
+			LinodeListTunnelConfigsResult: &protoapi.LinodeListTunnelConfigsResponse{
+				Result: &protoapi.LinodeListTunnelConfigsResponse_Configs{
+					Configs: &protoapi.LinodeListTunnelConfigsResponse_List{L: xs},
+				},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createListTunnelConfigsErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeListTunnelConfigsResult{
+			LinodeListTunnelConfigsResult: &protoapi.LinodeListTunnelConfigsResponse{
+				Result: &protoapi.LinodeListTunnelConfigsResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// Responses to protoapi.LinodeAddTunnelIPRequest.
+
+func (p *protobufLinode) createAddTunnelIPOK(x *protoapi.LinodeTunnelIP) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeAddTunnelIPResult{
+			LinodeAddTunnelIPResult: &protoapi.LinodeAddTunnelIPResponse{
+				Result: &protoapi.LinodeAddTunnelIPResponse_Ip{Ip: x},
+			},
+		},
+	}
+}
+
+func (p *protobufLinode) createAddTunnelIPErr(err error) *protoapi.Response {
+	return &protoapi.Response{
+		R: &protoapi.Response_LinodeAddTunnelIPResult{
+			LinodeAddTunnelIPResult: &protoapi.LinodeAddTunnelIPResponse{
+				Result: &protoapi.LinodeAddTunnelIPResponse_Error{Error: p.createError(err)},
+			},
+		},
+	}
+}
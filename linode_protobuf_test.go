@@ -0,0 +1,1970 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"protoapi"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetrieveTunnelInstancesKeepsDistinctInstances(t *testing.T) {
+	api := newTestLinodeAPI(t, serveInstances(t, []LinodeInfo{
+		{ID: 1, Label: "hp-default-instance"},
+		{ID: 2, Label: "hp-other-instance"},
+		{ID: 3, Label: "hp-default-instance"},
+		{ID: 4, Label: "hp-default-instance"},
+	}))
+
+	p := &protobufLinode{}
+	tunnels, err := p.retrieveTunnelInstances(api, "hp-default-instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{1, 3, 4}
+	if len(tunnels) != len(want) {
+		t.Fatalf("got %d instances, want %d", len(tunnels), len(want))
+	}
+	for i, tunnel := range tunnels {
+		if tunnel.ID != want[i] {
+			t.Errorf("instance #%d: got ID %d, want %d", i, tunnel.ID, want[i])
+		}
+	}
+}
+
+func TestAcceptMaintenance(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(fakeRoutes{
+		"POST /linode/instances/:id/migrate": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			// Migration starts a while after it is initiated.
+			f.queueStatuses(1, LinodeStatusRunning, LinodeStatusMigrating, LinodeStatusMigrating, LinodeStatusRunning)
+			writeJSON(t, w, http.StatusOK, struct{}{})
+		},
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.AcceptMaintenance(&protoapi.LinodeAcceptMaintenanceRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if !linode.requested("POST /linode/instances/:id/migrate") {
+		t.Error("migration wasn't initiated")
+	}
+	if statuses := linode.statuses[1]; len(statuses) != 0 {
+		t.Errorf("migration wasn't awaited, %d statuses left", len(statuses))
+	}
+
+	result := writer.response.R.(*protoapi.Response_LinodeAcceptMaintenanceResult).LinodeAcceptMaintenanceResult
+	instance := result.Result.(*protoapi.LinodeAcceptMaintenanceResponse_Instance).Instance
+	if instance.Id != 1 || instance.Status != protoapi.LinodeInstance_RUNNING {
+		t.Errorf("got instance %+v, want running instance 1", instance)
+	}
+}
+
+func TestListInstancesWithoutInstancesIsEmpty(t *testing.T) {
+	p, writer := newTestProtobufLinode(newFakeLinode(t))
+
+	if err := p.ListInstances(&protoapi.LinodeListInstancesRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListInstancesResult).LinodeListInstancesResult
+	list := result.Result.(*protoapi.LinodeListInstancesResponse_Instances).Instances
+	if list.L == nil || len(list.L) != 0 {
+		t.Errorf("got instances %#v, want explicitly empty list", list.L)
+	}
+}
+
+func TestListTunnelsWithoutInstancesIsEmpty(t *testing.T) {
+	p, writer := newTestProtobufLinode(newFakeLinode(t))
+
+	if err := p.ListTunnels(&protoapi.LinodeListTunnelsRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListTunnelsResult).LinodeListTunnelsResult
+	list := result.Result.(*protoapi.LinodeListTunnelsResponse_Tunnels).Tunnels
+	if list.L == nil || len(list.L) != 0 {
+		t.Errorf("got tunnels %#v, want explicitly empty list", list.L)
+	}
+}
+
+func TestListTunnelsSkipsUnmanagedInstances(t *testing.T) {
+	p, writer := newTestProtobufLinode(newFakeLinode(t,
+		LinodeInfo{ID: 1, Label: "hp_instance"},
+		LinodeInfo{ID: 2, Label: "web-server"},
+		LinodeInfo{ID: 3, Label: "hp_team-a_vpn"},
+		LinodeInfo{ID: 4, Label: "hpx_instance"},
+		LinodeInfo{ID: 5, Label: "database_hp_instance"},
+	))
+
+	if err := p.ListTunnels(&protoapi.LinodeListTunnelsRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListTunnelsResult).LinodeListTunnelsResult
+	tunnels := result.Result.(*protoapi.LinodeListTunnelsResponse_Tunnels).Tunnels.L
+	if len(tunnels) != 2 || tunnels[0].Id != 1 || tunnels[1].Id != 3 {
+		t.Errorf("got tunnels %+v, want instances 1 and 3", tunnels)
+	}
+}
+
+// diskInstance is a running nanode tunnel instance having 25600 MB of disk
+// space, split between the root disk and swap served by diskRoutes.
+var diskInstance = func() LinodeInfo {
+	instance := LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning, Region: "us-east", Type: "g6-nanode-1"}
+	instance.Specs.Disk = 25600
+	return instance
+}()
+
+// diskRoutes serve disks of diskInstance, which can only be resized while
+// the instance is offline.
+var diskRoutes = fakeRoutes{
+	"GET /linode/instances/:id/disks": respond(http.StatusOK, &linodeDiskPaginated{Pages: 1, Page: 1, Data: []LinodeDisk{
+		{ID: 10, Label: "root", Status: DiskStatusReady, Size: 20000},
+		{ID: 11, Label: "swap", Status: DiskStatusReady, Size: 512},
+	}}),
+	"GET /linode/instances/:id/disks/:id": respond(http.StatusOK, &LinodeDisk{ID: 10, Label: "root", Status: DiskStatusReady, Size: 20000}),
+	"POST /linode/instances/:id/disks/:id/resize": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		if f.instance(1).Status != LinodeStatusOffline {
+			writeLinodeError(f.t, w, http.StatusBadRequest, "Linode must be offline")
+			return
+		}
+		writeJSON(f.t, w, http.StatusOK, struct{}{})
+	},
+}
+
+func TestResizeTunnelDisk(t *testing.T) {
+	linode := newFakeLinode(t, diskInstance).handle(diskRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ResizeTunnelDisk(&protoapi.LinodeResizeTunnelDiskRequest{
+			Auth: testAuth(),
+			Size: 25000,
+		})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	var body struct {
+		Size int `json:"size"`
+	}
+	linode.body("POST /linode/instances/:id/disks/:id/resize", &body)
+	if body.Size != 25000 {
+		t.Errorf("disk was resized to %d MB, want 25000 MB", body.Size)
+	}
+	if status := linode.instance(1).Status; status != LinodeStatusRunning {
+		t.Errorf("instance is %s after resize, want running", status)
+	}
+}
+
+func TestResizeTunnelDiskRejectsOverAllocation(t *testing.T) {
+	linode := newFakeLinode(t, diskInstance).handle(diskRoutes)
+	// Swap leaves only 25088 MB for the root disk.
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ResizeTunnelDisk(&protoapi.LinodeResizeTunnelDiskRequest{
+			Auth: testAuth(),
+			Size: 25100,
+		})
+	})
+	if writer.err == nil {
+		t.Fatal("over-allocating resize succeeded")
+	}
+	if linode.requested("POST /linode/instances/:id/shutdown") {
+		t.Error("instance was shut down for rejected resize")
+	}
+}
+
+func TestResizeTunnelDiskBootsBackOnFailure(t *testing.T) {
+	linode := newFakeLinode(t, diskInstance).handle(diskRoutes, fakeRoutes{
+		"POST /linode/instances/:id/disks/:id/resize": respondError(http.StatusBadRequest, "Disk is busy"),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ResizeTunnelDisk(&protoapi.LinodeResizeTunnelDiskRequest{
+			Auth: testAuth(),
+			Size: 25000,
+		})
+	})
+	if writer.err == nil {
+		t.Fatal("failed resize succeeded")
+	}
+	if status := linode.instance(1).Status; status != LinodeStatusRunning {
+		t.Errorf("instance is %s after failed resize, want running", status)
+	}
+}
+
+func TestResizeTunnelDiskBootsBackWhenRequestIsCancelled(t *testing.T) {
+	resized := make(chan struct{})
+	linode := newFakeLinode(t, diskInstance).handle(diskRoutes, fakeRoutes{
+		"POST /linode/instances/:id/disks/:id/resize": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			diskRoutes["POST /linode/instances/:id/disks/:id/resize"](f, w, r)
+			close(resized)
+		},
+		"GET /linode/instances/:id/disks/:id": respond(http.StatusOK, &LinodeDisk{ID: 10, Label: "root", Status: DiskStatusNotReady, Size: 20000}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := &protobufCaptureWriter{}
+	p := newProtobufLinode(ctx, writer, newTestLinodeConfig(linode), newLinodeTunnelProvider)
+
+	done := make(chan error)
+	go func() {
+		done <- p.ResizeTunnelDisk(&protoapi.LinodeResizeTunnelDiskRequest{
+			Auth: testAuth(),
+			Size: 25000,
+		})
+	}()
+	// Client disconnects while the disk is being resized.
+	<-resized
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if writer.err == nil {
+		t.Fatal("cancelled resize succeeded")
+	}
+	if status := linode.instance(1).Status; status != LinodeStatusRunning {
+		t.Errorf("instance is %s after cancelled resize, want running", status)
+	}
+}
+
+func TestTunnelLabel(t *testing.T) {
+	p := &protobufLinode{labelPrefix: "hp", instanceName: "instance"}
+
+	cases := []struct {
+		namespace string
+		name      string
+		label     string
+	}{
+		{"", "", "hp_instance"},
+		{"", "vpn", "hp_vpn"},
+		{"team-a", "vpn", "hp_team-a_vpn"},
+	}
+	for _, c := range cases {
+		label, err := p.tunnelLabel(c.namespace, c.name)
+		if err != nil {
+			t.Errorf("%s/%s: got error %v", c.namespace, c.name, err)
+			continue
+		}
+		if label != c.label {
+			t.Errorf("%s/%s: got label %s, want %s", c.namespace, c.name, label, c.label)
+		}
+		namespace, ok := p.parseTunnelLabel(label)
+		if !ok || namespace != c.namespace {
+			t.Errorf("%s: got namespace '%s' (%v), want '%s'", label, namespace, ok, c.namespace)
+		}
+	}
+
+	for _, namespace := range []string{"team_a", "-team", "team-", "a/b"} {
+		if _, err := p.tunnelLabel(namespace, "vpn"); err == nil {
+			t.Errorf("namespace '%s' was accepted", namespace)
+		}
+	}
+	// Longest name and namespace leave no room for the prefix.
+	long := strings.Repeat("a", 32)
+	if _, err := p.tunnelLabel(long, long); err == nil || !strings.Contains(err.Error(), "Invalid tunnel name") {
+		t.Errorf("got error %v of too long label", err)
+	}
+	for _, label := range []string{"other_vpn", "hp", "hp_a_b_c", "hp_team_-vpn"} {
+		if _, ok := p.parseTunnelLabel(label); ok {
+			t.Errorf("label '%s' was parsed as a tunnel", label)
+		}
+	}
+}
+
+// noFirewallRoutes serve instances without firewalls attached.
+var noFirewallRoutes = fakeRoutes{
+	"GET /linode/instances/:id/firewalls": respond(http.StatusOK, &linodeFirewallPaginated{Pages: 1, Page: 1}),
+}
+
+// namespacedInstances are a tunnel of the same name in two namespaces.
+var namespacedInstances = []LinodeInfo{
+	{ID: 1, Label: "hp_team-a_vpn", Status: LinodeStatusRunning},
+	{ID: 2, Label: "hp_team-b_vpn", Status: LinodeStatusRunning},
+}
+
+func TestNamespacesDontSeeEachOther(t *testing.T) {
+	linode := newFakeLinode(t, namespacedInstances...).handle(noFirewallRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ListTunnels(&protoapi.LinodeListTunnelsRequest{Auth: testAuth(), Namespace: "team-a"})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListTunnelsResult).LinodeListTunnelsResult
+	tunnels := result.Result.(*protoapi.LinodeListTunnelsResponse_Tunnels).Tunnels.L
+	if len(tunnels) != 1 || tunnels[0].Id != 1 {
+		t.Errorf("got tunnels %+v, want only instance 1", tunnels)
+	}
+}
+
+func TestNamespacesDontDestroyEachOther(t *testing.T) {
+	linode := newFakeLinode(t, namespacedInstances...).handle(noFirewallRoutes)
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.DestroyTunnel(&protoapi.LinodeDestroyTunnelRequest{
+		Auth:       testAuth(),
+		Namespace:  "team-a",
+		TunnelName: "vpn",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if linode.instance(1) != nil {
+		t.Error("tunnel of the namespace wasn't destroyed")
+	}
+	if linode.instance(2) == nil {
+		t.Error("tunnel of another namespace was destroyed")
+	}
+
+	// Tunnel is gone from team-a, destroying it again mustn't reach team-b.
+	writer.err = nil
+	if err := p.DestroyTunnel(&protoapi.LinodeDestroyTunnelRequest{
+		Auth:       testAuth(),
+		Namespace:  "team-a",
+		TunnelName: "vpn",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err == nil || linode.instance(2) == nil {
+		t.Error("tunnel of another namespace was destroyed")
+	}
+}
+
+func TestGetTunnelSpecDiff(t *testing.T) {
+	for _, test := range []struct {
+		args *protoapi.LinodeGetTunnelSpecDiffRequest
+		// Field, requested and actual value of each mismatch.
+		want [][3]string
+	}{
+		{&protoapi.LinodeGetTunnelSpecDiffRequest{Region: "eu-central", Plan: "g6-nanode-1"}, nil},
+		{
+			&protoapi.LinodeGetTunnelSpecDiffRequest{Region: "us-east", Plan: "g6-nanode-1", Image: "linode/debian12"},
+			[][3]string{
+				{"region", "us-east", "eu-central"},
+				{"image", "linode/debian12", "linode/debian11"},
+			},
+		},
+	} {
+		linode := newFakeLinode(t, LinodeInfo{
+			ID:     1,
+			Label:  "hp_instance",
+			Status: LinodeStatusRunning,
+			Region: "eu-central",
+			Type:   "g6-nanode-1",
+			Image:  "linode/debian11",
+		})
+		writer := linode.call(func(p *protobufLinode) error {
+			test.args.Auth = testAuth()
+			return p.GetTunnelSpecDiff(test.args)
+		})
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+		result := writer.response.R.(*protoapi.Response_LinodeGetTunnelSpecDiffResult).LinodeGetTunnelSpecDiffResult
+		diff := result.Result.(*protoapi.LinodeGetTunnelSpecDiffResponse_Diff).Diff
+
+		if diff.Matches != (len(test.want) == 0) {
+			t.Errorf("%s: got match %v", test.args.Region, diff.Matches)
+		}
+		if len(diff.Entries) != len(test.want) {
+			t.Fatalf("%s: got %d entries, want %d", test.args.Region, len(diff.Entries), len(test.want))
+		}
+		for n, entry := range diff.Entries {
+			got := [3]string{entry.Field, entry.Requested, entry.Actual}
+			if got != test.want[n] {
+				t.Errorf("%s: entry #%d: got %v, want %v", test.args.Region, n, got, test.want[n])
+			}
+		}
+	}
+}
+
+func TestTunnelStatusReportsConflicts(t *testing.T) {
+	linode := newFakeLinode(t,
+		LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning, CreatedAt: "2024-01-01T10:00:00"},
+		LinodeInfo{ID: 2, Label: "hp_other", Status: LinodeStatusRunning},
+		LinodeInfo{ID: 3, Label: "hp_instance", Status: LinodeStatusOffline, CreatedAt: "2024-01-02T10:00:00"},
+	)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.TunnelStatus(&protoapi.LinodeGetTunnelStatusRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeTunnelStatusResult).LinodeTunnelStatusResult
+
+	if len(result.Conflicts) != 2 {
+		t.Fatalf("got %d conflicts, want 2", len(result.Conflicts))
+	}
+	first, second := result.Conflicts[0], result.Conflicts[1]
+	if first.Id != 1 || first.CreatedAt != "2024-01-01T10:00:00" || first.Status != protoapi.LinodeInstance_RUNNING {
+		t.Errorf("got conflict %+v", first)
+	}
+	if second.Id != 3 || second.CreatedAt != "2024-01-02T10:00:00" || second.Status != protoapi.LinodeInstance_OFFLINE {
+		t.Errorf("got conflict %+v", second)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != protoapi.Warning_DUPLICATE_INSTANCES {
+		t.Errorf("got warnings %v, want DUPLICATE_INSTANCES", result.Warnings)
+	}
+}
+
+func TestTunnelStatusWithoutConflicts(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.TunnelStatus(&protoapi.LinodeGetTunnelStatusRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeTunnelStatusResult).LinodeTunnelStatusResult
+
+	instance := result.Result.(*protoapi.LinodeGetTunnelStatusResponse_Instance).Instance
+	if instance.Id != 1 || len(result.Conflicts) != 0 || len(result.Warnings) != 0 {
+		t.Errorf("got instance %+v, conflicts %v, warnings %v", instance, result.Conflicts, result.Warnings)
+	}
+}
+
+// rescueRoutes boot instance 1 into rescue mode, which it can only enter
+// while offline.
+var rescueRoutes = fakeRoutes{
+	"POST /linode/instances/:id/rescue": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		if f.instance(1).Status != LinodeStatusOffline {
+			writeLinodeError(f.t, w, http.StatusBadRequest, "Linode must be offline")
+			return
+		}
+		f.queueStatuses(1, LinodeStatusBooting, LinodeStatusRunning)
+		writeJSON(f.t, w, http.StatusOK, struct{}{})
+	},
+	"GET /profile": respond(http.StatusOK, &LinodeProfile{Username: "operator"}),
+}
+
+func TestRescueTunnel(t *testing.T) {
+	linode := newFakeLinode(t, diskInstance).handle(diskRoutes, rescueRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.RescueTunnel(&protoapi.LinodeRescueTunnelRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if !linode.requested("POST /linode/instances/:id/shutdown") {
+		t.Error("running instance wasn't shut down")
+	}
+
+	var body struct {
+		Devices map[string]struct {
+			DiskID int `json:"disk_id"`
+		} `json:"devices"`
+	}
+	linode.body("POST /linode/instances/:id/rescue", &body)
+	if len(body.Devices) != 2 || body.Devices["sda"].DiskID != 10 || body.Devices["sdb"].DiskID != 11 {
+		t.Errorf("got rescue devices %+v", body.Devices)
+	}
+
+	result := writer.response.R.(*protoapi.Response_LinodeRescueTunnelResult).LinodeRescueTunnelResult
+	rescue := result.Result.(*protoapi.LinodeRescueTunnelResponse_Rescue).Rescue
+	if rescue.Instance.Status != protoapi.LinodeInstance_RUNNING {
+		t.Errorf("got status %v, want RUNNING", rescue.Instance.Status)
+	}
+	if rescue.Lish.Host != "lish-newark.linode.com" || rescue.Lish.SshCommand != "ssh -t operator@lish-newark.linode.com hp_instance" {
+		t.Errorf("got LISH connection %+v", rescue.Lish)
+	}
+}
+
+func TestRescueTunnelOffline(t *testing.T) {
+	linode := newFakeLinode(t, diskInstance).handle(diskRoutes, rescueRoutes)
+	linode.setStatus(1, LinodeStatusOffline)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.RescueTunnel(&protoapi.LinodeRescueTunnelRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if linode.requested("POST /linode/instances/:id/shutdown") {
+		t.Error("offline instance was shut down")
+	}
+}
+
+func TestBootTunnel(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusOffline})
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.BootTunnel(&protoapi.LinodeBootTunnelRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if !linode.requested("POST /linode/instances/:id/boot") {
+		t.Error("instance wasn't booted")
+	}
+}
+
+func TestBootTunnelDoesNotExist(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_other", Status: LinodeStatusOffline})
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.BootTunnel(&protoapi.LinodeBootTunnelRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err == nil {
+		t.Error("missing tunnel was booted")
+	}
+	if linode.requested("POST /linode/instances/:id/boot") {
+		t.Error("another instance was booted")
+	}
+}
+
+func TestRebootTunnelAwaitsRunning(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(fakeRoutes{
+		"POST /linode/instances/:id/reboot": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			f.setStatus(1, LinodeStatusRebooting)
+			f.queueStatuses(1, LinodeStatusRebooting, LinodeStatusRunning)
+			writeJSON(t, w, http.StatusOK, struct{}{})
+		},
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.RebootTunnel(&protoapi.LinodeRebootTunnelRequest{Auth: testAuth(), Await: true})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeRebootTunnelResult).LinodeRebootTunnelResult
+	instance := result.Result.(*protoapi.LinodeRebootTunnelResponse_Instance).Instance
+	if instance.Status != protoapi.LinodeInstance_RUNNING {
+		t.Errorf("got status %v, want RUNNING", instance.Status)
+	}
+}
+
+func TestRebootTunnelRefusesOffline(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusOffline})
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.RebootTunnel(&protoapi.LinodeRebootTunnelRequest{Auth: testAuth(), Await: true}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err == nil {
+		t.Error("offline tunnel was rebooted")
+	}
+	if linode.requested("POST /linode/instances/:id/reboot") {
+		t.Error("reboot was requested")
+	}
+}
+
+func TestListSSHKeys(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /profile/sshkeys": respond(http.StatusOK, &linodeSSHKeyPaginated{Pages: 1, Page: 1, Data: []LinodeSSHKey{
+			{ID: 5, Label: "laptop", SSHKey: "ssh-ed25519 AAAA laptop", CreatedAt: "2024-01-01T10:00:00"},
+		}}),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ListSSHKeys(&protoapi.LinodeListSSHKeysRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListSshKeysResult).LinodeListSshKeysResult
+	keys := result.Result.(*protoapi.LinodeListSSHKeysResponse_Keys).Keys.L
+	if len(keys) != 1 || keys[0].Id != 5 || keys[0].Label != "laptop" ||
+		keys[0].PublicKey != "ssh-ed25519 AAAA laptop" || keys[0].CreatedAt != "2024-01-01T10:00:00" {
+		t.Errorf("got keys %+v", keys)
+	}
+}
+
+func TestListSSHKeysWithoutProfileAccess(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /profile/sshkeys": respondError(http.StatusForbidden, "Unauthorized"),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ListSSHKeys(&protoapi.LinodeListSSHKeysRequest{Auth: testAuth()})
+	})
+	linodeErr, ok := writer.err.(*LinodeError)
+	if !ok || !linodeErr.IsPermissionsError() {
+		t.Errorf("got error %v, want permissions error", writer.err)
+	}
+}
+
+func TestShutdownTunnel(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning})
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.ShutdownTunnel(&protoapi.LinodeShutdownTunnelRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeShutdownTunnelResult).LinodeShutdownTunnelResult
+	instance := result.Result.(*protoapi.LinodeShutdownTunnelResponse_Instance).Instance
+	if instance.Status != protoapi.LinodeInstance_OFFLINE {
+		t.Errorf("got status %v, want OFFLINE", instance.Status)
+	}
+	if linode.instance(1) == nil || linode.requested("DELETE /linode/instances/:id") {
+		t.Error("instance was destroyed")
+	}
+}
+
+func TestShutdownTunnelAlreadyOffline(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusOffline})
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.ShutdownTunnel(&protoapi.LinodeShutdownTunnelRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if linode.requested("POST /linode/instances/:id/shutdown") {
+		t.Error("offline instance was shut down again")
+	}
+}
+
+// resizeRoutes serve plans diskInstance can be resized to and migrate it to
+// another plan.
+var resizeRoutes = fakeRoutes{
+	"GET /linode/types": respond(http.StatusOK, &linodeTypePaginated{Pages: 1, Page: 1, Data: []LinodeType{
+		{ID: "g6-nanode-1", Disk: 25600},
+		{ID: "g6-standard-1", Disk: 51200},
+		{ID: "g6-tiny-1", Disk: 10240},
+	}}),
+	"POST /linode/instances/:id/resize": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		f.setStatus(1, LinodeStatusMigrating)
+		f.queueStatuses(1, LinodeStatusMigrating, LinodeStatusRunning)
+		writeJSON(f.t, w, http.StatusOK, struct{}{})
+	},
+}
+
+func TestResizeTunnel(t *testing.T) {
+	linode := newFakeLinode(t, diskInstance).handle(diskRoutes, resizeRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ResizeTunnel(&protoapi.LinodeResizeTunnelRequest{Auth: testAuth(), Plan: "g6-standard-1"})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	var body struct {
+		Type                string `json:"type"`
+		AllowAutoDiskResize bool   `json:"allow_auto_disk_resize"`
+	}
+	linode.body("POST /linode/instances/:id/resize", &body)
+	if body.Type != "g6-standard-1" || !body.AllowAutoDiskResize {
+		t.Errorf("got resize request %+v", body)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeResizeTunnelResult).LinodeResizeTunnelResult
+	instance := result.Result.(*protoapi.LinodeResizeTunnelResponse_Instance).Instance
+	if instance.Status != protoapi.LinodeInstance_RUNNING {
+		t.Errorf("got status %v, want RUNNING", instance.Status)
+	}
+}
+
+func TestResizeTunnelForwardsAutoDiskResize(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		linode := newFakeLinode(t, diskInstance).handle(diskRoutes, resizeRoutes)
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.ResizeTunnel(&protoapi.LinodeResizeTunnelRequest{
+				Auth:                testAuth(),
+				Plan:                "g6-standard-1",
+				AllowAutoDiskResize: &allow,
+			})
+		})
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+
+		var body map[string]interface{}
+		linode.body("POST /linode/instances/:id/resize", &body)
+		if body["allow_auto_disk_resize"] != allow {
+			t.Errorf("got allow_auto_disk_resize %v, want %v", body["allow_auto_disk_resize"], allow)
+		}
+	}
+}
+
+func TestResizeTunnelRejectsInvalidPlans(t *testing.T) {
+	// Current plan, unknown plan and plan too small for the disks.
+	for _, plan := range []string{"g6-nanode-1", "g6-huge-1", "g6-tiny-1"} {
+		linode := newFakeLinode(t, diskInstance).handle(diskRoutes, resizeRoutes)
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.ResizeTunnel(&protoapi.LinodeResizeTunnelRequest{Auth: testAuth(), Plan: plan})
+		})
+		if writer.err == nil {
+			t.Errorf("resize to %s was accepted", plan)
+		}
+		if linode.requested("POST /linode/instances/:id/resize") {
+			t.Errorf("resize to %s was started", plan)
+		}
+	}
+}
+
+func TestRequestTimeoutCoversAwait(t *testing.T) {
+	config := &linodeConfig{awaitTimeout: 5 * time.Minute, resizeAwaitTimeout: 20 * time.Minute}
+
+	cases := map[string]time.Duration{
+		"LinodeListInstances":    defaultRequestTimeout,
+		"LinodeCreateTunnel":     defaultRequestTimeout + 5*time.Minute,
+		"LinodeRescueTunnel":     defaultRequestTimeout + 10*time.Minute,
+		"LinodeResizeTunnel":     defaultRequestTimeout + 20*time.Minute,
+		"LinodeResizeTunnelDisk": defaultRequestTimeout + 15*time.Minute,
+		"LinodeCloneTunnel":      defaultRequestTimeout + 25*time.Minute,
+	}
+	for verb, want := range cases {
+		if timeout := config.requestTimeout(verb); timeout != want {
+			t.Errorf("%s: got %v, want %v", verb, timeout, want)
+		}
+	}
+}
+
+func TestAwaitStopsWhenRequestIsCancelled(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusBooting})
+	ctx, cancel := context.WithCancel(context.Background())
+	config := newTestLinodeConfig(linode)
+	config.awaitDelay = time.Hour
+	p := newProtobufLinode(ctx, &protobufCaptureWriter{}, config, newLinodeTunnelProvider)
+
+	done := make(chan error)
+	go func() {
+		_, _, err := p.awaitUntilRunning(p.newLinodeAPI(testAuth()), 1)
+		done <- err
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if errors.Cause(err) != context.Canceled {
+			t.Errorf("got error %v, want cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("await didn't stop")
+	}
+	if linode.requested("GET /linode/instances/:id") {
+		t.Error("instance was queried after cancellation")
+	}
+}
+
+func TestAwaitGivesUpAfterAttempts(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusBooting})
+	p, _ := newTestProtobufLinode(linode)
+	p.config.awaitAttempts = 3
+	p.config.awaitTimeout = time.Hour
+
+	_, _, err := p.awaitUntilRunning(p.newLinodeAPI(testAuth()), 1)
+	if _, ok := err.(*awaitTimeoutError); !ok {
+		t.Errorf("got error %v, want await timeout", err)
+	}
+	polls := 0
+	for _, route := range linode.requests {
+		if route == "GET /linode/instances/:id" {
+			polls++
+		}
+	}
+	if polls != 3 {
+		t.Errorf("got %d polls, want 3", polls)
+	}
+}
+
+// invoiceRoutes serve invoices of the account. Only the February invoice
+// covers January, which tunnels 11 and 12 were billed for.
+var invoiceRoutes = fakeRoutes{
+	"GET /account/invoices": respond(http.StatusOK, &linodeInvoicePaginated{Pages: 1, Page: 1, Data: []LinodeInvoice{
+		{ID: 1, Date: "2023-12-01T00:00:00"},
+		{ID: 2, Date: "2024-02-01T00:00:00"},
+	}}),
+	"GET /account/invoices/:id/items": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		if pathID(r, 4) != 2 {
+			f.t.Errorf("items of invoice %d were requested", pathID(r, 4))
+		}
+		writeJSON(f.t, w, http.StatusOK, &linodeInvoiceItemPaginated{Pages: 1, Page: 1, Data: []LinodeInvoiceItem{
+			{Label: "Linode 2GB - hp_instance (11)", From: "2024-01-10T00:00:00", Total: 5},
+			{Label: "Linode 2GB - hp_instance (11)", From: "2024-01-31T12:00:00", Total: 1},
+			{Label: "Linode 2GB - hp_instance (11)", From: "2024-02-01T00:00:00", Total: 9},
+			{Label: "Linode 2GB - hp_team-a_vpn (12)", From: "2024-01-15T00:00:00", Total: 3},
+			{Label: "Linode 2GB - other (13)", From: "2024-01-15T00:00:00", Total: 7},
+		}})
+	},
+}
+
+func TestGetCostHistory(t *testing.T) {
+	linode := newFakeLinode(t).handle(invoiceRoutes)
+	// End date is inclusive, usage billed on it counts.
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetCostHistory(&protoapi.LinodeGetCostHistoryRequest{Auth: testAuth(), Since: "2024-01-01", Until: "2024-01-31"})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeGetCostHistoryResult).LinodeGetCostHistoryResult
+	history := result.Result.(*protoapi.LinodeGetCostHistoryResponse_History).History
+	if history.Total != 9 || len(history.Tunnels) != 2 {
+		t.Fatalf("got history %+v, want total 9 of 2 tunnels", history)
+	}
+	if tunnel := history.Tunnels[0]; tunnel.Id != 11 || tunnel.Label != "hp_instance" || tunnel.Cost != 6 {
+		t.Errorf("got tunnel cost %+v", tunnel)
+	}
+	if tunnel := history.Tunnels[1]; tunnel.Id != 12 || tunnel.Cost != 3 {
+		t.Errorf("got tunnel cost %+v", tunnel)
+	}
+}
+
+func TestGetCostHistoryOfNamespace(t *testing.T) {
+	linode := newFakeLinode(t).handle(invoiceRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetCostHistory(&protoapi.LinodeGetCostHistoryRequest{
+			Auth:      testAuth(),
+			Namespace: "team-a",
+			Since:     "2024-01-01",
+			Until:     "2024-01-31",
+		})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeGetCostHistoryResult).LinodeGetCostHistoryResult
+	history := result.Result.(*protoapi.LinodeGetCostHistoryResponse_History).History
+	if history.Total != 3 || len(history.Tunnels) != 1 || history.Tunnels[0].Id != 12 {
+		t.Errorf("got history %+v, want only tunnel 12", history)
+	}
+}
+
+func TestGetCostHistoryRejectsInvalidRanges(t *testing.T) {
+	for _, args := range []*protoapi.LinodeGetCostHistoryRequest{
+		{Since: "2024-01-31", Until: "2024-01-01"},
+		{Since: "January"},
+		{Since: "2024-01-01", Until: "2024-13-01"},
+		{Since: "2999-01-01"},
+	} {
+		linode := newFakeLinode(t).handle(invoiceRoutes)
+		writer := linode.call(func(p *protobufLinode) error {
+			args.Auth = testAuth()
+			return p.GetCostHistory(args)
+		})
+		if writer.err == nil {
+			t.Errorf("range %s..%s was accepted", args.Since, args.Until)
+		}
+	}
+}
+
+func TestStackScriptParamsTimezone(t *testing.T) {
+	p, _ := newTestProtobufLinode(newFakeLinode(t))
+	api := p.newLinodeAPI(testAuth())
+
+	cases := map[string]string{
+		"":              "UTC",
+		"Europe/Berlin": "Europe/Berlin",
+	}
+	for timezone, want := range cases {
+		_, params, err := p.makeStackScriptParams(api, "freedom_node", &TunnelSpec{Timezone: timezone})
+		if err != nil {
+			t.Errorf("timezone '%s': got error %v", timezone, err)
+		} else if params["udf_timezone"] != want {
+			t.Errorf("timezone '%s': got UDF %v, want %s", timezone, params["udf_timezone"], want)
+		}
+	}
+
+	for _, timezone := range []string{"Mars/Olympus_Mons", "Local", "../etc/passwd"} {
+		if _, _, err := p.makeStackScriptParams(api, "freedom_node", &TunnelSpec{Timezone: timezone}); err == nil {
+			t.Errorf("timezone '%s' was accepted", timezone)
+		}
+	}
+}
+
+// canCreateRoutes let an unrestricted user create a nanode in us-east.
+var canCreateRoutes = fakeRoutes{
+	"GET /linode/types": respond(http.StatusOK, &linodeTypePaginated{Pages: 1, Page: 1, Data: []LinodeType{{ID: "g6-nanode-1"}}}),
+	"GET /regions": respond(http.StatusOK, &linodeRegionPaginated{Pages: 1, Page: 1, Data: []LinodeRegion{
+		{ID: "us-east", Status: "ok"},
+		{ID: "eu-west", Status: "outage"},
+	}}),
+	"GET /profile/grants": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	},
+}
+
+func TestCanCreate(t *testing.T) {
+	nanode := &protoapi.LinodeCanCreateRequest{Region: "us-east", Plan: "g6-nanode-1"}
+	cases := []struct {
+		instances []LinodeInfo
+		routes    fakeRoutes
+		args      *protoapi.LinodeCanCreateRequest
+		policyErr error
+		code      protoapi.LinodeCanCreateResult_BlockerCode
+	}{
+		{nil, nil, nanode, nil, protoapi.LinodeCanCreateResult_NONE},
+		{nil, nil, nanode, errors.New("forbidden"), protoapi.LinodeCanCreateResult_FORBIDDEN_BY_POLICY},
+		{
+			nil, nil, &protoapi.LinodeCanCreateRequest{Namespace: "a_b", Region: "us-east", Plan: "g6-nanode-1"},
+			nil, protoapi.LinodeCanCreateResult_INVALID_NAMESPACE,
+		},
+		{
+			nil, nil, &protoapi.LinodeCanCreateRequest{Region: "mars-1", Plan: "g6-nanode-1"},
+			nil, protoapi.LinodeCanCreateResult_UNKNOWN_REGION,
+		},
+		{
+			nil, nil, &protoapi.LinodeCanCreateRequest{Region: "eu-west", Plan: "g6-nanode-1"},
+			nil, protoapi.LinodeCanCreateResult_REGION_UNAVAILABLE,
+		},
+		{
+			nil, nil, &protoapi.LinodeCanCreateRequest{Region: "us-east", Plan: "g6-huge-1"},
+			nil, protoapi.LinodeCanCreateResult_UNKNOWN_PLAN,
+		},
+		{
+			nil, fakeRoutes{"GET /profile/grants": respond(http.StatusOK, &LinodeGrants{})},
+			nanode, nil, protoapi.LinodeCanCreateResult_INSUFFICIENT_GRANTS,
+		},
+		{
+			[]LinodeInfo{{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}}, nil,
+			nanode, nil, protoapi.LinodeCanCreateResult_TUNNEL_EXISTS,
+		},
+		{
+			nil, fakeRoutes{"GET /linode/stackscripts": respond(http.StatusOK, &stackScriptPaginated{Pages: 1, Page: 1})},
+			nanode, nil, protoapi.LinodeCanCreateResult_STACKSCRIPT_MISSING,
+		},
+	}
+	for _, c := range cases {
+		linode := newFakeLinode(t, c.instances...).handle(canCreateRoutes, c.routes)
+		writer := linode.call(func(p *protobufLinode) error {
+			c.args.Auth = testAuth()
+			return p.CanCreate(c.args, c.policyErr)
+		})
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+		result := writer.response.R.(*protoapi.Response_LinodeCanCreateResult).LinodeCanCreateResult
+		blocker := result.Result.(*protoapi.LinodeCanCreateResponse_Result).Result
+
+		if blocker.Allowed != (c.code == protoapi.LinodeCanCreateResult_NONE) || blocker.BlockerCode != c.code {
+			t.Errorf("got result %+v, want blocker %v", blocker, c.code)
+		}
+		if !blocker.Allowed && len(blocker.BlockerMessage) == 0 {
+			t.Errorf("got blocker %v without message", blocker.BlockerCode)
+		}
+	}
+}
+
+// configRoutes serve config profiles of instances.
+var configRoutes = fakeRoutes{
+	"GET /linode/instances/:id/configs": respond(http.StatusOK, &linodeInstanceConfigPaginated{Pages: 1, Page: 1, Data: []LinodeInstanceConfig{
+		{ID: 20, Label: "default"},
+		{ID: 21, Label: "recovery"},
+	}}),
+}
+
+func TestBootTunnelWithConfigLabel(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusOffline}).handle(configRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.BootTunnel(&protoapi.LinodeBootTunnelRequest{Auth: testAuth(), ConfigLabel: "recovery"})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	var body struct {
+		ConfigID int `json:"config_id"`
+	}
+	linode.body("POST /linode/instances/:id/boot", &body)
+	if body.ConfigID != 21 {
+		t.Errorf("got config %d, want 21", body.ConfigID)
+	}
+}
+
+func TestBootTunnelWithUnknownConfigLabel(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusOffline}).handle(configRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.BootTunnel(&protoapi.LinodeBootTunnelRequest{Auth: testAuth(), ConfigLabel: "missing"})
+	})
+	if writer.err == nil {
+		t.Error("unknown config was accepted")
+	}
+	if linode.requested("POST /linode/instances/:id/boot") {
+		t.Error("instance was booted")
+	}
+}
+
+func TestExportInventory(t *testing.T) {
+	linode := newFakeLinode(t,
+		LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning, Region: "us-east"},
+		LinodeInfo{ID: 2, Label: "unmanaged", Status: LinodeStatusRunning},
+		LinodeInfo{ID: 3, Label: "hp_team-a_vpn", Status: LinodeStatusOffline, Tags: []string{"team-a"}},
+	)
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.ExportInventory(&protoapi.LinodeExportInventoryRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeExportInventoryResult).LinodeExportInventoryResult
+	inventory := result.Result.(*protoapi.LinodeExportInventoryResponse_Inventory).Inventory
+
+	if _, err := time.Parse(time.RFC3339, inventory.GeneratedAt); err != nil {
+		t.Errorf("got generation time '%s'", inventory.GeneratedAt)
+	}
+	if inventory.Truncated || len(inventory.Tunnels) != 2 {
+		t.Fatalf("got inventory %+v, want 2 tunnels", inventory)
+	}
+	first, second := inventory.Tunnels[0], inventory.Tunnels[1]
+	if first.Namespace != "" || first.Instance.Id != 1 || first.Instance.Region != "us-east" {
+		t.Errorf("got entry %+v", first)
+	}
+	if second.Namespace != "team-a" || second.Instance.Id != 3 ||
+		second.Instance.Status != protoapi.LinodeInstance_OFFLINE || len(second.Tags) != 1 || second.Tags[0] != "team-a" {
+		t.Errorf("got entry %+v", second)
+	}
+}
+
+func TestListCompatibleImages(t *testing.T) {
+	for _, test := range []struct {
+		scriptImages []string
+		want         []string
+	}{
+		{[]string{"linode/debian11", "linode/ubuntu22.04", "linode/centos7"}, []string{"linode/debian11", "linode/ubuntu22.04"}},
+		{[]string{"any/all"}, []string{"linode/debian11", "linode/ubuntu22.04", "linode/arch"}},
+	} {
+		linode := newFakeLinode(t).handle(fakeRoutes{
+			"GET /images": respond(http.StatusOK, &linodeImagePaginated{Pages: 1, Page: 1, Data: []LinodeImage{
+				{ID: "linode/debian11"},
+				{ID: "linode/ubuntu22.04"},
+				{ID: "linode/arch"},
+			}}),
+		})
+		linode.scripts[0].Images = test.scriptImages
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.ListCompatibleImages(&protoapi.LinodeListCompatibleImagesRequest{Auth: testAuth()})
+		})
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+		result := writer.response.R.(*protoapi.Response_LinodeListCompatibleImagesResult).LinodeListCompatibleImagesResult
+		images := result.Result.(*protoapi.LinodeListCompatibleImagesResponse_Images).Images.L
+
+		if len(images) != len(test.want) {
+			t.Fatalf("%v: got images %+v, want %v", test.scriptImages, images, test.want)
+		}
+		for n := range images {
+			if images[n].Id != test.want[n] {
+				t.Errorf("%v: got images %+v, want %v", test.scriptImages, images, test.want)
+			}
+		}
+	}
+}
+
+// planClassRoutes serve plans of two classes.
+var planClassRoutes = fakeRoutes{
+	"GET /linode/types": respond(http.StatusOK, &linodeTypePaginated{Pages: 1, Page: 1, Data: []LinodeType{
+		{ID: "g6-nanode-1", Class: "nanode"},
+		{ID: "g6-dedicated-32", Class: "dedicated"},
+	}}),
+}
+
+func TestAwaitLimitsForPlan(t *testing.T) {
+	linode := newFakeLinode(t).handle(planClassRoutes)
+	p, _ := newTestProtobufLinode(linode)
+	p.config.awaitTimeout = 5 * time.Minute
+	p.config.awaitAttempts = 60
+	p.config.awaitTimeoutByClass = map[string]time.Duration{
+		"nanode":    2 * time.Minute,
+		"dedicated": 20 * time.Minute,
+	}
+
+	for _, test := range []struct {
+		plan     string
+		timeout  time.Duration
+		attempts int
+	}{
+		{"g6-dedicated-32", 20 * time.Minute, 0},
+		{"g6-nanode-1", 2 * time.Minute, 0},
+		{"g6-unknown-1", 5 * time.Minute, 60},
+	} {
+		timeout, attempts := p.awaitLimitsForPlan(linode.api, test.plan)
+		if timeout != test.timeout || attempts != test.attempts {
+			t.Errorf("%s: got %s and %d attempts, want %s and %d",
+				test.plan, timeout, attempts, test.timeout, test.attempts)
+		}
+	}
+}
+
+func TestAwaitLimitsForPlanWithoutClasses(t *testing.T) {
+	linode := newFakeLinode(t).handle(planClassRoutes)
+	p, _ := newTestProtobufLinode(linode)
+	p.config.awaitAttempts = 60
+
+	timeout, attempts := p.awaitLimitsForPlan(linode.api, "g6-dedicated-32")
+	if timeout != p.config.awaitTimeout || attempts != 60 {
+		t.Errorf("got %s and %d attempts, want defaults", timeout, attempts)
+	}
+	if linode.requested("GET /linode/types") {
+		t.Error("plans were listed without any class timeouts")
+	}
+}
+
+func TestCreateTunnelAwaitsPerPlanClass(t *testing.T) {
+	for _, test := range []struct {
+		plan string
+		ok   bool
+	}{
+		// The large plan outlives the attempt limit of the default await.
+		{"g6-dedicated-32", true},
+		{"g6-nanode-1", false},
+	} {
+		linode := newFakeLinode(t).handle(planClassRoutes)
+		linode.createStatus = LinodeStatusProvisioning
+		linode.queueStatuses(1001, LinodeStatusProvisioning, LinodeStatusBooting, LinodeStatusRunning)
+		writer := linode.call(func(p *protobufLinode) error {
+			p.config.awaitAttempts = 1
+			p.config.awaitTimeoutByClass = map[string]time.Duration{"dedicated": time.Second}
+			return p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+				Auth:   testAuth(),
+				Region: "us-east",
+				Plan:   test.plan,
+			})
+		})
+		if ok := writer.err == nil; ok != test.ok {
+			t.Errorf("%s: got error %v", test.plan, writer.err)
+		}
+	}
+}
+
+func TestRequestTimeoutCoversLongestPlanClass(t *testing.T) {
+	config := &linodeConfig{
+		awaitTimeout:        time.Minute,
+		awaitTimeoutByClass: map[string]time.Duration{"dedicated": 10 * time.Minute, "nanode": 30 * time.Second},
+	}
+	if got, want := config.requestTimeout("LinodeCreateTunnel"), 10*time.Minute+defaultRequestTimeout; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	// Plan classes only apply to creates.
+	if got, want := config.requestTimeout("LinodeRebootTunnel"), time.Minute+defaultRequestTimeout; got != want {
+		t.Errorf("reboot: got %s, want %s", got, want)
+	}
+}
+
+func TestBatchTunnelStatus(t *testing.T) {
+	linode := newFakeLinode(t,
+		LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning},
+		LinodeInfo{ID: 2, Label: "hp_vpn", Status: LinodeStatusRunning},
+		LinodeInfo{ID: 3, Label: "hp_vpn", Status: LinodeStatusOffline},
+		LinodeInfo{ID: 4, Label: "hp_team-a_missing", Status: LinodeStatusRunning},
+	)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.BatchTunnelStatus(&protoapi.LinodeBatchTunnelStatusRequest{
+			Auth:        testAuth(),
+			TunnelNames: []string{"", "vpn", "missing", "Not a name!"},
+		})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeBatchTunnelStatusResult).LinodeBatchTunnelStatusResult
+	entries := result.Result.(*protoapi.LinodeBatchTunnelStatusResponse_Statuses).Statuses.L
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(entries))
+	}
+
+	if entry := entries[0]; entry.Instance == nil || entry.Instance.Id != 1 || entry.Error != nil || len(entry.Conflicts) != 0 {
+		t.Errorf("default tunnel: got instance %v, error %v, conflicts %v", entry.Instance, entry.Error, entry.Conflicts)
+	}
+	if entry := entries[1]; entry.TunnelName != "vpn" || entry.Instance == nil || len(entry.Conflicts) != 2 {
+		t.Errorf("duplicated tunnel: got instance %v, conflicts %v", entry.Instance, entry.Conflicts)
+	}
+	for _, entry := range entries[2:] {
+		if entry.Instance != nil || entry.Error == nil {
+			t.Errorf("%s: got instance %v, error %v", entry.TunnelName, entry.Instance, entry.Error)
+		}
+	}
+	if n := len(linode.requests); n != 1 {
+		t.Errorf("got %d requests, want a single list: %v", n, linode.requests)
+	}
+}
+
+func TestBatchTunnelStatusLimitsBatchSize(t *testing.T) {
+	for _, batch := range [][]string{nil, make([]string, maxBatchTunnelStatus+1)} {
+		linode := newFakeLinode(t)
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.BatchTunnelStatus(&protoapi.LinodeBatchTunnelStatusRequest{Auth: testAuth(), TunnelNames: batch})
+		})
+		if writer.err == nil {
+			t.Errorf("batch of %d tunnels was accepted", len(batch))
+		}
+		if len(linode.requests) != 0 {
+			t.Errorf("got requests %v for rejected batch", linode.requests)
+		}
+	}
+}
+
+// reverseDNSInstance is a tunnel instance with a public and a private IPv4
+// address.
+var reverseDNSInstance = LinodeInfo{
+	ID:     1,
+	Label:  "hp_instance",
+	Status: LinodeStatusRunning,
+	IPv4:   []string{"192.0.2.10", "192.168.128.5"},
+}
+
+// reverseDNSRoutes set rDNS of the public address of reverseDNSInstance to
+// vpn.example.com, which is the only domain resolving to it.
+var reverseDNSRoutes = fakeRoutes{
+	"PUT /networking/ips/192.0.2.10": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RDNS *string `json:"rdns"`
+		}
+		f.body("PUT /networking/ips/192.0.2.10", &body)
+		if body.RDNS != nil && *body.RDNS != "vpn.example.com" {
+			writeJSON(f.t, w, http.StatusBadRequest, map[string]interface{}{
+				"errors": []map[string]string{{"field": "rdns", "reason": "Domain does not resolve to this IP"}},
+			})
+			return
+		}
+		writeJSON(f.t, w, http.StatusOK, struct{}{})
+	},
+}
+
+func TestSetTunnelReverseDNS(t *testing.T) {
+	for _, hostname := range []string{"vpn.example.com", ""} {
+		linode := newFakeLinode(t, reverseDNSInstance).handle(reverseDNSRoutes)
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.SetTunnelReverseDNS(&protoapi.LinodeSetTunnelReverseDNSRequest{Auth: testAuth(), Hostname: hostname})
+		})
+		if writer.err != nil {
+			t.Fatalf("%q: %v", hostname, writer.err)
+		}
+
+		var body map[string]interface{}
+		linode.body("PUT /networking/ips/192.0.2.10", &body)
+		// Empty hostname resets rDNS to Linode's default.
+		if rdns, ok := body["rdns"]; !ok || (hostname == "" && rdns != nil) || (hostname != "" && rdns != hostname) {
+			t.Errorf("%q: got body %v", hostname, body)
+		}
+	}
+}
+
+func TestSetTunnelReverseDNSPassesValidationErrors(t *testing.T) {
+	linode := newFakeLinode(t, reverseDNSInstance).handle(reverseDNSRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.SetTunnelReverseDNS(&protoapi.LinodeSetTunnelReverseDNSRequest{Auth: testAuth(), Hostname: "elsewhere.example.com"})
+	})
+	linodeErr, ok := writer.err.(*LinodeError)
+	if !ok || len(linodeErr.Errors) != 1 || linodeErr.Errors[0].Field != "rdns" {
+		t.Errorf("got error %v, want validation error of rdns", writer.err)
+	}
+}
+
+func TestSetTunnelReverseDNSWithoutIPv4(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.SetTunnelReverseDNS(&protoapi.LinodeSetTunnelReverseDNSRequest{Auth: testAuth(), Hostname: "vpn.example.com"})
+	})
+	if writer.err == nil {
+		t.Error("rDNS was set without an address")
+	}
+}
+
+// notificationRoutes serve an outage in us-east, a maintenance of the whole
+// platform and a billing reminder.
+var notificationRoutes = fakeRoutes{
+	"GET /account/notifications": respond(http.StatusOK, map[string]interface{}{
+		"pages": 1,
+		"page":  1,
+		"data": []map[string]interface{}{
+			{
+				"type":     "outage",
+				"label":    "Connectivity issue",
+				"severity": "critical",
+				"when":     "2024-01-01T10:00:00",
+				"entity":   map[string]string{"id": "us-east", "type": "region"},
+			},
+			{"type": "maintenance", "label": "Platform maintenance", "severity": "minor"},
+			{"type": "payment_due", "label": "Invoice is due", "severity": "major"},
+		},
+	}),
+}
+
+func providerNotices(t *testing.T, writer *protobufCaptureWriter) []*protoapi.LinodeProviderNotice {
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeGetProviderStatusResult).LinodeGetProviderStatusResult
+	return result.Result.(*protoapi.LinodeGetProviderStatusResponse_Notices).Notices.L
+}
+
+func TestGetProviderStatus(t *testing.T) {
+	linode := newFakeLinode(t).handle(notificationRoutes)
+	notices := providerNotices(t, linode.call(func(p *protobufLinode) error {
+		return p.GetProviderStatus(&protoapi.LinodeGetProviderStatusRequest{Auth: testAuth()})
+	}))
+	if len(notices) != 2 {
+		t.Fatalf("got %d notices, want outage and maintenance", len(notices))
+	}
+	outage := notices[0]
+	if outage.Type != "outage" || outage.Region != "us-east" || outage.Severity != "critical" || outage.Since != "2024-01-01T10:00:00" {
+		t.Errorf("got outage %+v", outage)
+	}
+	if notices[1].Type != "maintenance" || notices[1].Region != "" {
+		t.Errorf("got maintenance %+v", notices[1])
+	}
+
+	// Notices of other regions are left out, global ones are kept.
+	notices = providerNotices(t, linode.call(func(p *protobufLinode) error {
+		return p.GetProviderStatus(&protoapi.LinodeGetProviderStatusRequest{Auth: testAuth(), Region: "eu-central"})
+	}))
+	if len(notices) != 1 || notices[0].Type != "maintenance" {
+		t.Errorf("got notices %+v, want only the global maintenance", notices)
+	}
+}
+
+func TestGetProviderStatusFeedUnavailable(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /account/notifications": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "0")
+			writeLinodeError(t, w, http.StatusServiceUnavailable, "Service unavailable")
+		},
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetProviderStatus(&protoapi.LinodeGetProviderStatusRequest{Auth: testAuth()})
+	})
+	if writer.err == nil {
+		t.Error("unavailable feed wasn't reported")
+	}
+}
+
+func TestGetProviderStatusIsCached(t *testing.T) {
+	linode := newFakeLinode(t).handle(notificationRoutes)
+	p, writer := newTestProtobufLinode(linode)
+	p.config.notifications = newLinodeMetadataCache(time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if err := p.GetProviderStatus(&protoapi.LinodeGetProviderStatusRequest{Auth: testAuth()}); err != nil {
+			t.Fatal(err)
+		}
+		if notices := providerNotices(t, writer); len(notices) != 2 {
+			t.Errorf("got %d notices", len(notices))
+		}
+	}
+	if n := len(linode.requests); n != 1 {
+		t.Errorf("got %d requests of notifications, want 1", n)
+	}
+}
+
+// pagedRoutes list 3 pages of one instance each.
+var pagedRoutes = fakeRoutes{
+	"GET /linode/instances": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		writeJSON(f.t, w, http.StatusOK, &linodeInfoPaginated{
+			Pages: 3,
+			Page:  page,
+			Data:  []LinodeInfo{{ID: page, Label: fmt.Sprintf("hp_page-%d", page)}},
+		})
+	},
+}
+
+func TestListInstancesResumesFromCursor(t *testing.T) {
+	p, writer := newTestProtobufLinode(newFakeLinode(t).handle(pagedRoutes))
+
+	cursor := ""
+	for page := 1; page <= 3; page++ {
+		if err := p.ListInstances(&protoapi.LinodeListInstancesRequest{
+			Auth:     testAuth(),
+			Paginate: true,
+			Cursor:   cursor,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+		result := writer.response.R.(*protoapi.Response_LinodeListInstancesResult).LinodeListInstancesResult
+		instances := result.Result.(*protoapi.LinodeListInstancesResponse_Instances).Instances.L
+		if len(instances) != 1 || instances[0].Id != int64(page) {
+			t.Fatalf("page %d: got instances %+v", page, instances)
+		}
+		if last := page == 3; last != (result.NextCursor == "") {
+			t.Fatalf("page %d: got next cursor %q", page, result.NextCursor)
+		}
+		cursor = result.NextCursor
+	}
+}
+
+func TestListInstancesRejectsForeignCursors(t *testing.T) {
+	p, writer := newTestProtobufLinode(newFakeLinode(t).handle(pagedRoutes))
+	if err := p.ListInstances(&protoapi.LinodeListInstancesRequest{Auth: testAuth(), Paginate: true}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	cursor := writer.response.R.(*protoapi.Response_LinodeListInstancesResult).LinodeListInstancesResult.NextCursor
+
+	// Cursor signed with another key, and one issued for another namespace.
+	forged, err := encodeListCursor([]byte("another-key"), &listCursor{Page: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range []*protoapi.LinodeListInstancesRequest{
+		{Auth: testAuth(), Cursor: forged},
+		{Auth: testAuth(), Cursor: cursor, Namespace: "team-a"},
+	} {
+		writer.err = nil
+		if err := p.ListInstances(args); err != nil {
+			t.Fatal(err)
+		}
+		if writer.err == nil {
+			t.Errorf("cursor %q of namespace '%s' was accepted", args.Cursor, args.Namespace)
+		}
+	}
+}
+
+func TestInstanceStatusToProtobuf(t *testing.T) {
+	p, _ := newTestProtobufLinode(newFakeLinode(t))
+	for status, want := range map[string]protoapi.LinodeInstance_Status{
+		"running":       protoapi.LinodeInstance_RUNNING,
+		"offline":       protoapi.LinodeInstance_OFFLINE,
+		"shutting_down": protoapi.LinodeInstance_SHUTTING_DOWN,
+		// Status Linode may add in the future mustn't read as offline.
+		"powered_off": protoapi.LinodeInstance_UNKNOWN,
+		"":            protoapi.LinodeInstance_UNKNOWN,
+	} {
+		if got := p.instanceStatusToProtobuf(status); got != want {
+			t.Errorf("%q: got %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestTunnelStatusWithUnknownStatus(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: "powered_off"})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.TunnelStatus(&protoapi.LinodeGetTunnelStatusRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeTunnelStatusResult).LinodeTunnelStatusResult
+	instance := result.Result.(*protoapi.LinodeGetTunnelStatusResponse_Instance).Instance
+	if instance.Status != protoapi.LinodeInstance_UNKNOWN {
+		t.Errorf("got status %v, want UNKNOWN", instance.Status)
+	}
+}
+
+func TestTunnelStatusReportsAllIPv6Addresses(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{
+		ID:     1,
+		Label:  "hp_instance",
+		Status: LinodeStatusRunning,
+		IPv6:   LinodeIPv6{"2001:db8::f03c:91ff:fe24:3a2f/64"},
+	}).handle(fakeRoutes{
+		"GET /linode/instances/:id/ips": respondFixture(linodeInstanceIPsFixture),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.TunnelStatus(&protoapi.LinodeGetTunnelStatusRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeTunnelStatusResult).LinodeTunnelStatusResult
+	instance := result.Result.(*protoapi.LinodeGetTunnelStatusResponse_Instance).Instance
+	if len(instance.Ipv6) != 3 || instance.Ipv6[1] != "fe80::f03c:91ff:fe24:3a2f/64" || instance.Ipv6[2] != "2001:db8:1::/56" {
+		t.Errorf("got IPv6 %v, want SLAAC, link-local and global", instance.Ipv6)
+	}
+}
+
+func TestListKernels(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /linode/kernels": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			if auth := r.Header.Get("Authorization"); len(auth) > 0 {
+				t.Errorf("kernels were listed with credentials %s", auth)
+			}
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			kernels := []LinodeKernel{{ID: "linode/latest-64bit", Label: "Latest 64 bit", Version: "6.1.10", Architecture: "x86_64", KVM: true}}
+			if page == 2 {
+				kernels = []LinodeKernel{{ID: "linode/4.9.7-x86_64", Version: "4.9.7", Architecture: "x86_64", Deprecated: true}}
+			}
+			writeJSON(t, w, http.StatusOK, &linodeKernelPaginated{Pages: 2, Page: page, Data: kernels})
+		},
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ListKernels(&protoapi.LinodeListKernelsRequest{})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListKernelsResult).LinodeListKernelsResult
+	kernels := result.Result.(*protoapi.LinodeListKernelsResponse_Kernels).Kernels.L
+	if len(kernels) != 2 {
+		t.Fatalf("got %d kernels, want both pages", len(kernels))
+	}
+	if k := kernels[0]; k.Id != "linode/latest-64bit" || k.Label != "Latest 64 bit" || k.Version != "6.1.10" ||
+		k.Architecture != "x86_64" || !k.Kvm || k.Deprecated {
+		t.Errorf("got kernel %+v", k)
+	}
+	if k := kernels[1]; k.Id != "linode/4.9.7-x86_64" || !k.Deprecated {
+		t.Errorf("got kernel %+v", k)
+	}
+}
+
+// truncatedRoutes list 5 pages of instances, of which the 4th fails.
+var truncatedRoutes = fakeRoutes{
+	"GET /linode/instances": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "4" {
+			writeLinodeError(f.t, w, http.StatusBadRequest, "Invalid page")
+			return
+		}
+		servePages(f.t, 5, 3).ServeHTTP(w, r)
+	},
+}
+
+func TestListInstancesReturnsPartialResults(t *testing.T) {
+	p, writer := newTestProtobufLinode(newFakeLinode(t).handle(truncatedRoutes))
+	p.config.partialResults = true
+
+	if err := p.ListInstances(&protoapi.LinodeListInstancesRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListInstancesResult).LinodeListInstancesResult
+	instances := result.Result.(*protoapi.LinodeListInstancesResponse_Instances).Instances.L
+	if !result.Truncated || len(instances) != 9 {
+		t.Errorf("got truncated %v with %d instances, want the first 3 pages", result.Truncated, len(instances))
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != protoapi.Warning_LIST_TRUNCATED {
+		t.Errorf("got warnings %v, want LIST_TRUNCATED", result.Warnings)
+	}
+}
+
+func TestListInstancesFailsWithoutPartialResults(t *testing.T) {
+	linode := newFakeLinode(t).handle(truncatedRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ListInstances(&protoapi.LinodeListInstancesRequest{Auth: testAuth()})
+	})
+	if writer.err == nil {
+		t.Error("truncated listing was returned")
+	}
+}
+
+// linodeInstanceConfigsFixture is a config profile with a VLAN interface and
+// unused devices, as reported by Linode.
+const linodeInstanceConfigsFixture = `{"pages": 1, "page": 1, "data": [{
+	"id": 20,
+	"label": "default",
+	"kernel": "linode/grub2",
+	"root_device": "/dev/sda",
+	"interfaces": [
+		{"purpose": "public", "label": "", "ipam_address": ""},
+		{"purpose": "vlan", "label": "tunnels", "ipam_address": "10.0.0.1/24"}
+	],
+	"devices": {"sda": {"disk_id": 100, "volume_id": null}, "sdb": {"disk_id": 101, "volume_id": null}, "sdc": null}
+}]}`
+
+func TestListTunnelConfigs(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(fakeRoutes{
+		"GET /linode/instances/:id/configs": respondFixture(linodeInstanceConfigsFixture),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ListTunnelConfigs(&protoapi.LinodeListTunnelConfigsRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeListTunnelConfigsResult).LinodeListTunnelConfigsResult
+	configs := result.Result.(*protoapi.LinodeListTunnelConfigsResponse_Configs).Configs.L
+	if len(configs) != 1 {
+		t.Fatalf("got %d configs, want 1", len(configs))
+	}
+	config := configs[0]
+	if config.Id != 20 || config.Label != "default" || config.Kernel != "linode/grub2" || config.RootDevice != "/dev/sda" {
+		t.Errorf("got config %+v", config)
+	}
+	if len(config.Interfaces) != 2 || config.Interfaces[1].Purpose != "vlan" || config.Interfaces[1].IpamAddress != "10.0.0.1/24" {
+		t.Errorf("got interfaces %+v", config.Interfaces)
+	}
+	// Unused devices are left out, the rest are sorted by name.
+	if len(config.Devices) != 2 || config.Devices[0].Name != "sda" || config.Devices[0].DiskId != 100 || config.Devices[1].Name != "sdb" {
+		t.Errorf("got devices %+v", config.Devices)
+	}
+}
+
+func TestUpdateInstanceConfig(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"PUT /linode/instances/:id/configs/:id": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			if pathID(r, 4) != 1 || pathID(r, 6) != 20 {
+				t.Errorf("got path %s", r.URL.Path)
+			}
+			writeJSON(t, w, http.StatusOK, &LinodeInstanceConfig{ID: 20, Kernel: "linode/latest-64bit"})
+		},
+	})
+
+	config, err := linode.api.UpdateInstanceConfig(1, 20, map[string]interface{}{"kernel": "linode/latest-64bit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]string
+	linode.body("PUT /linode/instances/:id/configs/:id", &body)
+	if body["kernel"] != "linode/latest-64bit" || config.Kernel != "linode/latest-64bit" {
+		t.Errorf("got body %v, config %+v", body, config)
+	}
+}
+
+// duplicateInstances are two instances carrying the default tunnel label
+// and an unrelated one.
+var duplicateInstances = []LinodeInfo{
+	{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning},
+	{ID: 2, Label: "web-server", Status: LinodeStatusRunning},
+	{ID: 3, Label: "hp_instance", Status: LinodeStatusRunning},
+}
+
+func TestStrictSingleTunnelRefusesMutations(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		linode := newFakeLinode(t, duplicateInstances...).handle(noFirewallRoutes)
+		p, writer := newTestProtobufLinode(linode)
+		p.config.strictSingleTunnel = strict
+
+		if err := p.ShutdownTunnel(&protoapi.LinodeShutdownTunnelRequest{Auth: testAuth()}); err != nil {
+			t.Fatal(err)
+		}
+		if !strict {
+			if writer.err != nil || linode.instance(1).Status != LinodeStatusOffline {
+				t.Errorf("got error %v, want the first instance shut down", writer.err)
+			}
+			continue
+		}
+		if code := errorCode(t, writer.err); code != protoapi.HolepuncherError_DUPLICATE_TUNNELS {
+			t.Errorf("got error code %v, want DUPLICATE_TUNNELS", code)
+		}
+		if linode.requested("POST /linode/instances/:id/shutdown") {
+			t.Error("duplicated tunnel was shut down")
+		}
+	}
+}
+
+func TestStrictSingleTunnelAllowsStatus(t *testing.T) {
+	linode := newFakeLinode(t, duplicateInstances...).handle(noFirewallRoutes)
+	p, writer := newTestProtobufLinode(linode)
+	p.config.strictSingleTunnel = true
+
+	if err := p.TunnelStatus(&protoapi.LinodeGetTunnelStatusRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeTunnelStatusResult).LinodeTunnelStatusResult
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != protoapi.Warning_DUPLICATE_INSTANCES {
+		t.Errorf("got warnings %v, want DUPLICATE_INSTANCES", result.Warnings)
+	}
+}
+
+func TestDestroyDuplicateByInstanceID(t *testing.T) {
+	linode := newFakeLinode(t, duplicateInstances...).handle(noFirewallRoutes)
+	p, writer := newTestProtobufLinode(linode)
+	p.config.strictSingleTunnel = true
+
+	// Only instances carrying the tunnel label may be picked.
+	if err := p.DestroyTunnel(&protoapi.LinodeDestroyTunnelRequest{Auth: testAuth(), InstanceId: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err == nil || linode.instance(2) == nil {
+		t.Error("unrelated instance was destroyed")
+	}
+
+	writer.err = nil
+	if err := p.DestroyTunnel(&protoapi.LinodeDestroyTunnelRequest{Auth: testAuth(), InstanceId: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if linode.instance(3) != nil || linode.instance(1) == nil {
+		t.Error("wrong duplicate was destroyed")
+	}
+}
+
+// addIPInstance is a tunnel instance with a single public IPv4 address.
+var addIPInstance = LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning, IPv4: []string{"192.0.2.10"}}
+
+// addIPRoutes allocate 192.0.2.20 to addIPInstance.
+var addIPRoutes = fakeRoutes{
+	"POST /networking/ips": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		f.instances[0].IPv4 = append(f.instances[0].IPv4, "192.0.2.20")
+		f.mutex.Unlock()
+		writeJSON(f.t, w, http.StatusOK, &LinodeIP{Address: "192.0.2.20", Prefix: 24, Gateway: "192.0.2.1", Public: true, LinodeID: 1})
+	},
+}
+
+func addedTunnelIP(t *testing.T, writer *protobufCaptureWriter) *protoapi.LinodeTunnelIP {
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeAddTunnelIPResult).LinodeAddTunnelIPResult
+	return result.Result.(*protoapi.LinodeAddTunnelIPResponse_Ip).Ip
+}
+
+func TestAddTunnelIP(t *testing.T) {
+	linode := newFakeLinode(t, addIPInstance).handle(addIPRoutes)
+	ip := addedTunnelIP(t, linode.call(func(p *protobufLinode) error {
+		return p.AddTunnelIP(&protoapi.LinodeAddTunnelIPRequest{Auth: testAuth(), Public: true})
+	}))
+
+	var body map[string]interface{}
+	linode.body("POST /networking/ips", &body)
+	if body["linode_id"] != 1.0 || body["type"] != "ipv4" || body["public"] != true {
+		t.Errorf("got allocation request %v", body)
+	}
+	if ip.Address != "192.0.2.20" || ip.Prefix != 24 || !ip.Public || len(ip.Warnings) != 0 {
+		t.Errorf("got IP %+v", ip)
+	}
+	if ipv4 := ip.Instance.Ipv4; len(ipv4) != 2 || ipv4[1] != "192.0.2.20" {
+		t.Errorf("got instance addresses %v, want the new one included", ipv4)
+	}
+}
+
+func TestAddTunnelIPRequiresJustification(t *testing.T) {
+	for _, reason := range []string{
+		"Additional IPv4 addresses require technical justification.",
+		"Region is out of addresses",
+	} {
+		linode := newFakeLinode(t, addIPInstance).handle(fakeRoutes{
+			"POST /networking/ips": respondError(http.StatusBadRequest, reason),
+		})
+		writer := linode.call(func(p *protobufLinode) error {
+			return p.AddTunnelIP(&protoapi.LinodeAddTunnelIPRequest{Auth: testAuth(), Public: true})
+		})
+		if strings.Contains(reason, "justification") {
+			if code := errorCode(t, writer.err); code != protoapi.HolepuncherError_IP_JUSTIFICATION_REQUIRED {
+				t.Errorf("got error code %v, want IP_JUSTIFICATION_REQUIRED", code)
+			}
+			continue
+		}
+		// Other failures are passed as they are.
+		if _, ok := writer.err.(*LinodeError); !ok {
+			t.Errorf("got error %v, want LinodeError", writer.err)
+		}
+	}
+}
+
+func TestAddTunnelIPWhenInstanceNotRefreshed(t *testing.T) {
+	linode := newFakeLinode(t, addIPInstance).handle(addIPRoutes, fakeRoutes{
+		"GET /linode/instances/:id": respondError(http.StatusForbidden, "Forbidden"),
+	})
+
+	// Address is allocated already, so it is returned with a warning.
+	ip := addedTunnelIP(t, linode.call(func(p *protobufLinode) error {
+		return p.AddTunnelIP(&protoapi.LinodeAddTunnelIPRequest{Auth: testAuth(), Public: true})
+	}))
+	if ip.Address != "192.0.2.20" || ip.Instance == nil {
+		t.Errorf("got IP %+v", ip)
+	}
+	if len(ip.Warnings) != 1 || ip.Warnings[0].Code != protoapi.Warning_INSTANCE_NOT_REFRESHED {
+		t.Errorf("got warnings %v, want INSTANCE_NOT_REFRESHED", ip.Warnings)
+	}
+}
+
+// backupRoutes serve an automatic backup and a snapshot in progress, which
+// can be restored only to an offline instance.
+var backupRoutes = fakeRoutes{
+	"GET /linode/instances/:id/backups": respond(http.StatusOK, map[string]interface{}{
+		"automatic": []LinodeBackup{{
+			ID:     10,
+			Type:   "auto",
+			Status: "successful",
+			Disks:  []LinodeBackupDisk{{Label: "Boot", Size: 25600, Filesystem: "ext4"}},
+		}},
+		"snapshot": map[string]interface{}{
+			"current":     nil,
+			"in_progress": &LinodeBackup{ID: 11, Label: "before-upgrade", Type: "snapshot", Status: "running"},
+		},
+	}),
+	"POST /linode/instances/:id/backups/:id/restore": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		if f.instance(1).Status != LinodeStatusOffline {
+			writeLinodeError(f.t, w, http.StatusBadRequest, "Linode must be offline")
+			return
+		}
+		writeJSON(f.t, w, http.StatusOK, struct{}{})
+	},
+}
+
+func TestListTunnelBackups(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(backupRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.ListTunnelBackups(&protoapi.LinodeListTunnelBackupsRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	result := writer.response.R.(*protoapi.Response_LinodeListTunnelBackupsResult).LinodeListTunnelBackupsResult
+	backups := result.Result.(*protoapi.LinodeListTunnelBackupsResponse_Backups).Backups.L
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want automatic and in-progress snapshot", len(backups))
+	}
+	if backups[0].Id != 10 || len(backups[0].Disks) != 1 || backups[0].Disks[0].Size != 25600 {
+		t.Errorf("got automatic backup %+v", backups[0])
+	}
+	if backups[1].Id != 11 || backups[1].Label != "before-upgrade" || backups[1].Status != "running" {
+		t.Errorf("got snapshot %+v", backups[1])
+	}
+}
+
+func TestRestoreTunnelBackup(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(backupRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.RestoreTunnelBackup(&protoapi.LinodeRestoreTunnelBackupRequest{Auth: testAuth(), BackupId: 10})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	if !linode.requested("POST /linode/instances/:id/shutdown") {
+		t.Error("running instance wasn't shut down before restore")
+	}
+	var body map[string]interface{}
+	linode.body("POST /linode/instances/:id/backups/:id/restore", &body)
+	if body["linode_id"] != float64(1) || body["overwrite"] != true {
+		t.Errorf("got restore body %v", body)
+	}
+}
+
+func TestRestoreTunnelBackupOfOfflineTunnel(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusOffline}).handle(backupRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.RestoreTunnelBackup(&protoapi.LinodeRestoreTunnelBackupRequest{Auth: testAuth(), BackupId: 10})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	if linode.requested("POST /linode/instances/:id/shutdown") {
+		t.Error("offline instance was shut down")
+	}
+}
+
+// stackScriptRoutes let StackScripts be created, updated and deleted, of
+// which only StackScript #1 exists.
+var stackScriptRoutes = fakeRoutes{
+	"POST /linode/stackscripts": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		var spec StackScriptSpec
+		f.body("POST /linode/stackscripts", &spec)
+		writeJSON(f.t, w, http.StatusOK, &StackScript{ID: 2, Label: spec.Label, Description: spec.Description})
+	},
+	"PUT /linode/stackscripts/:id": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		var spec StackScriptSpec
+		f.body("PUT /linode/stackscripts/:id", &spec)
+		writeJSON(f.t, w, http.StatusOK, &StackScript{ID: pathID(r, 4), Label: "freedom_node", Description: spec.Description})
+	},
+	"DELETE /linode/stackscripts/:id": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		if pathID(r, 4) != 1 {
+			writeLinodeError(f.t, w, http.StatusNotFound, "Not found")
+			return
+		}
+		writeJSON(f.t, w, http.StatusOK, struct{}{})
+	},
+}
+
+func TestCreateStackScript(t *testing.T) {
+	linode := newFakeLinode(t).handle(stackScriptRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CreateStackScript(&protoapi.LinodeCreateStackScriptRequest{
+			Auth:   testAuth(),
+			Label:  "freedom_node_v2",
+			Images: []string{"linode/debian12"},
+			Script: "#!/bin/sh\n",
+		})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	var spec StackScriptSpec
+	linode.body("POST /linode/stackscripts", &spec)
+	if spec.Label != "freedom_node_v2" || len(spec.Images) != 1 || spec.Script != "#!/bin/sh\n" {
+		t.Errorf("got create request %+v", spec)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeCreateStackscriptResult).LinodeCreateStackscriptResult
+	if script := result.Result.(*protoapi.LinodeCreateStackScriptResponse_Stackscript).Stackscript; script.Id != 2 {
+		t.Errorf("got StackScript %+v", script)
+	}
+}
+
+func TestCreateStackScriptRequiresFields(t *testing.T) {
+	for _, args := range []*protoapi.LinodeCreateStackScriptRequest{
+		{Images: []string{"linode/debian12"}, Script: "#!/bin/sh\n"},
+		{Label: "freedom_node_v2", Script: "#!/bin/sh\n"},
+		{Label: "freedom_node_v2", Images: []string{"linode/debian12"}},
+	} {
+		linode := newFakeLinode(t).handle(stackScriptRoutes)
+		args.Auth = testAuth()
+		writer := linode.call(func(p *protobufLinode) error { return p.CreateStackScript(args) })
+		if writer.err == nil || linode.requested("POST /linode/stackscripts") {
+			t.Errorf("incomplete StackScript %+v was created", args)
+		}
+	}
+}
+
+func TestUpdateStackScriptSendsSetFields(t *testing.T) {
+	linode := newFakeLinode(t).handle(stackScriptRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.UpdateStackScript(&protoapi.LinodeUpdateStackScriptRequest{Auth: testAuth(), Id: 1, Description: "Tunnel node"})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	var body map[string]interface{}
+	linode.body("PUT /linode/stackscripts/:id", &body)
+	if len(body) != 1 || body["description"] != "Tunnel node" {
+		t.Errorf("got update body %v, want description only", body)
+	}
+}
+
+func TestDeleteStackScript(t *testing.T) {
+	for _, id := range []int64{1, 3} {
+		writer := newFakeLinode(t).handle(stackScriptRoutes).call(func(p *protobufLinode) error {
+			return p.DeleteStackScript(&protoapi.LinodeDeleteStackScriptRequest{Auth: testAuth(), Id: id})
+		})
+		// Only StackScript #1 exists.
+		if (writer.err == nil) != (id == 1) {
+			t.Errorf("StackScript %d: got error %v", id, writer.err)
+		}
+	}
+}
+
+func TestGetTunnelNetworkDetails(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(fakeRoutes{
+		"GET /linode/instances/:id/ips": respondFixture(linodeInstanceIPsFixture),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetTunnelNetworkDetails(&protoapi.LinodeGetTunnelNetworkDetailsRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	result := writer.response.R.(*protoapi.Response_LinodeGetTunnelNetworkDetailsResult).LinodeGetTunnelNetworkDetailsResult
+	details := result.Result.(*protoapi.LinodeGetTunnelNetworkDetailsResponse_Details).Details
+	if len(details.PublicIpv4) != 1 || details.PublicIpv4[0] != "192.0.2.10" || details.Gateway != "192.0.2.1" {
+		t.Errorf("got public IPv4 %v via %s", details.PublicIpv4, details.Gateway)
+	}
+	if len(details.PrivateIpv4) != 1 || details.PrivateIpv4[0] != "192.168.128.5" {
+		t.Errorf("got private IPv4 %v", details.PrivateIpv4)
+	}
+	if len(details.Ipv6) != 3 || details.Ipv6Gateway != "fe80::1" {
+		t.Errorf("got IPv6 %v via %s", details.Ipv6, details.Ipv6Gateway)
+	}
+	// Linode doesn't report MTU.
+	if details.Mtu != 0 {
+		t.Errorf("got MTU %d", details.Mtu)
+	}
+}
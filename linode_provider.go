@@ -0,0 +1,611 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"protoapi"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxFirewallLabelLength is the longest firewall label Linode accepts.
+const maxFirewallLabelLength = 32
+
+// linodeTunnelProvider implements TunnelProvider on top of Linode API. It
+// shares instance lookup and await helpers with the request handler.
+type linodeTunnelProvider struct {
+	linode *protobufLinode
+}
+
+func newLinodeTunnelProvider(p *protobufLinode) TunnelProvider {
+	return &linodeTunnelProvider{linode: p}
+}
+
+func (t *linodeTunnelProvider) CreateTunnel(req *CreateTunnelRequest) (*ProvisionedTunnel, error) {
+	p := t.linode
+	api := t.newLinodeAPI(req.AccessToken)
+
+	label, err := p.tunnelLabel(req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	label, tags, err := t.approveTunnel(req.Spec.Tags, &preProvisionRequest{
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		Label:     label,
+		Region:    req.Region,
+		Plan:      req.Plan,
+		Image:     p.instanceImage,
+		Timezone:  req.Spec.Timezone,
+		DryRun:    req.DryRun,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.ensureTunnelDoesNotExist(api, label); err != nil {
+		return nil, err
+	}
+
+	sshKeys, err := p.resolveSSHKeys(api, req.Spec.SSHKeys, req.Spec.SSHKeyLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Firewall rules are checked before the instance is paid for.
+	var firewallRules *LinodeFirewallRules
+	if req.Spec.Firewall != nil {
+		if firewallRules, err = tunnelFirewallRules(&req.Spec); err != nil {
+			return nil, err
+		}
+	}
+
+	// Configure builder.
+	tunnelBuilder := api.NewInstanceBuilder(req.Region, req.Plan)
+	tunnelBuilder.SetLabel(label)
+	tunnelBuilder.SetTags(tags)
+	tunnelBuilder.SetAuthorizedKeys(sshKeys)
+	tunnelBuilder.SetImage(p.instanceImage)
+	tunnelBuilder.SetBooted(true)
+	tunnelBuilder.SetBackupsEnabled(false)
+	tunnelBuilder.SetRootPass(req.Spec.RootPassword)
+
+	script, params, err := p.makeStackScriptParams(api, p.instanceScript, &req.Spec)
+	if err != nil {
+		return nil, err
+	}
+	tunnelBuilder.SetStackscript(script.ID, params)
+
+	if req.DryRun {
+		return &ProvisionedTunnel{
+			Tunnel: &Tunnel{
+				Label:  label,
+				Region: req.Region,
+				Plan:   req.Plan,
+				Image:  p.instanceImage,
+				Tags:   tags,
+			},
+			DryRun: true,
+		}, nil
+	}
+
+	// Create instance.
+	start := time.Now()
+	instance, err := tunnelBuilder.Create()
+	if err != nil {
+		p.logError(err, "Couldn't create Linode instance")
+		return nil, linodeAccountError(err)
+	}
+
+	p.logInstance(instance, "Job to create instance was started successfully")
+
+	// Only instances accepted by Linode count towards reliability, rejected
+	// creates are mostly invalid requests. Region and plan come from Linode,
+	// so clients can't make up metric labels.
+	region, plan := instance.Region, instance.Type
+	timeout, attempts := p.awaitLimitsForPlan(api, req.Plan)
+	instance, slow, err := p.awaitUntilStatusWithin(api, instance.ID, LinodeStatusRunning, timeout, attempts)
+	p.config.reliability.Record(region, plan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	t.resolveIPv6(api, instance)
+	result := newProvisionedTunnel(instance, slow, start)
+	p.logInstance(instance, "Instance was successfully created", log.Fields{"duration": result.Duration})
+
+	// Instance is usable without the firewall, failure to set it up is
+	// reported as a warning rather than failing the whole create.
+	if firewallRules != nil {
+		if err := t.attachFirewall(api, instance, firewallRules); err != nil {
+			p.logError(err, "Couldn't set up tunnel firewall")
+			result.Warnings = append(result.Warnings, TunnelWarning{
+				Code:    "FIREWALL_NOT_ATTACHED",
+				Message: err.Error(),
+			})
+		}
+	}
+	return result, nil
+}
+
+// approveTunnel applies tag policy to tags of a new instance and asks the
+// pre-provision hook to approve it. Label and tags of the instance are
+// returned, as possibly overridden by the hook.
+func (t *linodeTunnelProvider) approveTunnel(tags []string, req *preProvisionRequest) (string, []string, error) {
+	p := t.linode
+
+	// Tag policy is checked before the hook, so that it doesn't hear about
+	// tunnels which are rejected anyway.
+	tags, err := p.config.tagPolicy.Apply(tags)
+	if err != nil {
+		return "", nil, err
+	}
+
+	override, err := p.config.hooks.PreProvision(p.ctx, req)
+	if err != nil {
+		p.logError(err, "Pre-provision hook didn't approve the tunnel")
+		return "", nil, err
+	}
+	label := req.Label
+	if len(override.Name) > 0 {
+		if label, err = p.tunnelLabel(req.Namespace, override.Name); err != nil {
+			return "", nil, errors.Wrapf(err, "Pre-provision hook returned invalid name")
+		}
+	}
+	if len(override.Tags) > 0 {
+		if tags, err = p.config.tagPolicy.Apply(override.Tags); err != nil {
+			return "", nil, err
+		}
+	}
+	return label, tags, nil
+}
+
+func (t *linodeTunnelProvider) CloneTunnel(req *CloneTunnelRequest) (*ProvisionedTunnel, error) {
+	p := t.linode
+	api := t.newLinodeAPI(req.AccessToken)
+
+	label, err := p.tunnelLabel(req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	targetName := req.TargetName
+	if len(targetName) == 0 {
+		name := req.Name
+		if len(name) == 0 {
+			name = p.instanceName
+		}
+		targetName = name + "-" + req.Region
+	}
+	targetLabel, err := p.tunnelLabel(req.Namespace, targetName)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := p.ensureTunnelMutable(api, label)
+	if err != nil {
+		return nil, err
+	}
+	plan := req.Plan
+	if len(plan) == 0 {
+		plan = source.Type
+	}
+
+	// Clone is a new billable instance, so it goes through the same policy
+	// and approval as a created one.
+	targetLabel, tags, err := t.approveTunnel(source.Tags, &preProvisionRequest{
+		Namespace: req.Namespace,
+		Name:      targetName,
+		Label:     targetLabel,
+		Region:    req.Region,
+		Plan:      plan,
+		Image:     source.Image,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := p.ensureTunnelDoesNotExist(api, targetLabel); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	instance, err := api.CloneInstance(source.ID, req.Region, plan, targetLabel)
+	if err != nil {
+		p.logError(err, "Couldn't clone instance")
+		return nil, linodeAccountError(err)
+	}
+	p.logInstance(instance, "Job to clone instance was started successfully", log.Fields{"source": source.ID})
+
+	instance, slow, err := t.bootClone(api, instance, tags)
+	p.config.reliability.Record(instance.Region, instance.Type, err)
+	if err != nil {
+		return nil, err
+	}
+
+	t.resolveIPv6(api, instance)
+	result := newProvisionedTunnel(instance, slow, start)
+	p.logInstance(instance, "Instance was successfully cloned", log.Fields{"duration": result.Duration})
+	return result, nil
+}
+
+// bootClone waits until disks are copied to the clone, tags and boots it.
+// Instance is returned as last seen even on failure.
+func (t *linodeTunnelProvider) bootClone(
+	api *LinodeAPI,
+	instance *LinodeInfo,
+	tags []string,
+) (*LinodeInfo, bool, error) {
+	p := t.linode
+
+	// Disks are copied across regions, which takes about as long as
+	// migration after resize.
+	clone, _, err := p.awaitUntilStatusWithin(
+		api, instance.ID, LinodeStatusOffline, p.config.resizeAwaitTimeout, 0,
+	)
+	if err != nil {
+		return instance, false, err
+	}
+	// Tags aren't copied by clone.
+	if len(tags) > 0 {
+		if err := api.SetInstanceTags(clone.ID, tags); err != nil {
+			return clone, false, err
+		}
+	}
+	if err := api.BootInstance(clone.ID); err != nil {
+		p.logError(err, "Couldn't boot instance")
+		return clone, false, err
+	}
+	running, slow, err := p.awaitUntilRunning(api, clone.ID)
+	if err != nil {
+		return clone, false, err
+	}
+	return running, slow, nil
+}
+
+func (t *linodeTunnelProvider) RebuildTunnel(req *RebuildTunnelRequest) (*ProvisionedTunnel, error) {
+	p := t.linode
+	api := t.newLinodeAPI(req.AccessToken)
+
+	label, err := p.tunnelLabel(req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnels, err := p.ensureTunnelInstances(api, label)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.refuseDuplicateInstances(tunnels); err != nil {
+		return nil, err
+	}
+	tunnel := tunnels[0]
+
+	// Instances created before the policy was introduced get default tags
+	// on rebuild, ones still lacking required tags aren't rebuilt.
+	tags, err := p.config.tagPolicy.Apply(tunnel.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) != len(tunnel.Tags) && !req.DryRun {
+		if err := api.SetInstanceTags(tunnel.ID, tags); err != nil {
+			return nil, err
+		}
+	}
+
+	configID := 0
+	if len(req.ConfigLabel) > 0 {
+		if configID, err = p.resolveConfigLabel(api, tunnel.ID, req.ConfigLabel); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rebuild can only boot the default config, instance is booted separately
+	// when a specific one is requested.
+	tunnelRebuilder := api.NewInstanceRebuilder(tunnel.ID)
+	tunnelRebuilder.SetAuthorizedKeys(req.Spec.SSHKeys)
+	tunnelRebuilder.SetBooted(configID == 0)
+	tunnelRebuilder.SetImage(p.instanceImage)
+	tunnelRebuilder.SetRootPass(req.Spec.RootPassword)
+
+	script, params, err := p.makeStackScriptParams(api, p.instanceScript, &req.Spec)
+	if err != nil {
+		return nil, err
+	}
+	tunnelRebuilder.SetStackscript(script.ID, params)
+
+	if req.DryRun {
+		result := &ProvisionedTunnel{Tunnel: linodeInstanceToTunnel(tunnel), DryRun: true}
+		result.Tunnel.Image = p.instanceImage
+		if len(tunnels) > 1 {
+			result.Warnings = append(result.Warnings, duplicateInstancesWarning(tunnels))
+		}
+		return result, nil
+	}
+
+	start := time.Now()
+	instance, err := tunnelRebuilder.Rebuild()
+	if err != nil {
+		p.logError(err, "Couldn't rebuild Linode instance")
+		return nil, linodeAccountError(err)
+	}
+
+	p.logInstance(instance, "Job to rebuild instance was started successfully")
+
+	if configID != 0 {
+		if _, _, err := p.awaitUntilStatus(api, instance.ID, LinodeStatusOffline); err != nil {
+			return nil, err
+		}
+		if err := api.BootInstanceWithConfig(instance.ID, configID); err != nil {
+			p.logError(err, "Couldn't boot instance")
+			return nil, err
+		}
+	}
+
+	instance, slow, err := p.awaitUntilRunning(api, instance.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	t.resolveIPv6(api, instance)
+	result := newProvisionedTunnel(instance, slow, start)
+	if len(tunnels) > 1 {
+		result.Warnings = append(result.Warnings, duplicateInstancesWarning(tunnels))
+	}
+	p.logInstance(instance, "Instance was successfully rebuilt", log.Fields{"duration": result.Duration})
+	return result, nil
+}
+
+func (t *linodeTunnelProvider) DestroyTunnel(req *DestroyTunnelRequest) (*Tunnel, error) {
+	p := t.linode
+	api := t.newLinodeAPI(req.AccessToken)
+
+	label, err := p.tunnelLabel(req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnels, err := p.ensureTunnelInstances(api, label)
+	if err != nil {
+		return nil, err
+	}
+	var tunnel *LinodeInfo
+	if req.InstanceID != 0 {
+		// Only instances carrying the tunnel label may be picked.
+		for _, instance := range tunnels {
+			if instance.ID == req.InstanceID {
+				tunnel = instance
+			}
+		}
+		if tunnel == nil {
+			return nil, errors.Errorf("Instance %d does not carry the tunnel label", req.InstanceID)
+		}
+	} else {
+		if err := p.refuseDuplicateInstances(tunnels); err != nil {
+			return nil, err
+		}
+		tunnel = tunnels[0]
+	}
+
+	// Firewalls have to be looked up while they are still attached. Failing
+	// that, the firewall is left behind rather than the instance.
+	firewalls, err := api.ListInstanceFirewalls(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't list instance firewalls, tunnel firewall won't be deleted")
+		firewalls = nil
+	}
+
+	err = api.DeleteInstance(tunnel.ID)
+	if err != nil {
+		p.logError(err, "Couldn't delete instance")
+		return nil, err
+	}
+	p.logInstance(tunnel, "Instance was successfully deleted")
+
+	// Only the firewall created along with the tunnel is deleted, firewalls
+	// shared with other instances are left alone.
+	for _, firewall := range firewalls {
+		if firewall.Label != tunnelFirewallLabel(tunnel.Label) {
+			continue
+		}
+		if err := api.DeleteFirewall(firewall.ID); err != nil {
+			p.logError(err, "Couldn't delete tunnel firewall")
+		}
+	}
+	p.config.hooks.PostDestroy(tunnel)
+	return linodeInstanceToTunnel(tunnel), nil
+}
+
+func (t *linodeTunnelProvider) TunnelStatus(ref *TunnelRef) (*TunnelStatusResult, error) {
+	p := t.linode
+	api := t.newLinodeAPI(ref.AccessToken)
+
+	label, err := p.tunnelLabel(ref.Namespace, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnels, err := p.retrieveTunnelInstances(api, label)
+	if err != nil {
+		return nil, err
+	}
+	if len(tunnels) == 0 {
+		return nil, errors.New("Tunnel does not exist")
+	}
+
+	// Duplicates are reported back so that the client could let user decide
+	// which instance to keep.
+	t.resolveIPv6(api, tunnels[0])
+	result := &TunnelStatusResult{Tunnel: linodeInstanceToTunnel(tunnels[0])}
+	if len(tunnels) > 1 {
+		p.logDuplicateInstances(tunnels)
+		for _, tunnel := range tunnels {
+			result.Conflicts = append(result.Conflicts, linodeInstanceToTunnel(tunnel))
+		}
+		result.Warnings = append(result.Warnings, duplicateInstancesWarning(tunnels))
+	}
+	return result, nil
+}
+
+func (t *linodeTunnelProvider) ListInstances(req *ListInstancesRequest) (*TunnelPage, error) {
+	p := t.linode
+
+	if len(req.Namespace) > 0 && !namespaceRe.MatchString(req.Namespace) {
+		return nil, errors.Errorf("Invalid namespace: %s", req.Namespace)
+	}
+
+	api := t.newLinodeAPI(req.AccessToken)
+	page := &TunnelPage{}
+	var instances []LinodeInfo
+	var err error
+	if req.Page > 0 {
+		var pageCount int
+		instances, pageCount, err = api.ListLinodeInstancesPage(req.Page)
+		if req.Page < pageCount {
+			page.NextPage = req.Page + 1
+		}
+	} else {
+		instances, err = api.ListLinodeInstances()
+	}
+	if err != nil && req.AllowPartial && req.Page == 0 && len(instances) > 0 {
+		p.logError(err, "Couldn't list all Linode instances, returning partial list")
+		page.Truncated = true
+		page.Warnings = append(page.Warnings, TunnelWarning{
+			Code:    "LIST_TRUNCATED",
+			Message: err.Error(),
+		})
+	} else if err != nil {
+		p.logError(err, "Couldn't list Linode instances")
+		return nil, err
+	}
+
+	// Tenants only get to see instances from their own namespace.
+	namespacePrefix := p.labelPrefix + "_" + req.Namespace + "_"
+	page.Tunnels = make([]*Tunnel, 0, len(instances))
+	for i := range instances {
+		if req.ManagedOnly {
+			namespace, ok := p.parseTunnelLabel(instances[i].Label)
+			if !ok || (len(req.Namespace) > 0 && namespace != req.Namespace) {
+				continue
+			}
+		} else if len(req.Namespace) > 0 && !strings.HasPrefix(instances[i].Label, namespacePrefix) {
+			continue
+		}
+		page.Tunnels = append(page.Tunnels, linodeInstanceToTunnel(&instances[i]))
+	}
+	return page, nil
+}
+
+// resolveIPv6 replaces the SLAAC address reported in instance listing with
+// all IPv6 addresses of the instance. Instance is left as is on failure.
+func (t *linodeTunnelProvider) resolveIPv6(api *LinodeAPI, instance *LinodeInfo) {
+	ips, err := api.GetInstanceIPs(instance.ID)
+	if err != nil {
+		t.linode.logError(err, "Couldn't retrieve instance IPs")
+		return
+	}
+	if addresses := ips.IPv6Addresses(); len(addresses) > 0 {
+		instance.IPv6 = addresses
+	}
+}
+
+// attachFirewall creates firewall named after the tunnel (see
+// tunnelFirewallLabel) and puts the instance behind it.
+func (t *linodeTunnelProvider) attachFirewall(
+	api *LinodeAPI,
+	instance *LinodeInfo,
+	rules *LinodeFirewallRules,
+) error {
+	firewall, err := api.CreateFirewall(tunnelFirewallLabel(instance.Label), rules)
+	if err != nil {
+		return err
+	}
+	if err := api.AttachFirewallToInstance(firewall.ID, instance.ID); err != nil {
+		if err := api.DeleteFirewall(firewall.ID); err != nil {
+			t.linode.logError(err, "Couldn't delete unattached firewall")
+		}
+		return err
+	}
+	t.linode.logInstance(instance, "Firewall was attached to instance", log.Fields{
+		"firewall": firewall.ID,
+	})
+	return nil
+}
+
+// tunnelFirewallLabel returns label of the firewall created along with the
+// tunnel. Tunnel labels may be longer than firewall labels can be, such labels
+// are truncated and suffixed with a hash of the whole label to stay unique.
+func tunnelFirewallLabel(tunnelLabel string) string {
+	if len(tunnelLabel) <= maxFirewallLabelLength {
+		return tunnelLabel
+	}
+	digest := sha256.Sum256([]byte(tunnelLabel))
+	suffix := hex.EncodeToString(digest[:4])
+	// Label must not end with a separator, nor have two of them in a row.
+	prefix := strings.TrimRight(tunnelLabel[:maxFirewallLabelLength-len(suffix)-1], "_-.")
+	return prefix + "-" + suffix
+}
+
+func (t *linodeTunnelProvider) newLinodeAPI(accessToken string) *LinodeAPI {
+	return t.linode.config.clients.Get(accessToken).WithContext(t.linode.ctx)
+}
+
+// newProvisionedTunnel makes result of create or rebuild started at the
+// given time. Zero start falls back to the creation time of the instance.
+func newProvisionedTunnel(instance *LinodeInfo, slow bool, start time.Time) *ProvisionedTunnel {
+	if start.IsZero() {
+		start, _ = time.Parse(linodeDateLayout, instance.CreatedAt)
+	}
+	result := &ProvisionedTunnel{
+		Tunnel:   linodeInstanceToTunnel(instance),
+		Slow:     slow,
+		Duration: time.Since(start),
+	}
+	if slow {
+		result.Warnings = append(result.Warnings, TunnelWarning{
+			Code:    "SLOW_PROVISIONING",
+			Message: "Instance took longer than usual to start",
+		})
+	}
+	return result
+}
+
+// linodeAccountError replaces errors caused by account which isn't ready to
+// create instances with ACCOUNT_NOT_READY, so that clients could send the
+// user to their Linode account page.
+func linodeAccountError(err error) error {
+	if linodeErr, ok := err.(*LinodeError); ok {
+		if reason, ok := linodeErr.AccountNotReadyReason(); ok {
+			return newHolepuncherError(
+				protoapi.HolepuncherError_ACCOUNT_NOT_READY,
+				"Linode account is not ready: %s", reason,
+			)
+		}
+	}
+	return err
+}
+
+func linodeInstanceToTunnel(instance *LinodeInfo) *Tunnel {
+	return &Tunnel{
+		ID:         instance.ID,
+		Label:      instance.Label,
+		Group:      instance.Group,
+		Region:     instance.Region,
+		Plan:       instance.Type,
+		Image:      instance.Image,
+		IPv4:       instance.IPv4,
+		IPv6:       instance.IPv6,
+		Status:     string(instance.Status),
+		CreatedAt:  instance.CreatedAt,
+		UpdatedAt:  instance.Updated,
+		Hypervisor: instance.Hypervisor,
+		Disk:       instance.Specs.Disk,
+		Memory:     instance.Specs.Memory,
+		VCPUs:      instance.Specs.VCPUs,
+		Transfer:   instance.Specs.Transfer,
+		Tags:       instance.Tags,
+	}
+}
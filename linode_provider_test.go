@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"protoapi"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errorCode returns code of the HolepuncherError causing err.
+func errorCode(t *testing.T, err error) protoapi.HolepuncherError_Code {
+	hpErr, ok := errors.Cause(err).(*HolepuncherError)
+	if !ok {
+		t.Fatalf("got error %v, want HolepuncherError", err)
+	}
+	return hpErr.Code
+}
+
+func TestCreateTunnelAccountNotReady(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"POST /linode/instances": respondError(http.StatusBadRequest,
+			"Please accept the Terms of Service before creating Linodes"),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+			Auth:   testAuth(),
+			Region: "us-east",
+			Plan:   "g6-nanode-1",
+		})
+	})
+	if code := errorCode(t, writer.err); code != protoapi.HolepuncherError_ACCOUNT_NOT_READY {
+		t.Errorf("got code %v, want ACCOUNT_NOT_READY", code)
+	}
+}
+
+func TestLinodeAccountErrorKeepsOtherErrors(t *testing.T) {
+	linodeErr := &LinodeError{}
+	if err := json.Unmarshal([]byte(`{"errors":[{"field":"region","reason":"region is not valid"}]}`), linodeErr); err != nil {
+		t.Fatal(err)
+	}
+	if err := linodeAccountError(linodeErr); err != linodeErr {
+		t.Errorf("got error %v, want the Linode error", err)
+	}
+}
+
+func TestCreateTunnelReportsSlowProvisioning(t *testing.T) {
+	for _, test := range []struct {
+		statuses  []LinodeStatus
+		warnAfter time.Duration
+		slow      bool
+	}{
+		{[]LinodeStatus{LinodeStatusProvisioning, LinodeStatusBooting, LinodeStatusRunning}, 0, true},
+		{[]LinodeStatus{LinodeStatusRunning}, time.Minute, false},
+	} {
+		linode := newFakeLinode(t)
+		linode.createStatus = LinodeStatusProvisioning
+		linode.queueStatuses(1001, test.statuses...)
+		writer := linode.call(func(p *protobufLinode) error {
+			p.config.awaitWarnAfter = test.warnAfter
+			return p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+				Auth:   testAuth(),
+				Region: "us-east",
+				Plan:   "g6-nanode-1",
+			})
+		})
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+
+		result := writer.response.R.(*protoapi.Response_LinodeCreateTunnelResult).LinodeCreateTunnelResult
+		if result.SlowProvisioning != test.slow {
+			t.Errorf("%v: got slow_provisioning %v", test.statuses, result.SlowProvisioning)
+		}
+		if test.slow && (len(result.Warnings) != 1 || result.Warnings[0].Code != protoapi.Warning_SLOW_PROVISIONING) {
+			t.Errorf("%v: got warnings %v, want SLOW_PROVISIONING", test.statuses, result.Warnings)
+		}
+		if !test.slow && len(result.Warnings) != 0 {
+			t.Errorf("%v: got warnings %v", test.statuses, result.Warnings)
+		}
+	}
+}
+
+// rebuildRoutes start rebuilding instance 1.
+var rebuildRoutes = fakeRoutes{
+	"POST /linode/instances/:id/rebuild": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		f.setStatus(1, LinodeStatusRebuilding)
+		writeJSON(f.t, w, http.StatusOK, f.instance(1))
+	},
+}
+
+func TestRebuildTunnelReportsSlowProvisioning(t *testing.T) {
+	for _, test := range []struct {
+		statuses  []LinodeStatus
+		warnAfter time.Duration
+		slow      bool
+	}{
+		{[]LinodeStatus{LinodeStatusRebuilding, LinodeStatusBooting, LinodeStatusRunning}, 0, true},
+		{[]LinodeStatus{LinodeStatusRunning}, time.Minute, false},
+	} {
+		linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(rebuildRoutes)
+		linode.queueStatuses(1, test.statuses...)
+		writer := linode.call(func(p *protobufLinode) error {
+			p.config.awaitWarnAfter = test.warnAfter
+			return p.RebuildTunnel(&protoapi.LinodeRebuildTunnelRequest{Auth: testAuth()})
+		})
+		if writer.err != nil {
+			t.Fatal(writer.err)
+		}
+
+		result := writer.response.R.(*protoapi.Response_LinodeRebuildTunnelResult).LinodeRebuildTunnelResult
+		if result.SlowProvisioning != test.slow {
+			t.Errorf("%v: got slow_provisioning %v", test.statuses, result.SlowProvisioning)
+		}
+		if test.slow && (len(result.Warnings) != 1 || result.Warnings[0].Code != protoapi.Warning_SLOW_PROVISIONING) {
+			t.Errorf("%v: got warnings %v, want SLOW_PROVISIONING", test.statuses, result.Warnings)
+		}
+		if !test.slow && len(result.Warnings) != 0 {
+			t.Errorf("%v: got warnings %v", test.statuses, result.Warnings)
+		}
+	}
+}
+
+func TestCreateTunnelDryRun(t *testing.T) {
+	linode := newFakeLinode(t)
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+		Auth:   testAuth(),
+		Region: "us-east",
+		Plan:   "g6-nanode-1",
+		DryRun: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeCreateTunnelResult).LinodeCreateTunnelResult
+	instance := result.Result.(*protoapi.LinodeCreateTunnelResponse_Instance).Instance
+	if !result.DryRun || instance.Label != "hp_instance" || instance.Region != "us-east" || instance.Plan != "g6-nanode-1" {
+		t.Errorf("got dry run %v of instance %+v", result.DryRun, instance)
+	}
+	if !linode.requested("GET /linode/stackscripts") {
+		t.Error("StackScript wasn't checked")
+	}
+	if linode.requested("POST /linode/instances") {
+		t.Error("instance was created by dry run")
+	}
+}
+
+func TestCreateTunnelDryRunValidates(t *testing.T) {
+	linode := newFakeLinode(t)
+	linode.scripts = nil
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+		Auth:   testAuth(),
+		Region: "us-east",
+		Plan:   "g6-nanode-1",
+		DryRun: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err == nil {
+		t.Error("dry run succeeded without the StackScript")
+	}
+}
+
+func TestRebuildTunnelDryRun(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning}).handle(rebuildRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.RebuildTunnel(&protoapi.LinodeRebuildTunnelRequest{Auth: testAuth(), DryRun: true})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeRebuildTunnelResult).LinodeRebuildTunnelResult
+	if !result.DryRun {
+		t.Error("dry_run isn't set")
+	}
+	if linode.requested("POST /linode/instances/:id/rebuild") {
+		t.Error("instance was rebuilt by dry run")
+	}
+}
+
+// profileKeysRoutes serve SSH keys of the profile.
+var profileKeysRoutes = fakeRoutes{
+	"GET /profile/sshkeys": respond(http.StatusOK, &linodeSSHKeyPaginated{Pages: 1, Page: 1, Data: []LinodeSSHKey{
+		{ID: 5, Label: "laptop", SSHKey: "ssh-ed25519 AAAA laptop"},
+		{ID: 6, Label: "desktop", SSHKey: "ssh-ed25519 BBBB desktop"},
+	}}),
+}
+
+func TestCreateTunnelWithProfileSSHKeys(t *testing.T) {
+	linode := newFakeLinode(t).handle(profileKeysRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+			Auth:         testAuth(),
+			Region:       "us-east",
+			Plan:         "g6-nanode-1",
+			SshKeys:      []string{"ssh-ed25519 CCCC verbatim", "ssh-ed25519 AAAA laptop"},
+			SshKeyLabels: []string{"laptop", "desktop"},
+		})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	var spec LinodeInstanceBuilder
+	linode.body("POST /linode/instances", &spec)
+	want := []string{"ssh-ed25519 CCCC verbatim", "ssh-ed25519 AAAA laptop", "ssh-ed25519 BBBB desktop"}
+	if len(spec.AuthorizedKeys) != len(want) {
+		t.Fatalf("got keys %v, want %v", spec.AuthorizedKeys, want)
+	}
+	for n := range want {
+		if spec.AuthorizedKeys[n] != want[n] {
+			t.Errorf("got keys %v, want %v", spec.AuthorizedKeys, want)
+		}
+	}
+}
+
+func TestCreateTunnelWithUnknownSSHKeyLabel(t *testing.T) {
+	linode := newFakeLinode(t).handle(profileKeysRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+			Auth:         testAuth(),
+			Region:       "us-east",
+			Plan:         "g6-nanode-1",
+			SshKeyLabels: []string{"phone"},
+		})
+	})
+	if writer.err == nil {
+		t.Error("unknown key label was accepted")
+	}
+	if linode.requested("POST /linode/instances") {
+		t.Error("instance was created")
+	}
+}
+
+func TestCreateNamedTunnelAlongsideDefault(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusRunning})
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+		Auth:       testAuth(),
+		TunnelName: "vpn",
+		Region:     "us-east",
+		Plan:       "g6-nanode-1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	created := linode.instance(1001)
+	if created == nil || created.Label != "hp_vpn" {
+		t.Fatalf("got instance %+v, want label hp_vpn", created)
+	}
+
+	// Existing default tunnel is still there and a second create of the named
+	// one is refused.
+	if linode.instance(1) == nil {
+		t.Error("default tunnel is gone")
+	}
+	writer.err = nil
+	if err := p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+		Auth:       testAuth(),
+		TunnelName: "vpn",
+		Region:     "us-east",
+		Plan:       "g6-nanode-1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err == nil || linode.instance(1002) != nil {
+		t.Error("duplicate named tunnel was created")
+	}
+}
+
+func TestCreateTunnelWithTags(t *testing.T) {
+	linode := newFakeLinode(t)
+	p, writer := newTestProtobufLinode(linode)
+
+	if err := p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+		Auth:   testAuth(),
+		Region: "us-east",
+		Plan:   "g6-nanode-1",
+		Tags:   []string{"holepuncher", "team:vpn"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	linode.body("POST /linode/instances", &body)
+	if len(body.Tags) != 2 || body.Tags[0] != "holepuncher" || body.Tags[1] != "team:vpn" {
+		t.Errorf("got tags %v in create request", body.Tags)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeCreateTunnelResult).LinodeCreateTunnelResult
+	if instance := result.Result.(*protoapi.LinodeCreateTunnelResponse_Instance).Instance; len(instance.Tags) != 2 {
+		t.Errorf("got tags %v in response", instance.Tags)
+	}
+}
+
+// clonedInstance is a tagged tunnel instance.
+var clonedInstance = LinodeInfo{
+	ID:     1,
+	Label:  "hp_instance",
+	Region: "us-east",
+	Type:   "g6-nanode-1",
+	Status: LinodeStatusRunning,
+	Tags:   []string{"team:vpn"},
+}
+
+// cloneRoutes clone instances, clones copy disks for a while before they
+// can be booted.
+var cloneRoutes = fakeRoutes{
+	"POST /linode/instances/:id/clone": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Region string `json:"region"`
+			Label  string `json:"label"`
+			Type   string `json:"type"`
+		}
+		f.body("POST /linode/instances/:id/clone", &body)
+		f.mutex.Lock()
+		f.nextID++
+		clone := LinodeInfo{
+			ID:     f.nextID,
+			Label:  body.Label,
+			Region: body.Region,
+			Type:   body.Type,
+			Status: LinodeStatusCloning,
+		}
+		f.instances = append(f.instances, clone)
+		f.mutex.Unlock()
+		f.queueStatuses(clone.ID, LinodeStatusCloning, LinodeStatusOffline)
+		writeJSON(f.t, w, http.StatusOK, &clone)
+	},
+	"PUT /linode/instances/:id": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		writeJSON(f.t, w, http.StatusOK, f.instance(pathID(r, 4)))
+	},
+}
+
+func TestCloneTunnel(t *testing.T) {
+	linode := newFakeLinode(t, clonedInstance).handle(cloneRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CloneTunnel(&protoapi.LinodeCloneTunnelRequest{Auth: testAuth(), Region: "eu-central"})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeCloneTunnelResult).LinodeCloneTunnelResult
+	instance := result.Result.(*protoapi.LinodeCloneTunnelResponse_Instance).Instance
+	if instance.Id != 1001 || instance.Label != "hp_instance-eu-central" || instance.Region != "eu-central" ||
+		instance.Plan != "g6-nanode-1" || instance.Status != protoapi.LinodeInstance_RUNNING {
+		t.Errorf("got clone %+v", instance)
+	}
+
+	// Clone doesn't copy tags, they are set before it is booted.
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	linode.body("PUT /linode/instances/:id", &body)
+	if len(body.Tags) != 1 || body.Tags[0] != "team:vpn" {
+		t.Errorf("got clone tags %v", body.Tags)
+	}
+	if !linode.requested("POST /linode/instances/:id/boot") {
+		t.Error("clone wasn't booted")
+	}
+}
+
+func TestCloneTunnelRefusesExistingTarget(t *testing.T) {
+	linode := newFakeLinode(t,
+		clonedInstance,
+		LinodeInfo{ID: 2, Label: "hp_backup", Status: LinodeStatusRunning},
+	).handle(cloneRoutes)
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.CloneTunnel(&protoapi.LinodeCloneTunnelRequest{
+			Auth:             testAuth(),
+			Region:           "eu-central",
+			TargetTunnelName: "backup",
+		})
+	})
+	if writer.err == nil {
+		t.Error("clone replaced an existing tunnel")
+	}
+	if linode.requested("POST /linode/instances/:id/clone") {
+		t.Error("instance was cloned")
+	}
+}
+
+func TestNewProvisionedTunnelDuration(t *testing.T) {
+	instance := &LinodeInfo{
+		ID:        1,
+		Label:     "hp_instance",
+		Status:    LinodeStatusRunning,
+		CreatedAt: time.Now().Add(-10 * time.Minute).UTC().Format(linodeDateLayout),
+	}
+
+	result := newProvisionedTunnel(instance, false, time.Now().Add(-90*time.Second))
+	if result.Duration < 90*time.Second || result.Duration > 100*time.Second {
+		t.Errorf("got duration %v, want 90s since the start", result.Duration)
+	}
+	// Creation time of the instance is used when start is unknown.
+	result = newProvisionedTunnel(instance, false, time.Time{})
+	if result.Duration < 10*time.Minute || result.Duration > 11*time.Minute {
+		t.Errorf("got duration %v, want 10m since creation", result.Duration)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"protoapi"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAccessToken is the Linode access token used by request handlers under
+// test.
+const testAccessToken = "test-token"
+
+// redirectTransport sends all requests to the test server regardless of the
+// host they were made for. Requests go through the base transport, or the
+// default one when there is none.
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	if t.base != nil {
+		return t.base.RoundTrip(r)
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestLinodeServer starts a test server serving the handler and returns
+// its URL. The server is closed when the test ends.
+func newTestLinodeServer(t *testing.T, handler http.Handler) *url.URL {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+// newTestLinodeAPI returns LinodeAPI talking to a test server serving the
+// handler.
+func newTestLinodeAPI(t *testing.T, handler http.Handler) *LinodeAPI {
+	api := NewLinodeAPI(testAccessToken, false)
+	api.client.SetTransport(&redirectTransport{target: newTestLinodeServer(t, handler)})
+	return api
+}
+
+// writeJSON encodes the value as the response body.
+func writeJSON(t *testing.T, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Error(err)
+	}
+}
+
+// serveInstances serves instances as a single page listing.
+func serveInstances(t *testing.T, instances []LinodeInfo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4/linode/instances" {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(t, w, http.StatusOK, linodeInfoPaginated{
+			Pages:   1,
+			Results: len(instances),
+			Data:    instances,
+			Page:    1,
+		})
+	})
+}
+
+// fakeRoute handles a request made to the fake Linode.
+type fakeRoute func(f *fakeLinode, w http.ResponseWriter, r *http.Request)
+
+// fakeRoutes are routes of the fake Linode keyed by method and endpoint
+// family, e.g. "POST /linode/instances/:id/boot".
+type fakeRoutes map[string]fakeRoute
+
+// defaultFakeRoutes serve instances, StackScripts and instance types needed
+// by most verbs.
+var defaultFakeRoutes = fakeRoutes{
+	"GET /linode/instances":               (*fakeLinode).listInstances,
+	"POST /linode/instances":              (*fakeLinode).createInstance,
+	"GET /linode/instances/:id":           (*fakeLinode).getInstance,
+	"DELETE /linode/instances/:id":        (*fakeLinode).deleteInstance,
+	"GET /linode/instances/:id/ips":       respond(http.StatusOK, &LinodeInstanceIPs{}),
+	"POST /linode/instances/:id/boot":     powerRoute(LinodeStatusRunning),
+	"POST /linode/instances/:id/reboot":   powerRoute(LinodeStatusRunning),
+	"POST /linode/instances/:id/shutdown": powerRoute(LinodeStatusOffline),
+	"GET /linode/stackscripts":            (*fakeLinode).listStackScripts,
+	"GET /linode/types":                   (*fakeLinode).listTypes,
+}
+
+// fakeLinode is an in-memory Linode API serving the default routes. Tests
+// pass it route tables of anything else, or of default routes to replace.
+type fakeLinode struct {
+	t   *testing.T
+	api *LinodeAPI
+	// API without credentials, e.g. for listing plans.
+	anonymousAPI *LinodeAPI
+	mutex        sync.Mutex
+	// Status of instances made by create.
+	createStatus LinodeStatus
+	instances    []LinodeInfo
+	types        []LinodeType
+	scripts      []StackScript
+	nextID       int
+	// Statuses instances go through, one per query of the instance.
+	statuses map[int][]LinodeStatus
+	routes   fakeRoutes
+	// Routes requested so far and bodies they were last requested with.
+	requests []string
+	bodies   map[string][]byte
+}
+
+func newFakeLinode(t *testing.T, instances ...LinodeInfo) *fakeLinode {
+	f := &fakeLinode{
+		t:            t,
+		createStatus: LinodeStatusRunning,
+		// Instances are changed by requests, tests may share the passed ones.
+		instances: append([]LinodeInfo(nil), instances...),
+		scripts:   []StackScript{{ID: 1, Label: "freedom_node"}},
+		nextID:    1000,
+		statuses:  make(map[int][]LinodeStatus),
+		routes:    make(fakeRoutes),
+		bodies:    make(map[string][]byte),
+	}
+	f.handle(defaultFakeRoutes)
+	target := newTestLinodeServer(t, f)
+	f.api = NewLinodeAPI(testAccessToken, false)
+	f.api.client.SetTransport(&redirectTransport{target: target})
+	f.anonymousAPI = NewLinodeAPIUnauthenticated(false)
+	f.anonymousAPI.client.SetTransport(&redirectTransport{target: target})
+	return f
+}
+
+// handle adds routes of the tables, later tables replace routes of earlier
+// ones.
+func (f *fakeLinode) handle(tables ...fakeRoutes) *fakeLinode {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, routes := range tables {
+		for route, handler := range routes {
+			f.routes[route] = handler
+		}
+	}
+	return f
+}
+
+// call runs the verb with request handler working with the fake, its
+// response is kept by the returned writer.
+func (f *fakeLinode) call(verb func(p *protobufLinode) error) *protobufCaptureWriter {
+	p, writer := newTestProtobufLinode(f)
+	if err := verb(p); err != nil {
+		f.t.Fatal(err)
+	}
+	return writer
+}
+
+func (f *fakeLinode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + linodeEndpointFamily(strings.TrimPrefix(r.URL.Path, "/v4"))
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mutex.Lock()
+	f.requests = append(f.requests, route)
+	f.bodies[route] = body
+	handler, ok := f.routes[route]
+	f.mutex.Unlock()
+
+	if !ok {
+		f.t.Logf("Fake Linode has no route %s", route)
+		writeLinodeError(f.t, w, http.StatusNotFound, "Not found")
+		return
+	}
+	handler(f, w, r)
+}
+
+// requested tells whether the route was requested.
+func (f *fakeLinode) requested(route string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, r := range f.requests {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// body decodes JSON body the route was last requested with.
+func (f *fakeLinode) body(route string, v interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := json.Unmarshal(f.bodies[route], v); err != nil {
+		f.t.Fatalf("Unable to decode body of %s: %v", route, err)
+	}
+}
+
+// instance returns a copy of the instance, nil if it doesn't exist.
+func (f *fakeLinode) instance(id int) *LinodeInfo {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, instance := range f.instances {
+		if instance.ID == id {
+			return &instance
+		}
+	}
+	return nil
+}
+
+func (f *fakeLinode) setStatus(id int, status LinodeStatus) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := range f.instances {
+		if f.instances[i].ID == id {
+			f.instances[i].Status = status
+		}
+	}
+}
+
+// queueStatuses makes the instance go through the statuses, switching to
+// the next one whenever the instance is queried. The last status is kept.
+func (f *fakeLinode) queueStatuses(id int, statuses ...LinodeStatus) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.statuses[id] = append(f.statuses[id], statuses...)
+}
+
+// advanceStatus switches the instance to the next queued status.
+func (f *fakeLinode) advanceStatus(id int) {
+	f.mutex.Lock()
+	statuses := f.statuses[id]
+	if len(statuses) > 0 {
+		f.statuses[id] = statuses[1:]
+	}
+	f.mutex.Unlock()
+
+	if len(statuses) > 0 {
+		f.setStatus(id, statuses[0])
+	}
+}
+
+func (f *fakeLinode) listInstances(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	writeJSON(f.t, w, http.StatusOK, &linodeInfoPaginated{Pages: 1, Page: 1, Data: f.instances})
+}
+
+func (f *fakeLinode) listStackScripts(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	writeJSON(f.t, w, http.StatusOK, &stackScriptPaginated{Pages: 1, Page: 1, Data: f.scripts})
+}
+
+func (f *fakeLinode) listTypes(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	writeJSON(f.t, w, http.StatusOK, &linodeTypePaginated{Pages: 1, Page: 1, Data: f.types})
+}
+
+func (f *fakeLinode) createInstance(w http.ResponseWriter, r *http.Request) {
+	var spec LinodeInstanceBuilder
+	f.body("POST /linode/instances", &spec)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.nextID++
+	instance := LinodeInfo{
+		ID:        f.nextID,
+		Label:     spec.Label,
+		Region:    spec.Region,
+		Type:      spec.Type,
+		Image:     spec.Image,
+		Tags:      spec.Tags,
+		Status:    f.createStatus,
+		CreatedAt: time.Now().UTC().Format(linodeDateLayout),
+	}
+	f.instances = append(f.instances, instance)
+	writeJSON(f.t, w, http.StatusOK, &instance)
+}
+
+func (f *fakeLinode) getInstance(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r, 4)
+	f.advanceStatus(id)
+	if instance := f.instance(id); instance != nil {
+		writeJSON(f.t, w, http.StatusOK, instance)
+		return
+	}
+	writeLinodeError(f.t, w, http.StatusNotFound, "Not found")
+}
+
+func (f *fakeLinode) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r, 4)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := range f.instances {
+		if f.instances[i].ID == id {
+			f.instances = append(f.instances[:i], f.instances[i+1:]...)
+			writeJSON(f.t, w, http.StatusOK, struct{}{})
+			return
+		}
+	}
+	writeLinodeError(f.t, w, http.StatusNotFound, "Not found")
+}
+
+// powerRoute switches the instance to the status right away.
+func powerRoute(status LinodeStatus) fakeRoute {
+	return func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		id := pathID(r, 4)
+		if f.instance(id) == nil {
+			writeLinodeError(f.t, w, http.StatusNotFound, "Not found")
+			return
+		}
+		f.setStatus(id, status)
+		writeJSON(f.t, w, http.StatusOK, struct{}{})
+	}
+}
+
+// respond is a route responding with the value.
+func respond(status int, v interface{}) fakeRoute {
+	return func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		writeJSON(f.t, w, status, v)
+	}
+}
+
+// respondError is a route failing with error formatted like Linode's.
+func respondError(status int, reason string) fakeRoute {
+	return func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		writeLinodeError(f.t, w, status, reason)
+	}
+}
+
+// respondFixture is a route responding with the JSON fixture as it is.
+func respondFixture(fixture string) fakeRoute {
+	return func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, fixture)
+	}
+}
+
+// pathID returns the n-th element of the request path split at slashes as
+// a number, e.g. 4 is the instance ID in /v4/linode/instances/:id.
+func pathID(r *http.Request, n int) int {
+	parts := strings.Split(r.URL.Path, "/")
+	if n >= len(parts) {
+		return 0
+	}
+	id, _ := strconv.Atoi(parts[n])
+	return id
+}
+
+// writeLinodeError responds with error formatted like Linode's.
+func writeLinodeError(t *testing.T, w http.ResponseWriter, status int, reason string) {
+	writeJSON(t, w, status, map[string]interface{}{
+		"errors": []map[string]string{{"reason": reason}},
+	})
+}
+
+// newTestLinodeConfig returns config of request handlers working with the
+// fake Linode. Awaits poll rapidly and give up after a second.
+func newTestLinodeConfig(linode *fakeLinode) *linodeConfig {
+	config := &linodeConfig{
+		awaitDelay:         time.Millisecond,
+		awaitWarnAfter:     time.Minute,
+		awaitTimeout:       time.Second,
+		resizeAwaitTimeout: time.Second,
+		clients:            newLinodeClientCache(linodeClientTTL, false, nil),
+		reliability:        newReliabilityTracker(time.Hour),
+		cursorKey:          []byte("cursor-key"),
+	}
+	// Unauthenticated requests, e.g. listing plans, go to the fake as well.
+	for token, api := range map[string]*LinodeAPI{testAccessToken: linode.api, "": linode.anonymousAPI} {
+		config.clients.clients[hashToken(token)] = &cachedLinodeClient{
+			api:      api,
+			lastUsed: time.Now(),
+		}
+	}
+	return config
+}
+
+// newTestProtobufLinode returns request handler working with the fake
+// Linode, its responses are kept by the returned writer.
+func newTestProtobufLinode(linode *fakeLinode) (*protobufLinode, *protobufCaptureWriter) {
+	writer := &protobufCaptureWriter{}
+	return newProtobufLinode(context.Background(), writer, newTestLinodeConfig(linode), newLinodeTunnelProvider), writer
+}
+
+// testAuth returns credentials of request handlers under test.
+func testAuth() *protoapi.LinodeAuth {
+	return &protoapi.LinodeAuth{AccessToken: testAccessToken}
+}
+
+func TestLinodeIPv6Unmarshal(t *testing.T) {
+	cases := []struct {
+		json string
+		want LinodeIPv6
+	}{
+		{`"2001:db8::1/128"`, LinodeIPv6{"2001:db8::1/128"}},
+		{`null`, nil},
+		{`""`, nil},
+		{`["2001:db8::1/128", "fe80::1/64"]`, LinodeIPv6{"2001:db8::1/128", "fe80::1/64"}},
+	}
+	for _, c := range cases {
+		var ipv6 LinodeIPv6
+		if err := json.Unmarshal([]byte(c.json), &ipv6); err != nil {
+			t.Errorf("%s: %v", c.json, err)
+			continue
+		}
+		if strings.Join(ipv6, ",") != strings.Join(c.want, ",") || len(ipv6) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.json, ipv6, c.want)
+		}
+	}
+
+	var ipv6 LinodeIPv6
+	if err := json.Unmarshal([]byte(`42`), &ipv6); err == nil {
+		t.Error("number was accepted as IPv6 address")
+	}
+}
+
+// linodeInstanceIPsFixture is networking of an instance with a private
+// IPv4, SLAAC, link-local and a routed global range, as reported by Linode.
+const linodeInstanceIPsFixture = `{
+	"ipv4": {
+		"public": [{"address": "192.0.2.10", "prefix": 24, "gateway": "192.0.2.1"}],
+		"private": [{"address": "192.168.128.5", "prefix": 17}]
+	},
+	"ipv6": {
+		"slaac": {"address": "2001:db8::f03c:91ff:fe24:3a2f", "prefix": 64, "gateway": "fe80::1"},
+		"link_local": {"address": "fe80::f03c:91ff:fe24:3a2f", "prefix": 64},
+		"global": [{"range": "2001:db8:1::", "prefix": 56}]
+	}
+}`
+
+func TestLinodeInstanceIPv6Addresses(t *testing.T) {
+	var ips LinodeInstanceIPs
+	if err := json.Unmarshal([]byte(linodeInstanceIPsFixture), &ips); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"2001:db8::f03c:91ff:fe24:3a2f/64",
+		"fe80::f03c:91ff:fe24:3a2f/64",
+		"2001:db8:1::/56",
+	}
+	if got := ips.IPv6Addresses(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if addresses := (&LinodeInstanceIPs{}).IPv6Addresses(); len(addresses) != 0 {
+		t.Errorf("got %v of instance without IPv6", addresses)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listCursor marks where a paginated listing resumes. It is handed to
+// clients as an opaque string signed with the server key, so that they
+// can't skip the namespace filter by editing it.
+type listCursor struct {
+	Page      int    `json:"page"`
+	Namespace string `json:"namespace"`
+}
+
+// encodeListCursor serializes cursor as base64(JSON).base64(HMAC-SHA256).
+func encodeListCursor(key []byte, cursor *listCursor) (string, error) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to encode list cursor")
+	}
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(signListCursor(key, payload)), nil
+}
+
+// decodeListCursor verifies signature of the cursor and deserializes it.
+func decodeListCursor(key []byte, s string) (*listCursor, error) {
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("Malformed list cursor")
+	}
+
+	encoding := base64.RawURLEncoding
+	payload, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("Malformed list cursor")
+	}
+	signature, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("Malformed list cursor")
+	}
+	if !hmac.Equal(signature, signListCursor(key, payload)) {
+		return nil, errors.New("List cursor signature mismatch")
+	}
+
+	cursor := &listCursor{}
+	if err := json.Unmarshal(payload, cursor); err != nil || cursor.Page < 1 {
+		return nil, errors.New("Malformed list cursor")
+	}
+	return cursor, nil
+}
+
+func signListCursor(key []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestListCursorRoundTrip(t *testing.T) {
+	key := []byte("server-key")
+	s, err := encodeListCursor(key, &listCursor{Page: 3, Namespace: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := decodeListCursor(key, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Page != 3 || cursor.Namespace != "team" {
+		t.Errorf("got %+v, want page 3 in namespace 'team'", cursor)
+	}
+}
+
+func TestListCursorRejectsTampering(t *testing.T) {
+	key := []byte("server-key")
+	s, err := encodeListCursor(key, &listCursor{Page: 2, Namespace: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := strings.SplitN(s, ".", 2)[1]
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"page":2,"namespace":"other"}`))
+
+	cases := map[string]struct {
+		key    []byte
+		cursor string
+	}{
+		"tampered payload":  {key, tampered + "." + signature},
+		"wrong key":         {[]byte("other-key"), s},
+		"missing signature": {key, strings.SplitN(s, ".", 2)[0]},
+		"bad encoding":      {key, "!!!." + signature},
+		"empty":             {key, ""},
+	}
+	for name, c := range cases {
+		if _, err := decodeListCursor(c.key, c.cursor); err == nil {
+			t.Errorf("%s: cursor was accepted", name)
+		}
+	}
+}
+
+func TestListCursorRejectsInvalidPage(t *testing.T) {
+	key := []byte("server-key")
+	s, err := encodeListCursor(key, &listCursor{Page: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeListCursor(key, s); err == nil {
+		t.Error("cursor with page 0 was accepted")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errorLog is a process-wide limiter for error conditions that tend to repeat
+// during incidents (e.g. Linode API outage). Interval is set at startup.
+var errorLog = newLogLimiter(0)
+
+// logLimiter prevents log flooding with identical entries. The first
+// occurrence of an entry is logged immediately, while repetitions within the
+// interval are suppressed and later reported as a single rolled-up entry
+// carrying the number of suppressed occurrences.
+type logLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	entries  map[string]*limitedEntry
+}
+
+type limitedEntry struct {
+	level      log.Level
+	msg        string
+	fields     log.Fields
+	suppressed int
+	lastEmit   time.Time
+}
+
+func newLogLimiter(interval time.Duration) *logLimiter {
+	return &logLimiter{
+		interval: interval,
+		entries:  make(map[string]*limitedEntry),
+	}
+}
+
+// SetInterval changes suppression interval. Zero interval disables
+// suppression.
+func (l *logLimiter) SetInterval(interval time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.interval = interval
+}
+
+// Error logs an error, unless an identical one was logged recently.
+func (l *logLimiter) Error(fields log.Fields, msg string) {
+	l.log(log.ErrorLevel, fields, msg)
+}
+
+// Warn logs a warning, unless an identical one was logged recently.
+func (l *logLimiter) Warn(fields log.Fields, msg string) {
+	l.log(log.WarnLevel, fields, msg)
+}
+
+// Run periodically reports suppressed entries until stop is closed.
+func (l *logLimiter) Run(stop <-chan struct{}) {
+	for {
+		l.mutex.Lock()
+		interval := l.interval
+		l.mutex.Unlock()
+		if interval <= 0 {
+			interval = time.Second
+		}
+
+		select {
+		case <-stop:
+			l.Flush()
+			return
+		case <-time.After(interval):
+			l.flushExpired(time.Now())
+		}
+	}
+}
+
+// Flush immediately reports all suppressed entries.
+func (l *logLimiter) Flush() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for key, entry := range l.entries {
+		if entry.suppressed > 0 {
+			l.emitRollup(entry)
+		}
+		delete(l.entries, key)
+	}
+}
+
+func (l *logLimiter) log(level log.Level, fields log.Fields, msg string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.interval <= 0 {
+		emit(level, fields, msg)
+		return
+	}
+
+	now := time.Now()
+	key := entryKey(level, fields, msg)
+	entry, ok := l.entries[key]
+	if !ok {
+		l.entries[key] = &limitedEntry{
+			level:    level,
+			msg:      msg,
+			fields:   fields,
+			lastEmit: now,
+		}
+		emit(level, fields, msg)
+		return
+	}
+
+	entry.suppressed++
+	if now.Sub(entry.lastEmit) >= l.interval {
+		l.emitRollup(entry)
+		entry.lastEmit = now
+	}
+}
+
+func (l *logLimiter) flushExpired(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastEmit) < l.interval {
+			continue
+		}
+		if entry.suppressed > 0 {
+			l.emitRollup(entry)
+			entry.lastEmit = now
+		} else {
+			// Condition went away, next occurrence is logged immediately.
+			delete(l.entries, key)
+		}
+	}
+}
+
+func (l *logLimiter) emitRollup(entry *limitedEntry) {
+	fields := log.Fields{"suppressed": entry.suppressed}
+	for k, v := range entry.fields {
+		fields[k] = v
+	}
+	emit(entry.level, fields, entry.msg+" (repeated)")
+	entry.suppressed = 0
+}
+
+func emit(level log.Level, fields log.Fields, msg string) {
+	entry := log.WithFields(fields)
+	switch level {
+	case log.ErrorLevel:
+		entry.Error(msg)
+	case log.WarnLevel:
+		entry.Warn(msg)
+	default:
+		entry.Info(msg)
+	}
+}
+
+func entryKey(level log.Level, fields log.Fields, msg string) string {
+	return fmt.Sprintf("%d|%s|%v", level, msg, fields["cause"])
+}
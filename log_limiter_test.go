@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// captureHook records entries emitted by the standard logger.
+type captureHook struct {
+	mutex   sync.Mutex
+	entries []*log.Entry
+}
+
+func (h *captureHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *captureHook) Fire(entry *log.Entry) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+// captureLog redirects the standard logger into a hook for the duration of
+// the test.
+func captureLog(t *testing.T) *captureHook {
+	logger := log.StandardLogger()
+	hook := &captureHook{}
+	hooks := logger.ReplaceHooks(log.LevelHooks{})
+	out := logger.Out
+	logger.AddHook(hook)
+	logger.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		logger.ReplaceHooks(hooks)
+		logger.SetOutput(out)
+	})
+	return hook
+}
+
+func TestLogLimiterSuppressesRepetitions(t *testing.T) {
+	hook := captureLog(t)
+	limiter := newLogLimiter(time.Hour)
+
+	for i := 0; i < 1000; i++ {
+		limiter.Error(log.Fields{"cause": "timeout"}, "Linode API request failed")
+	}
+	limiter.Flush()
+
+	if len(hook.entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(hook.entries))
+	}
+	rollup := hook.entries[1]
+	if rollup.Message != "Linode API request failed (repeated)" {
+		t.Errorf("got message '%s'", rollup.Message)
+	}
+	if rollup.Data["suppressed"] != 999 {
+		t.Errorf("got %v suppressed, want 999", rollup.Data["suppressed"])
+	}
+	if rollup.Level != log.ErrorLevel || rollup.Data["cause"] != "timeout" {
+		t.Errorf("rollup lost level or fields: %v %v", rollup.Level, rollup.Data)
+	}
+}
+
+func TestLogLimiterKeepsDistinctEntries(t *testing.T) {
+	hook := captureLog(t)
+	limiter := newLogLimiter(time.Hour)
+
+	limiter.Error(log.Fields{"cause": "timeout"}, "Linode API request failed")
+	limiter.Error(log.Fields{"cause": "refused"}, "Linode API request failed")
+	limiter.Warn(log.Fields{"cause": "timeout"}, "Linode API request failed")
+	limiter.Flush()
+
+	if len(hook.entries) != 3 {
+		t.Errorf("got %d entries, want 3", len(hook.entries))
+	}
+}
+
+func TestLogLimiterDisabled(t *testing.T) {
+	hook := captureLog(t)
+	limiter := newLogLimiter(0)
+
+	for i := 0; i < 10; i++ {
+		limiter.Error(log.Fields{}, "Linode API request failed")
+	}
+	if len(hook.entries) != 10 {
+		t.Errorf("got %d entries, want 10", len(hook.entries))
+	}
+}
+
+func TestLogLimiterForgetsClearedConditions(t *testing.T) {
+	hook := captureLog(t)
+	limiter := newLogLimiter(time.Minute)
+
+	limiter.Error(log.Fields{}, "Linode API request failed")
+	limiter.flushExpired(time.Now().Add(time.Hour))
+	limiter.Error(log.Fields{}, "Linode API request failed")
+
+	if len(hook.entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(hook.entries))
+	}
+}
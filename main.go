@@ -1,20 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+// keySize is the size of server and peer keys expected by protocore.
+const keySize = 32
+
+// shutdownGracePeriod is how long in-flight requests are given to complete
+// once the server starts shutting down.
+const shutdownGracePeriod = 60 * time.Second
+
 const helpTemplate = `NAME:
    {{.Name}}{{if .Usage}} - {{.Usage}}{{end}}
 
@@ -39,21 +53,107 @@ COPYRIGHT:
    {{.Copyright}}{{end}}
 `
 
-func parseKey(keyName string, value string, fallback []byte) ([]byte, error) {
-	if len(value) > 0 {
-		key, err := hex.DecodeString(value)
+// parseKey loads key from the file, from hex value given on the command line
+// or from the embedded fallback, in this order of precedence.
+func parseKey(keyName string, filename string, value string, fallback []byte) ([]byte, error) {
+	var key []byte
+	var err error
+	if len(filename) > 0 {
+		key, err = readKeyFile(filename)
+	} else if len(value) > 0 {
+		key, err = hex.DecodeString(value)
+	} else if len(fallback) > 0 {
+		key = fallback[:]
+	} else {
+		msg := fmt.Sprintf("%s is empty or missing", strings.ToUpper(keyName[0:1])+keyName[1:])
+		log.Error(msg)
+		return nil, errors.New(msg)
+	}
+	if err != nil {
+		log.WithField("cause", err).Errorf("Couldn't parse %s", keyName)
+		return nil, err
+	}
+
+	if len(key) != keySize {
+		err := errors.Errorf("Key must be %d bytes long, got %d", keySize, len(key))
+		log.WithField("cause", err).Errorf("Couldn't parse %s", keyName)
+		return nil, err
+	}
+	return key, nil
+}
+
+// parsePeerKeys loads authorized peer keys. Key file takes precedence over
+// keys given on the command line, which take precedence over the embedded
+// key.
+func parsePeerKeys(filename string, values []string) ([][]byte, error) {
+	if len(filename) > 0 || len(values) == 0 {
+		key, err := parseKey("peer key", filename, "", embeddedPeerKey[:])
 		if err != nil {
-			log.WithField("cause", err).Error("Couldn't parse %s", keyName)
 			return nil, err
 		}
-		return key, nil
-	} else if len(fallback) > 0 {
-		return fallback[:], nil
+		return [][]byte{key}, nil
+	}
+
+	var keys [][]byte
+	for n, value := range values {
+		key, err := parseKey(fmt.Sprintf("peer key #%d", n), "", value, nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// readKeyFile reads key stored either hex-encoded or as raw bytes.
+func readKeyFile(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read key file")
+	}
+	if text := bytes.TrimSpace(data); len(text) == hex.EncodedLen(keySize) {
+		if key, err := hex.DecodeString(string(text)); err == nil {
+			return key, nil
+		}
+	}
+	return data, nil
+}
+
+// parseClassTimeouts parses list of <plan class>=<duration> pairs.
+func parseClassTimeouts(values []string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, errors.Errorf("Malformed plan class timeout: %s", value)
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Malformed plan class timeout: %s", value)
+		}
+		timeouts[parts[0]] = timeout
+	}
+	return timeouts, nil
+}
+
+func newMetricsSink(c *cli.Context) (MetricsSink, error) {
+	switch c.String("metrics") {
+	case "none":
+		return noopMetricsSink{}, nil
+	case "prometheus":
+		return newPrometheusMetricsSink(), nil
+	case "statsd":
+		sink, err := newStatsdMetricsSink(c.String("statsd-address"))
+		if err != nil {
+			log.WithField("cause", err).Error("Couldn't initialize StatsD metrics")
+			return nil, err
+		}
+		return sink, nil
 	}
 
-	msg := fmt.Sprintf("%s is empty or missing", strings.ToUpper(keyName[0:1])+keyName[1:])
-	log.Error(msg)
-	return nil, errors.New(msg)
+	err := errors.Errorf("Unknown metrics sink: %s", c.String("metrics"))
+	log.WithField("cause", err).Error("Couldn't initialize metrics")
+	return nil, err
 }
 
 func startServer(c *cli.Context) error {
@@ -68,33 +168,200 @@ func startServer(c *cli.Context) error {
 	} else {
 		log.SetLevel(log.InfoLevel)
 	}
+	errorLog.SetInterval(c.Duration("log-dedup-interval"))
+	go errorLog.Run(nil)
+
+	awaitTimeoutByClass, err := parseClassTimeouts(c.StringSlice("await-timeout-class"))
+	if err != nil {
+		log.WithField("cause", err).Error("Couldn't parse plan class await timeouts")
+		return err
+	}
+
+	metadataCache := newLinodeMetadataCache(c.Duration("metadata-cache-ttl"))
+	linodeConfig := &linodeConfig{
+		awaitDelay:          c.Duration("await-delay"),
+		awaitAttempts:       c.Int("await-attempts"),
+		awaitWarnAfter:      c.Duration("await-warn-after"),
+		awaitTimeout:        c.Duration("await-timeout"),
+		awaitTimeoutByClass: awaitTimeoutByClass,
+		resizeAwaitTimeout:  c.Duration("resize-await-timeout"),
+		clients:             newLinodeClientCache(linodeClientTTL, c.Bool("verbose"), metadataCache),
+		hooks:               newProvisionHooks(c.String("pre-provision-webhook"), c.String("post-destroy-webhook")),
+		notifications:       newLinodeMetadataCache(providerStatusTTL),
+		tagPolicy:           newTagPolicy(c.StringSlice("required-tags"), c.StringSlice("default-tags")),
+		reliability:         newReliabilityTracker(c.Duration("reliability-window")),
+		partialResults:      c.Bool("partial-results"),
+		strictSingleTunnel:  c.Bool("strict-single-tunnel"),
+	}
+	if filename := c.String("audit-log"); len(filename) > 0 {
+		if linodeConfig.audit, err = newAuditLogger(filename); err != nil {
+			log.WithField("cause", err).Error("Couldn't open audit log")
+			return err
+		}
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(45 * time.Second))
 
-	hostKey, err := parseKey("server key", c.String("server-key"), embeddedHostKey[:])
+	hostKey, err := parseKey("server key", c.String("server-key-file"), c.String("server-key"), embeddedHostKey[:])
 	if err != nil {
 		return err
 	}
-	peerKey, err := parseKey("peer key", c.String("peer-key"), embeddedPeerKey[:])
+	linodeConfig.cursorKey = hostKey
+	peerKeys, err := parsePeerKeys(c.String("peer-key-file"), c.StringSlice("peer-key"))
 	if err != nil {
 		return err
 	}
 
-	protobufAPI := newProtobufAPIServer(hostKey, peerKey)
-	r.Mount("/proto", protobufAPI.Routes())
+	certFile, keyFile, err := tlsKeyPair(c.String("tls-cert"), c.String("tls-key"))
+	if err != nil {
+		log.WithField("cause", err).Error("Couldn't load TLS certificate")
+		return err
+	}
 
-	log.WithField("address", c.String("listen")).Info("Starting holepuncher server")
-	err = http.ListenAndServe(c.String("listen"), r)
+	var policy *accessPolicy
+	if filename := c.String("access-policy"); len(filename) > 0 {
+		policy, err = loadAccessPolicy(filename)
+		if err != nil {
+			log.WithField("cause", err).Error("Couldn't load access policy")
+			return err
+		}
+	}
+
+	metrics, err := newMetricsSink(c)
 	if err != nil {
+		return err
+	}
+	linodeMetrics = metrics
+	linodeMaxRetries = c.Int("max-retries")
+	linodePageSize = clampLinodePageSize(c.Int("page-size"))
+	if c.String("metrics") == "prometheus" {
+		if address := c.String("metrics-listen"); len(address) > 0 {
+			go serveMetrics(address)
+		} else {
+			r.Handle("/metrics", promhttp.Handler())
+		}
+	}
+
+	keys := newKeyInfo(
+		hostKey, peerKeys,
+		len(c.String("server-key-file")) == 0 && len(c.String("server-key")) == 0,
+		len(c.String("peer-key-file")) == 0 && len(c.StringSlice("peer-key")) == 0,
+	)
+	proxies, err := parseTrustedProxies(c.StringSlice("trusted-proxies"))
+	if err != nil {
+		log.WithField("cause", err).Error("Couldn't parse trusted proxies")
+		return err
+	}
+	config := &serverConfig{
+		listen:           c.String("listen"),
+		tls:              len(certFile) > 0,
+		linode:           linodeConfig,
+		metadataCacheTTL: c.Duration("metadata-cache-ttl"),
+		drainWindow:      c.Duration("drain-window"),
+		logDedupInterval: c.Duration("log-dedup-interval"),
+		metrics:          c.String("metrics"),
+		policy:           policy,
+		keys:             keys,
+		metricsListen:    c.String("metrics-listen"),
+		statsdAddress:    c.String("statsd-address"),
+		rateLimit:        c.Float64("rate-limit"),
+		rateBurst:        c.Int("rate-burst"),
+		trustedProxies:   proxies,
+	}
+	protobufAPI := newProtobufAPIServer(hostKey, peerKeys, keys, config, policy, metrics, linodeConfig)
+	limiter := newRateLimiter(c.Float64("rate-limit"), c.Int("rate-burst"))
+	r.Route("/proto", func(r chi.Router) {
+		r.Use(proxies.Middleware)
+		r.Use(limiter.Middleware)
+		r.Mount("/", protobufAPI.Routes())
+	})
+
+	drain := newDrainController(c.Duration("drain-window"), policy)
+	r.Get("/readyz", drain.handleReadyz)
+	r.Post("/admin/drain", drain.handleDrain)
+
+	health := newHealthChecker(drain, linodeConfig.clients)
+	r.Get("/health", health.handleHealth)
+	r.Get("/ready", health.handleReady)
+
+	server := &http.Server{Addr: c.String("listen"), Handler: r}
+	server.RegisterOnShutdown(protobufAPI.CloseStreams)
+	stopped := make(chan struct{})
+	go shutdownOnDrain(server, drain, stopped)
+
+	log.WithFields(log.Fields{
+		"address": c.String("listen"),
+		"tls":     len(certFile) > 0,
+	}).Info("Starting holepuncher server")
+	if len(certFile) > 0 {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.WithField("cause", err).Error("Couldn't start server")
 		return err
 	}
+	// Listener is closed as soon as shutdown starts, in-flight requests are
+	// still being served until it completes.
+	<-stopped
 	return nil
 }
 
+// tlsKeyPair checks that TLS certificate and key are either both given and
+// loadable, or both omitted (plaintext mode).
+func tlsKeyPair(certFile string, keyFile string) (string, string, error) {
+	if len(certFile) == 0 && len(keyFile) == 0 {
+		return "", "", nil
+	}
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return "", "", errors.New("Both TLS certificate and key must be given")
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return "", "", errors.Wrapf(err, "Unable to load TLS key pair")
+	}
+	return certFile, keyFile, nil
+}
+
+// serveMetrics serves Prometheus metrics on a separate address, so that they
+// aren't exposed alongside the API.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.WithField("address", address).Info("Serving metrics")
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.WithField("cause", err).Error("Couldn't serve metrics")
+	}
+}
+
+// shutdownOnDrain starts draining when a termination signal arrives (unless
+// it was already started by an operator) and gracefully shuts the server down
+// once the drain window elapses. Stopped is closed when in-flight requests
+// are finished and logs are flushed.
+func shutdownOnDrain(server *http.Server, drain *drainController, stopped chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case sig := <-signals:
+		log.WithField("signal", sig).Info("Received termination signal")
+		drain.Start()
+	case <-drain.Started():
+	}
+	<-drain.Done()
+
+	log.Info("Shutting down holepuncher server")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithField("cause", err).Error("Couldn't shut down server gracefully")
+	}
+	errorLog.Flush()
+	close(stopped)
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "holepuncher-server"
@@ -106,13 +373,147 @@ func main() {
 			Usage: "listen `address`",
 			Value: "localhost:9000",
 		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "serve over TLS using certificate from `file` (requires --tls-key)",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "private key `file` of the TLS certificate",
+		},
 		cli.StringFlag{
 			Name:  "server-key, s",
 			Usage: "pre-shared server `key`",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "peer-key, p",
-			Usage: "pre-shared peer `key`",
+			Usage: "pre-shared peer `key` (repeatable, one per client device)",
+		},
+		cli.StringFlag{
+			Name:  "server-key-file",
+			Usage: "read pre-shared server key (hex or raw) from `file`",
+		},
+		cli.StringFlag{
+			Name:  "peer-key-file",
+			Usage: "read pre-shared peer key (hex or raw) from `file`",
+		},
+		cli.StringFlag{
+			Name:  "access-policy",
+			Usage: "JSON `file` mapping access tokens to allowed verbs",
+		},
+		cli.DurationFlag{
+			Name:  "await-delay",
+			Usage: "delay between polls of instance status",
+			Value: 7 * time.Second,
+		},
+		cli.IntFlag{
+			Name:  "await-attempts",
+			Usage: "give up waiting for an instance after this many polls (0 = no limit)",
+			Value: 20,
+		},
+		cli.DurationFlag{
+			Name:  "await-warn-after",
+			Usage: "warn about slow provisioning after this long",
+			Value: 90 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:  "await-timeout",
+			Usage: "give up waiting for an instance after this long",
+			Value: 140 * time.Second,
+		},
+		cli.StringSliceFlag{
+			Name:  "await-timeout-class",
+			Usage: "give up waiting for a new instance of the plan class after this long, e.g. dedicated=5m (repeatable)",
+		},
+		cli.DurationFlag{
+			Name:  "resize-await-timeout",
+			Usage: "give up waiting for a resized instance after this long",
+			Value: 20 * time.Minute,
+		},
+		cli.IntFlag{
+			Name:  "max-retries",
+			Usage: "how many times to retry Linode API requests failed due to transient errors",
+			Value: 3,
+		},
+		cli.DurationFlag{
+			Name:  "reliability-window",
+			Usage: "how far back outcomes of creates are reported by reliability stats",
+			Value: 24 * time.Hour,
+		},
+		cli.BoolFlag{
+			Name:  "partial-results",
+			Usage: "return instances listed so far, marked as truncated, when listing fails midway",
+		},
+		cli.BoolFlag{
+			Name:  "strict-single-tunnel",
+			Usage: "refuse to modify tunnels whose label is carried by multiple instances, except destroying one by ID",
+		},
+		cli.IntFlag{
+			Name:  "page-size",
+			Usage: "items per page requested from Linode list endpoints (25-500)",
+			Value: 100,
+		},
+		cli.DurationFlag{
+			Name:  "metadata-cache-ttl",
+			Usage: "how long to cache Linode regions, plans and images (0 disables caching)",
+			Value: time.Hour,
+		},
+		cli.StringFlag{
+			Name:  "pre-provision-webhook",
+			Usage: "`URL` that must approve (2xx) every tunnel before it is created",
+		},
+		cli.StringFlag{
+			Name:  "post-destroy-webhook",
+			Usage: "`URL` notified about every destroyed tunnel",
+		},
+		cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "append JSON records of created, rebuilt and destroyed tunnels to `FILE`",
+		},
+		cli.StringSliceFlag{
+			Name:  "required-tags",
+			Usage: "tag `KEY` every tunnel must have, may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "default-tags",
+			Usage: "`TAG` added to tunnels without a tag of the same key, may be repeated",
+		},
+		cli.Float64Flag{
+			Name:  "rate-limit",
+			Usage: "requests per second allowed from a single client IP, off by default (needs --trusted-proxies behind a proxy)",
+		},
+		cli.IntFlag{
+			Name:  "rate-burst",
+			Usage: "requests a single client IP can make at once before rate limit applies",
+			Value: 20,
+		},
+		cli.StringSliceFlag{
+			Name:  "trusted-proxies",
+			Usage: "addresses or CIDR ranges of reverse proxies whose client address headers are honored",
+		},
+		cli.StringFlag{
+			Name:  "metrics",
+			Usage: "metrics `sink` (none, prometheus, statsd)",
+			Value: "none",
+		},
+		cli.StringFlag{
+			Name:  "metrics-listen",
+			Usage: "serve Prometheus metrics on a separate `address` instead of the API listener",
+		},
+		cli.StringFlag{
+			Name:  "statsd-address",
+			Usage: "StatsD daemon `address`",
+			Value: "localhost:8125",
+		},
+		cli.DurationFlag{
+			Name:  "drain-window",
+			Usage: "how long to keep serving after draining starts",
+			Value: 15 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:  "log-dedup-interval",
+			Usage: "how often repeated errors are logged (0 disables deduplication)",
+			Value: 30 * time.Second,
 		},
 		cli.BoolFlag{
 			Name:  "verbose, v",
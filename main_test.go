@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseClassTimeouts(t *testing.T) {
+	timeouts, err := parseClassTimeouts([]string{"dedicated=15m", "nanode=90s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeouts) != 2 || timeouts["dedicated"] != 15*time.Minute || timeouts["nanode"] != 90*time.Second {
+		t.Errorf("got %v", timeouts)
+	}
+
+	for _, value := range []string{"dedicated", "=15m", "dedicated=soon"} {
+		if _, err := parseClassTimeouts([]string{value}); err == nil {
+			t.Errorf("%q was accepted", value)
+		}
+	}
+}
+
+// writeTestKeyPair writes self-signed certificate and its key to the
+// directory and returns their paths.
+func writeTestKeyPair(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "holepuncher.test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: cert},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyBytes},
+	} {
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestTLSKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir)
+
+	if cert, key, err := tlsKeyPair(certFile, keyFile); err != nil || cert != certFile || key != keyFile {
+		t.Errorf("got %s, %s, %v", cert, key, err)
+	}
+	// Plaintext mode.
+	if cert, key, err := tlsKeyPair("", ""); err != nil || cert != "" || key != "" {
+		t.Errorf("got %s, %s, %v without TLS", cert, key, err)
+	}
+
+	for _, pair := range [][2]string{
+		{certFile, ""},
+		{"", keyFile},
+		{certFile, certFile},
+		{filepath.Join(dir, "missing.pem"), keyFile},
+	} {
+		if _, _, err := tlsKeyPair(pair[0], pair[1]); err == nil {
+			t.Errorf("key pair %v was accepted", pair)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	dir := t.TempDir()
+	fileKey := bytes.Repeat([]byte{0x11}, keySize)
+	valueKey := bytes.Repeat([]byte{0x22}, keySize)
+	fallback := bytes.Repeat([]byte{0x33}, keySize)
+
+	hexFile := filepath.Join(dir, "hex.key")
+	if err := ioutil.WriteFile(hexFile, []byte(hex.EncodeToString(fileKey)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rawFile := filepath.Join(dir, "raw.key")
+	if err := ioutil.WriteFile(rawFile, fileKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		filename string
+		value    string
+		want     []byte
+	}{
+		{"hex file", hexFile, hex.EncodeToString(valueKey), fileKey},
+		{"raw file", rawFile, "", fileKey},
+		{"value", "", hex.EncodeToString(valueKey), valueKey},
+		{"fallback", "", "", fallback},
+	} {
+		key, err := parseKey("test key", test.filename, test.value, fallback)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !bytes.Equal(key, test.want) {
+			t.Errorf("%s: got key %x, want %x", test.name, key, test.want)
+		}
+	}
+}
+
+func TestParseKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	shortFile := filepath.Join(dir, "short.key")
+	if err := ioutil.WriteFile(shortFile, []byte("0011"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		filename string
+		value    string
+	}{
+		{"missing", "", ""},
+		{"missing file", filepath.Join(dir, "missing.key"), ""},
+		{"short file", shortFile, ""},
+		{"bad hex", "", "not hex"},
+		{"short value", "", "0011"},
+	} {
+		if _, err := parseKey("test key", test.filename, test.value, nil); err == nil {
+			t.Errorf("%s key was accepted", test.name)
+		}
+	}
+}
+
+func TestParsePeerKeys(t *testing.T) {
+	first := bytes.Repeat([]byte{0x11}, keySize)
+	second := bytes.Repeat([]byte{0x22}, keySize)
+
+	keys, err := parsePeerKeys("", []string{hex.EncodeToString(first), hex.EncodeToString(second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[0], first) || !bytes.Equal(keys[1], second) {
+		t.Errorf("got keys %x", keys)
+	}
+
+	// Key file takes precedence.
+	filename := filepath.Join(t.TempDir(), "peer.key")
+	if err := ioutil.WriteFile(filename, first, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = parsePeerKeys(filename, []string{hex.EncodeToString(second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !bytes.Equal(keys[0], first) {
+		t.Errorf("got keys %x with key file", keys)
+	}
+
+	if _, err := parsePeerKeys("", []string{hex.EncodeToString(first), "0011"}); err == nil {
+		t.Error("short peer key was accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const metricsNamespace = "holepuncher"
+
+// MetricsSink is a destination for all instrumentation emitted by the server.
+// Implementations must be safe for concurrent use.
+type MetricsSink interface {
+	// IncCounter increments a monotonically increasing counter by one.
+	IncCounter(name string, labels map[string]string)
+	// ObserveHistogram records a single observation (e.g. a duration in
+	// seconds) in a histogram.
+	ObserveHistogram(name string, value float64, labels map[string]string)
+	// SetGauge sets current value of a gauge.
+	SetGauge(name string, value float64, labels map[string]string)
+}
+
+// noopMetricsSink discards everything. It is used when metrics are disabled.
+type noopMetricsSink struct{}
+
+// prometheusMetricsSink exposes metrics via the default Prometheus registry.
+// Collectors are registered lazily on first use; label names of a metric are
+// fixed by the first call.
+type prometheusMetricsSink struct {
+	mutex      sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+	gauges     map[string]*prometheus.GaugeVec
+}
+
+// statsdMetricsSink sends metrics to a StatsD daemon over UDP. Labels are
+// encoded as DogStatsD tags.
+type statsdMetricsSink struct {
+	conn net.Conn
+}
+
+func (noopMetricsSink) IncCounter(name string, labels map[string]string) {}
+
+func (noopMetricsSink) ObserveHistogram(name string, value float64, labels map[string]string) {}
+
+func (noopMetricsSink) SetGauge(name string, value float64, labels map[string]string) {}
+
+func newPrometheusMetricsSink() *prometheusMetricsSink {
+	return &prometheusMetricsSink{
+		counters:   make(map[string]*prometheus.CounterVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+	}
+}
+
+func (s *prometheusMetricsSink) IncCounter(name string, labels map[string]string) {
+	s.mutex.Lock()
+	counter, ok := s.counters[name]
+	if !ok {
+		counter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{Namespace: metricsNamespace, Name: name, Help: name},
+			labelNames(labels),
+		)
+		s.register(name, counter)
+		s.counters[name] = counter
+	}
+	s.mutex.Unlock()
+
+	if c, err := counter.GetMetricWith(labels); err == nil {
+		c.Inc()
+	}
+}
+
+func (s *prometheusMetricsSink) ObserveHistogram(name string, value float64, labels map[string]string) {
+	s.mutex.Lock()
+	histogram, ok := s.histograms[name]
+	if !ok {
+		histogram = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Namespace: metricsNamespace, Name: name, Help: name},
+			labelNames(labels),
+		)
+		s.register(name, histogram)
+		s.histograms[name] = histogram
+	}
+	s.mutex.Unlock()
+
+	if h, err := histogram.GetMetricWith(labels); err == nil {
+		h.Observe(value)
+	}
+}
+
+func (s *prometheusMetricsSink) SetGauge(name string, value float64, labels map[string]string) {
+	s.mutex.Lock()
+	gauge, ok := s.gauges[name]
+	if !ok {
+		gauge = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Namespace: metricsNamespace, Name: name, Help: name},
+			labelNames(labels),
+		)
+		s.register(name, gauge)
+		s.gauges[name] = gauge
+	}
+	s.mutex.Unlock()
+
+	if g, err := gauge.GetMetricWith(labels); err == nil {
+		g.Set(value)
+	}
+}
+
+func (s *prometheusMetricsSink) register(name string, c prometheus.Collector) {
+	if err := prometheus.Register(c); err != nil {
+		log.WithFields(log.Fields{"cause": err, "metric": name}).Warn("Couldn't register metric")
+	}
+}
+
+func newStatsdMetricsSink(address string) (*statsdMetricsSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to connect to StatsD at %s", address)
+	}
+	return &statsdMetricsSink{conn: conn}, nil
+}
+
+func (s *statsdMetricsSink) IncCounter(name string, labels map[string]string) {
+	s.send(name, "1", "c", labels)
+}
+
+func (s *statsdMetricsSink) ObserveHistogram(name string, value float64, labels map[string]string) {
+	s.send(name, fmt.Sprintf("%g", value), "h", labels)
+}
+
+func (s *statsdMetricsSink) SetGauge(name string, value float64, labels map[string]string) {
+	s.send(name, fmt.Sprintf("%g", value), "g", labels)
+}
+
+func (s *statsdMetricsSink) send(name string, value string, kind string, labels map[string]string) {
+	line := metricsNamespace + "." + name + ":" + value + "|" + kind
+	if len(labels) > 0 {
+		var tags []string
+		for _, k := range labelNames(labels) {
+			tags = append(tags, k+":"+labels[k])
+		}
+		line += "|#" + strings.Join(tags, ",")
+	}
+	// Metrics are best-effort, a lost datagram is not worth reporting.
+	s.conn.Write([]byte(line))
+}
+
+// labelNames returns sorted label names of a label set.
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
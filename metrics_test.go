@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetricsSink records metric calls as "kind name{labels}" strings, with
+// labels sorted by name.
+type fakeMetricsSink struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (s *fakeMetricsSink) IncCounter(name string, labels map[string]string) {
+	s.record("counter", name, labels)
+}
+
+func (s *fakeMetricsSink) ObserveHistogram(name string, value float64, labels map[string]string) {
+	s.record("histogram", name, labels)
+}
+
+func (s *fakeMetricsSink) SetGauge(name string, value float64, labels map[string]string) {
+	s.record("gauge", name, labels)
+}
+
+func (s *fakeMetricsSink) record(kind string, name string, labels map[string]string) {
+	call := kind + " " + name + "{"
+	for n, k := range labelNames(labels) {
+		if n > 0 {
+			call += ","
+		}
+		call += k + "=" + labels[k]
+	}
+	call += "}"
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+// called tells whether the call was recorded.
+func (s *fakeMetricsSink) called(call string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStatsdMetricsSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := newStatsdMetricsSink(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		send func()
+		want string
+	}{
+		{
+			send: func() { sink.IncCounter("requests_total", map[string]string{"verb": "LinodeCreateTunnel"}) },
+			want: "holepuncher.requests_total:1|c|#verb:LinodeCreateTunnel",
+		},
+		{
+			send: func() {
+				sink.ObserveHistogram("request_duration_seconds", 1.5, map[string]string{"verb": "x", "code": "y"})
+			},
+			want: "holepuncher.request_duration_seconds:1.5|h|#code:y,verb:x",
+		},
+		{
+			send: func() { sink.SetGauge("requests_in_flight", 3, nil) },
+			want: "holepuncher.requests_in_flight:3|g",
+		},
+	}
+	buf := make([]byte, 512)
+	for _, c := range cases {
+		c.send()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != c.want {
+			t.Errorf("got '%s', want '%s'", got, c.want)
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"protoapi"
 	"protocore"
 	"reflect"
+	"strings"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -17,6 +19,8 @@ type aProtobufWriter interface {
 type protobufHTTPWriter struct {
 	writer http.ResponseWriter
 	proto  *protocore.Proto
+	// Error passed to WriteError, kept for instrumentation.
+	err error
 }
 
 func newProtobufHTTPWriter(w http.ResponseWriter, proto *protocore.Proto) *protobufHTTPWriter {
@@ -36,6 +40,7 @@ func (w *protobufHTTPWriter) WriteMessage(m *protoapi.Response) error {
 func (w *protobufHTTPWriter) WriteError(m *protoapi.Response, err error) error {
 	w.writer.Header().Set("Content-Type", "application/octet-stream")
 	w.writer.Header().Set("Cache-Control", "no-cache")
+	w.err = err
 	if linodeErr, ok := err.(*LinodeError); ok {
 		if linodeErr.IsAuthError() {
 			w.writer.WriteHeader(http.StatusUnauthorized)
@@ -44,6 +49,15 @@ func (w *protobufHTTPWriter) WriteError(m *protoapi.Response, err error) error {
 		} else {
 			w.writer.WriteHeader(http.StatusTeapot)
 		}
+	} else if hpErr, ok := errors.Cause(err).(*HolepuncherError); ok {
+		switch hpErr.Code {
+		case protoapi.HolepuncherError_UNAUTHORIZED:
+			w.writer.WriteHeader(http.StatusUnauthorized)
+		case protoapi.HolepuncherError_VERB_FORBIDDEN, protoapi.HolepuncherError_ADMIN_REQUIRED:
+			w.writer.WriteHeader(http.StatusForbidden)
+		default:
+			w.writer.WriteHeader(http.StatusTeapot)
+		}
 	} else {
 		w.writer.WriteHeader(http.StatusTeapot)
 	}
@@ -61,3 +75,37 @@ func (w *protobufHTTPWriter) write(m *protoapi.Response) error {
 	}
 	return nil
 }
+
+// errorType classifies error for metrics, e.g. "linode_auth" or
+// "holepuncher_verb_forbidden".
+func errorType(err error) string {
+	if linodeErr, ok := err.(*LinodeError); ok {
+		if linodeErr.IsAuthError() {
+			return "linode_auth"
+		} else if linodeErr.IsPermissionsError() {
+			return "linode_permissions"
+		}
+		return "linode"
+	} else if hpErr, ok := errors.Cause(err).(*HolepuncherError); ok {
+		return "holepuncher_" + strings.ToLower(hpErr.Code.String())
+	}
+	return "other"
+}
+
+// protobufCaptureWriter keeps the response instead of sending it, so that
+// verb handlers can be reused outside of the request-response cycle.
+type protobufCaptureWriter struct {
+	response *protoapi.Response
+	err      error
+}
+
+func (w *protobufCaptureWriter) WriteMessage(m *protoapi.Response) error {
+	w.response = m
+	return nil
+}
+
+func (w *protobufCaptureWriter) WriteError(m *protoapi.Response, err error) error {
+	w.response = m
+	w.err = err
+	return nil
+}
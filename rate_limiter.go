@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimiterIdleTTL is how long a bucket of an inactive client is kept.
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// rateLimiter is a token bucket limiter keyed by client IP. Each client may
+// make burst requests at once, after which tokens are replenished at the
+// rate of requests per second. Zero rate disables limiting.
+type rateLimiter struct {
+	mutex     sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the client, reporting false when
+// the bucket is empty.
+func (l *rateLimiter) Allow(client string) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) >= rateLimiterIdleTTL {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst}
+		l.buckets[client] = bucket
+	} else {
+		bucket.tokens += now.Sub(bucket.lastSeen).Seconds() * l.rate
+		if bucket.tokens > l.burst {
+			bucket.tokens = l.burst
+		}
+	}
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Middleware rejects requests of clients that exceeded the rate with 429.
+// Client address is resolved by trustedProxies.Middleware.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientIPFromContext(r.Context())
+		if !l.Allow(client) {
+			errorLog.Warn(log.Fields{"ip": client}, "Client exceeded request rate")
+			render.Status(r, http.StatusTooManyRequests)
+			render.PlainText(w, r, "too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sweep evicts buckets of clients that weren't seen for the idle TTL.
+// Caller must hold the mutex.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) >= rateLimiterIdleTTL {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimiterAllowsBurst(t *testing.T) {
+	limiter := newRateLimiter(0.001, 3)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("192.0.2.1") {
+			t.Fatalf("request #%d within burst was rejected", i+1)
+		}
+	}
+	if limiter.Allow("192.0.2.1") {
+		t.Error("request exceeding burst was allowed")
+	}
+	if !limiter.Allow("192.0.2.2") {
+		t.Error("other client was rejected")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := newRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		if !limiter.Allow("192.0.2.1") {
+			t.Fatal("disabled limiter rejected request")
+		}
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	limiter := newRateLimiter(0.001, 1)
+	handler := trustedProxies(nil).Middleware(limiter.Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	))
+
+	serve := func(forwardedFor string) int {
+		r := httptest.NewRequest("POST", "/proto", nil)
+		r.RemoteAddr = "192.0.2.1:4000"
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve("198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("first request: got status %d", code)
+	}
+	// Without trusted proxies, spoofed header mustn't get a fresh bucket.
+	if code := serve("198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("second request: got status %d, want 429", code)
+	}
+}
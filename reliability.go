@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Outcomes of tunnel provisioning.
+const (
+	provisionSucceeded = "succeeded"
+	provisionFailed    = "failed"
+	provisionTimedOut  = "timed_out"
+)
+
+// reliabilityTracker keeps outcomes of tunnel creates over a rolling window.
+// Outcomes are kept in order of recording, so that expired ones can be cut
+// off the front.
+type reliabilityTracker struct {
+	mutex    sync.Mutex
+	window   time.Duration
+	outcomes []provisionOutcome
+}
+
+type provisionOutcome struct {
+	at      time.Time
+	region  string
+	plan    string
+	outcome string
+}
+
+// reliabilityCounts are outcome counts of a group of creates.
+type reliabilityCounts struct {
+	Succeeded int
+	Failed    int
+	TimedOut  int
+}
+
+// reliabilityBreakdown are counts of creates in a single region and plan.
+type reliabilityBreakdown struct {
+	Region string
+	Plan   string
+	Counts reliabilityCounts
+}
+
+type reliabilityStats struct {
+	Window    time.Duration
+	Total     reliabilityCounts
+	Breakdown []reliabilityBreakdown
+}
+
+func newReliabilityTracker(window time.Duration) *reliabilityTracker {
+	return &reliabilityTracker{window: window}
+}
+
+// provisionOutcomeOf classifies result of a create. Running out of the await
+// deadline or the request deadline counts as a timeout.
+func provisionOutcomeOf(err error) string {
+	if err == nil {
+		return provisionSucceeded
+	}
+	cause := errors.Cause(err)
+	if _, ok := cause.(*awaitTimeoutError); ok || cause == context.DeadlineExceeded {
+		return provisionTimedOut
+	}
+	return provisionFailed
+}
+
+// Record adds outcome of a create and counts it in metrics.
+func (t *reliabilityTracker) Record(region string, plan string, err error) {
+	outcome := provisionOutcomeOf(err)
+	linodeMetrics.IncCounter("tunnel_create_outcomes_total", map[string]string{
+		"region":  region,
+		"plan":    plan,
+		"outcome": outcome,
+	})
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	t.expire(now)
+	t.outcomes = append(t.outcomes, provisionOutcome{now, region, plan, outcome})
+}
+
+// Stats summarizes outcomes within the window. Breakdown is sorted by region
+// and plan.
+func (t *reliabilityTracker) Stats() *reliabilityStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.expire(time.Now())
+
+	stats := &reliabilityStats{Window: t.window}
+	groups := make(map[[2]string]*reliabilityCounts)
+	for _, outcome := range t.outcomes {
+		key := [2]string{outcome.region, outcome.plan}
+		counts, ok := groups[key]
+		if !ok {
+			counts = &reliabilityCounts{}
+			groups[key] = counts
+		}
+		counts.add(outcome.outcome)
+		stats.Total.add(outcome.outcome)
+	}
+
+	for key, counts := range groups {
+		stats.Breakdown = append(stats.Breakdown, reliabilityBreakdown{
+			Region: key[0],
+			Plan:   key[1],
+			Counts: *counts,
+		})
+	}
+	sort.Slice(stats.Breakdown, func(i, j int) bool {
+		a, b := stats.Breakdown[i], stats.Breakdown[j]
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Plan < b.Plan
+	})
+	return stats
+}
+
+// expire drops outcomes older than the window. Caller must hold the mutex.
+func (t *reliabilityTracker) expire(now time.Time) {
+	n := 0
+	for n < len(t.outcomes) && now.Sub(t.outcomes[n].at) > t.window {
+		n++
+	}
+	t.outcomes = t.outcomes[n:]
+}
+
+func (c *reliabilityCounts) add(outcome string) {
+	switch outcome {
+	case provisionSucceeded:
+		c.Succeeded++
+	case provisionFailed:
+		c.Failed++
+	case provisionTimedOut:
+		c.TimedOut++
+	}
+}
+
+func (c *reliabilityCounts) Total() int {
+	return c.Succeeded + c.Failed + c.TimedOut
+}
+
+// Rate returns fraction of creates with given count, zero if there were
+// none.
+func (c *reliabilityCounts) Rate(count int) float64 {
+	if c.Total() == 0 {
+		return 0
+	}
+	return float64(count) / float64(c.Total())
+}
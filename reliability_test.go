@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReliabilityStats(t *testing.T) {
+	tracker := newReliabilityTracker(time.Hour)
+	tracker.Record("us-east", "g6-nanode-1", nil)
+	tracker.Record("us-east", "g6-nanode-1", nil)
+	tracker.Record("us-east", "g6-nanode-1", errors.New("boom"))
+	tracker.Record("us-east", "g6-standard-2", &awaitTimeoutError{LinodeStatusRunning})
+	tracker.Record("eu-west", "g6-nanode-1", context.DeadlineExceeded)
+
+	stats := tracker.Stats()
+	want := reliabilityCounts{Succeeded: 2, Failed: 1, TimedOut: 2}
+	if stats.Total != want {
+		t.Errorf("got total %+v, want %+v", stats.Total, want)
+	}
+	if rate := stats.Total.Rate(stats.Total.Succeeded); rate != 0.4 {
+		t.Errorf("got success rate %v, want 0.4", rate)
+	}
+
+	wantBreakdown := []reliabilityBreakdown{
+		{"eu-west", "g6-nanode-1", reliabilityCounts{TimedOut: 1}},
+		{"us-east", "g6-nanode-1", reliabilityCounts{Succeeded: 2, Failed: 1}},
+		{"us-east", "g6-standard-2", reliabilityCounts{TimedOut: 1}},
+	}
+	if len(stats.Breakdown) != len(wantBreakdown) {
+		t.Fatalf("got breakdown %+v, want %+v", stats.Breakdown, wantBreakdown)
+	}
+	for i, b := range stats.Breakdown {
+		if b != wantBreakdown[i] {
+			t.Errorf("breakdown #%d: got %+v, want %+v", i, b, wantBreakdown[i])
+		}
+	}
+}
+
+func TestReliabilityStatsExpire(t *testing.T) {
+	tracker := newReliabilityTracker(time.Hour)
+	tracker.outcomes = []provisionOutcome{
+		{time.Now().Add(-2 * time.Hour), "us-east", "g6-nanode-1", provisionFailed},
+	}
+	tracker.Record("us-east", "g6-nanode-1", nil)
+
+	stats := tracker.Stats()
+	want := reliabilityCounts{Succeeded: 1}
+	if stats.Total != want {
+		t.Errorf("got total %+v, want %+v", stats.Total, want)
+	}
+}
+
+func TestReliabilityRateWithoutCreates(t *testing.T) {
+	var counts reliabilityCounts
+	if rate := counts.Rate(counts.Succeeded); rate != 0 {
+		t.Errorf("got rate %v, want 0", rate)
+	}
+}
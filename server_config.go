@@ -0,0 +1,88 @@
+package main
+
+import (
+	"protoapi"
+	"sort"
+	"time"
+)
+
+// version is set at build time, e.g. -ldflags "-X main.version=1.2.0".
+var version = "dev"
+
+// serverConfig is the effective configuration reported for diagnostics. It
+// must never carry key or token material. Hook URLs may embed credentials and
+// file paths reveal the host layout, so only whether they are set is
+// reported.
+type serverConfig struct {
+	listen           string
+	tls              bool
+	linode           *linodeConfig
+	metadataCacheTTL time.Duration
+	drainWindow      time.Duration
+	logDedupInterval time.Duration
+	metrics          string
+	policy           *accessPolicy
+	keys             *keyInfo
+	metricsListen    string
+	statsdAddress    string
+	rateLimit        float64
+	rateBurst        int
+	trustedProxies   trustedProxies
+}
+
+func (c *serverConfig) toProtobuf() *protoapi.ServerConfig {
+	config := &protoapi.ServerConfig{
+		Version:             version,
+		Listen:              c.listen,
+		Tls:                 c.tls,
+		AwaitDelay:          c.linode.awaitDelay.String(),
+		AwaitAttempts:       uint32(c.linode.awaitAttempts),
+		AwaitWarnAfter:      c.linode.awaitWarnAfter.String(),
+		AwaitTimeout:        c.linode.awaitTimeout.String(),
+		AwaitTimeoutByClass: make(map[string]string),
+		ResizeAwaitTimeout:  c.linode.resizeAwaitTimeout.String(),
+		MetadataCacheTtl:    c.metadataCacheTTL.String(),
+		DrainWindow:         c.drainWindow.String(),
+		LogDedupInterval:    c.logDedupInterval.String(),
+		Metrics:             c.metrics,
+		MetricsListen:       c.metricsListen,
+		StatsdAddress:       c.statsdAddress,
+		RateLimit:           c.rateLimit,
+		RateBurst:           uint32(c.rateBurst),
+		PreProvisionHook:    c.linode.hooks != nil && len(c.linode.hooks.preProvisionURL) > 0,
+		PostDestroyHook:     c.linode.hooks != nil && len(c.linode.hooks.postDestroyURL) > 0,
+		MaxRetries:          uint32(linodeMaxRetries),
+		AuditLog:            c.linode.audit != nil,
+		ReliabilityWindow:   c.linode.reliability.window.String(),
+		PageSize:            uint32(linodePageSize),
+		PartialResults:      c.linode.partialResults,
+		StrictSingleTunnel:  c.linode.strictSingleTunnel,
+		AccessPolicyLoaded:  c.policy != nil,
+		EmbeddedHostKey:     c.keys.embeddedHostKey,
+		EmbeddedPeerKey:     c.keys.embeddedPeerKey,
+	}
+	for _, network := range c.trustedProxies {
+		config.TrustedProxies = append(config.TrustedProxies, network.String())
+	}
+	if policy := c.linode.tagPolicy; policy != nil {
+		config.RequiredTags = policy.required
+		config.DefaultTags = policy.defaults
+	}
+	for class, timeout := range c.linode.awaitTimeoutByClass {
+		config.AwaitTimeoutByClass[class] = timeout.String()
+	}
+	if c.policy != nil {
+		// Entries are keyed by token hash, which is left out as well.
+		for _, entry := range c.policy.tokens {
+			config.AccessPolicy = append(config.AccessPolicy, &protoapi.ServerAccessPolicyEntry{
+				Verbs: entry.verbs,
+				Admin: entry.admin,
+			})
+		}
+	}
+	for name := range tunnelProviders {
+		config.Providers = append(config.Providers, name)
+	}
+	sort.Strings(config.Providers)
+	return config
+}
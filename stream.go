@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"protoapi"
+	"protocore"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Status streams push tunnel status to clients over server-sent events, so
+// that dashboards don't have to poll the server.
+//
+// A stream is opened with the same encrypted LinodeGetTunnelStatus request as
+// the regular verb, passed in the URL (/proto/stream/<base64>). Every event
+// carries a complete encrypted response, exactly as it would be returned by
+// the verb, encoded with unpadded standard base64:
+//
+//	event: status
+//	data: <base64 ciphertext>
+//
+// Events are sent on connect and whenever status changes afterwards. Comment
+// lines are sent periodically to keep the connection alive through proxies.
+//
+// Requests with until_running set follow a tunnel being provisioned: status
+// is polled as often as while awaiting the instance, and the stream ends
+// once the instance is running or the await timeout runs out. The last event
+// tells which of them happened:
+//
+//	event: end
+//	data: running|timeout
+
+const (
+	streamPollInterval      = 15 * time.Second
+	streamHeartbeatInterval = 30 * time.Second
+)
+
+func (s *protobufAPIServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	request, proto := s.readRequest(w, r)
+	if request == nil {
+		return
+	}
+	args := request.GetLinodeTunnelStatus()
+	if args == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.Error(w, "verb can't be streamed", 400)
+		return
+	}
+	if err := s.policy.Authorize(request.GetAccessToken(), s.verbName(request)); err != nil {
+		s.logRequest(r, "Rejected request: "+err.Error())
+		newProtobufHTTPWriter(w, proto).WriteError(s.createErrorResponse(err), err)
+		return
+	}
+	newProvider, err := selectTunnelProvider(request.GetProvider(), s.verbName(request))
+	if err != nil {
+		s.logRequest(r, "Rejected request: "+err.Error())
+		newProtobufHTTPWriter(w, proto).WriteError(s.createErrorResponse(err), err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.Error(w, "streaming unsupported", 500)
+		return
+	}
+
+	s.logRequest(r, "Got request to stream tunnel status")
+	s.metrics.SetGauge("streams_open", float64(atomic.AddInt64(&s.streamsOpen, 1)), nil)
+	defer func() {
+		s.metrics.SetGauge("streams_open", float64(atomic.AddInt64(&s.streamsOpen, -1)), nil)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var lastDigest string
+	var lastResponse *protoapi.Response
+	push := func() bool {
+		response := s.pollTunnelStatus(r.Context(), newProvider, args)
+		lastResponse = response
+		digest := tunnelStatusDigest(response)
+		if digest == lastDigest {
+			return true
+		}
+		if err := s.writeEvent(w, proto, "status", response); err != nil {
+			log.WithField("cause", err).Debug("Status stream was interrupted")
+			return false
+		}
+		flusher.Flush()
+		lastDigest = digest
+		return true
+	}
+
+	pollInterval := streamPollInterval
+	var deadline <-chan time.Time
+	if args.UntilRunning {
+		if s.linodeConfig.awaitDelay > 0 {
+			pollInterval = s.linodeConfig.awaitDelay
+		}
+		timer := time.NewTimer(s.linodeConfig.awaitTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// end reports whether the followed tunnel is up, finishing the stream.
+	end := func() bool {
+		if !args.UntilRunning || !tunnelStatusRunning(lastResponse) {
+			return false
+		}
+		s.writeEnd(w, "running")
+		flusher.Flush()
+		return true
+	}
+
+	if !push() || end() {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			s.logRequest(r, "Client closed status stream")
+			return
+		case <-s.streamsClosed:
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-deadline:
+			s.logRequest(r, "Tunnel didn't come up before status stream timed out")
+			s.writeEnd(w, "timeout")
+			flusher.Flush()
+			return
+		case <-poll.C:
+			if !push() || end() {
+				return
+			}
+		}
+	}
+}
+
+// CloseStreams terminates all open status streams. Streams never become idle,
+// so they have to be closed explicitly for graceful shutdown to complete.
+func (s *protobufAPIServer) CloseStreams() {
+	s.streamsClosedOnce.Do(func() {
+		close(s.streamsClosed)
+	})
+}
+
+// pollTunnelStatus runs TunnelStatus verb and returns its response.
+func (s *protobufAPIServer) pollTunnelStatus(
+	ctx context.Context,
+	newProvider tunnelProviderFactory,
+	args *protoapi.LinodeGetTunnelStatusRequest,
+) *protoapi.Response {
+	writer := &protobufCaptureWriter{}
+	newProtobufLinode(ctx, writer, s.linodeConfig, newProvider).TunnelStatus(args)
+	return writer.response
+}
+
+// writeEvent encrypts the response and writes it as a single SSE event.
+func (s *protobufAPIServer) writeEvent(
+	w io.Writer,
+	proto *protocore.Proto,
+	event string,
+	m *protoapi.Response,
+) error {
+	var buf bytes.Buffer
+	if err := proto.WriteMessage(&buf, m); err != nil {
+		return err
+	}
+	data := base64.RawStdEncoding.EncodeToString(buf.Bytes())
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// writeEnd writes the final event of the stream. Failure is not reported,
+// the stream is closed right after anyway.
+func (s *protobufAPIServer) writeEnd(w io.Writer, reason string) {
+	fmt.Fprintf(w, "event: end\ndata: %s\n\n", reason)
+}
+
+// tunnelStatusRunning tells whether the status response reports a running
+// instance.
+func tunnelStatusRunning(m *protoapi.Response) bool {
+	result, ok := m.R.(*protoapi.Response_LinodeTunnelStatusResult)
+	if !ok {
+		return false
+	}
+	x, ok := result.LinodeTunnelStatusResult.Result.(*protoapi.LinodeGetTunnelStatusResponse_Instance)
+	return ok && x.Instance.Status == protoapi.LinodeInstance_RUNNING
+}
+
+// tunnelStatusDigest summarizes parts of the status response that are worth
+// pushing to the client when changed.
+func tunnelStatusDigest(m *protoapi.Response) string {
+	result, ok := m.R.(*protoapi.Response_LinodeTunnelStatusResult)
+	if !ok {
+		return ""
+	}
+	status := result.LinodeTunnelStatusResult
+
+	switch x := status.Result.(type) {
+	case *protoapi.LinodeGetTunnelStatusResponse_Instance:
+		return fmt.Sprintf(
+			"instance|%d|%v|%v|%v|%d",
+			x.Instance.Id, x.Instance.Status, x.Instance.Ipv4, x.Instance.Ipv6, len(status.Conflicts),
+		)
+	case *protoapi.LinodeGetTunnelStatusResponse_Error:
+		digest := "error"
+		if x.Error.Error != nil {
+			digest += "|" + x.Error.Error.Message
+		}
+		for _, entry := range x.Error.Details {
+			digest += "|" + entry.Field + ":" + entry.Reason
+		}
+		return digest
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"protoapi"
+	"testing"
+)
+
+func TestStreamPushesStatusTransitions(t *testing.T) {
+	linode := newFakeLinode(t, LinodeInfo{ID: 1, Label: "hp_instance", Status: LinodeStatusProvisioning})
+	s := newTestAPIServer(linode, &fakeMetricsSink{})
+	args := &protoapi.LinodeGetTunnelStatusRequest{Auth: testAuth(), UntilRunning: true}
+
+	// Same decision as the stream makes: push when digest changes, end once
+	// running.
+	var pushed []*protoapi.Response
+	var lastDigest string
+	for _, status := range []LinodeStatus{
+		LinodeStatusProvisioning, LinodeStatusProvisioning,
+		LinodeStatusBooting, LinodeStatusRunning, LinodeStatusRunning,
+	} {
+		linode.setStatus(1, status)
+		response := s.pollTunnelStatus(context.Background(), newLinodeTunnelProvider, args)
+		if digest := tunnelStatusDigest(response); digest != lastDigest {
+			pushed = append(pushed, response)
+			lastDigest = digest
+		}
+		if tunnelStatusRunning(response) {
+			break
+		}
+	}
+
+	want := []protoapi.LinodeInstance_Status{
+		protoapi.LinodeInstance_PROVISIONING,
+		protoapi.LinodeInstance_BOOTING,
+		protoapi.LinodeInstance_RUNNING,
+	}
+	if len(pushed) != len(want) {
+		t.Fatalf("got %d events, want %d", len(pushed), len(want))
+	}
+	for n, response := range pushed {
+		result := response.R.(*protoapi.Response_LinodeTunnelStatusResult).LinodeTunnelStatusResult
+		instance := result.Result.(*protoapi.LinodeGetTunnelStatusResponse_Instance).Instance
+		if instance.Status != want[n] {
+			t.Errorf("event #%d: got status %v, want %v", n, instance.Status, want[n])
+		}
+	}
+}
+
+func TestTunnelStatusDigest(t *testing.T) {
+	status := func(status protoapi.LinodeInstance_Status, ipv4 ...string) *protoapi.Response {
+		return &protoapi.Response{
+			R: &protoapi.Response_LinodeTunnelStatusResult{
+				LinodeTunnelStatusResult: &protoapi.LinodeGetTunnelStatusResponse{
+					Result: &protoapi.LinodeGetTunnelStatusResponse_Instance{
+						Instance: &protoapi.LinodeInstance{Id: 1, Status: status, Ipv4: ipv4},
+					},
+				},
+			},
+		}
+	}
+
+	booting := tunnelStatusDigest(status(protoapi.LinodeInstance_BOOTING))
+	running := tunnelStatusDigest(status(protoapi.LinodeInstance_RUNNING))
+	addressed := tunnelStatusDigest(status(protoapi.LinodeInstance_RUNNING, "203.0.113.7"))
+	if booting == running || running == addressed {
+		t.Errorf("digests don't change with status: %s, %s, %s", booting, running, addressed)
+	}
+	if running != tunnelStatusDigest(status(protoapi.LinodeInstance_RUNNING)) {
+		t.Error("digest of the same status changed")
+	}
+
+	if tunnelStatusRunning(status(protoapi.LinodeInstance_BOOTING)) {
+		t.Error("booting instance is reported running")
+	}
+	if !tunnelStatusRunning(status(protoapi.LinodeInstance_RUNNING)) {
+		t.Error("running instance isn't reported running")
+	}
+}
+
+func TestStreamEndEvent(t *testing.T) {
+	s := newTestAPIServer(newFakeLinode(t), &fakeMetricsSink{})
+	var buf bytes.Buffer
+	s.writeEnd(&buf, "timeout")
+	if buf.String() != "event: end\ndata: timeout\n\n" {
+		t.Errorf("got event %q", buf.String())
+	}
+
+	// Streams may be closed by several shutdown paths.
+	s.CloseStreams()
+	s.CloseStreams()
+	select {
+	case <-s.streamsClosed:
+	default:
+		t.Error("streams weren't closed")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"protoapi"
+	"strings"
+)
+
+// tagPolicy enforces operator-defined tags on tunnel instances. Tags are
+// either plain ("vpn") or carry a value ("cost-center:eng"); policy works
+// with tag keys, i.e. the part before the colon.
+type tagPolicy struct {
+	// Keys every instance must be tagged with.
+	required []string
+	// Tags added to instances which don't have a tag with the same key.
+	defaults []string
+}
+
+func newTagPolicy(required []string, defaults []string) *tagPolicy {
+	return &tagPolicy{required: required, defaults: defaults}
+}
+
+// Apply adds default tags missing from the tags and checks that all required
+// keys are present, returning MISSING_REQUIRED_TAG otherwise. Nil policy
+// leaves tags as they are.
+func (p *tagPolicy) Apply(tags []string) ([]string, error) {
+	if p == nil {
+		return tags, nil
+	}
+
+	result := append([]string{}, tags...)
+	for _, tag := range p.defaults {
+		if !hasTagKey(result, tagKey(tag)) {
+			result = append(result, tag)
+		}
+	}
+	for _, key := range p.required {
+		if !hasTagKey(result, key) {
+			return nil, newHolepuncherError(
+				protoapi.HolepuncherError_MISSING_REQUIRED_TAG,
+				"Tunnel must be tagged with '%s'", key,
+			)
+		}
+	}
+	return result, nil
+}
+
+func tagKey(tag string) string {
+	return strings.SplitN(tag, ":", 2)[0]
+}
+
+func hasTagKey(tags []string, key string) bool {
+	for _, tag := range tags {
+		if tagKey(tag) == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"protoapi"
+	"reflect"
+	"testing"
+)
+
+func TestTagPolicyRejectsMissingRequiredTag(t *testing.T) {
+	policy := newTagPolicy([]string{"owner"}, []string{"vpn"})
+
+	_, err := policy.Apply([]string{"cost-center:eng"})
+	hpErr, ok := err.(*HolepuncherError)
+	if !ok {
+		t.Fatalf("got error %v, want HolepuncherError", err)
+	}
+	if hpErr.Code != protoapi.HolepuncherError_MISSING_REQUIRED_TAG {
+		t.Errorf("got code %v, want MISSING_REQUIRED_TAG", hpErr.Code)
+	}
+}
+
+func TestTagPolicyDefaultSuppliesRequiredTag(t *testing.T) {
+	policy := newTagPolicy([]string{"owner"}, []string{"owner:ops", "vpn"})
+
+	tags, err := policy.Apply([]string{"cost-center:eng"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cost-center:eng", "owner:ops", "vpn"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v, want %v", tags, want)
+	}
+}
+
+func TestTagPolicyKeepsExplicitValue(t *testing.T) {
+	policy := newTagPolicy(nil, []string{"owner:ops"})
+
+	tags, err := policy.Apply([]string{"owner:dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"owner:dev"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v, want %v", tags, want)
+	}
+}
+
+func TestNilTagPolicyLeavesTags(t *testing.T) {
+	var policy *tagPolicy
+	tags, err := policy.Apply([]string{"vpn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"vpn"}) {
+		t.Errorf("got tags %v", tags)
+	}
+}
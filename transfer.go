@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"protoapi"
+	"time"
+)
+
+// linodeTransferOveragePrice is the price of a single GB transferred in excess
+// of the account quota, in USD.
+const linodeTransferOveragePrice = 0.005
+
+// transferForecast is a projection of the account network transfer at the end
+// of the current billing month.
+type transferForecast struct {
+	projectedGB   float64
+	overageGB     float64
+	overageCost   float64
+	daysRemaining int
+	confidence    protoapi.LinodeTransferForecast_Confidence
+}
+
+// forecastTransfer linearly extrapolates transfer used so far to the whole
+// month. Linode bills transfer per calendar month in UTC.
+func forecastTransfer(usage *LinodeTransfer, now time.Time) *transferForecast {
+	now = now.UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+
+	monthDays := monthEnd.Sub(monthStart).Hours() / 24
+	elapsedDays := now.Sub(monthStart).Hours() / 24
+	// Usage during the first hours of the month says little about the rest of
+	// it, don't let it explode the projection.
+	if elapsedDays < 1 {
+		elapsedDays = 1
+	}
+
+	projected := float64(usage.Used) / elapsedDays * monthDays
+	overage := math.Max(0, projected-float64(usage.Quota))
+
+	confidence := protoapi.LinodeTransferForecast_HIGH
+	if elapsedDays < 7 {
+		confidence = protoapi.LinodeTransferForecast_LOW
+	} else if elapsedDays < 14 {
+		confidence = protoapi.LinodeTransferForecast_MEDIUM
+	}
+
+	return &transferForecast{
+		projectedGB:   projected,
+		overageGB:     overage,
+		overageCost:   overage * linodeTransferOveragePrice,
+		daysRemaining: int(math.Ceil(monthEnd.Sub(now).Hours() / 24)),
+		confidence:    confidence,
+	}
+}
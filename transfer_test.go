@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"protoapi"
+	"testing"
+	"time"
+)
+
+func TestForecastTransfer(t *testing.T) {
+	cases := []struct {
+		name       string
+		used       int
+		quota      int
+		now        time.Time
+		projected  float64
+		overage    float64
+		days       int
+		confidence protoapi.LinodeTransferForecast_Confidence
+	}{
+		{
+			name: "half of month", used: 500, quota: 800,
+			now:       time.Date(2024, time.April, 16, 0, 0, 0, 0, time.UTC),
+			projected: 1000, overage: 200, days: 15,
+			confidence: protoapi.LinodeTransferForecast_HIGH,
+		},
+		{
+			name: "under quota", used: 100, quota: 1000,
+			now:       time.Date(2024, time.April, 11, 0, 0, 0, 0, time.UTC),
+			projected: 300, overage: 0, days: 20,
+			confidence: protoapi.LinodeTransferForecast_MEDIUM,
+		},
+		{
+			name: "first hours of month", used: 10, quota: 1000,
+			now:       time.Date(2024, time.April, 1, 6, 0, 0, 0, time.UTC),
+			projected: 300, overage: 0, days: 30,
+			confidence: protoapi.LinodeTransferForecast_LOW,
+		},
+		{
+			// Still the 31st of January in UTC.
+			name: "local time", used: 310, quota: 100,
+			now:       time.Date(2024, time.February, 1, 0, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+			projected: 310.84, overage: 210.84, days: 1,
+			confidence: protoapi.LinodeTransferForecast_HIGH,
+		},
+	}
+
+	for _, c := range cases {
+		forecast := forecastTransfer(&LinodeTransfer{Used: c.used, Quota: c.quota}, c.now)
+		if math.Abs(forecast.projectedGB-c.projected) > 0.01 {
+			t.Errorf("%s: got projected %.2f GB, want %.2f", c.name, forecast.projectedGB, c.projected)
+		}
+		if math.Abs(forecast.overageGB-c.overage) > 0.01 {
+			t.Errorf("%s: got overage %.2f GB, want %.2f", c.name, forecast.overageGB, c.overage)
+		}
+		if math.Abs(forecast.overageCost-c.overage*linodeTransferOveragePrice) > 0.0001 {
+			t.Errorf("%s: got overage cost %.4f", c.name, forecast.overageCost)
+		}
+		if forecast.daysRemaining != c.days {
+			t.Errorf("%s: got %d days remaining, want %d", c.name, forecast.daysRemaining, c.days)
+		}
+		if forecast.confidence != c.confidence {
+			t.Errorf("%s: got confidence %v, want %v", c.name, forecast.confidence, c.confidence)
+		}
+	}
+}
+
+func TestGetTransferUsage(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /account/transfer": func(f *fakeLinode, w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+testAccessToken {
+				writeLinodeError(t, w, http.StatusUnauthorized, "Invalid Token")
+				return
+			}
+			writeJSON(t, w, http.StatusOK, &LinodeTransfer{Used: 350, Quota: 1000, Billable: 0})
+		},
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetTransferUsage(&protoapi.LinodeGetTransferUsageRequest{Auth: testAuth()})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeGetTransferUsageResult).LinodeGetTransferUsageResult
+	usage := result.Result.(*protoapi.LinodeGetTransferUsageResponse_Usage).Usage
+	if usage.UsedGb != 350 || usage.QuotaGb != 1000 || usage.BillableGb != 0 {
+		t.Errorf("got usage %+v", usage)
+	}
+}
+
+func TestGetTransferUsageFails(t *testing.T) {
+	linode := newFakeLinode(t).handle(fakeRoutes{
+		"GET /account/transfer": respondError(http.StatusForbidden, "Unauthorized"),
+	})
+	writer := linode.call(func(p *protobufLinode) error {
+		return p.GetTransferUsage(&protoapi.LinodeGetTransferUsageRequest{Auth: testAuth()})
+	})
+	if writer.err == nil {
+		t.Fatal("error wasn't reported")
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeGetTransferUsageResult).LinodeGetTransferUsageResult
+	if _, ok := result.Result.(*protoapi.LinodeGetTransferUsageResponse_Error); !ok {
+		t.Errorf("got result %T, want error", result.Result)
+	}
+}
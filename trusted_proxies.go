@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// trustedProxies are addresses of reverse proxies in front of the server.
+// Headers carrying client address are honored only on requests coming from
+// them, otherwise any client could pick its own address. Empty list means
+// the server is exposed directly.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses proxy addresses given either as CIDR ranges or
+// as single IPs.
+func parseTrustedProxies(specs []string) (trustedProxies, error) {
+	var proxies trustedProxies
+	for _, spec := range specs {
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, errors.Errorf("Invalid trusted proxy address: %s", spec)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, errors.Errorf("Invalid trusted proxy range: %s", spec)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (t trustedProxies) trusts(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP determines address of the client. Headers set by proxies (the
+// same ones logRequest reports) are used only when the request comes from a
+// trusted proxy. X-Forwarded-For is walked from the nearest hop, skipping
+// trusted proxies, as the leftmost entries are whatever the client sent.
+func (t trustedProxies) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !t.trusts(remote) {
+		return remote
+	}
+
+	if h := r.Header.Get("CF-Connecting-IP"); len(h) > 0 {
+		return h
+	}
+	if h := r.Header.Get("X-Real-IP"); len(h) > 0 {
+		return h
+	}
+	if h := r.Header.Get("X-Forwarded-For"); len(h) > 0 {
+		hops := strings.Split(h, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if !t.trusts(hop) || i == 0 {
+				return hop
+			}
+		}
+	}
+	return remote
+}
+
+// Middleware stores address of the client in the request context, where rate
+// limiter and audit log pick it up.
+func (t trustedProxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(withClientIP(r.Context(), t.ClientIP(r)))
+		next.ServeHTTP(w, r)
+	})
+}
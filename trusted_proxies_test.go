@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{"10.1.2.3", "192.0.2.1", "2001:db8::1"} {
+		if !proxies.trusts(address) {
+			t.Errorf("%s isn't trusted", address)
+		}
+	}
+	for _, address := range []string{"192.0.2.2", "2001:db8::2", "garbage"} {
+		if proxies.trusts(address) {
+			t.Errorf("%s is trusted", address)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("invalid address was accepted")
+	}
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid range was accepted")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer",
+			remote:  "192.0.2.1:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"},
+			want:    "192.0.2.1",
+		},
+		{
+			name:    "real ip from proxy",
+			remote:  "10.0.0.1:4000",
+			headers: map[string]string{"X-Real-IP": "198.51.100.2"},
+			want:    "198.51.100.2",
+		},
+		{
+			name:    "spoofed forwarded for",
+			remote:  "10.0.0.1:4000",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "only proxies forwarded",
+			remote:  "10.0.0.1:4000",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:    "10.0.0.3",
+		},
+		{
+			name:   "proxy without headers",
+			remote: "10.0.0.1:4000",
+			want:   "10.0.0.1",
+		},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for name, value := range c.headers {
+			r.Header.Set(name, value)
+		}
+		if got := proxies.ClientIP(r); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"protoapi"
+	"time"
+)
+
+// defaultTunnelProvider is used when request doesn't name a provider, which
+// is what clients predating provider selection do.
+const defaultTunnelProvider = "linode"
+
+// tunnelProviders maps provider names, as sent by clients, to constructors of
+// the provider. Provider is created per request and may reuse the helpers of
+// the request handler.
+var tunnelProviders = map[string]tunnelProviderFactory{
+	"linode": newLinodeTunnelProvider,
+}
+
+type tunnelProviderFactory func(p *protobufLinode) TunnelProvider
+
+// TunnelProvider manages tunnel instances of a particular cloud. It knows
+// nothing about the wire protocol: requests and results are plain structs,
+// translation is done by the request handler.
+type TunnelProvider interface {
+	CreateTunnel(req *CreateTunnelRequest) (*ProvisionedTunnel, error)
+	RebuildTunnel(req *RebuildTunnelRequest) (*ProvisionedTunnel, error)
+	// CloneTunnel copies the tunnel into a new one, possibly in another
+	// region.
+	CloneTunnel(req *CloneTunnelRequest) (*ProvisionedTunnel, error)
+	// DestroyTunnel returns the tunnel as it was before destruction.
+	DestroyTunnel(req *DestroyTunnelRequest) (*Tunnel, error)
+	TunnelStatus(ref *TunnelRef) (*TunnelStatusResult, error)
+	ListInstances(req *ListInstancesRequest) (*TunnelPage, error)
+}
+
+// TunnelRef addresses a single tunnel.
+type TunnelRef struct {
+	// Access token of the cloud account.
+	AccessToken string
+	Namespace   string
+	// Name of the tunnel within the namespace, empty for the default tunnel.
+	Name string
+}
+
+// TunnelSpec describes how a tunnel instance is initialized.
+type TunnelSpec struct {
+	SSHKeys []string
+	// Labels of keys stored in the cloud account.
+	SSHKeyLabels    []string
+	RootPassword    string
+	AccountName     string
+	AccountPassword string
+	// IANA time zone name, empty means UTC.
+	Timezone string
+	// Tags of a new tunnel. Ignored by rebuild, which keeps tags of the
+	// instance.
+	Tags       []string
+	WireGuard  *WireGuardSpec
+	Obfsproxy4 *ObfsproxySpec
+	Obfsproxy6 *ObfsproxySpec
+	// Firewall to put in front of a new tunnel, nil means no firewall.
+	// Ignored by rebuild.
+	Firewall *FirewallSpec
+}
+
+type WireGuardSpec struct {
+	Port      int
+	ServerKey string
+	PeerKeys  []string
+	// Either WireGuardTransportUDP or WireGuardTransportTCP, empty means UDP.
+	// TCP transport is provided by a userspace relay on the instance.
+	Transport string
+}
+
+const (
+	WireGuardTransportUDP = "udp"
+	WireGuardTransportTCP = "tcp"
+)
+
+// Protocol returns transport of WireGuard, resolving the default.
+func (s *WireGuardSpec) Protocol() string {
+	if len(s.Transport) == 0 {
+		return WireGuardTransportUDP
+	}
+	return s.Transport
+}
+
+type ObfsproxySpec struct {
+	Port   int
+	Secret string
+}
+
+// FirewallSpec describes inbound rules of the tunnel firewall. Rules use
+// Linode format, other providers are expected to translate them. Empty rules
+// allow only the tunnel services (WireGuard and obfsproxy ports).
+type FirewallSpec struct {
+	Inbound []LinodeFirewallRule
+}
+
+type CreateTunnelRequest struct {
+	TunnelRef
+	Region string
+	Plan   string
+	Spec   TunnelSpec
+	// Validate the request without creating the instance.
+	DryRun bool
+}
+
+type RebuildTunnelRequest struct {
+	TunnelRef
+	// Configuration profile to boot, empty means the default one.
+	ConfigLabel string
+	// Timezone is not changed by rebuild.
+	Spec TunnelSpec
+	// Validate the request without rebuilding the instance.
+	DryRun bool
+}
+
+type CloneTunnelRequest struct {
+	// The tunnel being cloned.
+	TunnelRef
+	// Name of the clone, empty means source name suffixed with the region.
+	TargetName string
+	Region     string
+	// Plan of the clone, empty means plan of the source.
+	Plan string
+}
+
+type DestroyTunnelRequest struct {
+	TunnelRef
+	// Instance to destroy when several carry the tunnel label, zero means
+	// the only one.
+	InstanceID int
+}
+
+type ListInstancesRequest struct {
+	AccessToken string
+	// Only instances from this namespace are listed when not empty.
+	Namespace string
+	// Page of the cloud listing to return, starting from 1. Zero means all
+	// pages.
+	Page int
+	// Return tunnels listed so far when listing of all pages fails midway,
+	// instead of failing.
+	AllowPartial bool
+	// List only instances managed by holepuncher, i.e. carrying a tunnel
+	// label.
+	ManagedOnly bool
+}
+
+type TunnelPage struct {
+	Tunnels []*Tunnel
+	// Page following the returned one, zero when there are no more.
+	NextPage int
+	// Listing failed midway, Tunnels are incomplete.
+	Truncated bool
+	Warnings  []TunnelWarning
+}
+
+// Tunnel is a cloud instance as seen by holepuncher.
+type Tunnel struct {
+	ID        int
+	Label     string
+	Group     string
+	Region    string
+	Plan      string
+	Image     string
+	IPv4      []string
+	IPv6      []string
+	Status    string
+	CreatedAt string
+	UpdatedAt string
+	// Host and resources of the instance.
+	Hypervisor string
+	Disk       int
+	Memory     int
+	VCPUs      int
+	Transfer   int
+	Tags       []string
+}
+
+// TunnelEndpoint is a service the tunnel listens on. Protocol is "udp" or
+// "tcp".
+type TunnelEndpoint struct {
+	Service  string
+	Protocol string
+	Port     int
+}
+
+// tunnelEndpoints lists services enabled by the spec.
+func tunnelEndpoints(spec *TunnelSpec) []TunnelEndpoint {
+	var endpoints []TunnelEndpoint
+	if spec.WireGuard != nil {
+		endpoints = append(endpoints, TunnelEndpoint{"wireguard", spec.WireGuard.Protocol(), spec.WireGuard.Port})
+	}
+	if spec.Obfsproxy4 != nil {
+		endpoints = append(endpoints, TunnelEndpoint{"obfs4-ipv4", "tcp", spec.Obfsproxy4.Port})
+	}
+	if spec.Obfsproxy6 != nil {
+		endpoints = append(endpoints, TunnelEndpoint{"obfs4-ipv6", "tcp", spec.Obfsproxy6.Port})
+	}
+	return endpoints
+}
+
+type ProvisionedTunnel struct {
+	Tunnel *Tunnel
+	// Whether provisioning took longer than usual.
+	Slow bool
+	// How long it took from the provisioning request until the instance
+	// was running.
+	Duration time.Duration
+	Warnings []TunnelWarning
+	// Nothing was provisioned, Tunnel describes what would be.
+	DryRun bool
+}
+
+type TunnelStatusResult struct {
+	Tunnel *Tunnel
+	// All instances carrying the tunnel label, reported only when there are
+	// duplicates.
+	Conflicts []*Tunnel
+	Warnings  []TunnelWarning
+}
+
+// TunnelWarning is a non-fatal issue encountered by otherwise successful
+// operation. Code is a name of protoapi.Warning_Code value.
+type TunnelWarning struct {
+	Code    string
+	Message string
+}
+
+// providerVerbs are verbs carried out by TunnelProvider. Other verbs call
+// Linode directly, so they can't be used with any other provider.
+var providerVerbs = map[string]bool{
+	"LinodeCreateTunnel":    true,
+	"LinodeRebuildTunnel":   true,
+	"LinodeCloneTunnel":     true,
+	"LinodeDestroyTunnel":   true,
+	"LinodeTunnelStatus":    true,
+	"LinodeListInstances":   true,
+	"LinodeListTunnels":     true,
+	"LinodeExportInventory": true,
+}
+
+// selectTunnelProvider finds provider by the name sent by client, checking
+// that it can carry out the verb.
+func selectTunnelProvider(name string, verb string) (tunnelProviderFactory, error) {
+	if len(name) == 0 {
+		name = defaultTunnelProvider
+	}
+	factory, ok := tunnelProviders[name]
+	if !ok {
+		return nil, newHolepuncherError(
+			protoapi.HolepuncherError_UNSUPPORTED_PROVIDER,
+			"Unsupported tunnel provider: %s", name,
+		)
+	}
+	if name != defaultTunnelProvider && !providerVerbs[verb] {
+		return nil, newHolepuncherError(
+			protoapi.HolepuncherError_UNSUPPORTED_PROVIDER,
+			"%s is not supported by tunnel provider %s", verb, name,
+		)
+	}
+	return factory, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"protoapi"
+	"testing"
+)
+
+// staticTunnelProvider reports the same tunnel for every status request and
+// supports nothing else.
+type staticTunnelProvider struct {
+	TunnelProvider
+	tunnel *Tunnel
+	refs   []TunnelRef
+}
+
+func (s *staticTunnelProvider) TunnelStatus(ref *TunnelRef) (*TunnelStatusResult, error) {
+	s.refs = append(s.refs, *ref)
+	return &TunnelStatusResult{Tunnel: s.tunnel}, nil
+}
+
+func TestSelectTunnelProvider(t *testing.T) {
+	tunnelProviders["static"] = func(p *protobufLinode) TunnelProvider { return &staticTunnelProvider{} }
+	defer delete(tunnelProviders, "static")
+
+	for _, c := range []struct {
+		provider string
+		verb     string
+	}{
+		{"", "LinodeResizeTunnel"},
+		{"linode", "LinodeResizeTunnel"},
+		{"static", "LinodeTunnelStatus"},
+	} {
+		if _, err := selectTunnelProvider(c.provider, c.verb); err != nil {
+			t.Errorf("%s/%s: got error %v", c.provider, c.verb, err)
+		}
+	}
+
+	for _, c := range []struct {
+		provider string
+		verb     string
+	}{
+		{"aws", "LinodeTunnelStatus"},
+		{"static", "LinodeResizeTunnel"},
+	} {
+		_, err := selectTunnelProvider(c.provider, c.verb)
+		if err == nil {
+			t.Errorf("%s/%s was accepted", c.provider, c.verb)
+		} else if code := errorCode(t, err); code != protoapi.HolepuncherError_UNSUPPORTED_PROVIDER {
+			t.Errorf("%s/%s: got code %v, want UNSUPPORTED_PROVIDER", c.provider, c.verb, code)
+		}
+	}
+}
+
+func TestHandlerUsesTunnelProvider(t *testing.T) {
+	provider := &staticTunnelProvider{tunnel: &Tunnel{ID: 42, Label: "hp_team-a_vpn", Status: "running"}}
+	writer := &protobufCaptureWriter{}
+	p := newProtobufLinode(context.Background(), writer, newTestLinodeConfig(newFakeLinode(t)),
+		func(p *protobufLinode) TunnelProvider { return provider })
+
+	if err := p.TunnelStatus(&protoapi.LinodeGetTunnelStatusRequest{
+		Auth:       testAuth(),
+		Namespace:  "team-a",
+		TunnelName: "vpn",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	want := TunnelRef{AccessToken: testAccessToken, Namespace: "team-a", Name: "vpn"}
+	if len(provider.refs) != 1 || provider.refs[0] != want {
+		t.Errorf("got refs %+v, want %+v", provider.refs, want)
+	}
+	result := writer.response.R.(*protoapi.Response_LinodeTunnelStatusResult).LinodeTunnelStatusResult
+	instance := result.Result.(*protoapi.LinodeGetTunnelStatusResponse_Instance).Instance
+	if instance.Id != 42 || instance.Status != protoapi.LinodeInstance_RUNNING {
+		t.Errorf("got instance %+v", instance)
+	}
+}
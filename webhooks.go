@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"protoapi"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/resty.v1"
+)
+
+const (
+	// webhookTimeout limits a single webhook call.
+	webhookTimeout = 10 * time.Second
+	// postDestroyAttempts is how many times post-destroy hook is called
+	// before giving up.
+	postDestroyAttempts = 5
+	// postDestroyRetryDelay is the delay before the first retry, doubled
+	// after each failed attempt.
+	postDestroyRetryDelay = 5 * time.Second
+)
+
+// provisionHooks calls operator-defined webhooks around tunnel lifecycle.
+// Hooks with empty URL are not called.
+type provisionHooks struct {
+	preProvisionURL string
+	postDestroyURL  string
+	client          *resty.Client
+	// Delay before the first retry of post-destroy hook.
+	retryDelay time.Duration
+}
+
+// preProvisionRequest is the proposed tunnel sent to the pre-provision hook.
+// Secrets (passwords, keys) are never sent.
+type preProvisionRequest struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Label     string `json:"label"`
+	Region    string `json:"region"`
+	Plan      string `json:"plan"`
+	Image     string `json:"image"`
+	Timezone  string `json:"timezone,omitempty"`
+	// Nothing is created when set, the hook is asked only for approval.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// preProvisionResponse optionally overrides name and tags of the tunnel. The
+// name stays within the requested namespace, the hook can't pick arbitrary
+// labels.
+type preProvisionResponse struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// postDestroyRequest describes destroyed tunnel, so that the hook could
+// reclaim resources tied to it.
+type postDestroyRequest struct {
+	ID    int      `json:"id"`
+	Label string   `json:"label"`
+	IPv4  []string `json:"ipv4"`
+	IPv6  []string `json:"ipv6"`
+}
+
+func newProvisionHooks(preProvisionURL string, postDestroyURL string) *provisionHooks {
+	client := resty.New()
+	client.SetTimeout(webhookTimeout)
+	client.SetHeader("User-Agent", "holepuncher-server")
+	return &provisionHooks{
+		preProvisionURL: preProvisionURL,
+		postDestroyURL:  postDestroyURL,
+		client:          client,
+		retryDelay:      postDestroyRetryDelay,
+	}
+}
+
+// PreProvision asks the pre-provision hook to approve the tunnel. Non-2xx
+// response is reported as PRE_PROVISION_REJECTED. Hook is called within
+// the request context, so it is cut short together with the request.
+func (h *provisionHooks) PreProvision(
+	ctx context.Context,
+	req *preProvisionRequest,
+) (*preProvisionResponse, error) {
+	override := &preProvisionResponse{}
+	if h == nil || len(h.preProvisionURL) == 0 {
+		return override, nil
+	}
+
+	resp, err := h.client.R().
+		SetContext(ctx).
+		SetBody(req).
+		SetResult(override).
+		Post(h.preProvisionURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to call pre-provision hook")
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() > 299 {
+		return nil, newHolepuncherError(
+			protoapi.HolepuncherError_PRE_PROVISION_REJECTED,
+			"Tunnel was rejected by pre-provision hook (HTTP %d)", resp.StatusCode(),
+		)
+	}
+	return override, nil
+}
+
+// PostDestroy notifies the post-destroy hook in background. Failed calls are
+// retried with backoff, failure of the hook never affects the destroy.
+func (h *provisionHooks) PostDestroy(instance *LinodeInfo) {
+	if h == nil || len(h.postDestroyURL) == 0 {
+		return
+	}
+
+	req := &postDestroyRequest{
+		ID:    instance.ID,
+		Label: instance.Label,
+		IPv4:  instance.IPv4,
+		IPv6:  instance.IPv6,
+	}
+	go func() {
+		delay := h.retryDelay
+		for attempt := 1; ; attempt++ {
+			err := h.postDestroy(req)
+			if err == nil {
+				return
+			}
+
+			fields := log.Fields{
+				"cause":   err,
+				"label":   req.Label,
+				"attempt": attempt,
+			}
+			if attempt >= postDestroyAttempts {
+				log.WithFields(fields).Error("Giving up on post-destroy hook")
+				return
+			}
+			log.WithFields(fields).Warn("Post-destroy hook failed, retrying")
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}()
+}
+
+func (h *provisionHooks) postDestroy(req *postDestroyRequest) error {
+	resp, err := h.client.R().SetBody(req).Post(h.postDestroyURL)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to call post-destroy hook")
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() > 299 {
+		return errors.Errorf("Post-destroy hook responded with HTTP %d", resp.StatusCode())
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"protoapi"
+	"testing"
+	"time"
+)
+
+// newHookServer returns webhook responding with the status and body, the
+// proposed tunnels it was called with are sent to the returned channel.
+func newHookServer(t *testing.T, status int, body interface{}) (*httptest.Server, chan *preProvisionRequest) {
+	calls := make(chan *preProvisionRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &preProvisionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Errorf("Unable to decode hook request: %v", err)
+		}
+		calls <- req
+		writeJSON(t, w, status, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, calls
+}
+
+func TestPreProvisionHookApproves(t *testing.T) {
+	server, calls := newHookServer(t, http.StatusOK, &preProvisionResponse{
+		Name: "approved",
+		Tags: []string{"cmdb:42"},
+	})
+	linode := newFakeLinode(t)
+	writer := linode.call(func(p *protobufLinode) error {
+		p.config.hooks = newProvisionHooks(server.URL, "")
+		return p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+			Auth:   testAuth(),
+			Region: "us-east",
+			Plan:   "g6-nanode-1",
+			Tags:   []string{"team:vpn"},
+		})
+	})
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	proposed := <-calls
+	if proposed.Label != "hp_instance" || proposed.Region != "us-east" || proposed.Plan != "g6-nanode-1" {
+		t.Errorf("got proposed tunnel %+v", proposed)
+	}
+	var body struct {
+		Label string   `json:"label"`
+		Tags  []string `json:"tags"`
+	}
+	linode.body("POST /linode/instances", &body)
+	if body.Label != "hp_approved" || len(body.Tags) != 1 || body.Tags[0] != "cmdb:42" {
+		t.Errorf("overrides weren't applied, got label %s, tags %v", body.Label, body.Tags)
+	}
+}
+
+func TestPreProvisionHookRejects(t *testing.T) {
+	server, calls := newHookServer(t, http.StatusForbidden, struct{}{})
+	linode := newFakeLinode(t)
+	writer := linode.call(func(p *protobufLinode) error {
+		p.config.hooks = newProvisionHooks(server.URL, "")
+		return p.CreateTunnel(&protoapi.LinodeCreateTunnelRequest{
+			Auth:   testAuth(),
+			Region: "us-east",
+			Plan:   "g6-nanode-1",
+			Tags:   []string{"team:vpn"},
+		})
+	})
+	if code := errorCode(t, writer.err); code != protoapi.HolepuncherError_PRE_PROVISION_REJECTED {
+		t.Errorf("got error code %v, want PRE_PROVISION_REJECTED", code)
+	}
+	if len(calls) != 1 {
+		t.Errorf("hook was called %d times", len(calls))
+	}
+	if linode.requested("POST /linode/instances") {
+		t.Error("instance was created despite rejection")
+	}
+}
+
+func TestPreProvisionWithoutHook(t *testing.T) {
+	var hooks *provisionHooks
+	override, err := hooks.PreProvision(context.Background(), &preProvisionRequest{})
+	if err != nil || len(override.Name) > 0 || len(override.Tags) > 0 {
+		t.Errorf("got override %+v, error %v", override, err)
+	}
+}
+
+func TestPostDestroyHookPayload(t *testing.T) {
+	payloads := make(chan *postDestroyRequest, 10)
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &postDestroyRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Errorf("Unable to decode hook request: %v", err)
+		}
+		payloads <- req
+		// Transient failures of the hook are retried.
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	linode := newFakeLinode(t, LinodeInfo{
+		ID:     1,
+		Label:  "hp_instance",
+		Status: LinodeStatusRunning,
+		IPv4:   []string{"192.0.2.10"},
+		IPv6:   LinodeIPv6{"2001:db8::1/128"},
+	}).handle(noFirewallRoutes)
+	p, writer := newTestProtobufLinode(linode)
+	p.config.hooks = newProvisionHooks("", server.URL)
+	p.config.hooks.retryDelay = time.Millisecond
+
+	if err := p.DestroyTunnel(&protoapi.LinodeDestroyTunnelRequest{Auth: testAuth()}); err != nil {
+		t.Fatal(err)
+	}
+	if writer.err != nil {
+		t.Fatal(writer.err)
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		select {
+		case payload := <-payloads:
+			if payload.ID != 1 || payload.Label != "hp_instance" ||
+				len(payload.IPv4) != 1 || payload.IPv4[0] != "192.0.2.10" || len(payload.IPv6) != 1 {
+				t.Errorf("attempt %d: got payload %+v", attempt, payload)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("hook wasn't called for attempt %d", attempt)
+		}
+	}
+	select {
+	case <-payloads:
+		t.Error("hook was called again after it succeeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPostDestroyHookGivesUp(t *testing.T) {
+	calls := make(chan struct{}, postDestroyAttempts+1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	hooks := newProvisionHooks("", server.URL)
+	hooks.retryDelay = time.Millisecond
+	hooks.PostDestroy(&LinodeInfo{ID: 1, Label: "hp_instance"})
+
+	for attempt := 1; attempt <= postDestroyAttempts; attempt++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("hook wasn't called for attempt %d", attempt)
+		}
+	}
+	select {
+	case <-calls:
+		t.Error("hook was called after the last attempt")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// wireGuardEndpointPlaceholder stands in for the address of a tunnel which
+// doesn't exist yet. It is a documentation address (RFC 5737), so that the
+// preview parses as a regular config.
+const wireGuardEndpointPlaceholder = "192.0.2.1"
+
+// wireGuardClientTemplate is the config of a peer connecting to the tunnel.
+// Peers know their private keys, the server only ever sees public ones.
+var wireGuardClientTemplate = template.Must(template.New("wireguard").Parse(`[Interface]
+# Private key of the peer with public key {{.PeerKey}}.
+PrivateKey = <peer private key>
+
+[Peer]
+PublicKey = {{.ServerPublicKey}}
+{{- if eq .Transport "tcp"}}
+# WireGuard is carried over TCP: dial the endpoint through a userspace relay
+# (e.g. udp2raw or wstunnel) and point Endpoint at the relay.
+{{- end}}
+Endpoint = {{.Endpoint}}:{{.Port}}
+AllowedIPs = 0.0.0.0/0, ::/0
+`))
+
+type wireGuardClientParams struct {
+	PeerKey         string
+	ServerPublicKey string
+	Endpoint        string
+	Port            int
+	Transport       string
+}
+
+type wireGuardClientConfig struct {
+	PeerKey string
+	Config  string
+}
+
+// renderWireGuardClientConfigs validates the WireGuard spec and renders
+// client config for each of its peers, in order of the peer keys.
+func renderWireGuardClientConfigs(spec *WireGuardSpec, endpoint string) ([]wireGuardClientConfig, error) {
+	if spec == nil {
+		return nil, errors.New("WireGuard is not configured")
+	}
+	if err := validateWireGuardOptions(&TunnelSpec{WireGuard: spec}); err != nil {
+		return nil, err
+	}
+	if len(spec.PeerKeys) == 0 {
+		return nil, errors.New("WireGuard has no peers")
+	}
+
+	serverKey, err := wireGuardPublicKey(spec.ServerKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid WireGuard server key")
+	}
+
+	configs := make([]wireGuardClientConfig, 0, len(spec.PeerKeys))
+	for _, peerKey := range spec.PeerKeys {
+		var config bytes.Buffer
+		err := wireGuardClientTemplate.Execute(&config, &wireGuardClientParams{
+			PeerKey:         peerKey,
+			ServerPublicKey: serverKey,
+			Endpoint:        endpoint,
+			Port:            spec.Port,
+			Transport:       spec.Protocol(),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to render WireGuard config")
+		}
+		configs = append(configs, wireGuardClientConfig{PeerKey: peerKey, Config: config.String()})
+	}
+	return configs, nil
+}
+
+// validateWireGuardOptions checks WireGuard options before they are handed
+// to the instance, where a bad key or port would only break the tunnel
+// silently. WireGuard over TCP must not share port with obfsproxy.
+func validateWireGuardOptions(spec *TunnelSpec) error {
+	wg := spec.WireGuard
+	if wg == nil {
+		return nil
+	}
+
+	if wg.Port < 1 || wg.Port > 65535 {
+		return errors.Errorf("Invalid WireGuard port %d", wg.Port)
+	}
+	if _, err := decodeWireGuardKey(wg.ServerKey); err != nil {
+		return errors.Wrapf(err, "Invalid WireGuard server key")
+	}
+	for n, peerKey := range wg.PeerKeys {
+		if _, err := decodeWireGuardKey(peerKey); err != nil {
+			return errors.Wrapf(err, "Invalid WireGuard peer key #%d", n+1)
+		}
+	}
+
+	switch wg.Protocol() {
+	case WireGuardTransportUDP:
+		return nil
+	case WireGuardTransportTCP:
+	default:
+		return errors.Errorf("Unknown WireGuard transport '%s'", wg.Transport)
+	}
+	for _, obfs := range []*ObfsproxySpec{spec.Obfsproxy4, spec.Obfsproxy6} {
+		if obfs != nil && obfs.Port == wg.Port {
+			return errors.Errorf("WireGuard over TCP can't share port %d with obfsproxy", wg.Port)
+		}
+	}
+	return nil
+}
+
+// wireGuardPublicKey derives public key from base64-encoded private key.
+func wireGuardPublicKey(privateKey string) (string, error) {
+	key, err := decodeWireGuardKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	private, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(private.PublicKey().Bytes()), nil
+}
+
+func decodeWireGuardKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("key must be 32 bytes encoded with base64")
+	}
+	return key, nil
+}
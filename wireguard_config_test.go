@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newWireGuardKey(t *testing.T) (private string, public string) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoding := base64.StdEncoding
+	return encoding.EncodeToString(key.Bytes()), encoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+func TestValidateWireGuardOptions(t *testing.T) {
+	serverKey, _ := newWireGuardKey(t)
+	_, peerKey := newWireGuardKey(t)
+
+	cases := []struct {
+		name  string
+		spec  TunnelSpec
+		valid bool
+	}{
+		{
+			name:  "no wireguard",
+			spec:  TunnelSpec{},
+			valid: true,
+		},
+		{
+			name:  "udp",
+			spec:  TunnelSpec{WireGuard: &WireGuardSpec{Port: 51820, ServerKey: serverKey, PeerKeys: []string{peerKey}}},
+			valid: true,
+		},
+		{
+			name:  "port out of range",
+			spec:  TunnelSpec{WireGuard: &WireGuardSpec{Port: 70000, ServerKey: serverKey}},
+			valid: false,
+		},
+		{
+			name:  "short server key",
+			spec:  TunnelSpec{WireGuard: &WireGuardSpec{Port: 51820, ServerKey: "c2hvcnQ="}},
+			valid: false,
+		},
+		{
+			name:  "invalid peer key",
+			spec:  TunnelSpec{WireGuard: &WireGuardSpec{Port: 51820, ServerKey: serverKey, PeerKeys: []string{"not base64"}}},
+			valid: false,
+		},
+		{
+			name:  "unknown transport",
+			spec:  TunnelSpec{WireGuard: &WireGuardSpec{Port: 51820, ServerKey: serverKey, Transport: "sctp"}},
+			valid: false,
+		},
+		{
+			name: "tcp sharing obfsproxy port",
+			spec: TunnelSpec{
+				WireGuard:  &WireGuardSpec{Port: 443, ServerKey: serverKey, Transport: WireGuardTransportTCP},
+				Obfsproxy4: &ObfsproxySpec{Port: 443},
+			},
+			valid: false,
+		},
+		{
+			name: "udp sharing obfsproxy port",
+			spec: TunnelSpec{
+				WireGuard:  &WireGuardSpec{Port: 443, ServerKey: serverKey},
+				Obfsproxy4: &ObfsproxySpec{Port: 443},
+			},
+			valid: true,
+		},
+	}
+	for _, c := range cases {
+		err := validateWireGuardOptions(&c.spec)
+		if c.valid && err != nil {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: spec was accepted", c.name)
+		}
+	}
+}
+
+func TestRenderWireGuardClientConfigs(t *testing.T) {
+	serverKey, serverPublicKey := newWireGuardKey(t)
+	_, peerKey := newWireGuardKey(t)
+
+	configs, err := renderWireGuardClientConfigs(&WireGuardSpec{
+		Port:      51820,
+		ServerKey: serverKey,
+		PeerKeys:  []string{peerKey},
+	}, wireGuardEndpointPlaceholder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].PeerKey != peerKey {
+		t.Fatalf("got configs %+v, want one for the peer", configs)
+	}
+
+	config := configs[0].Config
+	for _, line := range []string{
+		"PublicKey = " + serverPublicKey,
+		"Endpoint = 192.0.2.1:51820",
+	} {
+		if !strings.Contains(config, line) {
+			t.Errorf("config doesn't contain '%s':\n%s", line, config)
+		}
+	}
+	if strings.Contains(config, serverKey) {
+		t.Error("config contains server private key")
+	}
+}
+
+func TestRenderWireGuardClientConfigsOverTCP(t *testing.T) {
+	serverKey, _ := newWireGuardKey(t)
+	_, peerKey := newWireGuardKey(t)
+
+	configs, err := renderWireGuardClientConfigs(&WireGuardSpec{
+		Port:      443,
+		ServerKey: serverKey,
+		PeerKeys:  []string{peerKey},
+		Transport: WireGuardTransportTCP,
+	}, wireGuardEndpointPlaceholder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(configs[0].Config, "WireGuard is carried over TCP") {
+		t.Errorf("config doesn't mention TCP transport:\n%s", configs[0].Config)
+	}
+}
+
+func TestRenderWireGuardClientConfigsWithoutPeers(t *testing.T) {
+	serverKey, _ := newWireGuardKey(t)
+	_, err := renderWireGuardClientConfigs(&WireGuardSpec{Port: 51820, ServerKey: serverKey}, wireGuardEndpointPlaceholder)
+	if err == nil {
+		t.Error("spec without peers was accepted")
+	}
+}